@@ -0,0 +1,24 @@
+package store
+
+import "context"
+
+type actorKey struct{}
+
+// WithActor returns a context carrying the ID of the actor performing the
+// operation. Repositories record it on entities implementing Auditable.
+func WithActor(ctx context.Context, actorID string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actorID)
+}
+
+// ActorFromContext returns the actor ID stored by WithActor.
+func ActorFromContext(ctx context.Context) (string, bool) {
+	actorID, ok := ctx.Value(actorKey{}).(string)
+	return actorID, ok && actorID != ""
+}
+
+// Auditable is implemented by entities that track who created and last
+// updated them (typically the created_by / updated_by columns).
+type Auditable interface {
+	SetCreatedBy(actorID string)
+	SetUpdatedBy(actorID string)
+}
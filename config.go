@@ -25,16 +25,24 @@ type Config struct {
 	MaxOpenConns    int           `json:"max_open_conns"`
 	MaxIdleConns    int           `json:"max_idle_conns"`
 	ConnMaxLifetime time.Duration `json:"conn_max_lifetime"`
+	WarmUp          bool          `json:"warm_up"` // pre-open MaxIdleConns connections on connect
 
 	// Timeouts
 	ConnectTimeout time.Duration `json:"connect_timeout"`
 	QueryTimeout   time.Duration `json:"query_timeout"`
 
+	// Health monitoring: ping every interval and replace broken connections
+	HealthCheckInterval time.Duration `json:"health_check_interval"` // 0 disables the watcher
+
 	// SSL/Security
-	SSLMode string `json:"ssl_mode"` // "disable", "require", "verify-full"
+	SSLMode string     `json:"ssl_mode"`      // "disable", "require", "verify-ca", "verify-full"
+	TLS     *TLSConfig `json:"tls,omitempty"` // certificates; enables TLS when SSLMode is "disable"
+
+	// Read replicas, connected with the same adapter (SQL backends)
+	Replicas []Config `json:"replicas,omitempty"`
 
 	// Performance
-	EnableMetrics bool `json:"enable_metrics"`
+	EnableMetrics bool `json:"enable_metrics"` // record operations and pool gauges in DefaultMetrics
 
 	// Backend-specific options (escape hatch for special settings)
 	Options map[string]string `json:"options"`
@@ -118,6 +126,10 @@ func (c *Config) Validate() error {
 		if c.FilePath == "" {
 			return NewConfigError("file path required for SQLite")
 		}
+	case "redis":
+		if c.Host == "" {
+			return NewConfigError("host required for redis")
+		}
 	case "memory":
 		// No validation needed for memory
 	default:
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
@@ -26,6 +27,13 @@ var (
 	ErrTransactionAborted = errors.New("transaction aborted")
 	ErrTransactionTimeout = errors.New("transaction timeout")
 	ErrInvalidTransaction = errors.New("invalid transaction")
+	ErrNoTransaction      = errors.New("no transaction")
+
+	// Lock errors
+	ErrLockNotHeld = errors.New("lock not held")
+
+	// Migration errors
+	ErrSchemaVersion = errors.New("unexpected schema version")
 
 	// Query errors
 	ErrQueryFailed  = errors.New("query failed")
@@ -34,9 +42,10 @@ var (
 	ErrQuerySyntax  = errors.New("query syntax error")
 
 	// Record errors
-	ErrRecordNotFound = errors.New("record not found")
-	ErrRecordExists   = errors.New("record already exists")
-	ErrInvalidRecord  = errors.New("invalid record")
+	ErrRecordNotFound  = errors.New("record not found")
+	ErrRecordExists    = errors.New("record already exists")
+	ErrInvalidRecord   = errors.New("invalid record")
+	ErrMultipleRecords = errors.New("multiple records found")
 
 	// Constraint errors
 	ErrUniqueConstraint     = errors.New("unique constraint violation")
@@ -48,6 +57,7 @@ var (
 	ErrValidationFailed = errors.New("validation failed")
 	ErrInvalidInput     = errors.New("invalid input")
 	ErrMissingRequired  = errors.New("missing required field")
+	ErrCursorTampered   = errors.New("cursor signature is invalid")
 
 	// Configuration errors
 	ErrInvalidConfig = errors.New("invalid configuration")
@@ -137,11 +147,13 @@ func (e *RecordNotFoundError) Error() string {
 	return fmt.Sprintf("record not found in table %s with ID %s", e.Table, e.ID)
 }
 
-// ValidationError represents validation errors.
+// ValidationError represents validation errors. Err, when set, is the
+// error the value was rejected with.
 type ValidationError struct {
 	Field   string
 	Value   any
 	Message string
+	Err     error
 }
 
 func (e *ValidationError) Error() string {
@@ -151,6 +163,10 @@ func (e *ValidationError) Error() string {
 	return fmt.Sprintf("validation error: %s", e.Message)
 }
 
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
 // ConfigError represents configuration errors.
 type ConfigError struct {
 	Field   string
@@ -168,12 +184,13 @@ func (e *ConfigError) Error() string {
 // Constructor functions for custom errors
 
 // NewConnectionError creates a new connection error.
+// An exceeded deadline is reported as ErrConnectionTimeout.
 func NewConnectionError(err error, operation, driver, host string) *ConnectionError {
 	return &ConnectionError{
 		Operation: operation,
 		Driver:    driver,
 		Host:      host,
-		Err:       err,
+		Err:       classifyTimeout(err, ErrConnectionTimeout),
 	}
 }
 
@@ -187,22 +204,40 @@ func NewDriverError(err error, driver, operation string) *DriverError {
 }
 
 // NewTransactionError creates a new transaction error.
+// An exceeded deadline not already attributed to a query or connection is
+// reported as ErrTransactionTimeout.
 func NewTransactionError(err error, operation string) *TransactionError {
 	return &TransactionError{
 		Operation: operation,
-		Err:       err,
+		Err:       classifyTimeout(err, ErrTransactionTimeout),
 	}
 }
 
 // NewQueryError creates a new query error.
+// An exceeded deadline is reported as ErrQueryTimeout.
 func NewQueryError(err error, operation, table, query string, args []any) *QueryError {
 	return &QueryError{
 		Operation: operation,
 		Table:     table,
 		Query:     query,
 		Args:      args,
-		Err:       err,
+		Err:       classifyTimeout(err, ErrQueryTimeout),
+	}
+}
+
+// classifyTimeout adds the timeout sentinel to an error caused by an
+// exceeded context deadline, so errors.Is tells which kind of operation
+// timed out. Errors already classified keep their sentinel.
+func classifyTimeout(err, sentinel error) error {
+	if !errors.Is(err, context.DeadlineExceeded) || IsTimeoutError(err) {
+		return err
 	}
+	return fmt.Errorf("%w: %w", sentinel, err)
+}
+
+// IsTimeoutError reports whether err is a connection, query or transaction timeout.
+func IsTimeoutError(err error) bool {
+	return errors.Is(err, ErrConnectionTimeout) || errors.Is(err, ErrQueryTimeout) || errors.Is(err, ErrTransactionTimeout)
 }
 
 // NewRecordNotFoundError creates a new record not found error.
@@ -271,6 +306,15 @@ func WrapTransactionError(err error, operation string) error {
 	return NewTransactionError(err, operation)
 }
 
+// WrapValidationError wraps the error a field value was rejected with as a
+// validation error.
+func WrapValidationError(err error, field string, value any) error {
+	if err == nil {
+		return nil
+	}
+	return &ValidationError{Field: field, Value: value, Message: err.Error(), Err: err}
+}
+
 // WrapQueryError wraps an error as a query error.
 func WrapQueryError(err error, operation, table, query string, args []any) error {
 	if err == nil {
@@ -352,6 +396,15 @@ func IsConfigError(err error) bool {
 	return errors.As(err, &configErr)
 }
 
+// IsConstraintViolation checks if an error is a unique, foreign key, check
+// or not null constraint violation.
+func IsConstraintViolation(err error) bool {
+	return errors.Is(err, ErrUniqueConstraint) ||
+		errors.Is(err, ErrForeignKeyConstraint) ||
+		errors.Is(err, ErrCheckConstraint) ||
+		errors.Is(err, ErrNotNullConstraint)
+}
+
 // NewValidationErrorFromResult creates a validation error from a validation result.
 func NewValidationErrorFromResult(result *validation.Result, entity interface{}) *ValidationError {
 	if result.IsValid {
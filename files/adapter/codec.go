@@ -0,0 +1,81 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+
+	filestore "store/files"
+)
+
+// MetadataCodec encodes the metadata sidecar stored next to each file.
+// Sidecars record the codec name, so files written with one codec stay
+// readable after the configured codec changes.
+type MetadataCodec interface {
+	// Name identifies the codec in sidecar headers. It must not contain a newline.
+	Name() string
+	Marshal(md filestore.FileMetadata) ([]byte, error)
+	Unmarshal(data []byte, md *filestore.FileMetadata) error
+}
+
+// Built-in metadata codecs. JSONCodec is the default.
+var (
+	JSONCodec MetadataCodec = jsonCodec{}
+	GobCodec  MetadataCodec = gobCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Marshal(md filestore.FileMetadata) ([]byte, error) {
+	return json.Marshal(md)
+}
+
+func (jsonCodec) Unmarshal(data []byte, md *filestore.FileMetadata) error {
+	return json.Unmarshal(data, md)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(md filestore.FileMetadata) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(md); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, md *filestore.FileMetadata) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(md)
+}
+
+// encodeSidecar returns the sidecar contents: the codec name, a newline and
+// the encoded metadata.
+func encodeSidecar(codec MetadataCodec, md filestore.FileMetadata) ([]byte, error) {
+	payload, err := codec.Marshal(md)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(codec.Name()+"\n"), payload...), nil
+}
+
+// decodeSidecar decodes sidecar contents with the codec named in its header,
+// chosen from codecs.
+func decodeSidecar(data []byte, codecs ...MetadataCodec) (filestore.FileMetadata, error) {
+	var md filestore.FileMetadata
+	name, payload, ok := bytes.Cut(data, []byte("\n"))
+	if !ok {
+		return md, fmt.Errorf("metadata sidecar has no codec header")
+	}
+	for _, codec := range codecs {
+		if codec.Name() == string(name) {
+			err := codec.Unmarshal(payload, &md)
+			return md, err
+		}
+	}
+	return md, fmt.Errorf("unknown metadata codec %q", name)
+}
@@ -0,0 +1,97 @@
+package adapter_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	filestore "store/files"
+	"store/files/adapter"
+)
+
+// upperCodec is a custom codec storing JSON with upper-cased names.
+type upperCodec struct{}
+
+func (upperCodec) Name() string { return "upper-json" }
+
+func (upperCodec) Marshal(md filestore.FileMetadata) ([]byte, error) {
+	md.Name = strings.ToUpper(md.Name)
+	return json.Marshal(md)
+}
+
+func (upperCodec) Unmarshal(data []byte, md *filestore.FileMetadata) error {
+	return json.Unmarshal(data, md)
+}
+
+func TestMetadataCodecsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		codec    adapter.MetadataCodec
+		reopen   adapter.MetadataCodec // codec the store is reopened with
+		wantName string
+	}{
+		{"default", nil, adapter.GobCodec, "Report Q1.pdf"},
+		{"json", adapter.JSONCodec, adapter.GobCodec, "Report Q1.pdf"},
+		{"gob", adapter.GobCodec, adapter.JSONCodec, "Report Q1.pdf"},
+		{"custom", upperCodec{}, upperCodec{}, "REPORT Q1.PDF"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := adapter.FilesystemConfig{Root: t.TempDir(), MetadataCodec: tt.codec}
+			fs, err := adapter.NewFilesystem(cfg)
+			if err != nil {
+				t.Fatalf("open filesystem store: %v", err)
+			}
+			ctx := context.Background()
+
+			id, _, err := filestore.NewRepository(fs).Save(ctx, "Report Q1.pdf", strings.NewReader("%PDF-1.7"), "application/x-report")
+			if err != nil {
+				t.Fatalf("save: %v", err)
+			}
+
+			md, err := fs.GetMetadata(ctx, id)
+			if err != nil {
+				t.Fatalf("get metadata: %v", err)
+			}
+			want := filestore.FileMetadata{Name: tt.wantName, Path: string(id), Size: 8, ContentType: "application/x-report"}
+			if *md != want {
+				t.Errorf("metadata = %+v, want %+v", *md, want)
+			}
+
+			f, err := fs.Retrieve(ctx, id)
+			if err != nil {
+				t.Fatalf("retrieve: %v", err)
+			}
+			if stream, _ := f.Stream(); stream != nil {
+				_ = stream.Close()
+			}
+			if f.Metadata() != want {
+				t.Errorf("retrieved metadata = %+v, want %+v", f.Metadata(), want)
+			}
+
+			// Sidecars stay readable when the store is reopened with another codec.
+			cfg.MetadataCodec = tt.reopen
+			reopened, err := adapter.NewFilesystem(cfg)
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			md, err = reopened.GetMetadata(ctx, id)
+			if err != nil {
+				t.Fatalf("get metadata after reopen: %v", err)
+			}
+			if *md != want {
+				t.Errorf("metadata after reopen = %+v, want %+v", *md, want)
+			}
+
+			items, _, err := fs.List(ctx, 10, "")
+			if err != nil {
+				t.Fatalf("list: %v", err)
+			}
+			if len(items) != 1 {
+				t.Errorf("List returned %d items, want 1 (sidecars must not be listed)", len(items))
+			}
+		})
+	}
+}
@@ -9,12 +9,15 @@ import (
 	"io"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
+	"store"
 	filestore "store/files"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"core/validation"
@@ -27,8 +30,25 @@ type FilesystemConfig struct {
 	SecretKey   string `validate:"omitempty"`
 	MaxFileSize int64  `validate:"min:0"` // 0 = unlimited
 	ChunkSize   int    `validate:"min:0"` // bytes per write; default 2MB if 0
+
+	// DirMode and FileMode are the permissions of created directories and
+	// stored files. They are applied explicitly, so the process umask does
+	// not narrow them. Defaults are 0755 and 0600.
+	DirMode  os.FileMode
+	FileMode os.FileMode
+
+	// MetadataCodec encodes the metadata sidecar written next to each file.
+	// Defaults to JSONCodec. Sidecars written with JSONCodec or GobCodec stay
+	// readable whichever codec is configured.
+	MetadataCodec MetadataCodec
 }
 
+// Default permissions of directories and files created by the filesystem store.
+const (
+	DefaultDirMode  os.FileMode = 0755
+	DefaultFileMode os.FileMode = 0600
+)
+
 // Validate validates the filesystem configuration.
 func (c FilesystemConfig) Validate() error {
 	res := validation.Validate(c)
@@ -48,14 +68,19 @@ func (c FilesystemConfig) Validate() error {
 
 // filesystemAdapter implements filestore.FileStore directly.
 type filesystemAdapter struct {
-	root        string
-	baseURL     string
-	secretKey   string
-	maxSize     int64
-	chunkSize   int
-	httpHandler http.Handler
+	root      string
+	baseURL   string
+	secretKey string
+	maxSize   int64
+	chunkSize int
+	dirMode   os.FileMode
+	fileMode  os.FileMode
+	codec     MetadataCodec
 }
 
+// Ensure the filesystem adapter serves its files over HTTP.
+var _ http.Handler = (*filesystemAdapter)(nil)
+
 // NewFilesystem creates a filesystem filestore from config.
 func NewFilesystem(cfg FilesystemConfig) (filestore.FileStore, error) {
 	if err := cfg.Validate(); err != nil {
@@ -67,12 +92,21 @@ func NewFilesystem(cfg FilesystemConfig) (filestore.FileStore, error) {
 		secretKey: cfg.SecretKey,
 		maxSize:   cfg.MaxFileSize,
 		chunkSize: cfg.ChunkSize,
+		dirMode:   cfg.DirMode,
+		fileMode:  cfg.FileMode,
+		codec:     cfg.MetadataCodec,
 	}
 	if ad.chunkSize <= 0 {
 		ad.chunkSize = 2 * 1024 * 1024 // 2MB default
 	}
-	if cfg.BaseURL != "" {
-		ad.httpHandler = http.StripPrefix("/files/", http.FileServer(http.Dir(cfg.Root)))
+	if ad.dirMode == 0 {
+		ad.dirMode = DefaultDirMode
+	}
+	if ad.fileMode == 0 {
+		ad.fileMode = DefaultFileMode
+	}
+	if ad.codec == nil {
+		ad.codec = JSONCodec
 	}
 	return ad, nil
 }
@@ -91,7 +125,7 @@ func (a *filesystemAdapter) Store(ctx context.Context, f filestore.File) (filest
 	// Write to a shard temp location to allow atomic rename
 	// Compute a temporary path under root to avoid loading everything in memory
 	tmpDir := a.root
-	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+	if err := a.ensureDir(tmpDir); err != nil {
 		return filestore.InvalidFileID, nil, err
 	}
 	tmpFile, err := os.CreateTemp(tmpDir, "upload-*")
@@ -136,7 +170,7 @@ func (a *filesystemAdapter) Store(ctx context.Context, f filestore.File) (filest
 
 	// Compute final path with sharding and ensure directory exists
 	finalPath := a.pathFor(id)
-	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
+	if err := a.ensureDir(filepath.Dir(finalPath)); err != nil {
 		return filestore.InvalidFileID, nil, err
 	}
 	// If file already exists (dedup), discard temp and return metadata
@@ -148,12 +182,25 @@ func (a *filesystemAdapter) Store(ctx context.Context, f filestore.File) (filest
 		meta, err := a.GetMetadata(ctx, id)
 		return id, meta, err
 	}
+	if err := tmpFile.Chmod(a.fileMode); err != nil {
+		return filestore.InvalidFileID, nil, err
+	}
 	// Sync temp to disk before rename (best-effort)
 	_ = tmpFile.Sync()
 	if err := tmpFile.Close(); err != nil {
 		return filestore.InvalidFileID, nil, err
 	}
+	// Write the sidecar first so the file is never visible without it
+	contentType := md.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(md.Name))
+	}
+	sidecar := filestore.FileMetadata{Name: md.Name, Path: string(id), Size: written, ContentType: contentType}
+	if err := a.writeSidecar(id, sidecar); err != nil {
+		return filestore.InvalidFileID, nil, err
+	}
 	if err := os.Rename(tmpFile.Name(), finalPath); err != nil {
+		_ = os.Remove(a.sidecarPath(id))
 		return filestore.InvalidFileID, nil, err
 	}
 	meta, err := a.GetMetadata(ctx, id)
@@ -161,27 +208,79 @@ func (a *filesystemAdapter) Store(ctx context.Context, f filestore.File) (filest
 }
 
 func (a *filesystemAdapter) Retrieve(ctx context.Context, id filestore.FileID) (filestore.File, error) {
-	p := a.pathFor(id)
-	stream, err := os.Open(p)
+	stream, err := os.Open(a.pathFor(id))
 	if err != nil {
 		return nil, err
 	}
-	info, err := os.Stat(p)
+	md, err := a.GetMetadata(ctx, id)
 	if err != nil {
 		stream.Close()
 		return nil, err
 	}
-	name := filestore.ExtractOriginalFileName(id)
-	if name == "" {
-		name = string(id)
+	return &fileAdapter{metadata: *md, stream: stream}, nil
+}
+
+func (a *filesystemAdapter) RetrieveSeeker(ctx context.Context, id filestore.FileID) (io.ReadSeekCloser, *filestore.FileMetadata, error) {
+	md, err := a.GetMetadata(ctx, id)
+	if err != nil {
+		return nil, nil, err
 	}
-	ext := filepath.Ext(name)
-	md := filestore.FileMetadata{Name: name, Path: string(id), Size: info.Size(), ContentType: mime.TypeByExtension(ext)}
-	return &fileAdapter{metadata: md, stream: stream}, nil
+	f, err := os.Open(a.pathFor(id))
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, md, nil
 }
 
 func (a *filesystemAdapter) Delete(ctx context.Context, id filestore.FileID) error {
-	return os.Remove(a.pathFor(id))
+	if err := os.Remove(a.pathFor(id)); err != nil {
+		return err
+	}
+	if err := os.Remove(a.sidecarPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// deleteConcurrency bounds the number of files removed in parallel.
+const deleteConcurrency = 8
+
+func (a *filesystemAdapter) DeleteBatch(ctx context.Context, ids []filestore.FileID) error {
+	res, err := a.DeleteBatchPartial(ctx, ids)
+	if err != nil {
+		return err
+	}
+	return res.Err()
+}
+
+func (a *filesystemAdapter) DeleteBatchPartial(ctx context.Context, ids []filestore.FileID) (filestore.BatchDeleteResult, error) {
+	errs := make([]error, len(ids))
+	sem := make(chan struct{}, deleteConcurrency)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := ctx.Err(); err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = a.Delete(ctx, id)
+		}()
+	}
+	wg.Wait()
+
+	res := filestore.BatchDeleteResult{Failed: map[filestore.FileID]error{}}
+	for i, id := range ids {
+		if errs[i] != nil {
+			res.Failed[id] = errs[i]
+			continue
+		}
+		res.Deleted = append(res.Deleted, id)
+	}
+	return res, nil
 }
 
 func (a *filesystemAdapter) Exists(ctx context.Context, id filestore.FileID) (bool, error) {
@@ -212,6 +311,16 @@ func (a *filesystemAdapter) GetMetadata(ctx context.Context, id filestore.FileID
 		Size:        info.Size(),
 		ContentType: mime.TypeByExtension(ext),
 	}
+
+	// Files stored before sidecars existed only have the derived metadata
+	stored, ok, err := a.readSidecar(id)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		md.Name = stored.Name
+		md.ContentType = stored.ContentType
+	}
 	return &md, nil
 }
 
@@ -225,8 +334,8 @@ func (a *filesystemAdapter) List(ctx context.Context, pageSize int32, pageToken
 		if d.IsDir() {
 			return nil
 		}
-		// Only include leaf files (skip temp files)
-		if strings.HasPrefix(filepath.Base(path), "upload-") {
+		// Only include leaf files (skip temp files and metadata sidecars)
+		if strings.HasPrefix(filepath.Base(path), "upload-") || strings.HasSuffix(path, sidecarSuffix) {
 			return nil
 		}
 		rel, _ := filepath.Rel(a.root, path)
@@ -270,7 +379,7 @@ func (a *filesystemAdapter) List(ctx context.Context, pageSize int32, pageToken
 	return items, nextToken, nil
 }
 
-func (a *filesystemAdapter) GeneratePresignedURL(ctx context.Context, id filestore.FileID, expires time.Duration) (string, error) {
+func (a *filesystemAdapter) GeneratePresignedURL(ctx context.Context, id filestore.FileID, expires time.Duration, opts ...filestore.URLOption) (string, error) {
 	if a.baseURL == "" {
 		return "", fmt.Errorf("base URL not configured for presigned URLs")
 	}
@@ -281,18 +390,125 @@ func (a *filesystemAdapter) GeneratePresignedURL(ctx context.Context, id filesto
 	if !exists {
 		return "", os.ErrNotExist
 	}
-	token := a.generateToken(id, expires)
-	return fmt.Sprintf("%s/files/%s?token=%s", strings.TrimSuffix(a.baseURL, "/"), id, token), nil
+	disposition := filestore.ApplyURLOptions(opts...).ContentDisposition()
+	token := a.generateToken(id, expires, disposition)
+	u := fmt.Sprintf("%s/files/%s?token=%s", strings.TrimSuffix(a.baseURL, "/"), id, token)
+	if disposition != "" {
+		u += "&" + dispositionParam + "=" + url.QueryEscape(disposition)
+	}
+	return u, nil
 }
 
-func (a *filesystemAdapter) GetURL(ctx context.Context, id filestore.FileID) (string, error) {
+// GetURL returns an unsigned URL for the file. ServeHTTP refuses unsigned
+// requests, so a download name cannot be honoured here and returns
+// store.ErrNotSupported; use GeneratePresignedURL instead.
+func (a *filesystemAdapter) GetURL(ctx context.Context, id filestore.FileID, opts ...filestore.URLOption) (string, error) {
+	if filestore.ApplyURLOptions(opts...).ContentDisposition() != "" {
+		return "", fmt.Errorf("%w: download name on unsigned filesystem URLs", store.ErrNotSupported)
+	}
 	if a.baseURL == "" {
 		return "file://" + a.pathFor(id), nil
 	}
 	return fmt.Sprintf("%s/files/%s", strings.TrimSuffix(a.baseURL, "/"), id), nil
 }
 
+// dispositionParam carries the Content-Disposition of a file URL, named as
+// in S3 presigned URLs.
+const dispositionParam = "response-content-disposition"
+
+// ServeHTTP serves presigned URLs under /files/<id>. Every request must carry
+// a valid unexpired token, which also covers the disposition parameter
+// returned as the Content-Disposition header. Unsigned URLs from GetURL are
+// meant for a server publishing the root directly.
+func (a *filesystemAdapter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := filestore.FileID(strings.TrimPrefix(r.URL.Path, "/files/"))
+	if id == filestore.InvalidFileID || strings.ContainsAny(string(id), "/\\") || strings.HasSuffix(string(id), sidecarSuffix) {
+		http.NotFound(w, r)
+		return
+	}
+
+	q := r.URL.Query()
+	disposition := q.Get(dispositionParam)
+	if !a.validToken(id, q.Get("token"), disposition) {
+		http.Error(w, "invalid or expired token", http.StatusForbidden)
+		return
+	}
+
+	f, md, err := a.RetrieveSeeker(r.Context(), id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := os.Stat(a.pathFor(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if md.ContentType != "" {
+		w.Header().Set("Content-Type", md.ContentType)
+	}
+	if disposition != "" {
+		w.Header().Set("Content-Disposition", disposition)
+	}
+	http.ServeContent(w, r, string(id), info.ModTime(), f)
+}
+
 // Helper methods
+
+// sidecarSuffix is appended to a file's path to name its metadata sidecar.
+const sidecarSuffix = ".meta"
+
+func (a *filesystemAdapter) sidecarPath(id filestore.FileID) string {
+	return a.pathFor(id) + sidecarSuffix
+}
+
+// writeSidecar stores md next to the file using the configured codec.
+func (a *filesystemAdapter) writeSidecar(id filestore.FileID, md filestore.FileMetadata) error {
+	data, err := encodeSidecar(a.codec, md)
+	if err != nil {
+		return err
+	}
+	p := a.sidecarPath(id)
+	if err := os.WriteFile(p, data, a.fileMode); err != nil {
+		return err
+	}
+	return os.Chmod(p, a.fileMode)
+}
+
+// readSidecar loads the metadata sidecar of a file, reporting false if it has none.
+func (a *filesystemAdapter) readSidecar(id filestore.FileID) (filestore.FileMetadata, bool, error) {
+	data, err := os.ReadFile(a.sidecarPath(id))
+	if os.IsNotExist(err) {
+		return filestore.FileMetadata{}, false, nil
+	}
+	if err != nil {
+		return filestore.FileMetadata{}, false, err
+	}
+	md, err := decodeSidecar(data, a.codec, JSONCodec, GobCodec)
+	if err != nil {
+		return filestore.FileMetadata{}, false, fmt.Errorf("read metadata of %s: %w", id, err)
+	}
+	return md, true, nil
+}
+
+// ensureDir creates dir and any missing parents with the configured mode.
+func (a *filesystemAdapter) ensureDir(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := a.ensureDir(parent); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, a.dirMode); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return os.Chmod(dir, a.dirMode)
+}
+
 func (a *filesystemAdapter) shardPath(id filestore.FileID) string {
 	name := string(id)
 	if len(name) < 4 {
@@ -305,13 +521,36 @@ func (a *filesystemAdapter) pathFor(id filestore.FileID) string {
 	return filepath.Join(a.shardPath(id), string(id))
 }
 
-func (a *filesystemAdapter) generateToken(fileID filestore.FileID, expires time.Duration) string {
+func (a *filesystemAdapter) generateToken(fileID filestore.FileID, expires time.Duration, disposition string) string {
 	expiresAt := time.Now().Add(expires)
 	ts := strconv.FormatInt(expiresAt.Unix(), 10)
-	sig := a.generateSignature(string(fileID), ts)
+	sig := a.generateSignature(signedPath(fileID, disposition), ts)
 	return fmt.Sprintf("%s.%s", ts, sig)
 }
 
+// validToken reports whether token is an unexpired token for the file and disposition.
+func (a *filesystemAdapter) validToken(fileID filestore.FileID, token, disposition string) bool {
+	ts, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	expiresAt, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return false
+	}
+	want := a.generateSignature(signedPath(fileID, disposition), ts)
+	return hmac.Equal([]byte(sig), []byte(want))
+}
+
+// signedPath is the signed part of a presigned URL. The disposition is
+// included so it cannot be changed without invalidating the token.
+func signedPath(fileID filestore.FileID, disposition string) string {
+	if disposition == "" {
+		return string(fileID)
+	}
+	return string(fileID) + "|" + disposition
+}
+
 func (a *filesystemAdapter) generateSignature(path, timestamp string) string {
 	data := fmt.Sprintf("%s:%s", path, timestamp)
 	h := hmac.New(sha256.New, []byte(a.secretKey))
@@ -0,0 +1,277 @@
+package adapter_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"store"
+	filestore "store/files"
+	"store/files/adapter"
+)
+
+func openFilesystem(t *testing.T) filestore.FileStore {
+	t.Helper()
+	fs, err := adapter.NewFilesystem(adapter.FilesystemConfig{Root: t.TempDir()})
+	if err != nil {
+		t.Fatalf("open filesystem store: %v", err)
+	}
+	return fs
+}
+
+func storeFiles(t *testing.T, fs filestore.FileStore, n int) []filestore.FileID {
+	t.Helper()
+	repo := filestore.NewRepository(fs)
+	ids := make([]filestore.FileID, n)
+	for i := range ids {
+		id, _, err := repo.Save(context.Background(), fmt.Sprintf("f%d.txt", i), strings.NewReader(fmt.Sprintf("content %d", i)), "text/plain")
+		if err != nil {
+			t.Fatalf("save: %v", err)
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+func TestDeleteBatch(t *testing.T) {
+	fs := openFilesystem(t)
+	ctx := context.Background()
+	ids := storeFiles(t, fs, 20)
+
+	if err := fs.DeleteBatch(ctx, ids); err != nil {
+		t.Fatalf("delete batch: %v", err)
+	}
+	for _, id := range ids {
+		if exists, err := fs.Exists(ctx, id); err != nil || exists {
+			t.Errorf("%s still exists after batch delete (%v)", id, err)
+		}
+	}
+}
+
+func TestDeleteBatchPartialFailure(t *testing.T) {
+	fs := openFilesystem(t)
+	ctx := context.Background()
+	ids := storeFiles(t, fs, 3)
+	missing := filestore.FileID("00000000deadbeef")
+
+	res, err := fs.DeleteBatchPartial(ctx, append(ids, missing))
+	if err != nil {
+		t.Fatalf("delete batch: %v", err)
+	}
+	if len(res.Deleted) != 3 {
+		t.Errorf("expected 3 deleted files, got %v", res.Deleted)
+	}
+	if len(res.Failed) != 1 || !errors.Is(res.Failed[missing], os.ErrNotExist) {
+		t.Errorf("expected only the missing file to fail, got %v", res.Failed)
+	}
+
+	if err := fs.DeleteBatch(ctx, []filestore.FileID{missing}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected DeleteBatch to report the failure, got %v", err)
+	}
+}
+
+func TestPresignedURLContentDisposition(t *testing.T) {
+	fs, err := adapter.NewFilesystem(adapter.FilesystemConfig{
+		Root:      t.TempDir(),
+		BaseURL:   "http://files.example.com",
+		SecretKey: "secret",
+	})
+	if err != nil {
+		t.Fatalf("open filesystem store: %v", err)
+	}
+	ctx := context.Background()
+	id := storeFiles(t, fs, 1)[0]
+	handler := fs.(http.Handler)
+
+	tests := []struct {
+		name string
+		url  func() (string, error)
+		want string
+	}{
+		{
+			name: "presigned",
+			url: func() (string, error) {
+				return fs.GeneratePresignedURL(ctx, id, time.Minute, filestore.WithDownloadName("report.pdf"))
+			},
+			want: `attachment; filename=report.pdf`,
+		},
+		{
+			name: "quoted download name",
+			url: func() (string, error) {
+				return fs.GeneratePresignedURL(ctx, id, time.Minute, filestore.WithDownloadName("Q1 report.pdf"))
+			},
+			want: `attachment; filename="Q1 report.pdf"`,
+		},
+		{
+			name: "no download name",
+			url: func() (string, error) {
+				return fs.GeneratePresignedURL(ctx, id, time.Minute)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := tt.url()
+			if err != nil {
+				t.Fatalf("url: %v", err)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, u, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Disposition"); got != tt.want {
+				t.Errorf("Content-Disposition = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	signed, err := fs.GeneratePresignedURL(ctx, id, time.Minute, filestore.WithDownloadName("report.pdf"))
+	if err != nil {
+		t.Fatalf("url: %v", err)
+	}
+	if _, err := fs.GetURL(ctx, id, filestore.WithDownloadName("report.pdf")); !errors.Is(err, store.ErrNotSupported) {
+		t.Fatalf("GetURL with download name: err = %v, want ErrNotSupported", err)
+	}
+	public, err := fs.GetURL(ctx, id)
+	if err != nil {
+		t.Fatalf("url: %v", err)
+	}
+	sidecar, _, _ := strings.Cut(signed, "?")
+
+	refused := []struct {
+		name   string
+		url    string
+		status int
+	}{
+		{"tampered disposition", strings.Replace(signed, "report.pdf", "other.exe", 1), http.StatusForbidden},
+		{"unsigned disposition", strings.Replace(signed, "token=", "x=", 1), http.StatusForbidden},
+		{"public url", public, http.StatusForbidden},
+		{"metadata sidecar", sidecar + ".meta", http.StatusNotFound},
+	}
+	for _, tt := range refused {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestRetrieveSeeker(t *testing.T) {
+	fs := openFilesystem(t)
+	ctx := context.Background()
+	id, _, err := filestore.NewRepository(fs).Save(ctx, "digits.txt", strings.NewReader("0123456789"), "text/plain")
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	rs, md, err := fs.RetrieveSeeker(ctx, id)
+	if err != nil {
+		t.Fatalf("retrieve seeker: %v", err)
+	}
+	defer rs.Close()
+	if md.Size != 10 {
+		t.Errorf("size = %d, want 10", md.Size)
+	}
+
+	if _, err := rs.Seek(6, io.SeekStart); err != nil {
+		t.Fatalf("seek: %v", err)
+	}
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(rs, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf) != "678" {
+		t.Errorf("read %q after seek, want %q", buf, "678")
+	}
+
+	end, err := rs.Seek(-2, io.SeekEnd)
+	if err != nil || end != 8 {
+		t.Fatalf("seek from end = %d, %v", end, err)
+	}
+	rest, err := io.ReadAll(rs)
+	if err != nil || string(rest) != "89" {
+		t.Errorf("read %q, %v after seek from end, want %q", rest, err, "89")
+	}
+}
+
+func TestFilesystemModes(t *testing.T) {
+	tests := []struct {
+		name     string
+		dirMode  os.FileMode
+		fileMode os.FileMode
+		wantDir  os.FileMode
+		wantFile os.FileMode
+	}{
+		{name: "defaults", wantDir: adapter.DefaultDirMode, wantFile: adapter.DefaultFileMode},
+		{name: "group writable", dirMode: 0770, fileMode: 0660, wantDir: 0770, wantFile: 0660},
+		{name: "world readable", dirMode: 0775, fileMode: 0644, wantDir: 0775, wantFile: 0644},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := filepath.Join(t.TempDir(), "store")
+			fs, err := adapter.NewFilesystem(adapter.FilesystemConfig{Root: root, DirMode: tt.dirMode, FileMode: tt.fileMode})
+			if err != nil {
+				t.Fatalf("open filesystem store: %v", err)
+			}
+			id := storeFiles(t, fs, 1)[0]
+
+			name := string(id)
+			shard := filepath.Join(root, name[0:2], name[2:4])
+			for _, dir := range []string{root, filepath.Dir(shard), shard} {
+				info, err := os.Stat(dir)
+				if err != nil {
+					t.Fatalf("stat %s: %v", dir, err)
+				}
+				if got := info.Mode().Perm(); got != tt.wantDir {
+					t.Errorf("%s mode = %v, want %v", dir, got, tt.wantDir)
+				}
+			}
+
+			info, err := os.Stat(filepath.Join(shard, name))
+			if err != nil {
+				t.Fatalf("stat file: %v", err)
+			}
+			if got := info.Mode().Perm(); got != tt.wantFile {
+				t.Errorf("file mode = %v, want %v", got, tt.wantFile)
+			}
+		})
+	}
+}
+
+func TestWithMetrics(t *testing.T) {
+	metrics := store.NewMetrics("test")
+	fs := filestore.WithMetrics(openFilesystem(t), "filesystem", metrics)
+	ctx := context.Background()
+
+	id := storeFiles(t, fs, 1)[0]
+	if _, err := fs.Retrieve(ctx, id); err != nil {
+		t.Fatalf("retrieve: %v", err)
+	}
+	if _, err := fs.Retrieve(ctx, "missing"); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+
+	if count, _ := metrics.Operations("filesystem", "store"); count != 1 {
+		t.Errorf("expected 1 store, got %d", count)
+	}
+	if count, errs := metrics.Operations("filesystem", "retrieve"); count != 2 || errs != 1 {
+		t.Errorf("expected 2 retrievals with 1 error, got %d and %d", count, errs)
+	}
+	if _, ok := fs.(http.Handler); !ok {
+		t.Error("expected the wrapped store to keep serving HTTP")
+	}
+}
@@ -2,7 +2,10 @@ package filestore
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"sort"
 	"time"
 )
 
@@ -20,6 +23,30 @@ type FileMetadata struct {
 	ContentType string
 }
 
+// BatchDeleteResult reports the outcome of a batch delete.
+type BatchDeleteResult struct {
+	Deleted []FileID
+	Failed  map[FileID]error
+}
+
+// Err joins the errors of the failed deletes, or returns nil if all succeeded.
+func (r BatchDeleteResult) Err() error {
+	if len(r.Failed) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(r.Failed))
+	for id := range r.Failed {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+
+	errs := make([]error, 0, len(ids))
+	for _, id := range ids {
+		errs = append(errs, fmt.Errorf("delete %s: %w", id, r.Failed[FileID(id)]))
+	}
+	return errors.Join(errs...)
+}
+
 type File interface {
 	Metadata() FileMetadata
 	Stream() (io.ReadCloser, error)
@@ -41,9 +68,19 @@ type FileStore interface {
 	// Retrieve gets a file by ID
 	Retrieve(ctx context.Context, id FileID) (File, error)
 
+	// RetrieveSeeker returns a seekable reader for the file and its metadata.
+	// Streaming-only backends return store.ErrNotSupported.
+	RetrieveSeeker(ctx context.Context, id FileID) (io.ReadSeekCloser, *FileMetadata, error)
+
 	// Delete removes a file by ID
 	Delete(ctx context.Context, id FileID) error
 
+	// DeleteBatch removes several files; it fails if any of them could not be removed
+	DeleteBatch(ctx context.Context, ids []FileID) error
+
+	// DeleteBatchPartial removes several files and reports each one's outcome
+	DeleteBatchPartial(ctx context.Context, ids []FileID) (BatchDeleteResult, error)
+
 	// Exists checks if a file exists
 	Exists(ctx context.Context, id FileID) (bool, error)
 
@@ -54,8 +91,9 @@ type FileStore interface {
 	List(ctx context.Context, pageSize int32, pageToken string) ([]FileMetadata, string, error)
 
 	// GeneratePresignedURL creates a temporary URL for file access (if supported)
-	GeneratePresignedURL(ctx context.Context, id FileID, expiration time.Duration) (string, error)
+	GeneratePresignedURL(ctx context.Context, id FileID, expiration time.Duration, opts ...URLOption) (string, error)
 
-	// GetURL returns the URL for a file
-	GetURL(ctx context.Context, id FileID) (string, error)
+	// GetURL returns the URL for a file. Backends whose unsigned URLs cannot
+	// carry URL options return store.ErrNotSupported when given any.
+	GetURL(ctx context.Context, id FileID, opts ...URLOption) (string, error)
 }
@@ -0,0 +1,109 @@
+package filestore
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"store"
+)
+
+// metricsStore records the operations of a FileStore with a metrics recorder.
+type metricsStore struct {
+	FileStore
+	backend  string
+	recorder store.MetricsRecorder
+}
+
+// metricsHandlerStore is a metricsStore over a FileStore that also serves
+// its files over HTTP.
+type metricsHandlerStore struct {
+	*metricsStore
+	http.Handler
+}
+
+// WithMetrics wraps fs so the count, errors and latency of its operations
+// are recorded under backend (e.g. "filesystem"). A FileStore serving HTTP
+// keeps doing so. A nil recorder returns fs unchanged.
+func WithMetrics(fs FileStore, backend string, recorder store.MetricsRecorder) FileStore {
+	if recorder == nil {
+		return fs
+	}
+	ms := &metricsStore{FileStore: fs, backend: backend, recorder: recorder}
+	if h, ok := fs.(http.Handler); ok {
+		return metricsHandlerStore{metricsStore: ms, Handler: h}
+	}
+	return ms
+}
+
+func (s *metricsStore) record(op string, start time.Time, err error) {
+	s.recorder.ObserveOp(s.backend, op, time.Since(start), err)
+}
+
+func (s *metricsStore) Store(ctx context.Context, file File) (FileID, *FileMetadata, error) {
+	start := time.Now()
+	id, md, err := s.FileStore.Store(ctx, file)
+	s.record("store", start, err)
+	return id, md, err
+}
+
+func (s *metricsStore) Retrieve(ctx context.Context, id FileID) (File, error) {
+	start := time.Now()
+	f, err := s.FileStore.Retrieve(ctx, id)
+	s.record("retrieve", start, err)
+	return f, err
+}
+
+func (s *metricsStore) RetrieveSeeker(ctx context.Context, id FileID) (io.ReadSeekCloser, *FileMetadata, error) {
+	start := time.Now()
+	r, md, err := s.FileStore.RetrieveSeeker(ctx, id)
+	s.record("retrieve", start, err)
+	return r, md, err
+}
+
+func (s *metricsStore) Delete(ctx context.Context, id FileID) error {
+	start := time.Now()
+	err := s.FileStore.Delete(ctx, id)
+	s.record("delete", start, err)
+	return err
+}
+
+func (s *metricsStore) DeleteBatch(ctx context.Context, ids []FileID) error {
+	start := time.Now()
+	err := s.FileStore.DeleteBatch(ctx, ids)
+	s.record("delete_batch", start, err)
+	return err
+}
+
+func (s *metricsStore) DeleteBatchPartial(ctx context.Context, ids []FileID) (BatchDeleteResult, error) {
+	start := time.Now()
+	result, err := s.FileStore.DeleteBatchPartial(ctx, ids)
+	if err != nil {
+		s.record("delete_batch", start, err)
+	} else {
+		s.record("delete_batch", start, result.Err())
+	}
+	return result, err
+}
+
+func (s *metricsStore) Exists(ctx context.Context, id FileID) (bool, error) {
+	start := time.Now()
+	ok, err := s.FileStore.Exists(ctx, id)
+	s.record("exists", start, err)
+	return ok, err
+}
+
+func (s *metricsStore) GetMetadata(ctx context.Context, id FileID) (*FileMetadata, error) {
+	start := time.Now()
+	md, err := s.FileStore.GetMetadata(ctx, id)
+	s.record("get_metadata", start, err)
+	return md, err
+}
+
+func (s *metricsStore) List(ctx context.Context, pageSize int32, pageToken string) ([]FileMetadata, string, error) {
+	start := time.Now()
+	files, next, err := s.FileStore.List(ctx, pageSize, pageToken)
+	s.record("list", start, err)
+	return files, next, err
+}
@@ -57,6 +57,17 @@ func (r *Repository) Delete(ctx context.Context, id FileID) error {
 	return r.store.Delete(ctx, id)
 }
 
+// RetrieveSeeker opens a file for random access, for example for range
+// requests. It returns store.ErrNotSupported on streaming-only backends.
+func (r *Repository) RetrieveSeeker(ctx context.Context, id FileID) (io.ReadSeekCloser, *FileMetadata, error) {
+	return r.store.RetrieveSeeker(ctx, id)
+}
+
+// DeleteBatch removes several files by ID.
+func (r *Repository) DeleteBatch(ctx context.Context, ids []FileID) error {
+	return r.store.DeleteBatch(ctx, ids)
+}
+
 // List returns file metadata using store cursor params.
 // Note: Underlying adapters may not return encoded cursors; NextCursor will be the adapter token.
 func (r *Repository) List(ctx context.Context, params store.CursorParams) (store.CursorResult[FileMetadata], error) {
@@ -74,13 +85,13 @@ func (r *Repository) List(ctx context.Context, params store.CursorParams) (store
 }
 
 // URL returns a public URL for the file (if available).
-func (r *Repository) URL(ctx context.Context, id FileID) (string, error) {
-	return r.store.GetURL(ctx, id)
+func (r *Repository) URL(ctx context.Context, id FileID, opts ...URLOption) (string, error) {
+	return r.store.GetURL(ctx, id, opts...)
 }
 
 // PresignedURL returns a temporary signed URL when supported.
-func (r *Repository) PresignedURL(ctx context.Context, id FileID, expiration time.Duration) (string, error) {
-	return r.store.GeneratePresignedURL(ctx, id, expiration)
+func (r *Repository) PresignedURL(ctx context.Context, id FileID, expiration time.Duration, opts ...URLOption) (string, error) {
+	return r.store.GeneratePresignedURL(ctx, id, expiration, opts...)
 }
 
 // Helper: lightweight bytes reader without extra allocations.
@@ -0,0 +1,35 @@
+package filestore
+
+import "mime"
+
+// URLOptions configures generated file URLs.
+type URLOptions struct {
+	// DownloadName, when set, makes browsers download the file under this name.
+	DownloadName string
+}
+
+// URLOption configures URLOptions.
+type URLOption func(*URLOptions)
+
+// WithDownloadName sets the file name browsers save the file under.
+func WithDownloadName(name string) URLOption {
+	return func(o *URLOptions) { o.DownloadName = name }
+}
+
+// ApplyURLOptions returns the options resulting from opts.
+func ApplyURLOptions(opts ...URLOption) URLOptions {
+	var o URLOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// ContentDisposition returns the Content-Disposition value for the options,
+// or "" if no download name is set.
+func (o URLOptions) ContentDisposition() string {
+	if o.DownloadName == "" {
+		return ""
+	}
+	return mime.FormatMediaType("attachment", map[string]string{"filename": o.DownloadName})
+}
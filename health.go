@@ -0,0 +1,114 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HealthState is the coarse readiness of a backend.
+type HealthState string
+
+const (
+	HealthUp       HealthState = "up"
+	HealthDegraded HealthState = "degraded" // Reachable but slow or flaky
+	HealthDown     HealthState = "down"     // Unreachable
+)
+
+// DefaultDegradedLatency is the ping latency above which a healthy backend
+// is reported as degraded.
+const DefaultDegradedLatency = 250 * time.Millisecond
+
+// HealthStatus describes the result of a health probe.
+type HealthStatus struct {
+	State   HealthState
+	Latency time.Duration // Latency of the last probe attempt
+	Error   error         // Last probe error, if any
+}
+
+// IsUp reports whether the backend is fully healthy.
+func (s HealthStatus) IsUp() bool {
+	return s.State == HealthUp
+}
+
+// HealthWatcher pings a backend in the background and records when it last
+// answered. After a failed ping it calls reconnect so broken connections are
+// replaced before callers run into them.
+type HealthWatcher struct {
+	interval  time.Duration
+	ping      func(context.Context) error
+	reconnect func(context.Context) error
+
+	lastHealthy atomic.Int64 // unix nanoseconds
+	started     atomic.Bool
+	stopOnce    sync.Once
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+// NewHealthWatcher creates a watcher pinging every interval. Each check,
+// including a reconnect, is bounded by interval.
+func NewHealthWatcher(interval time.Duration, ping, reconnect func(context.Context) error) *HealthWatcher {
+	w := &HealthWatcher{
+		interval:  interval,
+		ping:      ping,
+		reconnect: reconnect,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	w.MarkHealthy()
+	return w
+}
+
+// Start runs the watcher until Stop is called.
+func (w *HealthWatcher) Start() {
+	w.started.Store(true)
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				w.check()
+			}
+		}
+	}()
+}
+
+// Stop stops the watcher and waits for a running check to finish.
+func (w *HealthWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+		if w.started.Load() {
+			<-w.done
+		}
+	})
+}
+
+// check pings once, reconnecting and pinging again after a failure.
+func (w *HealthWatcher) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), w.interval)
+	defer cancel()
+
+	if w.ping(ctx) == nil {
+		w.MarkHealthy()
+		return
+	}
+	if w.reconnect != nil && w.reconnect(ctx) == nil && w.ping(ctx) == nil {
+		w.MarkHealthy()
+	}
+}
+
+// MarkHealthy records that the backend answered just now.
+func (w *HealthWatcher) MarkHealthy() {
+	w.lastHealthy.Store(time.Now().UnixNano())
+}
+
+// LastHealthy returns when the backend last answered a ping.
+func (w *HealthWatcher) LastHealthy() time.Time {
+	return time.Unix(0, w.lastHealthy.Load())
+}
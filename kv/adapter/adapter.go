@@ -49,6 +49,13 @@ type Connection interface {
 	Delete(ctx context.Context, key string) error
 	Exists(ctx context.Context, key string) (bool, error)
 
+	// SetNX stores a value only if the key does not exist and reports whether it did.
+	SetNX(ctx context.Context, key string, value []byte, expiration time.Duration) (bool, error)
+
+	// CompareAndDelete deletes a key only if it holds the expected value and
+	// reports whether it did.
+	CompareAndDelete(ctx context.Context, key string, expected []byte) (bool, error)
+
 	// Batch operations
 	MGet(ctx context.Context, keys []string) (map[string][]byte, error)
 	MSet(ctx context.Context, pairs map[string][]byte, expiration time.Duration) error
@@ -68,6 +75,10 @@ type Connection interface {
 	Decr(ctx context.Context, key string) (int64, error)
 	DecrBy(ctx context.Context, key string, value int64) (int64, error)
 
+	// IncrWithExpire increments a key by 1 and, when the increment creates
+	// the key, sets its expiration in the same atomic step.
+	IncrWithExpire(ctx context.Context, key string, expiration time.Duration) (int64, error)
+
 	// Transaction support (if available)
 	Pipeline() Pipeline
 	Transaction() Transaction
@@ -78,6 +89,13 @@ type Connection interface {
 	Close() error
 }
 
+// BatchToucher is implemented by connections that can refresh the TTL of many
+// keys in one round trip (e.g. pipelined EXPIRE commands). The result reports
+// which keys existed.
+type BatchToucher interface {
+	TouchBatch(ctx context.Context, keys []string, expiration time.Duration) (map[string]bool, error)
+}
+
 // Pipeline represents a pipeline for batching operations.
 type Pipeline interface {
 	Get(key string) PipelineCmd
@@ -1,8 +1,11 @@
 package adapter
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,8 +21,34 @@ type MemoryStore struct {
 	mu    sync.RWMutex
 	data  map[string]*MemoryValue
 	stats *MemoryStats
+
+	// scans holds key snapshots of recent scans, by scan ID.
+	scans   map[string]*scanSnapshot
+	scanSeq uint64
+}
+
+// scanSnapshot is the sorted key set captured when a scan starts.
+type scanSnapshot struct {
+	keys    []string
+	created time.Time
+	seq     uint64
+}
+
+// scanSnapshotTTL bounds how long a scan snapshot is kept.
+const scanSnapshotTTL = 5 * time.Minute
+
+// maxScanSnapshots bounds the snapshots kept at once; the oldest is dropped
+// to make room for a new one.
+const maxScanSnapshots = 1000
+
+// expired reports whether the snapshot has outlived scanSnapshotTTL.
+func (snap *scanSnapshot) expired(now time.Time) bool {
+	return now.Sub(snap.created) > scanSnapshotTTL
 }
 
+// defaultScanCount is the page size used when Scan is called with count <= 0.
+const defaultScanCount = 10
+
 // MemoryValue represents a value in memory with expiration.
 type MemoryValue struct {
 	Data      []byte
@@ -49,6 +78,7 @@ func NewMemoryAdapter() *MemoryAdapter {
 		store: &MemoryStore{
 			data:  make(map[string]*MemoryValue),
 			stats: &MemoryStats{},
+			scans: make(map[string]*scanSnapshot),
 		},
 	}
 }
@@ -103,10 +133,64 @@ func (a *MemoryAdapter) Close() error {
 	// Clear all data
 	a.store.data = make(map[string]*MemoryValue)
 	a.store.stats = &MemoryStats{}
+	a.store.scans = make(map[string]*scanSnapshot)
 
 	return nil
 }
 
+// StartJanitor starts a background goroutine that evicts expired keys every
+// interval. The returned function stops it.
+func (a *MemoryAdapter) StartJanitor(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				a.store.evictExpired()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// evictExpired removes expired keys and abandoned scan snapshots.
+func (s *MemoryStore) evictExpired() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	evicted := 0
+	for key, value := range s.data {
+		if value.ExpiresAt != nil && now.After(*value.ExpiresAt) {
+			delete(s.data, key)
+			s.stats.Keys--
+			s.stats.Expired++
+			evicted++
+		}
+	}
+
+	s.expireSnapshots(now)
+
+	return evicted
+}
+
+// expireSnapshots removes the scan snapshots older than scanSnapshotTTL. The
+// caller holds s.mu.
+func (s *MemoryStore) expireSnapshots(now time.Time) {
+	for id, snap := range s.scans {
+		if snap.expired(now) {
+			delete(s.scans, id)
+		}
+	}
+}
+
 // MemoryConnection implementations
 
 // Get retrieves a value by key.
@@ -163,6 +247,52 @@ func (c *MemoryConnection) Set(ctx context.Context, key string, value []byte, ex
 	return nil
 }
 
+// SetNX stores a value only if the key is absent or expired.
+func (c *MemoryConnection) SetNX(ctx context.Context, key string, value []byte, expiration time.Duration) (bool, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	now := time.Now()
+	existing, exists := c.store.data[key]
+	if exists && (existing.ExpiresAt == nil || now.Before(*existing.ExpiresAt)) {
+		return false, nil
+	}
+
+	c.store.stats.Sets++
+	c.store.stats.LastAccessed = now
+	if !exists {
+		c.store.stats.Keys++
+	}
+
+	var expiresAt *time.Time
+	if expiration > 0 {
+		expires := now.Add(expiration)
+		expiresAt = &expires
+	}
+	c.store.data[key] = &MemoryValue{Data: value, ExpiresAt: expiresAt}
+	return true, nil
+}
+
+// CompareAndDelete removes a live key whose value equals expected.
+func (c *MemoryConnection) CompareAndDelete(ctx context.Context, key string, expected []byte) (bool, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	value, exists := c.store.data[key]
+	if !exists || (value.ExpiresAt != nil && time.Now().After(*value.ExpiresAt)) {
+		return false, nil
+	}
+	if !bytes.Equal(value.Data, expected) {
+		return false, nil
+	}
+
+	c.store.stats.Deletes++
+	c.store.stats.LastAccessed = time.Now()
+	delete(c.store.data, key)
+	c.store.stats.Keys--
+	return true, nil
+}
+
 // Delete removes a key.
 func (c *MemoryConnection) Delete(ctx context.Context, key string) error {
 	c.store.mu.Lock()
@@ -244,35 +374,129 @@ func (c *MemoryConnection) Keys(ctx context.Context, pattern string) ([]string,
 	return keys, nil
 }
 
+// Scan pages through keys matching pattern in sorted order. The matching key
+// set is snapshotted when a scan starts (empty cursor), and later pages are
+// served from that snapshot, so a scan sequence stays consistent while keys
+// are written, deleted or evicted concurrently. Keys removed after the scan
+// started may still be returned. Snapshots are kept for scanSnapshotTTL, even
+// once the scan finished, so the cursors of earlier pages can be revisited;
+// of more than maxScanSnapshots scans, the oldest expire early.
 func (c *MemoryConnection) Scan(ctx context.Context, cursor string, pattern string, count int) ([]string, string, error) {
-	keys, err := c.Keys(ctx, pattern)
-	if err != nil {
-		return nil, "", err
+	if count <= 0 {
+		count = defaultScanCount
 	}
 
-	// Simple pagination implementation
+	var id string
+	var keys []string
 	start := 0
-	if cursor != "" {
-		// Parse cursor (simplified)
-		for i, key := range keys {
-			if key == cursor {
-				start = i + 1
-				break
-			}
+
+	if cursor == "" {
+		keys = c.snapshotKeys(pattern)
+	} else {
+		var err error
+		id, start, err = parseScanCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+
+		var ok bool
+		if keys, ok = c.loadSnapshot(id); !ok {
+			return nil, "", fmt.Errorf("scan cursor expired: %s", cursor)
 		}
 	}
 
+	if start > len(keys) {
+		start = len(keys)
+	}
 	end := start + count
 	if end > len(keys) {
 		end = len(keys)
 	}
 
-	var nextCursor string
-	if end < len(keys) {
-		nextCursor = keys[end-1]
+	if end == len(keys) {
+		return keys[start:end], "", nil
 	}
 
-	return keys[start:end], nextCursor, nil
+	if id == "" {
+		id = c.saveSnapshot(keys)
+	}
+	return keys[start:end], fmt.Sprintf("%s:%d", id, end), nil
+}
+
+// snapshotKeys returns a sorted copy of the unexpired keys matching pattern.
+func (c *MemoryConnection) snapshotKeys(pattern string) []string {
+	c.store.mu.RLock()
+	defer c.store.mu.RUnlock()
+
+	now := time.Now()
+	var keys []string
+	for key, value := range c.store.data {
+		if value.ExpiresAt != nil && now.After(*value.ExpiresAt) {
+			continue
+		}
+		if matchPattern(key, pattern) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// dropOldestSnapshot removes the scan snapshot created first. The caller
+// holds s.mu.
+func (s *MemoryStore) dropOldestSnapshot() {
+	var oldest *scanSnapshot
+	var oldestID string
+	for id, snap := range s.scans {
+		if oldest == nil || snap.seq < oldest.seq {
+			oldest, oldestID = snap, id
+		}
+	}
+	delete(s.scans, oldestID)
+}
+
+// loadSnapshot returns the keys of the scan snapshot id, removing it when it
+// has expired.
+func (c *MemoryConnection) loadSnapshot(id string) ([]string, bool) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	snap, ok := c.store.scans[id]
+	if !ok {
+		return nil, false
+	}
+	if snap.expired(time.Now()) {
+		delete(c.store.scans, id)
+		return nil, false
+	}
+	return snap.keys, true
+}
+
+// saveSnapshot stores keys for the following pages of a scan and returns its
+// ID. Expired snapshots are removed first, so they do not pile up when no
+// janitor runs, and past maxScanSnapshots the oldest one is dropped.
+func (c *MemoryConnection) saveSnapshot(keys []string) string {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	c.store.expireSnapshots(time.Now())
+	if len(c.store.scans) >= maxScanSnapshots {
+		c.store.dropOldestSnapshot()
+	}
+	c.store.scanSeq++
+	id := strconv.FormatUint(c.store.scanSeq, 10)
+	c.store.scans[id] = &scanSnapshot{keys: keys, created: time.Now(), seq: c.store.scanSeq}
+	return id
+}
+
+// parseScanCursor splits a "<scan id>:<offset>" cursor.
+func parseScanCursor(cursor string) (string, int, error) {
+	id, offsetStr, ok := strings.Cut(cursor, ":")
+	offset, err := strconv.Atoi(offsetStr)
+	if !ok || id == "" || err != nil || offset < 0 {
+		return "", 0, fmt.Errorf("invalid scan cursor: %s", cursor)
+	}
+	return id, offset, nil
 }
 
 // Expiration operations
@@ -281,7 +505,7 @@ func (c *MemoryConnection) Expire(ctx context.Context, key string, expiration ti
 	defer c.store.mu.Unlock()
 
 	value, exists := c.store.data[key]
-	if !exists {
+	if !exists || (value.ExpiresAt != nil && time.Now().After(*value.ExpiresAt)) {
 		return fmt.Errorf("key not found: %s", key)
 	}
 
@@ -291,6 +515,26 @@ func (c *MemoryConnection) Expire(ctx context.Context, key string, expiration ti
 	return nil
 }
 
+// TouchBatch sets the expiration of every existing key under a single lock.
+func (c *MemoryConnection) TouchBatch(ctx context.Context, keys []string, expiration time.Duration) (map[string]bool, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	now := time.Now()
+	expires := now.Add(expiration)
+	result := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		value, exists := c.store.data[key]
+		if !exists || (value.ExpiresAt != nil && now.After(*value.ExpiresAt)) {
+			result[key] = false
+			continue
+		}
+		value.ExpiresAt = &expires
+		result[key] = true
+	}
+	return result, nil
+}
+
 func (c *MemoryConnection) TTL(ctx context.Context, key string) (time.Duration, error) {
 	c.store.mu.RLock()
 	defer c.store.mu.RUnlock()
@@ -321,8 +565,56 @@ func (c *MemoryConnection) IncrBy(ctx context.Context, key string, value int64)
 	c.store.mu.Lock()
 	defer c.store.mu.Unlock()
 
-	// Simplified implementation - would need proper integer handling
-	return value, fmt.Errorf("atomic operations not fully implemented in memory adapter")
+	return c.incrLocked(key, value, 0)
+}
+
+// IncrWithExpire increments key by 1, setting its expiration if it is created.
+func (c *MemoryConnection) IncrWithExpire(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	return c.incrLocked(key, 1, expiration)
+}
+
+// incrLocked adds delta to the decimal integer stored at key, treating a
+// missing or expired key as 0. A key it creates expires after expiration,
+// if positive; an existing key keeps its expiration.
+// The caller must hold the write lock.
+func (c *MemoryConnection) incrLocked(key string, delta int64, expiration time.Duration) (int64, error) {
+	now := time.Now()
+	value, exists := c.store.data[key]
+	if exists && value.ExpiresAt != nil && now.After(*value.ExpiresAt) {
+		exists = false
+	}
+
+	var current int64
+	if exists {
+		n, err := strconv.ParseInt(string(value.Data), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("value of %s is not an integer", key)
+		}
+		current = n
+	}
+	current += delta
+
+	c.store.stats.Sets++
+	c.store.stats.LastAccessed = now
+
+	if exists {
+		value.Data = []byte(strconv.FormatInt(current, 10))
+		return current, nil
+	}
+
+	if _, present := c.store.data[key]; !present {
+		c.store.stats.Keys++
+	}
+	var expiresAt *time.Time
+	if expiration > 0 {
+		expires := now.Add(expiration)
+		expiresAt = &expires
+	}
+	c.store.data[key] = &MemoryValue{Data: []byte(strconv.FormatInt(current, 10)), ExpiresAt: expiresAt}
+	return current, nil
 }
 
 func (c *MemoryConnection) Decr(ctx context.Context, key string) (int64, error) {
@@ -0,0 +1,157 @@
+package kvstore
+
+import (
+	"context"
+	"time"
+
+	"store"
+	"store/kv/adapter"
+)
+
+// metricsConnection records the operations of a connection with a metrics
+// recorder. Missing keys are reported as successful lookups, not errors.
+type metricsConnection struct {
+	adapter.Connection
+	adapter  adapter.Adapter
+	recorder store.MetricsRecorder
+}
+
+// batchMetricsConnection is a metricsConnection over a connection that
+// implements adapter.BatchToucher.
+type batchMetricsConnection struct {
+	*metricsConnection
+	toucher adapter.BatchToucher
+}
+
+// instrument wraps connection so its operations are recorded, unless
+// recorder is nil.
+func instrument(connection adapter.Connection, adpt adapter.Adapter, recorder store.MetricsRecorder) adapter.Connection {
+	if mc, ok := connection.(interface{ unwrap() adapter.Connection }); ok {
+		connection = mc.unwrap()
+	}
+	if recorder == nil || connection == nil {
+		return connection
+	}
+	mc := &metricsConnection{Connection: connection, adapter: adpt, recorder: recorder}
+	if bt, ok := connection.(adapter.BatchToucher); ok {
+		return batchMetricsConnection{metricsConnection: mc, toucher: bt}
+	}
+	return mc
+}
+
+func (c *metricsConnection) unwrap() adapter.Connection {
+	return c.Connection
+}
+
+func (c *metricsConnection) record(op string, start time.Time, err error) {
+	if err != nil && c.adapter.IsKeyNotFoundError(err) {
+		err = nil
+	}
+	c.recorder.ObserveOp(c.adapter.Name(), op, time.Since(start), err)
+}
+
+// observe runs fn and records it as op.
+func observe[T any](c *metricsConnection, op string, fn func() (T, error)) (T, error) {
+	start := time.Now()
+	v, err := fn()
+	c.record(op, start, err)
+	return v, err
+}
+
+func observeErr(c *metricsConnection, op string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	c.record(op, start, err)
+	return err
+}
+
+func (c *metricsConnection) Get(ctx context.Context, key string) ([]byte, error) {
+	return observe(c, "get", func() ([]byte, error) { return c.Connection.Get(ctx, key) })
+}
+
+func (c *metricsConnection) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	return observeErr(c, "set", func() error { return c.Connection.Set(ctx, key, value, expiration) })
+}
+
+func (c *metricsConnection) Delete(ctx context.Context, key string) error {
+	return observeErr(c, "delete", func() error { return c.Connection.Delete(ctx, key) })
+}
+
+func (c *metricsConnection) Exists(ctx context.Context, key string) (bool, error) {
+	return observe(c, "exists", func() (bool, error) { return c.Connection.Exists(ctx, key) })
+}
+
+func (c *metricsConnection) SetNX(ctx context.Context, key string, value []byte, expiration time.Duration) (bool, error) {
+	return observe(c, "setnx", func() (bool, error) { return c.Connection.SetNX(ctx, key, value, expiration) })
+}
+
+func (c *metricsConnection) CompareAndDelete(ctx context.Context, key string, expected []byte) (bool, error) {
+	return observe(c, "compare_and_delete", func() (bool, error) { return c.Connection.CompareAndDelete(ctx, key, expected) })
+}
+
+func (c *metricsConnection) MGet(ctx context.Context, keys []string) (map[string][]byte, error) {
+	return observe(c, "mget", func() (map[string][]byte, error) { return c.Connection.MGet(ctx, keys) })
+}
+
+func (c *metricsConnection) MSet(ctx context.Context, pairs map[string][]byte, expiration time.Duration) error {
+	return observeErr(c, "mset", func() error { return c.Connection.MSet(ctx, pairs, expiration) })
+}
+
+func (c *metricsConnection) MDelete(ctx context.Context, keys []string) error {
+	return observeErr(c, "mdelete", func() error { return c.Connection.MDelete(ctx, keys) })
+}
+
+func (c *metricsConnection) Keys(ctx context.Context, pattern string) ([]string, error) {
+	return observe(c, "keys", func() ([]string, error) { return c.Connection.Keys(ctx, pattern) })
+}
+
+func (c *metricsConnection) Scan(ctx context.Context, cursor string, pattern string, count int) ([]string, string, error) {
+	start := time.Now()
+	keys, next, err := c.Connection.Scan(ctx, cursor, pattern, count)
+	c.record("scan", start, err)
+	return keys, next, err
+}
+
+func (c *metricsConnection) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	return observeErr(c, "expire", func() error { return c.Connection.Expire(ctx, key, expiration) })
+}
+
+func (c *metricsConnection) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return observe(c, "ttl", func() (time.Duration, error) { return c.Connection.TTL(ctx, key) })
+}
+
+func (c *metricsConnection) Incr(ctx context.Context, key string) (int64, error) {
+	return observe(c, "incr", func() (int64, error) { return c.Connection.Incr(ctx, key) })
+}
+
+func (c *metricsConnection) IncrBy(ctx context.Context, key string, value int64) (int64, error) {
+	return observe(c, "incr", func() (int64, error) { return c.Connection.IncrBy(ctx, key, value) })
+}
+
+func (c *metricsConnection) Decr(ctx context.Context, key string) (int64, error) {
+	return observe(c, "decr", func() (int64, error) { return c.Connection.Decr(ctx, key) })
+}
+
+func (c *metricsConnection) DecrBy(ctx context.Context, key string, value int64) (int64, error) {
+	return observe(c, "decr", func() (int64, error) { return c.Connection.DecrBy(ctx, key, value) })
+}
+
+func (c *metricsConnection) IncrWithExpire(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	return observe(c, "incr", func() (int64, error) { return c.Connection.IncrWithExpire(ctx, key, expiration) })
+}
+
+func (c batchMetricsConnection) TouchBatch(ctx context.Context, keys []string, expiration time.Duration) (map[string]bool, error) {
+	return observe(c.metricsConnection, "touch_batch", func() (map[string]bool, error) {
+		return c.toucher.TouchBatch(ctx, keys, expiration)
+	})
+}
+
+// SetMetrics sets the recorder receiving the operation counts and latencies
+// of the service, replacing store.DefaultMetrics selected by
+// Config.EnableMetrics. A nil recorder disables metrics.
+func (s *Service) SetMetrics(recorder store.MetricsRecorder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics = recorder
+	s.connection = instrument(s.connection, s.adapter, recorder)
+}
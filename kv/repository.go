@@ -2,16 +2,48 @@ package kvstore
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
 
 	"core/entity"
 	"store"
 )
 
+// defaultStreamBatchSize is the number of keys scanned and fetched per round trip by StreamAll.
+const defaultStreamBatchSize = 100
+
+// defaultListBatchSize is the number of keys fetched per MGet by List.
+const defaultListBatchSize = 100
+
+// listPaginator bounds List pages by the CursorParams limit of 1000 rather
+// than the default maximum page size.
+var listPaginator = store.NewPaginatorWithConfig(func() store.PaginationConfig {
+	cfg := store.DefaultPaginationConfig()
+	cfg.MaxPageSize = 1000
+	return cfg
+}())
+
 // Repository provides KV storage implementing the standardized interface.
 type Repository struct {
 	*store.RepositoryBase
 	kvService *Service
 	keyPrefix string
+	indexed   []string
+
+	listBatchSize int
+
+	// paginator, when set, bounds List and ListByField pages and encodes
+	// their cursors instead of the default paginators.
+	paginator *store.Paginator
+}
+
+// Indexer is implemented by entities with fields that ListByField can look
+// up. Fields are named by their JSON keys.
+type Indexer interface {
+	IndexedFields() []string
 }
 
 // Ensure Repository implements store.Repository
@@ -22,10 +54,16 @@ func NewRepository(service *Service, ent entity.Entity) *Repository {
 	base := store.NewRepositoryBase(ent)
 	keyPrefix := entity.GetEntityName(ent) + ":"
 
+	var indexed []string
+	if ix, ok := ent.(Indexer); ok {
+		indexed = ix.IndexedFields()
+	}
+
 	return &Repository{
 		RepositoryBase: base,
 		kvService:      service,
 		keyPrefix:      keyPrefix,
+		indexed:        indexed,
 	}
 }
 
@@ -38,6 +76,7 @@ func (r *Repository) Create(ctx context.Context, ent entity.Entity) error {
 	}
 
 	r.SetTimestamps(ent, true)
+	r.SetAuditFields(ctx, ent, true)
 
 	key := r.keyPrefix + ent.GetID()
 
@@ -56,6 +95,10 @@ func (r *Repository) Create(ctx context.Context, ent entity.Entity) error {
 		return r.HandleUpdateError(err, "create", ent.GetID())
 	}
 
+	if err := r.updateIndex(ctx, nil, ent); err != nil {
+		return r.HandleUpdateError(err, "create_index", ent.GetID())
+	}
+
 	return nil
 }
 
@@ -86,27 +129,93 @@ func (r *Repository) Update(ctx context.Context, ent entity.Entity) error {
 	}
 
 	r.SetTimestamps(ent, false)
+	r.SetAuditFields(ctx, ent, false)
 
 	key := r.keyPrefix + ent.GetID()
 
-	// Check if entity exists
-	exists, err := r.kvService.Exists(ctx, key)
-	if err != nil {
-		return r.HandleGetError(err, "exists_check", ent.GetID())
-	}
+	// Check if entity exists; indexed entities load the stored copy to find stale index entries
+	var old entity.Entity
+	if len(r.indexed) > 0 {
+		stored, err := r.Get(ctx, ent.GetID())
+		if err != nil {
+			return err
+		}
+		old = stored
+	} else {
+		exists, err := r.kvService.Exists(ctx, key)
+		if err != nil {
+			return r.HandleGetError(err, "exists_check", ent.GetID())
+		}
 
-	if !exists {
-		return store.NewRecordNotFoundError(r.EntityName(), ent.GetID())
+		if !exists {
+			return store.NewRecordNotFoundError(r.EntityName(), ent.GetID())
+		}
 	}
 
-	err = r.kvService.SetJSON(ctx, key, ent, 0)
+	err := r.kvService.SetJSON(ctx, key, ent, 0)
 	if err != nil {
 		return r.HandleUpdateError(err, "update", ent.GetID())
 	}
 
+	if err := r.updateIndex(ctx, old, ent); err != nil {
+		return r.HandleUpdateError(err, "update_index", ent.GetID())
+	}
+
 	return nil
 }
 
+// Upsert stores ent with the given TTL (0 for no expiration), creating it if
+// absent and replacing it otherwise, and reports whether it was created.
+// Replacing an entity keeps its stored created_at.
+func (r *Repository) Upsert(ctx context.Context, ent entity.Entity, ttl time.Duration) (created bool, err error) {
+	if err := r.Validate(ctx, ent); err != nil {
+		return false, err
+	}
+
+	key := r.keyPrefix + ent.GetID()
+
+	stored, err := r.Get(ctx, ent.GetID())
+	if err != nil && !store.IsRecordNotFoundError(err) {
+		return false, err
+	}
+	if stored == nil {
+		r.SetTimestamps(ent, true)
+		r.SetAuditFields(ctx, ent, true)
+
+		data, err := json.Marshal(ent)
+		if err != nil {
+			return false, r.HandleUpdateError(err, "upsert", ent.GetID())
+		}
+		acquired, err := r.kvService.conn().SetNX(ctx, key, data, ttl)
+		if err != nil {
+			return false, r.HandleUpdateError(err, "upsert", ent.GetID())
+		}
+		if acquired {
+			if err := r.updateIndex(ctx, nil, ent); err != nil {
+				return true, r.HandleUpdateError(err, "upsert_index", ent.GetID())
+			}
+			return true, nil
+		}
+
+		// Another writer created the key since the lookup; replace its value
+		if stored, err = r.Get(ctx, ent.GetID()); err != nil {
+			return false, err
+		}
+	}
+
+	ent.SetCreatedAt(stored.GetCreatedAt())
+	r.SetTimestamps(ent, false)
+	r.SetAuditFields(ctx, ent, false)
+
+	if err := r.kvService.SetJSON(ctx, key, ent, ttl); err != nil {
+		return false, r.HandleUpdateError(err, "upsert", ent.GetID())
+	}
+	if err := r.updateIndex(ctx, stored, ent); err != nil {
+		return false, r.HandleUpdateError(err, "upsert_index", ent.GetID())
+	}
+	return false, nil
+}
+
 // Delete removes an entity by ID.
 func (r *Repository) Delete(ctx context.Context, id string) error {
 	if err := r.ValidateID(id); err != nil {
@@ -115,6 +224,15 @@ func (r *Repository) Delete(ctx context.Context, id string) error {
 
 	key := r.keyPrefix + id
 
+	var old entity.Entity
+	if len(r.indexed) > 0 {
+		stored, err := r.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		old = stored
+	}
+
 	err := r.kvService.Delete(ctx, key)
 	if err != nil {
 		if r.kvService.adapter.IsKeyNotFoundError(err) {
@@ -123,6 +241,10 @@ func (r *Repository) Delete(ctx context.Context, id string) error {
 		return r.HandleUpdateError(err, "delete", id)
 	}
 
+	if err := r.updateIndex(ctx, old, nil); err != nil {
+		return r.HandleUpdateError(err, "delete_index", id)
+	}
+
 	return nil
 }
 
@@ -220,16 +342,276 @@ func (r *Repository) FindFirst(ctx context.Context, conditions ...store.Conditio
 	return entities[0], nil
 }
 
-// List returns paginated results - simplified for KV stores.
+// List returns a page of the entities stored under the repository key prefix,
+// in scan order. Entities of the page are fetched with MGet in sub-batches of
+// the list batch size, so large pages do not block the store with one command.
+// Scans cannot run in reverse: PreviousCursor pages back over the pages
+// already listed, and params.Backward is not supported.
 func (r *Repository) List(ctx context.Context, params store.CursorParams) (store.CursorResult[entity.Entity], error) {
-	// KV stores don't have efficient listing - return empty for now
-	// In a real implementation, this would use pattern matching or indexing
+	if params.Backward {
+		return store.CursorResult[entity.Entity]{}, fmt.Errorf("%w: backward scans of %s", store.ErrNotSupported, r.EntityName())
+	}
+
+	paginator := r.paginator
+	if paginator == nil {
+		paginator = listPaginator
+	}
+
+	keys, next, previous, err := r.kvService.scanPage(ctx, paginator, r.keyPrefix+"*", params.PageSize, params.Cursor)
+	if err != nil {
+		return store.CursorResult[entity.Entity]{}, r.HandleQueryError(err, "list", map[string]any{"cursor": params.Cursor})
+	}
+
+	values, err := r.mgetBatched(ctx, keys)
+	if err != nil {
+		return store.CursorResult[entity.Entity]{}, r.HandleQueryError(err, "list", map[string]any{"cursor": params.Cursor})
+	}
+
+	items := make([]entity.Entity, 0, len(keys))
+	for _, key := range keys {
+		data, ok := values[key]
+		if !ok {
+			continue // deleted since it was scanned
+		}
+		ent := r.CreateNewEntity()
+		if err := json.Unmarshal(data, ent); err != nil {
+			return store.CursorResult[entity.Entity]{}, r.HandleQueryError(err, "list", map[string]any{"key": key})
+		}
+		items = append(items, ent)
+	}
+
 	return store.CursorResult[entity.Entity]{
-		Items:   []entity.Entity{},
-		HasMore: false,
+		Items:          items,
+		NextCursor:     next,
+		PreviousCursor: previous,
+		HasMore:        next != "",
+		TotalCount:     -1,
 	}, nil
 }
 
+// WithListBatchSize returns a copy of the repository whose List fetches at
+// most n keys per MGet. A value <= 0 uses defaultListBatchSize.
+func (r *Repository) WithListBatchSize(n int) *Repository {
+	cp := *r
+	cp.listBatchSize = n
+	return &cp
+}
+
+// WithPaginator returns a copy of the repository whose List and ListByField
+// bound page sizes and encode cursors with p, for instance to sign them with
+// a CursorSecret.
+func (r *Repository) WithPaginator(p *store.Paginator) *Repository {
+	cp := *r
+	cp.paginator = p
+	return &cp
+}
+
+// mgetBatched fetches keys with one MGet per list batch.
+func (r *Repository) mgetBatched(ctx context.Context, keys []string) (map[string][]byte, error) {
+	size := r.listBatchSize
+	if size <= 0 {
+		size = defaultListBatchSize
+	}
+
+	values := make(map[string][]byte, len(keys))
+	for start := 0; start < len(keys); start += size {
+		end := min(start+size, len(keys))
+		batch, err := r.kvService.MGet(ctx, keys[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range batch {
+			values[k] = v
+		}
+	}
+	return values, nil
+}
+
+// StreamAll invokes fn for every entity stored under the repository key prefix.
+// Keys are scanned in batches and each batch is fetched with a single MGet, so
+// at most one batch of entities is held in memory at a time. Iteration stops at
+// the first error returned by fn or when ctx is cancelled.
+func (r *Repository) StreamAll(ctx context.Context, fn func(entity.Entity) error) error {
+	pattern := r.keyPrefix + "*"
+	cursor := ""
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		keys, next, err := r.kvService.Scan(ctx, cursor, pattern, defaultStreamBatchSize)
+		if err != nil {
+			return r.HandleQueryError(err, "stream_all", map[string]any{"cursor": cursor})
+		}
+
+		if len(keys) > 0 {
+			values, err := r.kvService.MGet(ctx, keys)
+			if err != nil {
+				return r.HandleQueryError(err, "stream_all", map[string]any{"cursor": cursor})
+			}
+
+			// Walk keys rather than the map to preserve scan order
+			for _, key := range keys {
+				data, ok := values[key]
+				if !ok {
+					continue // expired or deleted between scan and fetch
+				}
+
+				ent := r.CreateNewEntity()
+				if err := json.Unmarshal(data, ent); err != nil {
+					return r.HandleQueryError(err, "stream_all", map[string]any{"key": key})
+				}
+				if err := fn(ent); err != nil {
+					return err
+				}
+			}
+		}
+
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// ListByField returns a page of entities whose indexed field equals value,
+// paging over the field's index entries. The cursor is an opaque token from
+// a previous call; an empty next cursor means there are no more pages.
+// Index entries whose entity changed since they were read are skipped, so a
+// page may hold fewer than pageSize entities.
+func (r *Repository) ListByField(ctx context.Context, field string, value any, pageSize int32, cursor string) ([]entity.Entity, string, error) {
+	if !r.isIndexed(field) {
+		return nil, "", fmt.Errorf("%w: field %q of %s is not indexed", store.ErrInvalidQuery, field, r.EntityName())
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, "", store.NewValidationErrorForField(field, value, err.Error())
+	}
+
+	prefix := r.indexPrefix(field, raw)
+	paginator := r.paginator
+	if paginator == nil {
+		paginator = store.NewPaginator()
+	}
+	indexKeys, next, err := r.kvService.ScanWithPaginator(ctx, paginator, prefix+"*", pageSize, cursor)
+	if err != nil {
+		return nil, "", r.HandleQueryError(err, "list_by_field", map[string]any{"field": field, "cursor": cursor})
+	}
+	if len(indexKeys) == 0 {
+		return []entity.Entity{}, next, nil
+	}
+
+	keys := make([]string, len(indexKeys))
+	for i, indexKey := range indexKeys {
+		keys[i] = r.keyPrefix + strings.TrimPrefix(indexKey, prefix)
+	}
+	values, err := r.kvService.MGet(ctx, keys)
+	if err != nil {
+		return nil, "", r.HandleQueryError(err, "list_by_field", map[string]any{"field": field, "cursor": cursor})
+	}
+
+	entities := make([]entity.Entity, 0, len(keys))
+	for _, key := range keys {
+		data, ok := values[key]
+		if !ok {
+			continue // deleted since it was indexed
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, "", r.HandleQueryError(err, "list_by_field", map[string]any{"key": key})
+		}
+		if string(fields[field]) != string(raw) {
+			continue // updated since it was indexed
+		}
+		ent := r.CreateNewEntity()
+		if err := json.Unmarshal(data, ent); err != nil {
+			return nil, "", r.HandleQueryError(err, "list_by_field", map[string]any{"key": key})
+		}
+		entities = append(entities, ent)
+	}
+
+	return entities, next, nil
+}
+
+// Index maintenance
+
+func (r *Repository) isIndexed(field string) bool {
+	for _, f := range r.indexed {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// indexPrefix is the key prefix of the index entries for field = value.
+// Values are base64 encoded so they cannot contain scan pattern characters.
+func (r *Repository) indexPrefix(field string, raw json.RawMessage) string {
+	return "idx:" + r.keyPrefix + field + ":" + base64.RawURLEncoding.EncodeToString(raw) + ":"
+}
+
+// indexKeys returns the index entries of ent, one per indexed field.
+func (r *Repository) indexKeys(ent entity.Entity) (map[string]bool, error) {
+	keys := make(map[string]bool, len(r.indexed))
+	if ent == nil {
+		return keys, nil
+	}
+	data, err := json.Marshal(ent)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for _, field := range r.indexed {
+		if raw, ok := fields[field]; ok {
+			keys[r.indexPrefix(field, raw)+ent.GetID()] = true
+		}
+	}
+	return keys, nil
+}
+
+// updateIndex replaces the index entries of old with those of current.
+// Either may be nil on create or delete.
+func (r *Repository) updateIndex(ctx context.Context, old, current entity.Entity) error {
+	if len(r.indexed) == 0 {
+		return nil
+	}
+	oldKeys, err := r.indexKeys(old)
+	if err != nil {
+		return err
+	}
+	newKeys, err := r.indexKeys(current)
+	if err != nil {
+		return err
+	}
+
+	var stale []string
+	for key := range oldKeys {
+		if !newKeys[key] {
+			stale = append(stale, key)
+		}
+	}
+	added := make(map[string][]byte)
+	for key := range newKeys {
+		if !oldKeys[key] {
+			added[key] = []byte{}
+		}
+	}
+
+	if len(stale) > 0 {
+		if err := r.kvService.MDelete(ctx, stale); err != nil {
+			return err
+		}
+	}
+	if len(added) > 0 {
+		return r.kvService.MSet(ctx, added, 0)
+	}
+	return nil
+}
+
 // Count returns the number of entities - limited for KV stores.
 func (r *Repository) Count(ctx context.Context, conditions ...store.Condition) (int64, error) {
 	// KV stores don't have efficient counting - return 0 for now
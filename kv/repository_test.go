@@ -0,0 +1,450 @@
+package kvstore_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"core/entity"
+	"store"
+	kvstore "store/kv"
+	"store/kv/adapter"
+)
+
+type session struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (s *session) GetID() string            { return s.ID }
+func (s *session) SetID(id string)          { s.ID = id }
+func (s *session) GetCreatedAt() time.Time  { return s.CreatedAt }
+func (s *session) SetCreatedAt(t time.Time) { s.CreatedAt = t }
+func (s *session) GetUpdatedAt() time.Time  { return s.UpdatedAt }
+func (s *session) SetUpdatedAt(t time.Time) { s.UpdatedAt = t }
+
+// recordingAdapter wraps the memory adapter and records batch command sizes.
+type recordingAdapter struct {
+	*adapter.MemoryAdapter
+
+	mu        sync.Mutex
+	mgetSizes []int
+}
+
+func (a *recordingAdapter) Connect(ctx context.Context, config *adapter.Config) (adapter.Connection, error) {
+	conn, err := a.MemoryAdapter.Connect(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingConnection{Connection: conn, adapter: a}, nil
+}
+
+type recordingConnection struct {
+	adapter.Connection
+	adapter *recordingAdapter
+}
+
+func (c *recordingConnection) MGet(ctx context.Context, keys []string) (map[string][]byte, error) {
+	c.adapter.mu.Lock()
+	c.adapter.mgetSizes = append(c.adapter.mgetSizes, len(keys))
+	c.adapter.mu.Unlock()
+	return c.Connection.MGet(ctx, keys)
+}
+
+func openRecordingService(t *testing.T) (*kvstore.Service, *recordingAdapter) {
+	t.Helper()
+	adpt := &recordingAdapter{MemoryAdapter: adapter.NewMemoryAdapter()}
+	config := store.MemoryConfig()
+	svc, err := kvstore.Open(context.Background(), adpt, &config)
+	if err != nil {
+		t.Fatalf("failed to open kv service: %v", err)
+	}
+	t.Cleanup(func() { _ = svc.Close() })
+	return svc, adpt
+}
+
+func seedSessions(t *testing.T, repo *kvstore.Repository, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		s := &session{ID: fmt.Sprintf("s%04d", i), UserID: "u1"}
+		if err := repo.Create(context.Background(), s); err != nil {
+			t.Fatalf("create %d: %v", i, err)
+		}
+	}
+}
+
+func TestStreamAll(t *testing.T) {
+	svc, adpt := openRecordingService(t)
+	repo := svc.Repository(&session{})
+	seedSessions(t, repo, 1050)
+
+	seen := make(map[string]bool)
+	err := repo.StreamAll(context.Background(), func(ent entity.Entity) error {
+		seen[ent.GetID()] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream failed: %v", err)
+	}
+	if len(seen) != 1050 {
+		t.Errorf("expected 1050 streamed entities, got %d", len(seen))
+	}
+
+	if len(adpt.mgetSizes) < 11 {
+		t.Errorf("expected batched MGet calls, got %d", len(adpt.mgetSizes))
+	}
+	for _, size := range adpt.mgetSizes {
+		if size > 100 {
+			t.Errorf("MGet batch of %d keys exceeds the stream batch size", size)
+		}
+	}
+}
+
+func TestStreamAllStopsOnCancel(t *testing.T) {
+	svc, _ := openRecordingService(t)
+	repo := svc.Repository(&session{})
+	seedSessions(t, repo, 250)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	count := 0
+	err := repo.StreamAll(ctx, func(entity.Entity) error {
+		count++
+		if count == 10 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if count >= 250 {
+		t.Errorf("expected streaming to stop early, streamed %d", count)
+	}
+}
+
+type indexedSession struct {
+	session
+}
+
+func (s *indexedSession) IndexedFields() []string { return []string{"user_id"} }
+
+// listAllByField pages through ListByField and returns the ids found.
+func listAllByField(t *testing.T, repo *kvstore.Repository, field string, value any, pageSize int32) []string {
+	t.Helper()
+	var ids []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatal("pagination did not terminate")
+		}
+		page, next, err := repo.ListByField(context.Background(), field, value, pageSize, cursor)
+		if err != nil {
+			t.Fatalf("list by field: %v", err)
+		}
+		if int32(len(page)) > pageSize {
+			t.Errorf("page of %d entities exceeds page size %d", len(page), pageSize)
+		}
+		for _, ent := range page {
+			ids = append(ids, ent.GetID())
+		}
+		if next == "" {
+			return ids
+		}
+		cursor = next
+	}
+}
+
+func TestListByFieldPaginatesIndex(t *testing.T) {
+	svc, _ := openRecordingService(t)
+	repo := svc.Repository(&indexedSession{})
+	ctx := context.Background()
+
+	for i := 0; i < 30; i++ {
+		user := "u1"
+		if i%6 == 0 {
+			user = "u2"
+		}
+		s := &indexedSession{session{ID: fmt.Sprintf("s%02d", i), UserID: user}}
+		if err := repo.Create(ctx, s); err != nil {
+			t.Fatalf("create %d: %v", i, err)
+		}
+	}
+
+	counts := func() map[string]int {
+		byID := make(map[string]int)
+		for _, user := range []string{"u1", "u2"} {
+			ids := listAllByField(t, repo, "user_id", user, 7)
+			seen := make(map[string]bool)
+			for _, id := range ids {
+				if seen[id] {
+					t.Errorf("%s: id %s listed twice", user, id)
+				}
+				seen[id] = true
+			}
+			byID[user] = len(ids)
+		}
+		return byID
+	}
+
+	if got := counts(); got["u1"] != 25 || got["u2"] != 5 {
+		t.Fatalf("expected 25/5 sessions per user, got %v", got)
+	}
+
+	moved := &indexedSession{session{ID: "s01", UserID: "u2"}}
+	if err := repo.Update(ctx, moved); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if err := repo.Delete(ctx, "s02"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	if got := counts(); got["u1"] != 23 || got["u2"] != 6 {
+		t.Errorf("expected 23/6 sessions per user after update and delete, got %v", got)
+	}
+
+	count := 0
+	if err := repo.StreamAll(ctx, func(entity.Entity) error { count++; return nil }); err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	if count != 29 {
+		t.Errorf("StreamAll saw %d entities, want 29 (index entries must not be listed)", count)
+	}
+}
+
+func TestListByFieldRejectsUnindexedField(t *testing.T) {
+	svc, _ := openRecordingService(t)
+	repo := svc.Repository(&session{})
+
+	_, _, err := repo.ListByField(context.Background(), "user_id", "u1", 10, "")
+	if !errors.Is(err, store.ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery, got %v", err)
+	}
+}
+
+func TestListByFieldSignsCursors(t *testing.T) {
+	svc, _ := openRecordingService(t)
+	repo := svc.Repository(&indexedSession{})
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		if err := repo.Create(ctx, &indexedSession{session{ID: fmt.Sprintf("s%d", i), UserID: "u1"}}); err != nil {
+			t.Fatalf("create %d: %v", i, err)
+		}
+	}
+
+	cfg := store.DefaultPaginationConfig()
+	cfg.CursorSecret = []byte("s3cret")
+	signed := repo.WithPaginator(store.NewPaginatorWithConfig(cfg))
+	if ids := listAllByField(t, signed, "user_id", "u1", 2); len(ids) != 5 {
+		t.Errorf("expected signed cursors to page through 5 sessions, got %v", ids)
+	}
+
+	_, next, err := repo.ListByField(ctx, "user_id", "u1", 2, "")
+	if err != nil {
+		t.Fatalf("list by field: %v", err)
+	}
+	_, _, err = signed.ListByField(ctx, "user_id", "u1", 2, next)
+	if !store.IsValidationError(err) || !errors.Is(err, store.ErrCursorTampered) {
+		t.Errorf("expected an unsigned cursor to be rejected as tampered, got %v", err)
+	}
+}
+
+func TestUpsertReportsCreated(t *testing.T) {
+	svc, _ := openRecordingService(t)
+	repo := svc.Repository(&session{})
+	ctx := context.Background()
+
+	created, err := repo.Upsert(ctx, &session{ID: "s1", UserID: "u1"}, 0)
+	if err != nil {
+		t.Fatalf("upsert new: %v", err)
+	}
+	if !created {
+		t.Error("expected upsert of a new key to report created")
+	}
+	first, err := repo.Get(ctx, "s1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	created, err = repo.Upsert(ctx, &session{ID: "s1", UserID: "u2"}, time.Minute)
+	if err != nil {
+		t.Fatalf("upsert existing: %v", err)
+	}
+	if created {
+		t.Error("expected upsert of an existing key to report not created")
+	}
+
+	got, err := repo.Get(ctx, "s1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	s := got.(*session)
+	if s.UserID != "u2" {
+		t.Errorf("expected the value to be replaced, got user %q", s.UserID)
+	}
+	if !s.CreatedAt.Equal(first.GetCreatedAt()) {
+		t.Errorf("expected created_at %v to be preserved, got %v", first.GetCreatedAt(), s.CreatedAt)
+	}
+	if !s.UpdatedAt.After(first.GetUpdatedAt()) {
+		t.Errorf("expected updated_at to advance past %v, got %v", first.GetUpdatedAt(), s.UpdatedAt)
+	}
+	if ttl, err := svc.TTL(ctx, "session:s1"); err != nil || ttl <= 0 {
+		t.Errorf("expected the upsert TTL to apply, got %v (err %v)", ttl, err)
+	}
+}
+
+func TestListSubBatchesMGet(t *testing.T) {
+	svc, adpt := openRecordingService(t)
+	repo := svc.Repository(&session{})
+	seedSessions(t, repo, 250)
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		repo  *kvstore.Repository
+		sizes []int
+	}{
+		{name: "default batch size", repo: repo, sizes: []int{100, 100, 50}},
+		{name: "configured batch size", repo: repo.WithListBatchSize(120), sizes: []int{120, 120, 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adpt.mu.Lock()
+			adpt.mgetSizes = nil
+			adpt.mu.Unlock()
+
+			page, err := tt.repo.List(ctx, store.CursorParams{PageSize: 250})
+			if err != nil {
+				t.Fatalf("list: %v", err)
+			}
+			seen := make(map[string]bool)
+			for _, ent := range page.Items {
+				seen[ent.GetID()] = true
+			}
+			if len(seen) != 250 {
+				t.Errorf("expected 250 entities, got %d", len(seen))
+			}
+			if fmt.Sprint(adpt.mgetSizes) != fmt.Sprint(tt.sizes) {
+				t.Errorf("expected MGet batches %v, got %v", tt.sizes, adpt.mgetSizes)
+			}
+		})
+	}
+}
+
+func TestListPaginates(t *testing.T) {
+	svc, _ := openRecordingService(t)
+	repo := svc.Repository(&session{})
+	seedSessions(t, repo, 25)
+	ctx := context.Background()
+
+	seen := make(map[string]bool)
+	params := store.CursorParams{PageSize: 10}
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("pagination did not terminate")
+		}
+		page, err := repo.List(ctx, params)
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		for _, ent := range page.Items {
+			if seen[ent.GetID()] {
+				t.Errorf("entity %s listed twice", ent.GetID())
+			}
+			seen[ent.GetID()] = true
+		}
+		if !page.HasMore {
+			break
+		}
+		params.Cursor = page.NextCursor
+	}
+	if len(seen) != 25 {
+		t.Errorf("expected 25 entities across pages, got %d", len(seen))
+	}
+}
+
+func TestListPreviousCursor(t *testing.T) {
+	svc, _ := openRecordingService(t)
+	repo := svc.Repository(&session{})
+	seedSessions(t, repo, 25)
+	ctx := context.Background()
+
+	list := func(params store.CursorParams) store.CursorResult[entity.Entity] {
+		t.Helper()
+		page, err := repo.List(ctx, params)
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		return page
+	}
+	ids := func(page store.CursorResult[entity.Entity]) string {
+		var ids []string
+		for _, ent := range page.Items {
+			ids = append(ids, ent.GetID())
+		}
+		return strings.Join(ids, ",")
+	}
+
+	var pages []store.CursorResult[entity.Entity]
+	params := store.CursorParams{PageSize: 10}
+	for {
+		page := list(params)
+		pages = append(pages, page)
+		if !page.HasMore {
+			break
+		}
+		params.Cursor = page.NextCursor
+	}
+	if len(pages) != 3 {
+		t.Fatalf("expected 3 pages, got %d", len(pages))
+	}
+	if pages[0].PreviousCursor != "" {
+		t.Error("expected no previous cursor on the first page")
+	}
+
+	for i := len(pages) - 1; i > 0; i-- {
+		back := list(store.CursorParams{PageSize: 10, Cursor: pages[i].PreviousCursor})
+		if ids(back) != ids(pages[i-1]) {
+			t.Errorf("page %d: expected previous page %s, got %s", i, ids(pages[i-1]), ids(back))
+		}
+		if again := list(store.CursorParams{PageSize: 10, Cursor: back.NextCursor}); ids(again) != ids(pages[i]) {
+			t.Errorf("page %d: expected the previous page to lead back to %s, got %s", i, ids(pages[i]), ids(again))
+		}
+	}
+
+	if _, err := repo.List(ctx, store.CursorParams{PageSize: 10, Backward: true}); !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("expected backward scans to be unsupported, got %v", err)
+	}
+}
+
+func TestTypedRepository(t *testing.T) {
+	svc, _ := openRecordingService(t)
+	repo := kvstore.TypedRepository(svc, &session{})
+	ctx := context.Background()
+
+	if err := repo.Create(ctx, &session{ID: "s1", UserID: "u1"}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	s, err := repo.Get(ctx, "s1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if s.UserID != "u1" {
+		t.Errorf("unexpected session: %+v", s)
+	}
+
+	page, err := repo.List(ctx, store.CursorParams{PageSize: 10})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(page.Items) != 1 || page.Items[0].ID != "s1" {
+		t.Errorf("unexpected page: %+v", page.Items)
+	}
+}
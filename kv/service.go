@@ -2,8 +2,12 @@ package kvstore
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
 	"time"
 
 	"core/entity"
@@ -14,9 +18,14 @@ import (
 // Service wraps a KV adapter and provides the key-value service interface.
 // This follows the guard service pattern and extends the shared service base.
 type Service struct {
-	adapter    adapter.Adapter
+	adapter adapter.Adapter
+	config  *store.Config
+	watcher *store.HealthWatcher
+
+	mu         sync.RWMutex // guards connection, replaced on reconnect
 	connection adapter.Connection
-	config     *store.Config
+	metrics    store.MetricsRecorder
+	logger     store.Logger
 }
 
 // Ensure Service implements the service interface.
@@ -24,14 +33,30 @@ var _ store.Service = (*Service)(nil)
 
 // NewService creates a new KV service with the given adapter.
 func NewService(adpt adapter.Adapter, config *store.Config) *Service {
-	return &Service{
+	s := &Service{
 		adapter: adpt,
 		config:  config,
+		logger:  store.NopLogger,
+	}
+	if config != nil && config.EnableMetrics {
+		s.metrics = store.DefaultMetrics
 	}
+	return s
 }
 
 // Connect establishes the key-value store connection.
 func (s *Service) Connect(ctx context.Context) error {
+	if err := s.connect(ctx); err != nil {
+		s.logger.Log(ctx, slog.LevelError, "key-value store connection failed",
+			"backend", s.adapter.Name(), "host", s.config.Host, "error", err)
+		return err
+	}
+	s.logger.Log(ctx, slog.LevelInfo, "connected to key-value store",
+		"backend", s.adapter.Name(), "host", s.config.Host)
+	return nil
+}
+
+func (s *Service) connect(ctx context.Context) error {
 	connection, err := s.adapter.Connect(ctx, s.config)
 	if err != nil {
 		return store.WrapConnectionError(err, "connect", s.adapter.Name(), s.config.Host)
@@ -50,13 +75,78 @@ func (s *Service) Connect(ctx context.Context) error {
 		return store.WrapConnectionError(err, "ping", s.adapter.Name(), s.config.Host)
 	}
 
-	s.connection = connection
+	s.mu.Lock()
+	s.connection = instrument(connection, s.adapter, s.metrics)
+	s.mu.Unlock()
+
+	if s.config.HealthCheckInterval > 0 {
+		s.watcher = store.NewHealthWatcher(s.config.HealthCheckInterval, s.ping, s.reconnect)
+		s.watcher.Start()
+	}
 	return nil
 }
 
+// conn returns the current connection.
+func (s *Service) conn() adapter.Connection {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.connection
+}
+
+// ping pings the current connection.
+func (s *Service) ping(ctx context.Context) error {
+	return s.conn().Ping(ctx)
+}
+
+// reconnect replaces the connection after a failed health check. The old
+// connection is closed once the new one answers.
+func (s *Service) reconnect(ctx context.Context) error {
+	s.logger.Log(ctx, slog.LevelWarn, "key-value store health check failed, reconnecting",
+		"backend", s.adapter.Name(), "host", s.config.Host)
+	connection, err := s.adapter.Connect(ctx, s.config)
+	if err == nil {
+		if err = connection.Ping(ctx); err != nil {
+			_ = connection.Close()
+		}
+	}
+	if err != nil {
+		s.logger.Log(ctx, slog.LevelError, "key-value store reconnect failed",
+			"backend", s.adapter.Name(), "host", s.config.Host, "error", err)
+		return err
+	}
+
+	s.mu.Lock()
+	old := s.connection
+	s.connection = instrument(connection, s.adapter, s.metrics)
+	s.mu.Unlock()
+	if old != nil {
+		_ = old.Close()
+	}
+	return nil
+}
+
+// SetLogger sets the logger receiving connection events. A nil logger
+// discards them, which is the default.
+func (s *Service) SetLogger(logger store.Logger) {
+	if logger == nil {
+		logger = store.NopLogger
+	}
+	s.logger = logger
+}
+
+// LastHealthy returns when the store last answered a ping of the health
+// watcher. It is the zero time unless Config.HealthCheckInterval enables
+// the watcher.
+func (s *Service) LastHealthy() time.Time {
+	if s.watcher == nil {
+		return time.Time{}
+	}
+	return s.watcher.LastHealthy()
+}
+
 // Connection returns the underlying connection.
 func (s *Service) Connection() adapter.Connection {
-	return s.connection
+	return s.conn()
 }
 
 // Adapter returns the underlying adapter.
@@ -66,16 +156,19 @@ func (s *Service) Adapter() adapter.Adapter {
 
 // Close closes the connection.
 func (s *Service) Close() error {
-	if s.connection != nil {
-		return s.connection.Close()
+	if s.watcher != nil {
+		s.watcher.Stop()
+	}
+	if connection := s.conn(); connection != nil {
+		return connection.Close()
 	}
 	return nil
 }
 
 // Stats returns connection statistics.
 func (s *Service) Stats() interface{} {
-	if s.connection != nil {
-		return s.connection.Stats()
+	if connection := s.conn(); connection != nil {
+		return connection.Stats()
 	}
 	return nil
 }
@@ -90,6 +183,12 @@ func (s *Service) Repository(entity entity.Entity) *Repository {
 	return NewRepository(s, entity)
 }
 
+// TypedRepository creates a repository for entities of type T, returning
+// them as T. ent is the prototype of the entity, as for NewRepository.
+func TypedRepository[T entity.Entity](s *Service, ent T) *store.TypedRepository[T] {
+	return store.NewTypedRepository[T](NewRepository(s, ent))
+}
+
 // WithTimeout creates a context with timeout for operations.
 func (s *Service) WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
 	return context.WithTimeout(ctx, timeout)
@@ -99,29 +198,29 @@ func (s *Service) WithTimeout(ctx context.Context, timeout time.Duration) (conte
 
 // Get retrieves a value by key.
 func (s *Service) Get(ctx context.Context, key string) ([]byte, error) {
-	return s.connection.Get(ctx, key)
+	return s.conn().Get(ctx, key)
 }
 
 // Set stores a value with optional expiration.
 func (s *Service) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
-	return s.connection.Set(ctx, key, value, expiration)
+	return s.conn().Set(ctx, key, value, expiration)
 }
 
 // Delete removes a key.
 func (s *Service) Delete(ctx context.Context, key string) error {
-	return s.connection.Delete(ctx, key)
+	return s.conn().Delete(ctx, key)
 }
 
 // Exists checks if a key exists.
 func (s *Service) Exists(ctx context.Context, key string) (bool, error) {
-	return s.connection.Exists(ctx, key)
+	return s.conn().Exists(ctx, key)
 }
 
 // JSON operations for entities
 
 // GetJSON retrieves and unmarshals a JSON value.
 func (s *Service) GetJSON(ctx context.Context, key string, target interface{}) error {
-	data, err := s.connection.Get(ctx, key)
+	data, err := s.conn().Get(ctx, key)
 	if err != nil {
 		return err
 	}
@@ -136,79 +235,289 @@ func (s *Service) SetJSON(ctx context.Context, key string, value interface{}, ex
 		return fmt.Errorf("failed to marshal JSON: %w", err)
 	}
 
-	return s.connection.Set(ctx, key, data, expiration)
+	return s.conn().Set(ctx, key, data, expiration)
 }
 
 // Batch operations
 
 // MGet retrieves multiple values.
 func (s *Service) MGet(ctx context.Context, keys []string) (map[string][]byte, error) {
-	return s.connection.MGet(ctx, keys)
+	return s.conn().MGet(ctx, keys)
 }
 
 // MSet stores multiple values.
 func (s *Service) MSet(ctx context.Context, pairs map[string][]byte, expiration time.Duration) error {
-	return s.connection.MSet(ctx, pairs, expiration)
+	return s.conn().MSet(ctx, pairs, expiration)
 }
 
 // MDelete removes multiple keys.
 func (s *Service) MDelete(ctx context.Context, keys []string) error {
-	return s.connection.MDelete(ctx, keys)
+	return s.conn().MDelete(ctx, keys)
 }
 
 // Pattern operations
 
 // Keys returns all keys matching a pattern.
 func (s *Service) Keys(ctx context.Context, pattern string) ([]string, error) {
-	return s.connection.Keys(ctx, pattern)
+	return s.conn().Keys(ctx, pattern)
 }
 
 // Scan returns keys matching a pattern with pagination.
 func (s *Service) Scan(ctx context.Context, cursor string, pattern string, count int) ([]string, string, error) {
-	return s.connection.Scan(ctx, cursor, pattern, count)
+	return s.conn().Scan(ctx, cursor, pattern, count)
 }
 
 // ScanWithPagination returns keys with standard pagination.
+// The cursor is an opaque token produced by a previous call; the adapter's native
+// scan position is carried inside it so callers never see backend-specific cursors.
 func (s *Service) ScanWithPagination(ctx context.Context, pattern string, pageSize int32, cursor string) ([]string, string, error) {
-	// Use the new cursor-based pagination
-	paginator := store.NewPaginator()
+	return s.ScanWithPaginator(ctx, store.NewPaginator(), pattern, pageSize, cursor)
+}
+
+// ScanWithPaginator is ScanWithPagination with page sizes bounded and
+// cursors encoded by paginator, for instance to sign them with a
+// CursorSecret.
+func (s *Service) ScanWithPaginator(ctx context.Context, paginator *store.Paginator, pattern string, pageSize int32, cursor string) ([]string, string, error) {
+	keys, next, _, err := s.scanPage(ctx, paginator, pattern, pageSize, cursor)
+	return keys, next, err
+}
+
+// maxCursorTrail bounds the scan positions a cursor remembers to page back
+// through; older pages can only be reached again from the first page.
+const maxCursorTrail = 100
+
+// scanPage is ScanWithPaginator also returning a cursor to the previous
+// page. Scans only run forward, so that cursor is rebuilt from the trail of
+// page start positions each cursor carries.
+func (s *Service) scanPage(ctx context.Context, paginator *store.Paginator, pattern string, pageSize int32, cursor string) (keys []string, next, previous string, err error) {
 	params := paginator.ParseParams(pageSize, cursor)
 
-	return s.connection.Scan(ctx, cursor, pattern, int(params.PageSize))
+	decoded, err := paginator.DecodeCursor(params.Cursor)
+	if err != nil {
+		return nil, "", "", store.WrapValidationError(err, "cursor", cursor)
+	}
+
+	scanCursor := ""
+	var trail []string
+	if decoded != nil {
+		scanCursor = decoded.LastSort
+		trail = decoded.Trail
+	}
+
+	keys, nextScan, err := s.conn().Scan(ctx, scanCursor, pattern, int(params.PageSize))
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	if n := len(trail); n > 0 {
+		prev := paginator.CreateCursor("", time.Time{}, trail[n-1], params.PageSize)
+		prev.Trail = trail[:n-1]
+		if previous, err = paginator.EncodeCursor(prev); err != nil {
+			return nil, "", "", err
+		}
+	}
+
+	if nextScan == "" {
+		return keys, "", previous, nil
+	}
+
+	lastKey := ""
+	if len(keys) > 0 {
+		lastKey = keys[len(keys)-1]
+	}
+
+	nextCursor := paginator.CreateCursor(lastKey, time.Time{}, nextScan, params.PageSize)
+	nextCursor.Trail = append(slices.Clip(trail), scanCursor)
+	if len(nextCursor.Trail) > maxCursorTrail {
+		nextCursor.Trail = nextCursor.Trail[len(nextCursor.Trail)-maxCursorTrail:]
+	}
+	if next, err = paginator.EncodeCursor(nextCursor); err != nil {
+		return nil, "", "", err
+	}
+
+	return keys, next, previous, nil
 }
 
 // Expiration operations
 
 // Expire sets expiration for a key.
 func (s *Service) Expire(ctx context.Context, key string, expiration time.Duration) error {
-	return s.connection.Expire(ctx, key, expiration)
+	return s.conn().Expire(ctx, key, expiration)
 }
 
 // TTL returns time-to-live for a key.
 func (s *Service) TTL(ctx context.Context, key string) (time.Duration, error) {
-	return s.connection.TTL(ctx, key)
+	return s.conn().TTL(ctx, key)
+}
+
+// Touch extends the TTL of key without rewriting its value.
+// It reports whether the key existed.
+func (s *Service) Touch(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	err := s.conn().Expire(ctx, key, ttl)
+	if err != nil {
+		if s.adapter.IsKeyNotFoundError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// TouchBatch extends the TTL of several keys and reports which of them existed.
+// Connections implementing adapter.BatchToucher do this in one round trip.
+func (s *Service) TouchBatch(ctx context.Context, keys []string, ttl time.Duration) (map[string]bool, error) {
+	if bt, ok := s.conn().(adapter.BatchToucher); ok {
+		return bt.TouchBatch(ctx, keys, ttl)
+	}
+
+	result := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		existed, err := s.Touch(ctx, key, ttl)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = existed
+	}
+	return result, nil
+}
+
+// Idempotency
+
+// RunOnce state markers, stored as the first byte of the key's value.
+const (
+	runOncePending byte = 0
+	runOnceDone    byte = 1
+)
+
+// runOncePoll is how often RunOnce checks for a result computed elsewhere.
+const runOncePoll = 10 * time.Millisecond
+
+// RunOnce runs fn at most once per key within ttl and returns its result.
+// Concurrent and later callers with the same key wait for and receive the
+// stored result instead of running fn. If fn fails the key is released, so
+// a later call runs it again. The pending marker carries a random token and
+// is only released while it still holds it, so a caller whose marker expired
+// does not release the key of the caller that took over.
+func (s *Service) RunOnce(ctx context.Context, key string, ttl time.Duration, fn func() ([]byte, error)) ([]byte, error) {
+	pending := make([]byte, 1+lockTokenSize)
+	pending[0] = runOncePending
+	if _, err := rand.Read(pending[1:]); err != nil {
+		return nil, err
+	}
+
+	for {
+		acquired, err := s.conn().SetNX(ctx, key, pending, ttl)
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			result, err := fn()
+			if err != nil {
+				_, _ = s.conn().CompareAndDelete(context.WithoutCancel(ctx), key, pending)
+				return nil, err
+			}
+			if err := s.conn().Set(ctx, key, append([]byte{runOnceDone}, result...), ttl); err != nil {
+				return nil, err
+			}
+			return result, nil
+		}
+
+		value, err := s.conn().Get(ctx, key)
+		switch {
+		case err != nil && s.adapter.IsKeyNotFoundError(err):
+			continue // released or expired meanwhile; try to acquire it
+		case err != nil:
+			return nil, err
+		case len(value) > 0 && value[0] == runOnceDone:
+			return value[1:], nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(runOncePoll):
+		}
+	}
+}
+
+// Locking
+
+// lockTokenSize is the number of random bytes identifying a lock holder.
+const lockTokenSize = 16
+
+// Lock tries to acquire a lock on key that expires after ttl unless released.
+// The lock stores a random token, and unlock deletes the key only while it
+// still holds that token, so a holder whose lock expired cannot release a
+// lock acquired by someone else; unlock then returns store.ErrLockNotHeld.
+// acquired is false, with a nil unlock, if another holder has the lock.
+// A ttl <= 0 is rejected with store.ErrInvalidInput, as the lock would
+// never expire.
+func (s *Service) Lock(ctx context.Context, key string, ttl time.Duration) (unlock func() error, acquired bool, err error) {
+	if ttl <= 0 {
+		return nil, false, fmt.Errorf("%w: lock ttl must be positive, got %v", store.ErrInvalidInput, ttl)
+	}
+
+	token := make([]byte, lockTokenSize)
+	if _, err := rand.Read(token); err != nil {
+		return nil, false, err
+	}
+
+	acquired, err = s.conn().SetNX(ctx, key, token, ttl)
+	if err != nil || !acquired {
+		return nil, false, err
+	}
+
+	unlockCtx := context.WithoutCancel(ctx)
+	unlock = func() error {
+		released, err := s.conn().CompareAndDelete(unlockCtx, key, token)
+		if err != nil {
+			return err
+		}
+		if !released {
+			return fmt.Errorf("%w: %s", store.ErrLockNotHeld, key)
+		}
+		return nil
+	}
+	return unlock, true, nil
+}
+
+// Rate limiting
+
+// Allow counts a request against key and reports whether it is within limit
+// for the current fixed window. The window starts with the first request
+// and the counter resets when it expires.
+func (s *Service) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, error) {
+	if limit <= 0 || window <= 0 {
+		return false, store.NewValidationError("rate limit and window must be positive")
+	}
+
+	count, err := s.conn().IncrWithExpire(ctx, key, window)
+	if err != nil {
+		return false, err
+	}
+	return count <= int64(limit), nil
 }
 
 // Atomic operations
 
 // Incr increments a key by 1.
 func (s *Service) Incr(ctx context.Context, key string) (int64, error) {
-	return s.connection.Incr(ctx, key)
+	return s.conn().Incr(ctx, key)
 }
 
 // IncrBy increments a key by a value.
 func (s *Service) IncrBy(ctx context.Context, key string, value int64) (int64, error) {
-	return s.connection.IncrBy(ctx, key, value)
+	return s.conn().IncrBy(ctx, key, value)
 }
 
 // Decr decrements a key by 1.
 func (s *Service) Decr(ctx context.Context, key string) (int64, error) {
-	return s.connection.Decr(ctx, key)
+	return s.conn().Decr(ctx, key)
 }
 
 // DecrBy decrements a key by a value.
 func (s *Service) DecrBy(ctx context.Context, key string, value int64) (int64, error) {
-	return s.connection.DecrBy(ctx, key, value)
+	return s.conn().DecrBy(ctx, key, value)
 }
 
 // WithTx executes fn within a transaction context (KV stores typically don't support transactions).
@@ -0,0 +1,554 @@
+package kvstore_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"store"
+	kvstore "store/kv"
+	"store/kv/adapter"
+)
+
+func TestScanWithPaginationOpaqueCursor(t *testing.T) {
+	svc, _ := openRecordingService(t)
+	ctx := context.Background()
+
+	for i := 0; i < 25; i++ {
+		if err := svc.Set(ctx, fmt.Sprintf("page:%02d", i), []byte("v"), 0); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+	if err := svc.Set(ctx, "other:1", []byte("v"), 0); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	paginator := store.NewPaginator()
+	seen := make(map[string]bool)
+	cursor := ""
+	pages := 0
+
+	for {
+		keys, next, err := svc.ScanWithPagination(ctx, "page:*", 10, cursor)
+		if err != nil {
+			t.Fatalf("scan page %d: %v", pages, err)
+		}
+		pages++
+
+		for _, key := range keys {
+			if seen[key] {
+				t.Errorf("key %s returned twice", key)
+			}
+			seen[key] = true
+		}
+
+		if next == "" {
+			break
+		}
+		if next == keys[len(keys)-1] {
+			t.Fatalf("next cursor leaked the raw adapter key: %s", next)
+		}
+		if _, err := paginator.DecodeCursor(next); err != nil {
+			t.Fatalf("next cursor is not an encoded store cursor: %v", err)
+		}
+		cursor = next
+	}
+
+	if pages != 3 {
+		t.Errorf("expected 3 pages, got %d", pages)
+	}
+	if len(seen) != 25 {
+		t.Errorf("expected 25 keys, got %d", len(seen))
+	}
+}
+
+func TestScanWithPaginationRejectsInvalidCursor(t *testing.T) {
+	svc, _ := openRecordingService(t)
+
+	_, _, err := svc.ScanWithPagination(context.Background(), "*", 10, "not-a-cursor")
+	if !store.IsValidationError(err) {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+}
+
+func TestScanSnapshotsAreBounded(t *testing.T) {
+	svc, _ := openRecordingService(t)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if err := svc.Set(ctx, fmt.Sprintf("k:%d", i), []byte("v"), 0); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+
+	scan := func() string {
+		t.Helper()
+		_, next, err := svc.Scan(ctx, "", "k:*", 1)
+		if err != nil || next == "" {
+			t.Fatalf("expected a scan with more pages, got %q, %v", next, err)
+		}
+		return next
+	}
+
+	// The memory adapter keeps at most 1000 snapshots without a janitor
+	first := scan()
+	var last string
+	for i := 0; i < 1000; i++ {
+		last = scan()
+	}
+	if _, _, err := svc.Scan(ctx, first, "k:*", 1); err == nil {
+		t.Error("expected the oldest scan to be dropped")
+	}
+	if keys, _, err := svc.Scan(ctx, last, "k:*", 1); err != nil || len(keys) != 1 {
+		t.Errorf("expected the latest scan to continue, got %v, %v", keys, err)
+	}
+}
+
+func TestScanIsConsistentWhileJanitorRuns(t *testing.T) {
+	svc, adpt := openRecordingService(t)
+	ctx := context.Background()
+
+	for i := 0; i < 500; i++ {
+		ttl := time.Duration(0)
+		if i%2 == 1 {
+			ttl = time.Duration(1+i%20) * time.Millisecond
+		}
+		if err := svc.Set(ctx, fmt.Sprintf("k:%04d", i), []byte("v"), ttl); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+
+	stop := adpt.StartJanitor(time.Millisecond)
+	defer stop()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			seen := make(map[string]bool)
+			last := ""
+			cursor := ""
+			for {
+				keys, next, err := svc.Scan(ctx, cursor, "k:*", 7)
+				if err != nil {
+					t.Errorf("scan: %v", err)
+					return
+				}
+				for _, key := range keys {
+					if seen[key] {
+						t.Errorf("key %s returned twice", key)
+					}
+					if key <= last {
+						t.Errorf("key %s out of order after %s", key, last)
+					}
+					seen[key] = true
+					last = key
+				}
+				if next == "" {
+					break
+				}
+				cursor = next
+				time.Sleep(100 * time.Microsecond)
+			}
+
+			for i := 0; i < 500; i += 2 {
+				if key := fmt.Sprintf("k:%04d", i); !seen[key] {
+					t.Errorf("persistent key %s missing from scan", key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestTouchExtendsTTLWithoutChangingValue(t *testing.T) {
+	svc, _ := openRecordingService(t)
+	ctx := context.Background()
+
+	if err := svc.Set(ctx, "sess:1", []byte("payload"), 50*time.Millisecond); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	existed, err := svc.Touch(ctx, "sess:1", time.Hour)
+	if err != nil {
+		t.Fatalf("touch: %v", err)
+	}
+	if !existed {
+		t.Fatal("expected touched key to exist")
+	}
+
+	ttl, err := svc.TTL(ctx, "sess:1")
+	if err != nil {
+		t.Fatalf("ttl: %v", err)
+	}
+	if ttl < 59*time.Minute {
+		t.Errorf("expected TTL to be extended to about an hour, got %s", ttl)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	value, err := svc.Get(ctx, "sess:1")
+	if err != nil {
+		t.Fatalf("get after original expiry: %v", err)
+	}
+	if string(value) != "payload" {
+		t.Errorf("touch changed the value to %q", value)
+	}
+
+	existed, err = svc.Touch(ctx, "sess:missing", time.Hour)
+	if err != nil {
+		t.Fatalf("touch missing: %v", err)
+	}
+	if existed {
+		t.Error("expected missing key to be reported as not existing")
+	}
+}
+
+func TestTouchBatch(t *testing.T) {
+	config := store.MemoryConfig()
+	direct, err := kvstore.Open(context.Background(), adapter.NewMemoryAdapter(), &config)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { _ = direct.Close() })
+	recording, _ := openRecordingService(t)
+
+	// The plain memory connection touches keys in one call; the recording
+	// wrapper hides that and exercises the per-key fallback.
+	for name, svc := range map[string]*kvstore.Service{"batched": direct, "fallback": recording} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, key := range []string{"a", "b"} {
+				if err := svc.Set(ctx, key, []byte("v-"+key), time.Minute); err != nil {
+					t.Fatalf("set: %v", err)
+				}
+			}
+
+			result, err := svc.TouchBatch(ctx, []string{"a", "b", "c"}, time.Hour)
+			if err != nil {
+				t.Fatalf("touch batch: %v", err)
+			}
+			if !result["a"] || !result["b"] || result["c"] || len(result) != 3 {
+				t.Errorf("unexpected existence map: %v", result)
+			}
+
+			for _, key := range []string{"a", "b"} {
+				ttl, err := svc.TTL(ctx, key)
+				if err != nil {
+					t.Fatalf("ttl: %v", err)
+				}
+				if ttl < 59*time.Minute {
+					t.Errorf("%s: expected extended TTL, got %s", key, ttl)
+				}
+				value, err := svc.Get(ctx, key)
+				if err != nil || string(value) != "v-"+key {
+					t.Errorf("%s: value changed: %q, %v", key, value, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRunOnceConcurrent(t *testing.T) {
+	svc, _ := openRecordingService(t)
+	ctx := context.Background()
+
+	var calls atomic.Int32
+	fn := func() ([]byte, error) {
+		calls.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		return []byte("receipt-1"), nil
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan []byte, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := svc.RunOnce(ctx, "idem:charge-1", time.Minute, fn)
+			if err != nil {
+				t.Errorf("run once: %v", err)
+				return
+			}
+			results <- result
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected fn to run once, ran %d times", n)
+	}
+	for result := range results {
+		if string(result) != "receipt-1" {
+			t.Errorf("unexpected result %q", result)
+		}
+	}
+}
+
+func TestRunOnceRetriesAfterFailure(t *testing.T) {
+	svc, _ := openRecordingService(t)
+	ctx := context.Background()
+
+	_, err := svc.RunOnce(ctx, "idem:k", time.Minute, func() ([]byte, error) {
+		return nil, errors.New("boom")
+	})
+	if err == nil {
+		t.Fatal("expected fn error")
+	}
+
+	result, err := svc.RunOnce(ctx, "idem:k", time.Minute, func() ([]byte, error) {
+		return []byte("ok"), nil
+	})
+	if err != nil || string(result) != "ok" {
+		t.Fatalf("expected retry to run fn, got %q, %v", result, err)
+	}
+}
+
+func TestRunOnceFailureKeepsTakenOverKey(t *testing.T) {
+	svc, _ := openRecordingService(t)
+	ctx := context.Background()
+
+	_, err := svc.RunOnce(ctx, "idem:slow", 20*time.Millisecond, func() ([]byte, error) {
+		// The marker expires while fn runs and another caller completes
+		time.Sleep(30 * time.Millisecond)
+		if _, err := svc.RunOnce(ctx, "idem:slow", time.Minute, func() ([]byte, error) {
+			return []byte("second"), nil
+		}); err != nil {
+			t.Errorf("second run once: %v", err)
+		}
+		return nil, errors.New("boom")
+	})
+	if err == nil {
+		t.Fatal("expected fn error")
+	}
+
+	result, err := svc.RunOnce(ctx, "idem:slow", time.Minute, func() ([]byte, error) {
+		return []byte("third"), nil
+	})
+	if err != nil || string(result) != "second" {
+		t.Errorf("expected the failed caller to leave the stored result, got %q, %v", result, err)
+	}
+}
+
+func TestLock(t *testing.T) {
+	svc, _ := openRecordingService(t)
+	ctx := context.Background()
+
+	unlock, acquired, err := svc.Lock(ctx, "lock:job", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("expected to acquire lock, got %v, %v", acquired, err)
+	}
+
+	if _, acquired, err := svc.Lock(ctx, "lock:job", time.Minute); err != nil || acquired {
+		t.Fatalf("expected contended lock to be refused, got %v, %v", acquired, err)
+	}
+
+	if err := unlock(); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+	if err := unlock(); !errors.Is(err, store.ErrLockNotHeld) {
+		t.Errorf("expected second unlock to report ErrLockNotHeld, got %v", err)
+	}
+
+	unlock, acquired, err = svc.Lock(ctx, "lock:job", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("expected to reacquire released lock, got %v, %v", acquired, err)
+	}
+	_ = unlock()
+
+	if _, acquired, err := svc.Lock(ctx, "lock:forever", 0); !errors.Is(err, store.ErrInvalidInput) || acquired {
+		t.Errorf("expected a zero ttl to be rejected, got %v, %v", acquired, err)
+	}
+}
+
+func TestLockExpires(t *testing.T) {
+	svc, _ := openRecordingService(t)
+	ctx := context.Background()
+
+	staleUnlock, acquired, err := svc.Lock(ctx, "lock:exp", 20*time.Millisecond)
+	if err != nil || !acquired {
+		t.Fatalf("expected to acquire lock, got %v, %v", acquired, err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	unlock, acquired, err := svc.Lock(ctx, "lock:exp", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("expected to acquire expired lock, got %v, %v", acquired, err)
+	}
+
+	// The first holder's lock expired; releasing it must not drop the new lock.
+	if err := staleUnlock(); !errors.Is(err, store.ErrLockNotHeld) {
+		t.Errorf("expected stale unlock to report ErrLockNotHeld, got %v", err)
+	}
+	if _, acquired, _ := svc.Lock(ctx, "lock:exp", time.Minute); acquired {
+		t.Error("stale unlock released the current holder's lock")
+	}
+	if err := unlock(); err != nil {
+		t.Errorf("unlock: %v", err)
+	}
+}
+
+func TestAllowEnforcesLimit(t *testing.T) {
+	svc, _ := openRecordingService(t)
+	ctx := context.Background()
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := svc.Allow(ctx, "rl:client-1", 5, time.Minute)
+			if err != nil {
+				t.Errorf("allow: %v", err)
+				return
+			}
+			if ok {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := allowed.Load(); n != 5 {
+		t.Errorf("expected 5 allowed requests, got %d", n)
+	}
+
+	// Other keys have their own window.
+	if ok, err := svc.Allow(ctx, "rl:client-2", 5, time.Minute); err != nil || !ok {
+		t.Errorf("expected other key to be allowed, got %v, %v", ok, err)
+	}
+}
+
+func TestAllowResetsAfterWindow(t *testing.T) {
+	svc, _ := openRecordingService(t)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := svc.Allow(ctx, "rl:w", 2, 30*time.Millisecond); !ok {
+			t.Fatalf("request %d unexpectedly refused", i)
+		}
+	}
+	if ok, _ := svc.Allow(ctx, "rl:w", 2, 30*time.Millisecond); ok {
+		t.Fatal("expected request over the limit to be refused")
+	}
+
+	time.Sleep(40 * time.Millisecond)
+
+	if ok, err := svc.Allow(ctx, "rl:w", 2, 30*time.Millisecond); err != nil || !ok {
+		t.Errorf("expected request in new window to be allowed, got %v, %v", ok, err)
+	}
+}
+
+// flakyAdapter hands out memory connections that fail once marked broken.
+type flakyAdapter struct {
+	*adapter.MemoryAdapter
+	connects atomic.Int32
+	current  atomic.Pointer[flakyConnection]
+}
+
+func (a *flakyAdapter) Connect(ctx context.Context, config *adapter.Config) (adapter.Connection, error) {
+	conn, err := a.MemoryAdapter.Connect(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	a.connects.Add(1)
+	flaky := &flakyConnection{Connection: conn}
+	a.current.Store(flaky)
+	return flaky, nil
+}
+
+type flakyConnection struct {
+	adapter.Connection
+	broken atomic.Bool
+}
+
+var errBrokenConnection = errors.New("connection reset by peer")
+
+func (c *flakyConnection) Ping(ctx context.Context) error {
+	if c.broken.Load() {
+		return errBrokenConnection
+	}
+	return c.Connection.Ping(ctx)
+}
+
+func (c *flakyConnection) Get(ctx context.Context, key string) ([]byte, error) {
+	if c.broken.Load() {
+		return nil, errBrokenConnection
+	}
+	return c.Connection.Get(ctx, key)
+}
+
+func TestHealthWatcherReconnects(t *testing.T) {
+	adpt := &flakyAdapter{MemoryAdapter: adapter.NewMemoryAdapter()}
+	config := store.MemoryConfig()
+	config.HealthCheckInterval = 5 * time.Millisecond
+	svc, err := kvstore.Open(context.Background(), adpt, &config)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { _ = svc.Close() })
+	ctx := context.Background()
+	if err := svc.Set(ctx, "k", []byte("v"), 0); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	adpt.current.Load().broken.Store(true)
+	if _, err := svc.Get(ctx, "k"); !errors.Is(err, errBrokenConnection) {
+		t.Fatalf("expected the broken connection to fail, got %v", err)
+	}
+	brokenAt := time.Now()
+
+	deadline := time.Now().Add(time.Second)
+	for adpt.connects.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if adpt.connects.Load() < 2 {
+		t.Fatal("expected the watcher to reconnect")
+	}
+	value, err := svc.Get(ctx, "k")
+	if err != nil || string(value) != "v" {
+		t.Fatalf("expected reads on the new connection, got %q, %v", value, err)
+	}
+	for svc.LastHealthy().Before(brokenAt) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if svc.LastHealthy().Before(brokenAt) {
+		t.Errorf("expected LastHealthy after the reconnect, got %s", svc.LastHealthy())
+	}
+}
+
+func TestServiceMetrics(t *testing.T) {
+	config := store.MemoryConfig()
+	svc, err := kvstore.Open(context.Background(), adapter.NewMemoryAdapter(), &config)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { _ = svc.Close() })
+	metrics := store.NewMetrics("test")
+	svc.SetMetrics(metrics)
+	ctx := context.Background()
+
+	if err := svc.Set(ctx, "k", []byte("v"), 0); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	_, _ = svc.Get(ctx, "k")
+	_, _ = svc.Get(ctx, "missing")
+	if _, err := svc.TouchBatch(ctx, []string{"k"}, time.Minute); err != nil {
+		t.Fatalf("touch batch: %v", err)
+	}
+
+	if count, errs := metrics.Operations("memory", "get"); count != 2 || errs != 0 {
+		t.Errorf("expected 2 gets without errors, got %d and %d", count, errs)
+	}
+	if count, _ := metrics.Operations("memory", "touch_batch"); count != 1 {
+		t.Errorf("expected the batched touch to be kept and recorded, got %d", count)
+	}
+}
@@ -0,0 +1,30 @@
+package store
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Logger receives structured log records from store services: connection
+// events, transaction retries and rollbacks, and constraint violations.
+// Arguments are alternating keys and values, as in log/slog; *slog.Logger
+// implements Logger.
+type Logger interface {
+	Log(ctx context.Context, level slog.Level, msg string, args ...any)
+}
+
+// NewSlogLogger returns a Logger writing to l, or to slog.Default() when l
+// is nil.
+func NewSlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		return slog.Default()
+	}
+	return l
+}
+
+// NopLogger discards every record. Services use it until a logger is set.
+var NopLogger Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Log(context.Context, slog.Level, string, ...any) {}
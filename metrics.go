@@ -0,0 +1,223 @@
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsRecorder receives the metrics of store services: one observation
+// per operation, and gauges read on collection. *Metrics implements it; an
+// implementation backed by prometheus.Registerer vectors makes services
+// report to an existing Prometheus registry instead.
+type MetricsRecorder interface {
+	// ObserveOp records an operation of a backend, named after its adapter
+	// (e.g. "postgres", "memory"), with its latency and error, if any.
+	ObserveOp(backend, op string, duration time.Duration, err error)
+
+	// RegisterGauge registers a gauge whose value is read on collection,
+	// replacing one with the same name and labels. The returned function
+	// removes it.
+	RegisterGauge(name string, labels map[string]string, value func() float64) (unregister func())
+}
+
+// DefaultMetrics is the recorder services use when Config.EnableMetrics is
+// set and no recorder was given.
+var DefaultMetrics = NewMetrics("store")
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the latency
+// histogram buckets.
+var DefaultLatencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics is an in-process MetricsRecorder. It counts operations and errors,
+// keeps a latency histogram per backend and operation, and serves all
+// metrics in the Prometheus text exposition format.
+type Metrics struct {
+	namespace string
+	buckets   []float64
+
+	mu     sync.Mutex
+	ops    map[opKey]*opStats
+	gauges map[string]gauge
+}
+
+type opKey struct {
+	backend, op string
+}
+
+type opStats struct {
+	count, errors uint64
+	sum           float64
+	buckets       []uint64 // cumulative counts are computed on output
+}
+
+type gauge struct {
+	name   string
+	labels string // rendered label set
+	value  func() float64
+}
+
+var _ MetricsRecorder = (*Metrics)(nil)
+
+// NewMetrics creates a recorder whose metric names start with namespace.
+func NewMetrics(namespace string) *Metrics {
+	return &Metrics{
+		namespace: namespace,
+		buckets:   DefaultLatencyBuckets,
+		ops:       make(map[opKey]*opStats),
+		gauges:    make(map[string]gauge),
+	}
+}
+
+// ObserveOp records an operation.
+func (m *Metrics) ObserveOp(backend, op string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := opKey{backend, op}
+	st := m.ops[key]
+	if st == nil {
+		st = &opStats{buckets: make([]uint64, len(m.buckets))}
+		m.ops[key] = st
+	}
+	st.count++
+	if err != nil {
+		st.errors++
+	}
+	seconds := duration.Seconds()
+	st.sum += seconds
+	if i, _ := slices.BinarySearch(m.buckets, seconds); i < len(m.buckets) {
+		st.buckets[i]++
+	}
+}
+
+// RegisterGauge registers a gauge read on collection.
+func (m *Metrics) RegisterGauge(name string, labels map[string]string, value func() float64) func() {
+	g := gauge{name: m.namespace + "_" + name, labels: renderLabels(labels), value: value}
+	key := g.name + g.labels
+
+	m.mu.Lock()
+	m.gauges[key] = g
+	m.mu.Unlock()
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.gauges, key)
+	}
+}
+
+// Operations returns the number of operations and errors recorded for a
+// backend and operation.
+func (m *Metrics) Operations(backend, op string) (count, errors uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if st := m.ops[opKey{backend, op}]; st != nil {
+		return st.count, st.errors
+	}
+	return 0, 0
+}
+
+// WritePrometheus writes all metrics in the Prometheus text format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	keys := slices.SortedFunc(maps.Keys(m.ops), func(a, b opKey) int {
+		return strings.Compare(a.backend+"\x00"+a.op, b.backend+"\x00"+b.op)
+	})
+	ops := make([]opStats, len(keys))
+	for i, key := range keys {
+		st := *m.ops[key]
+		st.buckets = slices.Clone(st.buckets)
+		ops[i] = st
+	}
+	gauges := slices.SortedFunc(maps.Values(m.gauges), func(a, b gauge) int {
+		return strings.Compare(a.name+a.labels, b.name+b.labels)
+	})
+	m.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	total := m.namespace + "_operations_total"
+	errs := m.namespace + "_operation_errors_total"
+	latency := m.namespace + "_operation_duration_seconds"
+
+	fmt.Fprintf(bw, "# HELP %s Operations run by store backends.\n# TYPE %s counter\n", total, total)
+	for i, key := range keys {
+		fmt.Fprintf(bw, "%s%s %d\n", total, opLabels(key, ""), ops[i].count)
+	}
+	fmt.Fprintf(bw, "# HELP %s Operations that failed.\n# TYPE %s counter\n", errs, errs)
+	for i, key := range keys {
+		fmt.Fprintf(bw, "%s%s %d\n", errs, opLabels(key, ""), ops[i].errors)
+	}
+	fmt.Fprintf(bw, "# HELP %s Operation latency.\n# TYPE %s histogram\n", latency, latency)
+	for i, key := range keys {
+		var cumulative uint64
+		for j, bound := range m.buckets {
+			cumulative += ops[i].buckets[j]
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", latency, opLabels(key, formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(bw, "%s_bucket%s %d\n", latency, opLabels(key, "+Inf"), ops[i].count)
+		fmt.Fprintf(bw, "%s_sum%s %s\n", latency, opLabels(key, ""), formatFloat(ops[i].sum))
+		fmt.Fprintf(bw, "%s_count%s %d\n", latency, opLabels(key, ""), ops[i].count)
+	}
+
+	typed := make(map[string]bool)
+	for _, g := range gauges {
+		if !typed[g.name] {
+			fmt.Fprintf(bw, "# TYPE %s gauge\n", g.name)
+			typed[g.name] = true
+		}
+		fmt.Fprintf(bw, "%s%s %s\n", g.name, g.labels, formatFloat(g.value()))
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics to a Prometheus scraper.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.WritePrometheus(w)
+}
+
+func opLabels(key opKey, le string) string {
+	labels := map[string]string{"backend": key.backend, "op": key.op}
+	if le != "" {
+		labels["le"] = le
+	}
+	return renderLabels(labels)
+}
+
+// renderLabels renders a label set in sorted order, escaped as the text
+// format requires.
+func renderLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range slices.Sorted(maps.Keys(labels)) {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteString(`="`)
+		sb.WriteString(labelEscaper.Replace(labels[name]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
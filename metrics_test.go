@@ -0,0 +1,51 @@
+package store_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"store"
+)
+
+func TestMetricsPrometheusOutput(t *testing.T) {
+	m := store.NewMetrics("app")
+	m.ObserveOp("postgres", "select", 3*time.Millisecond, nil)
+	m.ObserveOp("postgres", "select", 2*time.Second, errors.New("timeout"))
+	unregister := m.RegisterGauge("pool_open_connections", map[string]string{"pool": `pri"mary`}, func() float64 { return 4 })
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+
+	for _, want := range []string{
+		"# TYPE app_operations_total counter",
+		`app_operations_total{backend="postgres",op="select"} 2`,
+		`app_operation_errors_total{backend="postgres",op="select"} 1`,
+		"# TYPE app_operation_duration_seconds histogram",
+		`app_operation_duration_seconds_bucket{backend="postgres",le="0.005",op="select"} 1`,
+		`app_operation_duration_seconds_bucket{backend="postgres",le="2.5",op="select"} 2`,
+		`app_operation_duration_seconds_bucket{backend="postgres",le="+Inf",op="select"} 2`,
+		`app_operation_duration_seconds_count{backend="postgres",op="select"} 2`,
+		"# TYPE app_pool_open_connections gauge",
+		`app_pool_open_connections{pool="pri\"mary"} 4`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+	if count, errs := m.Operations("postgres", "select"); count != 2 || errs != 1 {
+		t.Errorf("expected 2 operations and 1 error, got %d and %d", count, errs)
+	}
+
+	unregister()
+	var sb strings.Builder
+	if err := m.WritePrometheus(&sb); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if strings.Contains(sb.String(), "pool_open_connections") {
+		t.Error("expected the gauge to be removed")
+	}
+}
@@ -27,15 +27,84 @@ func (m Insert) WithReturning(cols ...string) Insert {
 	return m
 }
 
+// WithKey names the columns identifying the inserted row, "id" by default.
+// Backends without RETURNING read the row back by them: by the inserted
+// values when the insert sets them all, else by the generated id.
+func (m Insert) WithKey(cols ...string) Insert {
+	if m.Hints == nil {
+		m.Hints = map[string]any{}
+	}
+	m.Hints["key"] = cols
+	return m
+}
+
+// BulkInsert inserts many rows with one multi-row INSERT statement. Every
+// row must set the same columns.
+type BulkInsert struct {
+	Rows []map[string]any
+}
+
+func (BulkInsert) isMutation() {}
+
+// Upsert inserts a row or, when it conflicts with an existing row on the
+// conflict columns (a primary key or unique constraint), updates that row.
+// UpdateColumns lists the columns overwritten on conflict; when empty every
+// inserted column except the conflict columns is overwritten.
+type Upsert struct {
+	Values          map[string]any
+	ConflictColumns []string
+	UpdateColumns   []string
+	Hints           map[string]any // e.g., {"returning": []string{"id"}}
+}
+
+func (Upsert) isMutation() {}
+
+func (m Upsert) WithReturning(cols ...string) Upsert {
+	if m.Hints == nil {
+		m.Hints = map[string]any{}
+	}
+	m.Hints["returning"] = cols
+	return m
+}
+
 // Update represents an update with SET values and WHERE conditions.
 type Update struct {
-	Set   map[string]any
-	Where []Condition    // Simple list of conditions (all ANDed together)
-	Hints map[string]any // e.g., {"returning": []string{"updated_at"}}
+	Set     map[string]any
+	Where   []Condition    // Simple list of conditions (all ANDed together)
+	Nodes   []Node         // Filter trees, ANDed with Where
+	OrderBy []Order        // Order in which Limit picks rows
+	Limit   int            // Maximum number of rows updated; 0 means no limit
+	Hints   map[string]any // e.g., {"returning": []string{"updated_at"}}
 }
 
 func (Update) isMutation() {}
 
+// SetExpr is an Update.Set value computed by the database from the current
+// row, such as an increment, so no read-modify-write is needed. Each ? in
+// SQL binds the next value of Args; ?? is a literal ?, such as the jsonb ?
+// operator, and is only supported on PostgreSQL. SetExpr is only valid in Update; other mutations reject it.
+type SetExpr struct {
+	Column string // column the expression starts with, quoted like other identifiers
+	SQL    string
+	Args   []any
+}
+
+// Expr returns a Set value computed by the SQL expression sql, e.g.
+// Expr("price * ?", 1.1). Identifiers in sql are used as written.
+func Expr(sql string, args ...any) SetExpr {
+	return SetExpr{SQL: sql, Args: args}
+}
+
+// Increment returns a Set value adding by to column.
+func Increment(column string, by any) SetExpr {
+	return SetExpr{Column: column, SQL: "+ ?", Args: []any{by}}
+}
+
+// Decrement returns a Set value subtracting by from column.
+func Decrement(column string, by any) SetExpr {
+	return SetExpr{Column: column, SQL: "- ?", Args: []any{by}}
+}
+
 func (m Update) WithReturning(cols ...string) Update {
 	if m.Hints == nil {
 		m.Hints = map[string]any{}
@@ -44,10 +113,52 @@ func (m Update) WithReturning(cols ...string) Update {
 	return m
 }
 
+// UpdateFrom represents a correlated update that sets columns of the target
+// table from a joined source table. Conditions may reference source columns
+// by qualifying them with the source table name (e.g. "src.active").
+type UpdateFrom struct {
+	From    string            // Source table
+	On      map[string]string // Join columns: target column -> source column
+	SetFrom map[string]string // Target column -> source column
+	Set     map[string]any    // Target column -> literal value
+	Where   []Condition       // Additional conditions (all ANDed together)
+}
+
+func (UpdateFrom) isMutation() {}
+
+// InsertSelect inserts the rows produced by a source query into the target
+// table. Columns name the target columns and must line up with the columns
+// selected by Source.
+type InsertSelect struct {
+	Columns []string
+	Source  Query
+}
+
+func (InsertSelect) isMutation() {}
+
+// UpdateCase updates many rows in one statement. Each column is set with a
+// CASE on the key column, so every row can receive different values.
+type UpdateCase struct {
+	Key  string // Column identifying the rows
+	Rows []CaseRow
+}
+
+// CaseRow holds the values to set on the row whose key column equals Key.
+// Columns a row does not set keep their current value.
+type CaseRow struct {
+	Key any
+	Set map[string]any
+}
+
+func (UpdateCase) isMutation() {}
+
 // Delete represents a delete with WHERE conditions.
 type Delete struct {
-	Where []Condition // Simple list of conditions (all ANDed together)
-	Hints map[string]any
+	Where   []Condition // Simple list of conditions (all ANDed together)
+	Nodes   []Node      // Filter trees, ANDed with Where
+	OrderBy []Order     // Order in which Limit picks rows
+	Limit   int         // Maximum number of rows deleted; 0 means no limit
+	Hints   map[string]any
 }
 
 func (Delete) isMutation() {}
@@ -72,10 +183,30 @@ func NewInsert(values map[string]any) Insert {
 	return Insert{Values: values}
 }
 
+func NewBulkInsert(rows ...map[string]any) BulkInsert {
+	return BulkInsert{Rows: rows}
+}
+
+func NewUpsert(values map[string]any, conflictColumns ...string) Upsert {
+	return Upsert{Values: values, ConflictColumns: conflictColumns}
+}
+
 func NewUpdate(set map[string]any, conditions ...Condition) Update {
 	return Update{Set: set, Where: conditions}
 }
 
+func NewUpdateFrom(from string, on, setFrom map[string]string, conditions ...Condition) UpdateFrom {
+	return UpdateFrom{From: from, On: on, SetFrom: setFrom, Where: conditions}
+}
+
+func NewInsertSelect(columns []string, source Query) InsertSelect {
+	return InsertSelect{Columns: columns, Source: source}
+}
+
+func NewUpdateCase(key string, rows ...CaseRow) UpdateCase {
+	return UpdateCase{Key: key, Rows: rows}
+}
+
 func NewDelete(conditions ...Condition) Delete {
 	return Delete{Where: conditions}
 }
@@ -0,0 +1,255 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Node is a boolean filter expression. Conditions are leaf nodes and are
+// combined with And, Or and Not.
+type Node interface{ isNode() }
+
+func (Condition) isNode() {}
+
+// AndNode matches when all of its children match.
+type AndNode struct{ Nodes []Node }
+
+// OrNode matches when any of its children matches.
+type OrNode struct{ Nodes []Node }
+
+// NotNode matches when its child does not.
+type NotNode struct{ Node Node }
+
+func (AndNode) isNode() {}
+func (OrNode) isNode()  {}
+func (NotNode) isNode() {}
+
+// Helper functions for combining nodes
+func And(nodes ...Node) Node {
+	return AndNode{Nodes: nodes}
+}
+
+func Or(nodes ...Node) Node {
+	return OrNode{Nodes: nodes}
+}
+
+func Not(node Node) Node {
+	return NotNode{Node: node}
+}
+
+// NodeConditions returns the leaf conditions of a node tree, in order.
+func NodeConditions(node Node) []Condition {
+	switch n := node.(type) {
+	case Condition:
+		return []Condition{n}
+	case AndNode:
+		return nodesConditions(n.Nodes)
+	case OrNode:
+		return nodesConditions(n.Nodes)
+	case NotNode:
+		return NodeConditions(n.Node)
+	default:
+		return nil
+	}
+}
+
+func nodesConditions(nodes []Node) []Condition {
+	var conds []Condition
+	for _, n := range nodes {
+		conds = append(conds, NodeConditions(n)...)
+	}
+	return conds
+}
+
+// EvalNode evaluates a node tree against a record in memory.
+// An empty And matches everything; an empty Or matches nothing.
+func EvalNode(node Node, record map[string]any) (bool, error) {
+	switch n := node.(type) {
+	case Condition:
+		return EvalCondition(n, record)
+	case AndNode:
+		for _, child := range n.Nodes {
+			ok, err := EvalNode(child, record)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case OrNode:
+		for _, child := range n.Nodes {
+			ok, err := EvalNode(child, record)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	case NotNode:
+		ok, err := EvalNode(n.Node, record)
+		if err != nil {
+			return false, err
+		}
+		return !ok, nil
+	default:
+		return false, fmt.Errorf("%w: unknown node type %T", ErrInvalidQuery, node)
+	}
+}
+
+// EvalCondition evaluates a single condition against a record in memory.
+// Missing fields are treated as nil.
+func EvalCondition(cond Condition, record map[string]any) (bool, error) {
+	value := record[cond.Field]
+
+	if ref, ok := cond.Value.(FieldRef); ok {
+		if !isComparison(cond.Op) {
+			return false, fmt.Errorf("%w: operator %s cannot compare fields", ErrInvalidQuery, cond.Op)
+		}
+		cond.Value = record[string(ref)]
+	}
+
+	switch cond.Op {
+	case OpIsNull:
+		return value == nil, nil
+	case OpNotNull:
+		return value != nil, nil
+	case OpEq:
+		return valuesEqual(value, cond.Value), nil
+	case OpNe:
+		return !valuesEqual(value, cond.Value), nil
+	case OpDistinctFrom:
+		if value == nil || cond.Value == nil {
+			return value != cond.Value, nil
+		}
+		return !valuesEqual(value, cond.Value), nil
+	case OpGt, OpGe, OpLt, OpLe:
+		cmp, ok := compareValues(value, cond.Value)
+		if !ok {
+			return false, nil
+		}
+		switch cond.Op {
+		case OpGt:
+			return cmp > 0, nil
+		case OpGe:
+			return cmp >= 0, nil
+		case OpLt:
+			return cmp < 0, nil
+		default:
+			return cmp <= 0, nil
+		}
+	case OpIn, OpNotIn:
+		values, _ := cond.Value.([]any)
+		found := false
+		for _, v := range values {
+			if valuesEqual(value, v) {
+				found = true
+				break
+			}
+		}
+		return found == (cond.Op == OpIn), nil
+	case OpBetween:
+		r, ok := BetweenBounds(cond.Value)
+		if !ok {
+			return false, fmt.Errorf("%w: between expects [2]any or Range bounds", ErrInvalidQuery)
+		}
+		lo, okLo := compareValues(value, r.From)
+		hi, okHi := compareValues(value, r.To)
+		if !okLo || !okHi {
+			return false, nil
+		}
+		return (lo > 0 || r.IncLow && lo == 0) && (hi < 0 || r.IncHigh && hi == 0), nil
+	case OpPrefix, OpSuffix, OpContains:
+		s, ok1 := value.(string)
+		sub, ok2 := cond.Value.(string)
+		if !ok1 || !ok2 {
+			return false, nil
+		}
+		switch cond.Op {
+		case OpPrefix:
+			return strings.HasPrefix(s, sub), nil
+		case OpSuffix:
+			return strings.HasSuffix(s, sub), nil
+		default:
+			return strings.Contains(s, sub), nil
+		}
+	default:
+		return false, fmt.Errorf("%w: operator %s cannot be evaluated in memory", ErrNotSupported, cond.Op)
+	}
+}
+
+// valuesEqual compares two values, treating numbers of different types as equal
+// when they have the same numeric value.
+func valuesEqual(a, b any) bool {
+	if cmp, ok := compareValues(a, b); ok {
+		return cmp == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compareValues orders numbers, strings and times. It reports false when the
+// values are not comparable.
+func compareValues(a, b any) (int, bool) {
+	if af, ok := toFloat(a); ok {
+		if bf, ok := toFloat(b); ok {
+			switch {
+			case af < bf:
+				return -1, true
+			case af > bf:
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+		return 0, false
+	}
+
+	switch av := a.(type) {
+	case string:
+		if bv, ok := b.(string); ok {
+			return strings.Compare(av, bv), true
+		}
+	case time.Time:
+		if bv, ok := b.(time.Time); ok {
+			return av.Compare(bv), true
+		}
+	}
+	return 0, false
+}
+
+func toFloat(v any) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+// BetweenBounds returns the bounds of an OpBetween value. [2]any bounds are
+// inclusive.
+func BetweenBounds(value any) (Range, bool) {
+	switch v := value.(type) {
+	case Range:
+		return v, true
+	case [2]any:
+		return Range{From: v[0], To: v[1], IncLow: true, IncHigh: true}, true
+	}
+	return Range{}, false
+}
+
+// isComparison reports whether op compares a field with a single value.
+func isComparison(op Operator) bool {
+	switch op {
+	case OpEq, OpNe, OpGt, OpGe, OpLt, OpLe:
+		return true
+	}
+	return false
+}
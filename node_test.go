@@ -0,0 +1,147 @@
+package store_test
+
+import (
+	"errors"
+	"testing"
+
+	"store"
+)
+
+func TestEvalNodeNot(t *testing.T) {
+	node := store.Not(store.And(store.Eq("status", "active"), store.Gt("age", 18)))
+
+	tests := []struct {
+		name   string
+		record map[string]any
+		want   bool
+	}{
+		{"both match", map[string]any{"status": "active", "age": 30}, false},
+		{"status differs", map[string]any{"status": "banned", "age": 30}, true},
+		{"too young", map[string]any{"status": "active", "age": int64(12)}, true},
+		{"missing fields", map[string]any{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.EvalNode(node, tt.record)
+			if err != nil {
+				t.Fatalf("eval failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEvalNodeCombinations(t *testing.T) {
+	record := map[string]any{"role": "admin", "score": 7.5, "name": "alice"}
+
+	node := store.Or(
+		store.Not(store.Eq("role", "admin")),
+		store.And(store.In("name", "alice", "bob"), store.Between("score", 5, 10)),
+	)
+	got, err := store.EvalNode(node, record)
+	if err != nil {
+		t.Fatalf("eval failed: %v", err)
+	}
+	if !got {
+		t.Error("expected record to match")
+	}
+
+	if got, _ := store.EvalNode(store.Not(store.Not(store.Eq("role", "admin"))), record); !got {
+		t.Error("expected double negation to match")
+	}
+
+	_, err = store.EvalNode(store.Not(store.Like("name", "a%")), record)
+	if !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported for LIKE, got %v", err)
+	}
+}
+
+func TestEvalFieldCompare(t *testing.T) {
+	tests := []struct {
+		name   string
+		cond   store.Condition
+		record map[string]any
+		want   bool
+	}{
+		{"greater", store.FieldCompare("spent", store.OpGt, "budget"), map[string]any{"spent": 120, "budget": 100.0}, true},
+		{"not greater", store.FieldCompare("spent", store.OpGt, "budget"), map[string]any{"spent": 80, "budget": 100}, false},
+		{"equal strings", store.FieldCompare("billing", store.OpEq, "shipping"), map[string]any{"billing": "x", "shipping": "x"}, true},
+		{"less or equal", store.FieldCompare("start", store.OpLe, "end"), map[string]any{"start": 5, "end": 5}, true},
+		{"missing right field", store.FieldCompare("spent", store.OpGt, "budget"), map[string]any{"spent": 1}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.EvalCondition(tt.cond, tt.record)
+			if err != nil {
+				t.Fatalf("eval failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	_, err := store.EvalCondition(store.FieldCompare("a", store.OpIn, "b"), map[string]any{})
+	if !errors.Is(err, store.ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery for IN between fields, got %v", err)
+	}
+}
+
+func TestEvalDistinctFrom(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  any
+		record map[string]any
+		want   bool
+	}{
+		{"different values", "open", map[string]any{"status": "paid"}, true},
+		{"equal values", "open", map[string]any{"status": "open"}, false},
+		{"null field", "open", map[string]any{"status": nil}, true},
+		{"null value", nil, map[string]any{"status": "open"}, true},
+		{"both null", nil, map[string]any{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.EvalCondition(store.DistinctFrom("status", tt.value), tt.record)
+			if err != nil {
+				t.Fatalf("eval failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalBetweenEx(t *testing.T) {
+	tests := []struct {
+		name            string
+		incLow, incHigh bool
+		want            map[int]bool
+	}{
+		{"inclusive", true, true, map[int]bool{9: true, 10: true, 11: true}},
+		{"exclusive upper", true, false, map[int]bool{9: true, 10: true}},
+		{"exclusive lower", false, true, map[int]bool{10: true, 11: true}},
+		{"exclusive", false, false, map[int]bool{10: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cond := store.BetweenEx("hour", 9, 11, tt.incLow, tt.incHigh)
+			for hour := 8; hour <= 12; hour++ {
+				got, err := store.EvalCondition(cond, map[string]any{"hour": hour})
+				if err != nil {
+					t.Fatalf("eval failed: %v", err)
+				}
+				if got != tt.want[hour] {
+					t.Errorf("hour %d: got %v, want %v", hour, got, tt.want[hour])
+				}
+			}
+		})
+	}
+}
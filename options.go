@@ -79,6 +79,14 @@ func WithMaxIdleConns(max int) Option {
 	}
 }
 
+// WithWarmUp pre-opens MaxIdleConns connections when connecting, so the
+// first burst of traffic does not wait for connections to be established.
+func WithWarmUp() Option {
+	return func(c *Config) {
+		c.WarmUp = true
+	}
+}
+
 // WithConnMaxLifetime sets the maximum connection lifetime.
 func WithConnMaxLifetime(lifetime time.Duration) Option {
 	return func(c *Config) {
@@ -1,9 +1,12 @@
 package store
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"core/validation"
@@ -17,17 +20,156 @@ type Cursor struct {
 	LastTimestamp time.Time `json:"timestamp"` // Last item timestamp for ordering
 	LastSort      string    `json:"sort"`      // Last item sort value (for custom ordering)
 
+	// Keys is the position of a compound ordering: the value of each ordering
+	// column of the last item, most significant first. Cursors with keys are
+	// CursorVersionKeyed.
+	Keys []CursorKey `json:"keys,omitempty"`
+
+	// Backward makes the cursor page toward the start, returning the items
+	// before its position. Previous cursors are backward.
+	Backward bool `json:"backward,omitempty"`
+
+	// Trail holds the start positions of the pages before this one, for
+	// backends that cannot page backwards, such as key scans.
+	Trail []string `json:"trail,omitempty"`
+
 	// Metadata
 	PageSize  int32     `json:"page_size"`  // Page size for this cursor
 	CreatedAt time.Time `json:"created_at"` // When cursor was created
 	Version   int       `json:"version"`    // Cursor format version
 }
 
+// Cursor format versions. Version 1 cursors carry a (timestamp, id)
+// position; keyed cursors carry an ordered list of keys instead.
+const (
+	CursorVersion      = 1
+	CursorVersionKeyed = 2
+)
+
+// CursorKey is one column of a compound cursor position: the field ordered
+// by, its value for the item the cursor points at and the order direction.
+// Values keep their type through encoding; time.Time, strings, []byte,
+// booleans, integers, floats and nil are supported.
+type CursorKey struct {
+	Field string
+	Value any
+	Desc  bool
+}
+
+// cursorKeyJSON is the encoded form of a CursorKey, tagging the value with
+// its type so it decodes to the same type.
+type cursorKeyJSON struct {
+	Field string          `json:"field"`
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value,omitempty"`
+	Desc  bool            `json:"desc,omitempty"`
+}
+
+// MarshalJSON encodes the key with the type of its value.
+func (k CursorKey) MarshalJSON() ([]byte, error) {
+	var typ string
+	var value any
+	switch v := k.Value.(type) {
+	case nil:
+		typ = "null"
+	case time.Time:
+		typ, value = "time", v.Format(time.RFC3339Nano)
+	case string:
+		typ, value = "string", v
+	case []byte:
+		typ, value = "bytes", v
+	case bool:
+		typ, value = "bool", v
+	case int:
+		typ, value = "int", int64(v)
+	case int8:
+		typ, value = "int", int64(v)
+	case int16:
+		typ, value = "int", int64(v)
+	case int32:
+		typ, value = "int", int64(v)
+	case int64:
+		typ, value = "int", v
+	case uint:
+		typ, value = "uint", uint64(v)
+	case uint8:
+		typ, value = "uint", uint64(v)
+	case uint16:
+		typ, value = "uint", uint64(v)
+	case uint32:
+		typ, value = "uint", uint64(v)
+	case uint64:
+		typ, value = "uint", v
+	case float32:
+		typ, value = "float", float64(v)
+	case float64:
+		typ, value = "float", v
+	default:
+		return nil, fmt.Errorf("unsupported cursor value type %T for %s", k.Value, k.Field)
+	}
+
+	enc := cursorKeyJSON{Field: k.Field, Type: typ, Desc: k.Desc}
+	if value != nil {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		enc.Value = raw
+	}
+	return json.Marshal(enc)
+}
+
+// UnmarshalJSON decodes a key encoded by MarshalJSON, restoring the value
+// as time.Time, string, []byte, bool, int64, uint64, float64 or nil.
+func (k *CursorKey) UnmarshalJSON(data []byte) error {
+	var enc cursorKeyJSON
+	if err := json.Unmarshal(data, &enc); err != nil {
+		return err
+	}
+
+	var err error
+	switch enc.Type {
+	case "null":
+		k.Value = nil
+	case "time":
+		var s string
+		if err = json.Unmarshal(enc.Value, &s); err == nil {
+			k.Value, err = time.Parse(time.RFC3339Nano, s)
+		}
+	case "string":
+		k.Value, err = decodeKeyValue[string](enc.Value)
+	case "bytes":
+		k.Value, err = decodeKeyValue[[]byte](enc.Value)
+	case "bool":
+		k.Value, err = decodeKeyValue[bool](enc.Value)
+	case "int":
+		k.Value, err = decodeKeyValue[int64](enc.Value)
+	case "uint":
+		k.Value, err = decodeKeyValue[uint64](enc.Value)
+	case "float":
+		k.Value, err = decodeKeyValue[float64](enc.Value)
+	default:
+		return fmt.Errorf("unsupported cursor value type %q for %s", enc.Type, enc.Field)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid cursor value for %s: %w", enc.Field, err)
+	}
+
+	k.Field, k.Desc = enc.Field, enc.Desc
+	return nil
+}
+
+func decodeKeyValue[T any](raw json.RawMessage) (T, error) {
+	var v T
+	err := json.Unmarshal(raw, &v)
+	return v, err
+}
+
 // CursorParams holds cursor-based pagination parameters.
 type CursorParams struct {
 	PageSize int32  `validate:"min:value=1,max:value=1000"` // Number of items per page
 	Cursor   string `validate:"omitempty"`                  // Encoded cursor string (empty for first page)
-	Backward bool   // When true, paginate backward (older items when ordering ascending)
+	Backward bool   // When true, paginate backward (older items when ordering ascending); without a cursor, start from the last page
 }
 
 // CursorResult holds the result of a cursor-based paginated query.
@@ -45,6 +187,11 @@ type PaginationConfig struct {
 	MaxPageSize     int32
 	MinPageSize     int32
 	MaxCursorAge    time.Duration // How long cursors remain valid
+
+	// CursorSecret, when set, signs encoded cursors with HMAC-SHA256 so
+	// clients cannot forge or alter their position. Decoding then rejects
+	// unsigned cursors and bad signatures with ErrCursorTampered.
+	CursorSecret []byte
 }
 
 // DefaultPaginationConfig returns sensible cursor pagination defaults.
@@ -99,12 +246,22 @@ func (p *CursorParams) Validate() error {
 	return NewValidationError("invalid pagination parameters")
 }
 
-// DecodeCursor decodes a cursor string into a Cursor struct.
+// DecodeCursor decodes a cursor string into a Cursor struct. With a
+// CursorSecret, the signature is verified first and a cursor that is unsigned
+// or altered fails with ErrCursorTampered.
 func (p *Paginator) DecodeCursor(cursorStr string) (*Cursor, error) {
 	if cursorStr == "" {
 		return nil, nil
 	}
 
+	if len(p.config.CursorSecret) > 0 {
+		payload, err := p.verifyCursor(cursorStr)
+		if err != nil {
+			return nil, err
+		}
+		cursorStr = payload
+	}
+
 	// Decode base64
 	decoded, err := base64.URLEncoding.DecodeString(cursorStr)
 	if err != nil {
@@ -124,14 +281,21 @@ func (p *Paginator) DecodeCursor(cursorStr string) (*Cursor, error) {
 	}
 
 	// Validate version compatibility
-	if cursor.Version != 1 {
+	switch cursor.Version {
+	case CursorVersion:
+	case CursorVersionKeyed:
+		if len(cursor.Keys) == 0 {
+			return nil, fmt.Errorf("invalid cursor content: version %d cursor without keys", cursor.Version)
+		}
+	default:
 		return nil, fmt.Errorf("unsupported cursor version: %d", cursor.Version)
 	}
 
 	return &cursor, nil
 }
 
-// EncodeCursor encodes a Cursor struct into a base64 string.
+// EncodeCursor encodes a Cursor struct into a base64 string, followed by its
+// signature when the configuration has a CursorSecret.
 func (p *Paginator) EncodeCursor(cursor *Cursor) (string, error) {
 	if cursor == nil {
 		return "", nil
@@ -141,8 +305,10 @@ func (p *Paginator) EncodeCursor(cursor *Cursor) (string, error) {
 	if cursor.CreatedAt.IsZero() {
 		cursor.CreatedAt = time.Now()
 	}
-	if cursor.Version == 0 {
-		cursor.Version = 1
+	if len(cursor.Keys) > 0 {
+		cursor.Version = CursorVersionKeyed
+	} else if cursor.Version == 0 {
+		cursor.Version = CursorVersion
 	}
 
 	// Marshal to JSON
@@ -152,7 +318,32 @@ func (p *Paginator) EncodeCursor(cursor *Cursor) (string, error) {
 	}
 
 	// Encode to base64
-	return base64.URLEncoding.EncodeToString(data), nil
+	encoded := base64.URLEncoding.EncodeToString(data)
+	if len(p.config.CursorSecret) > 0 {
+		encoded += "." + base64.RawURLEncoding.EncodeToString(p.signCursor(encoded))
+	}
+	return encoded, nil
+}
+
+// signCursor returns the HMAC-SHA256 of an encoded cursor payload.
+func (p *Paginator) signCursor(payload string) []byte {
+	mac := hmac.New(sha256.New, p.config.CursorSecret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// verifyCursor checks the signature of a signed cursor, payload.signature,
+// and returns its payload.
+func (p *Paginator) verifyCursor(cursorStr string) (string, error) {
+	payload, signature, ok := strings.Cut(cursorStr, ".")
+	if !ok {
+		return "", fmt.Errorf("%w: cursor is not signed", ErrCursorTampered)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, p.signCursor(payload)) {
+		return "", ErrCursorTampered
+	}
+	return payload, nil
 }
 
 // CreateCursor creates a new cursor for the given item.
@@ -163,7 +354,19 @@ func (p *Paginator) CreateCursor(id string, timestamp time.Time, sortValue strin
 		LastSort:      sortValue,
 		PageSize:      pageSize,
 		CreatedAt:     time.Now(),
-		Version:       1,
+		Version:       CursorVersion,
+	}
+}
+
+// CreateKeyedCursor creates a cursor positioned at keys, the values of the
+// ordering columns of an item. A backward cursor pages toward the start.
+func (p *Paginator) CreateKeyedCursor(keys []CursorKey, pageSize int32, backward bool) *Cursor {
+	return &Cursor{
+		Keys:      keys,
+		Backward:  backward,
+		PageSize:  pageSize,
+		CreatedAt: time.Now(),
+		Version:   CursorVersionKeyed,
 	}
 }
 
@@ -191,9 +394,10 @@ func (p *Paginator) CreatePreviousCursor(firstItem interface{}, pageSize int32)
 		LastID:        id,
 		LastTimestamp: timestamp,
 		LastSort:      sortValue,
+		Backward:      true,
 		PageSize:      pageSize,
 		CreatedAt:     time.Now(),
-		Version:       1,
+		Version:       CursorVersion,
 	}, nil
 }
 
@@ -254,10 +458,75 @@ func BuildCursorResult[T any](
 		}
 	}
 
-	// PreviousCursor is set by backend-specific paginators when params.Cursor != ""
+	// PreviousCursor is set by BuildCursorPage, which knows where the page
+	// came from
 	return result
 }
 
+// BuildCursorPage creates the cursor result of a page fetched in the
+// direction of params. items are in display order and hasMore reports
+// whether more items follow in that direction. A forward page gets a
+// PreviousCursor when it was reached with a cursor; a backward page always
+// gets a NextCursor, back to the items it was reached from. It fails when a
+// cursor cannot be created or encoded.
+func BuildCursorPage[T any](p *Paginator, items []T, params CursorParams, hasMore bool, totalCount int64) (CursorResult[T], error) {
+	return buildCursorPage(p, items, params, hasMore, totalCount, func(item T, backward bool) (*Cursor, error) {
+		if backward {
+			return p.CreatePreviousCursor(item, params.PageSize)
+		}
+		return p.CreateNextCursor(item, params.PageSize)
+	})
+}
+
+// BuildKeyedCursorPage is BuildCursorPage for compound orderings: the
+// cursors carry the keys returned by keys for the first and last items.
+func BuildKeyedCursorPage[T any](p *Paginator, items []T, params CursorParams, hasMore bool, totalCount int64, keys func(T) ([]CursorKey, error)) (CursorResult[T], error) {
+	return buildCursorPage(p, items, params, hasMore, totalCount, func(item T, backward bool) (*Cursor, error) {
+		k, err := keys(item)
+		if err != nil {
+			return nil, err
+		}
+		return p.CreateKeyedCursor(k, params.PageSize, backward), nil
+	})
+}
+
+func buildCursorPage[T any](p *Paginator, items []T, params CursorParams, hasMore bool, totalCount int64, cursorAt func(item T, backward bool) (*Cursor, error)) (CursorResult[T], error) {
+	result := CursorResult[T]{
+		Items:      items,
+		HasMore:    hasMore,
+		TotalCount: totalCount,
+	}
+	if len(items) == 0 {
+		return result, nil
+	}
+
+	hasNext, hasPrevious := hasMore, params.Cursor != ""
+	if params.Backward {
+		hasNext, hasPrevious = params.Cursor != "", hasMore
+	}
+	var err error
+	if hasNext {
+		if result.NextCursor, err = encodeCursorAt(p, items[len(items)-1], false, cursorAt); err != nil {
+			return CursorResult[T]{}, err
+		}
+	}
+	if hasPrevious {
+		if result.PreviousCursor, err = encodeCursorAt(p, items[0], true, cursorAt); err != nil {
+			return CursorResult[T]{}, err
+		}
+	}
+	return result, nil
+}
+
+// encodeCursorAt encodes the cursor of item made by cursorAt.
+func encodeCursorAt[T any](p *Paginator, item T, backward bool, cursorAt func(item T, backward bool) (*Cursor, error)) (string, error) {
+	cursor, err := cursorAt(item, backward)
+	if err != nil {
+		return "", fmt.Errorf("create cursor: %w", err)
+	}
+	return p.EncodeCursor(cursor)
+}
+
 // ValidateCursor validates if a cursor string is valid.
 func (p *Paginator) ValidateCursor(cursorStr string) error {
 	_, err := p.DecodeCursor(cursorStr)
@@ -0,0 +1,95 @@
+package store_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"store"
+)
+
+func TestKeyedCursorRoundTrip(t *testing.T) {
+	p := store.NewPaginator()
+	at := time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC)
+	keys := []store.CursorKey{
+		{Field: "created_at", Value: at, Desc: true},
+		{Field: "score", Value: 42},
+		{Field: "ratio", Value: 0.5},
+		{Field: "name", Value: "ada"},
+		{Field: "blob", Value: []byte{1, 2}},
+		{Field: "active", Value: false},
+		{Field: "deleted_at", Value: nil},
+	}
+
+	encoded, err := p.EncodeCursor(p.CreateKeyedCursor(keys, 10, true))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	cursor, err := p.DecodeCursor(encoded)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	if cursor.Version != store.CursorVersionKeyed || !cursor.Backward || cursor.PageSize != 10 {
+		t.Errorf("unexpected cursor metadata: %+v", cursor)
+	}
+	if len(cursor.Keys) != len(keys) {
+		t.Fatalf("expected %d keys, got %d", len(keys), len(cursor.Keys))
+	}
+	got := cursor.Keys
+	if v, ok := got[0].Value.(time.Time); !ok || !v.Equal(at) || !got[0].Desc {
+		t.Errorf("expected time key %v descending, got %#v", at, got[0])
+	}
+	if got[1].Value != int64(42) || got[2].Value != 0.5 || got[3].Value != "ada" || got[5].Value != false || got[6].Value != nil {
+		t.Errorf("expected typed key values, got %#v", got)
+	}
+	if v, ok := got[4].Value.([]byte); !ok || !bytes.Equal(v, []byte{1, 2}) {
+		t.Errorf("expected bytes key, got %#v", got[4])
+	}
+	for i, key := range got {
+		if key.Field != keys[i].Field {
+			t.Errorf("key %d: expected field %s, got %s", i, keys[i].Field, key.Field)
+		}
+	}
+
+	if _, err := p.EncodeCursor(p.CreateKeyedCursor([]store.CursorKey{{Field: "f", Value: struct{}{}}}, 10, false)); err == nil {
+		t.Error("expected an unsupported key value to fail encoding")
+	}
+}
+
+func TestSignedCursors(t *testing.T) {
+	cfg := store.DefaultPaginationConfig()
+	cfg.CursorSecret = []byte("s3cret")
+	p := store.NewPaginatorWithConfig(cfg)
+
+	encoded, err := p.EncodeCursor(p.CreateCursor("id-1", time.Now(), "", 10))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	cursor, err := p.DecodeCursor(encoded)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if cursor.LastID != "id-1" {
+		t.Errorf("expected the signed cursor to decode, got %+v", cursor)
+	}
+
+	// Forge a cursor pointing elsewhere, keeping the signature
+	payload, signature, _ := strings.Cut(encoded, ".")
+	raw, _ := base64.URLEncoding.DecodeString(payload)
+	forged := base64.URLEncoding.EncodeToString(bytes.Replace(raw, []byte("id-1"), []byte("id-9"), 1)) + "." + signature
+
+	unsigned, _ := store.NewPaginator().EncodeCursor(p.CreateCursor("id-1", time.Now(), "", 10))
+	otherCfg := cfg
+	otherCfg.CursorSecret = []byte("other")
+	otherKey, _ := store.NewPaginatorWithConfig(otherCfg).EncodeCursor(p.CreateCursor("id-1", time.Now(), "", 10))
+
+	for name, cursor := range map[string]string{"forged": forged, "unsigned": unsigned, "other secret": otherKey} {
+		if _, err := p.DecodeCursor(cursor); !errors.Is(err, store.ErrCursorTampered) {
+			t.Errorf("%s: expected ErrCursorTampered, got %v", name, err)
+		}
+	}
+}
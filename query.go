@@ -1,5 +1,7 @@
 package store
 
+import "time"
+
 // Operator represents a comparison operation in filters.
 type Operator string
 
@@ -21,22 +23,91 @@ const (
 	OpRegex    Operator = "regex"    // regular expression match
 	OpIsNull   Operator = "isnull"
 	OpNotNull  Operator = "notnull"
+
+	OpFullText Operator = "fulltext" // full-text search match
+
+	// OpMatch matches a full-text query written in the backend's search
+	// syntax, such as "quick & !slow" on PostgreSQL or "+quick -slow" on MySQL.
+	OpMatch Operator = "match"
+
+	// OpWithinLast matches timestamps no older than a time.Duration before now.
+	OpWithinLast Operator = "within_last"
+
+	// OpWithinBBox matches geometries inside a BBox.
+	OpWithinBBox Operator = "within_bbox"
+
+	// OpDistinctFrom is a null-safe inequality: NULL is distinct from any
+	// value but not from NULL.
+	OpDistinctFrom Operator = "distinct_from"
+
+	// OpArrayOverlaps matches array columns sharing an element with a []any.
+	OpArrayOverlaps Operator = "array_overlaps"
+
+	// OpJSONEq matches JSON columns whose value at a JSONPath equals its Value.
+	OpJSONEq Operator = "json_eq"
 )
 
+// JSONPath is the value of an OpJSONEq condition: a path such as "$.color"
+// or "$.sizes[0].name" within a JSON field, and the value found there.
+type JSONPath struct {
+	Path  string
+	Value any
+}
+
+// BBox is a latitude/longitude bounding box in WGS 84.
+type BBox struct {
+	MinLat, MinLng float64
+	MaxLat, MaxLng float64
+}
+
 // Condition is a simple filter condition (field op value).
 type Condition struct {
 	Field string
 	Op    Operator
-	// Value can be a single value, []any for OpIn, or [2]any for OpBetween.
+	// Value can be a single value, []any for OpIn, or [2]any or Range for OpBetween.
 	Value any
 }
 
+// Range is the OpBetween value of BetweenEx, with a choice of inclusive or
+// exclusive bounds.
+type Range struct {
+	From, To        any
+	IncLow, IncHigh bool
+}
+
+// FieldRef is a condition value naming another field of the same row.
+type FieldRef string
+
 // Order defines ordering on a field.
 type Order struct {
 	Field string
 	Desc  bool
 }
 
+// Query describes a SELECT over a single table or subquery. It is used where
+// a mutation needs a source row set, such as InsertSelect, and as the
+// subquery of InQuery and NotInQuery.
+type Query struct {
+	From      string      // Table, or the alias of FromQuery
+	FromQuery *Query      // Optional subquery selected from, as in FROM (<query>) From
+	Columns   []string    // Selected columns or expressions; * when empty
+	Where     []Condition // All ANDed together
+	OrderBy   []Order
+	Limit     int
+	Offset    int
+}
+
+// InQuery matches rows whose field is among the values selected by q.
+// SQL backends compile it to field IN (<q>).
+func InQuery(field string, q Query) Condition {
+	return Condition{Field: field, Op: OpIn, Value: q}
+}
+
+// NotInQuery matches rows whose field is not among the values selected by q.
+func NotInQuery(field string, q Query) Condition {
+	return Condition{Field: field, Op: OpNotIn, Value: q}
+}
+
 // Helper functions for creating conditions
 func Eq(field string, value any) Condition {
 	return Condition{Field: field, Op: OpEq, Value: value}
@@ -74,6 +145,21 @@ func Between(field string, from, to any) Condition {
 	return Condition{Field: field, Op: OpBetween, Value: [2]any{from, to}}
 }
 
+// BetweenEx matches values between from and to, including each bound only
+// when incLow or incHigh is set. BetweenEx(f, a, b, true, false) is the
+// half-open range [a, b) used for time buckets.
+func BetweenEx(field string, from, to any, incLow, incHigh bool) Condition {
+	return Condition{Field: field, Op: OpBetween, Value: Range{From: from, To: to, IncLow: incLow, IncHigh: incHigh}}
+}
+
+func Prefix(field string, value string) Condition {
+	return Condition{Field: field, Op: OpPrefix, Value: value}
+}
+
+func Suffix(field string, value string) Condition {
+	return Condition{Field: field, Op: OpSuffix, Value: value}
+}
+
 func Contains(field string, value string) Condition {
 	return Condition{Field: field, Op: OpContains, Value: value}
 }
@@ -90,6 +176,58 @@ func NotNull(field string) Condition {
 	return Condition{Field: field, Op: OpNotNull, Value: nil}
 }
 
+// FullText matches rows whose field matches a full-text search query.
+// SQL backends require an appropriate full-text index on the field.
+func FullText(field string, query string) Condition {
+	return Condition{Field: field, Op: OpFullText, Value: query}
+}
+
+// Match matches rows whose field matches a full-text query with boolean
+// operators. Unlike FullText, the query is passed to the backend's query
+// parser as written.
+func Match(field string, query string) Condition {
+	return Condition{Field: field, Op: OpMatch, Value: query}
+}
+
+// WithinLast matches rows whose field lies within the duration before now.
+// The current time is taken from the database, not the application.
+func WithinLast(field string, d time.Duration) Condition {
+	return Condition{Field: field, Op: OpWithinLast, Value: d}
+}
+
+// WithinBBox matches rows whose geometry field lies inside the bounding box.
+// It needs a spatial database; other backends reject it with ErrNotSupported.
+func WithinBBox(field string, minLat, minLng, maxLat, maxLng float64) Condition {
+	return Condition{Field: field, Op: OpWithinBBox, Value: BBox{MinLat: minLat, MinLng: minLng, MaxLat: maxLat, MaxLng: maxLng}}
+}
+
+// DistinctFrom matches rows whose field differs from value, treating NULL as
+// an ordinary value. Unlike Ne, it matches rows where the field is NULL and
+// value is not.
+func DistinctFrom(field string, value any) Condition {
+	return Condition{Field: field, Op: OpDistinctFrom, Value: value}
+}
+
+// JSONEq matches rows whose JSON field holds value at path, e.g.
+// JSONEq("metadata", "$.color", "red"). Paths start at $ and use .key and
+// [index] steps; values are compared as text on PostgreSQL.
+func JSONEq(field, path string, value any) Condition {
+	return Condition{Field: field, Op: OpJSONEq, Value: JSONPath{Path: path, Value: value}}
+}
+
+// ArrayOverlaps matches rows whose array field has at least one element in
+// values. It needs native array columns (PostgreSQL); other backends reject
+// it with ErrNotSupported.
+func ArrayOverlaps(field string, values []any) Condition {
+	return Condition{Field: field, Op: OpArrayOverlaps, Value: values}
+}
+
+// FieldCompare compares two fields of the same row, as in left > right.
+// op must be one of OpEq, OpNe, OpGt, OpGe, OpLt or OpLe.
+func FieldCompare(left string, op Operator, right string) Condition {
+	return Condition{Field: left, Op: op, Value: FieldRef(right)}
+}
+
 // Helper functions for creating orders
 func Asc(field string) Order {
 	return Order{Field: field, Desc: false}
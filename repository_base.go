@@ -2,6 +2,8 @@ package store
 
 import (
 	"context"
+	"reflect"
+	"sync"
 	"time"
 
 	"core/entity"
@@ -12,22 +14,56 @@ import (
 type RepositoryBase struct {
 	entityName     string
 	tableName      string
+	idColumn       string
 	newEntityFunc  func() entity.Entity
 	validator      validation.Validator
 	metricsEnabled bool
 }
 
 // NewRepositoryBase creates a new base repository.
+// Names are derived once per entity type and cached.
 func NewRepositoryBase(ent entity.Entity) *RepositoryBase {
+	info := entityInfoOf(ent)
 	return &RepositoryBase{
-		entityName:     entity.GetEntityName(ent),
-		tableName:      entity.GetTableName(ent),
-		newEntityFunc:  func() entity.Entity { return entity.CreateNewEntity(ent) },
+		entityName:     info.entityName,
+		tableName:      info.tableName,
+		idColumn:       info.idColumn,
+		newEntityFunc:  info.newEntity,
 		validator:      nil, // Use default validation.Validate function
 		metricsEnabled: true,
 	}
 }
 
+// entityInfo holds what a repository derives from its entity type.
+type entityInfo struct {
+	entityName string
+	tableName  string
+	idColumn   string
+	newEntity  func() entity.Entity
+}
+
+// entityInfoCache maps reflect.Type to *entityInfo.
+var entityInfoCache sync.Map
+
+// entityInfoOf returns the cached entityInfo for the type of ent. The table
+// name and ID column are assumed to depend on the type only.
+func entityInfoOf(ent entity.Entity) *entityInfo {
+	typ := reflect.TypeOf(ent)
+	if info, ok := entityInfoCache.Load(typ); ok {
+		return info.(*entityInfo)
+	}
+	// Keep a fresh instance rather than ent so the cache does not retain caller data.
+	proto := entity.CreateNewEntity(ent)
+	info := &entityInfo{
+		entityName: entity.GetEntityName(ent),
+		tableName:  entity.GetTableName(ent),
+		idColumn:   idColumnOf(ent),
+		newEntity:  func() entity.Entity { return entity.CreateNewEntity(proto) },
+	}
+	actual, _ := entityInfoCache.LoadOrStore(typ, info)
+	return actual.(*entityInfo)
+}
+
 // EntityName returns the entity name.
 func (r *RepositoryBase) EntityName() string {
 	return r.entityName
@@ -38,11 +74,39 @@ func (r *RepositoryBase) TableName() string {
 	return r.tableName
 }
 
+// IDColumn returns the primary-key column name.
+func (r *RepositoryBase) IDColumn() string {
+	return r.idColumn
+}
+
+// WithIDColumn returns a copy of the base using the given primary-key column.
+func (r *RepositoryBase) WithIDColumn(column string) *RepositoryBase {
+	clone := *r
+	clone.idColumn = column
+	return &clone
+}
+
 // CreateNewEntity creates a new entity instance.
 func (r *RepositoryBase) CreateNewEntity() entity.Entity {
 	return r.newEntityFunc()
 }
 
+// IDColumner is implemented by entities whose primary-key column is not "id".
+type IDColumner interface {
+	IDColumn() string
+}
+
+// DefaultIDColumn is the primary-key column used when an entity does not
+// implement IDColumner.
+const DefaultIDColumn = "id"
+
+func idColumnOf(ent entity.Entity) string {
+	if c, ok := ent.(IDColumner); ok && c.IDColumn() != "" {
+		return c.IDColumn()
+	}
+	return DefaultIDColumn
+}
+
 // Validate validates an entity.
 func (r *RepositoryBase) Validate(ctx context.Context, ent entity.Entity) error {
 	// Use the default validation function
@@ -71,6 +135,23 @@ func (r *RepositoryBase) SetTimestamps(ent entity.Entity, isCreate bool) {
 	ent.SetUpdatedAt(now)
 }
 
+// SetAuditFields records the actor from ctx on Auditable entities.
+// It does nothing when ctx carries no actor.
+func (r *RepositoryBase) SetAuditFields(ctx context.Context, ent entity.Entity, isCreate bool) {
+	auditable, ok := ent.(Auditable)
+	if !ok {
+		return
+	}
+	actorID, ok := ActorFromContext(ctx)
+	if !ok {
+		return
+	}
+	if isCreate {
+		auditable.SetCreatedBy(actorID)
+	}
+	auditable.SetUpdatedBy(actorID)
+}
+
 // Error handling helpers
 
 // HandleGetError wraps get operation errors with context.
@@ -0,0 +1,71 @@
+package store_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"store"
+)
+
+type ticket struct {
+	ID        string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (t *ticket) GetID() string            { return t.ID }
+func (t *ticket) SetID(id string)          { t.ID = id }
+func (t *ticket) GetCreatedAt() time.Time  { return t.CreatedAt }
+func (t *ticket) SetCreatedAt(v time.Time) { t.CreatedAt = v }
+func (t *ticket) GetUpdatedAt() time.Time  { return t.UpdatedAt }
+func (t *ticket) SetUpdatedAt(v time.Time) { t.UpdatedAt = v }
+
+type archivedTicket struct{ ticket }
+
+func (a *archivedTicket) TableName() string { return "ticket_archive" }
+func (a *archivedTicket) IDColumn() string  { return "ticket_id" }
+
+func TestRepositoryBaseNamesPerType(t *testing.T) {
+	tests := []struct {
+		name     string
+		build    func() *store.RepositoryBase
+		entity   string
+		table    string
+		idColumn string
+		newType  string
+	}{
+		{"default names", func() *store.RepositoryBase { return store.NewRepositoryBase(&ticket{ID: "t-1"}) }, "ticket", "tickets", "id", "*store_test.ticket"},
+		{"custom names", func() *store.RepositoryBase { return store.NewRepositoryBase(&archivedTicket{}) }, "archivedticket", "ticket_archive", "ticket_id", "*store_test.archivedTicket"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Build twice so the second base comes from the cache.
+			for i := 0; i < 2; i++ {
+				base := tt.build()
+				if base.EntityName() != tt.entity || base.TableName() != tt.table || base.IDColumn() != tt.idColumn {
+					t.Errorf("build %d: got %q/%q/%q, want %q/%q/%q", i, base.EntityName(), base.TableName(), base.IDColumn(), tt.entity, tt.table, tt.idColumn)
+				}
+
+				first, second := base.CreateNewEntity(), base.CreateNewEntity()
+				if first == second {
+					t.Error("CreateNewEntity returned the same instance twice")
+				}
+				if first.GetID() != "" {
+					t.Errorf("new entity carries data from the original: id %q", first.GetID())
+				}
+				if got := fmt.Sprintf("%T", first); got != tt.newType {
+					t.Errorf("new entity has type %s, want %s", got, tt.newType)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkNewRepositoryBase(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = store.NewRepositoryBase(&ticket{})
+	}
+}
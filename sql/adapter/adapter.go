@@ -29,12 +29,27 @@ type Adapter interface {
 	SupportsUUID() bool
 	SupportsJSON() bool
 	SupportsFullTextSearch() bool
+	SupportsGeoSpatial() bool
+
+	// Capabilities describes the SQL features the database supports.
+	Capabilities() Capabilities
 
 	// Error classification
+
+	// TranslateError marks driver errors with the matching store sentinel,
+	// such as store.ErrUniqueConstraint, using the driver's typed error
+	// codes. The driver error stays reachable through errors.As; errors
+	// it does not recognize are returned unchanged.
+	TranslateError(err error) error
 	IsUniqueConstraintViolation(err error) bool
 	IsForeignKeyViolation(err error) bool
 	IsConnectionError(err error) bool
 
+	// IsRetryableTxError reports whether err, returned by a transaction,
+	// is a conflict such as a deadlock or serialization failure that
+	// running the whole transaction again may resolve.
+	IsRetryableTxError(err error) bool
+
 	// Close releases any resources held by the adapter.
 	Close() error
 }
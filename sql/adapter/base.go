@@ -3,6 +3,7 @@ package adapter
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"store"
 )
 
@@ -35,7 +36,17 @@ func (a *BaseSQLAdapter) Connect(ctx context.Context, config *store.Config, conn
 		return nil, store.WrapConnectionError(
 			err, "connect", a.driverName, config.Host)
 	}
+	return a.connectDB(ctx, config, db)
+}
+
+// ConnectConnector is Connect for adapters that open the database through a
+// driver.Connector rather than a connection string.
+func (a *BaseSQLAdapter) ConnectConnector(ctx context.Context, config *store.Config, connector driver.Connector) (*sql.DB, error) {
+	return a.connectDB(ctx, config, sql.OpenDB(connector))
+}
 
+// connectDB configures the pool of an opened database and verifies it.
+func (a *BaseSQLAdapter) connectDB(ctx context.Context, config *store.Config, db *sql.DB) (*sql.DB, error) {
 	// Configure connection pool - identical across all SQL adapters
 	a.configureConnectionPool(db, config)
 
@@ -63,6 +74,23 @@ func (a *BaseSQLAdapter) configureConnectionPool(db *sql.DB, config *store.Confi
 	}
 }
 
+// sslMode returns the SSL mode of config. TLS settings enable TLS when the
+// mode is left at "disable": verify-full, or require when verification is
+// skipped.
+func sslMode(config *store.Config) string {
+	mode := config.SSLMode
+	if config.TLS != nil && (mode == "" || mode == "disable") {
+		if config.TLS.InsecureSkipVerify {
+			return "require"
+		}
+		return "verify-full"
+	}
+	if mode == "" {
+		return "disable"
+	}
+	return mode
+}
+
 // Close closes the database connection.
 func (a *BaseSQLAdapter) Close() error {
 	if a.db != nil {
@@ -164,6 +192,10 @@ func (a *BaseSQLAdapter) SupportsFullTextSearch() bool {
 	return false
 }
 
+func (a *BaseSQLAdapter) SupportsGeoSpatial() bool {
+	return false
+}
+
 func (a *BaseSQLAdapter) IsUniqueConstraintViolation(err error) bool {
 	if err == nil {
 		return false
@@ -0,0 +1,75 @@
+package adapter
+
+// UpsertSyntax names the INSERT conflict clause a database accepts.
+type UpsertSyntax string
+
+const (
+	UpsertNone           UpsertSyntax = ""                 // no native upsert
+	UpsertOnConflict     UpsertSyntax = "on_conflict"      // ON CONFLICT (...) DO UPDATE
+	UpsertOnDuplicateKey UpsertSyntax = "on_duplicate_key" // ON DUPLICATE KEY UPDATE
+)
+
+// Capabilities describes the SQL features of an adapter's database, so the
+// compiler and repositories can branch on features instead of dialects.
+type Capabilities struct {
+	Returning       bool         // INSERT/UPDATE/DELETE ... RETURNING
+	Upsert          UpsertSyntax // native upsert clause, if any
+	Savepoints      bool         // SAVEPOINT within transactions
+	JSON            bool         // JSON columns and path functions
+	FullTextSearch  bool
+	GeoSpatial      bool
+	MaxPlaceholders int    // bound parameters allowed per statement
+	IdentifierQuote string // character quoting identifiers
+}
+
+// Capabilities returns a conservative feature set for unknown databases.
+func (a *BaseSQLAdapter) Capabilities() Capabilities {
+	return Capabilities{
+		JSON:            a.SupportsJSON(),
+		FullTextSearch:  a.SupportsFullTextSearch(),
+		GeoSpatial:      a.SupportsGeoSpatial(),
+		MaxPlaceholders: 999,
+		IdentifierQuote: `"`,
+	}
+}
+
+// Capabilities returns the features of PostgreSQL.
+func (a *PostgreSQLAdapter) Capabilities() Capabilities {
+	return Capabilities{
+		Returning:       true,
+		Upsert:          UpsertOnConflict,
+		Savepoints:      true,
+		JSON:            true,
+		FullTextSearch:  a.SupportsFullTextSearch(),
+		GeoSpatial:      a.SupportsGeoSpatial(),
+		MaxPlaceholders: 65535,
+		IdentifierQuote: `"`,
+	}
+}
+
+// Capabilities returns the features of MySQL 8.
+func (a *MySQLAdapter) Capabilities() Capabilities {
+	return Capabilities{
+		Upsert:          UpsertOnDuplicateKey,
+		Savepoints:      true,
+		JSON:            true,
+		FullTextSearch:  a.SupportsFullTextSearch(),
+		GeoSpatial:      a.SupportsGeoSpatial(),
+		MaxPlaceholders: 65535,
+		IdentifierQuote: "`",
+	}
+}
+
+// Capabilities returns the features of SQLite 3.35 and later.
+func (a *SQLiteAdapter) Capabilities() Capabilities {
+	return Capabilities{
+		Returning:       true,
+		Upsert:          UpsertOnConflict,
+		Savepoints:      true,
+		JSON:            true,
+		FullTextSearch:  a.SupportsFullTextSearch(),
+		GeoSpatial:      a.SupportsGeoSpatial(),
+		MaxPlaceholders: 32766,
+		IdentifierQuote: `"`,
+	}
+}
@@ -0,0 +1,65 @@
+package adapter
+
+import (
+	"database/sql"
+	"errors"
+	"store"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// crdbRetryableCode is the SQLSTATE CockroachDB reports when a transaction
+// must be retried, usually after a serialization conflict.
+const crdbRetryableCode = "40001"
+
+// CockroachDBAdapter implements the Adapter interface for CockroachDB. It
+// speaks the PostgreSQL wire protocol and dialect, but runs every
+// transaction as SERIALIZABLE and expects clients to retry those that fail
+// with a retryable error.
+type CockroachDBAdapter struct {
+	*PostgreSQLAdapter
+}
+
+// NewCockroachDBAdapter creates a new CockroachDB adapter.
+func NewCockroachDBAdapter() *CockroachDBAdapter {
+	pg := NewPostgreSQLAdapter()
+	pg.BaseSQLAdapter = NewBaseSQLAdapter("postgres", "cockroachdb")
+	return &CockroachDBAdapter{PostgreSQLAdapter: pg}
+}
+
+// DefaultTxOptions returns SERIALIZABLE, the only isolation level
+// CockroachDB runs by default.
+func (a *CockroachDBAdapter) DefaultTxOptions() *sql.TxOptions {
+	return &sql.TxOptions{
+		Isolation: sql.LevelSerializable,
+		ReadOnly:  false,
+	}
+}
+
+// IsRetryableTxError reports whether err asks the client to retry the whole
+// transaction (SQLSTATE 40001).
+func (a *CockroachDBAdapter) IsRetryableTxError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code) == crdbRetryableCode
+	}
+	return strings.Contains(err.Error(), "restart transaction")
+}
+
+// TxRetryPolicy returns the policy TransactionHandler applies to
+// transactions that do not set their own, so conflicts are retried
+// client-side as CockroachDB requires.
+func (a *CockroachDBAdapter) TxRetryPolicy() *store.RetryPolicy {
+	return &store.RetryPolicy{
+		MaxRetries:        5,
+		InitialDelay:      5 * time.Millisecond,
+		MaxDelay:          500 * time.Millisecond,
+		BackoffMultiplier: 2.0,
+		Jitter:            0.2,
+	}
+}
@@ -0,0 +1,193 @@
+package adapter
+
+import (
+	"errors"
+	"fmt"
+	"store"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// withSentinel marks err with a store sentinel error, keeping the driver
+// error reachable through errors.As.
+func withSentinel(sentinel, err error) error {
+	if sentinel == nil || errors.Is(err, sentinel) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", sentinel, err)
+}
+
+// TranslateError marks err with the store sentinel matching its message.
+// Adapters with typed driver errors override it.
+func (a *BaseSQLAdapter) TranslateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch {
+	case a.IsUniqueConstraintViolation(err):
+		return withSentinel(store.ErrUniqueConstraint, err)
+	case a.IsForeignKeyViolation(err):
+		return withSentinel(store.ErrForeignKeyConstraint, err)
+	case a.IsConnectionError(err):
+		return withSentinel(store.ErrConnectionFailed, err)
+	}
+	return err
+}
+
+// retryableTxErrors are messages of transaction conflicts across databases.
+var retryableTxErrors = []string{
+	"serialization failure",
+	"could not serialize",
+	"deadlock",
+	"lock wait timeout",
+}
+
+// IsRetryableTxError reports errors marked store.ErrTransactionAborted and
+// messages of deadlocks and serialization failures. Adapters with typed
+// driver errors override it.
+func (a *BaseSQLAdapter) IsRetryableTxError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, store.ErrTransactionAborted) {
+		return true
+	}
+	for _, pattern := range retryableTxErrors {
+		if contains(err.Error(), pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// pqSentinels maps PostgreSQL SQLSTATE codes to store sentinel errors.
+var pqSentinels = map[pq.ErrorCode]error{
+	"23505": store.ErrUniqueConstraint,
+	"23503": store.ErrForeignKeyConstraint,
+	"23514": store.ErrCheckConstraint,
+	"23502": store.ErrNotNullConstraint,
+	"40001": store.ErrTransactionAborted, // serialization_failure
+	"40P01": store.ErrTransactionAborted, // deadlock_detected
+	"42601": store.ErrQuerySyntax,
+	"57014": store.ErrQueryTimeout, // query_canceled, e.g. by statement_timeout
+}
+
+// translatePQ returns the sentinel of a *pq.Error in err's chain.
+func translatePQ(err error) (error, bool) {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return nil, false
+	}
+	if pqErr.Code.Class() == "08" {
+		return store.ErrConnectionFailed, true
+	}
+	return pqSentinels[pqErr.Code], true
+}
+
+// TranslateError marks err with the store sentinel matching its SQLSTATE.
+func (a *PostgreSQLAdapter) TranslateError(err error) error {
+	if sentinel, ok := translatePQ(err); ok {
+		return withSentinel(sentinel, err)
+	}
+	return a.BaseSQLAdapter.TranslateError(err)
+}
+
+// IsRetryableTxError reports a serialization_failure (40001) or
+// deadlock_detected (40P01).
+func (a *PostgreSQLAdapter) IsRetryableTxError(err error) bool {
+	return errors.Is(a.TranslateError(err), store.ErrTransactionAborted)
+}
+
+// IsUniqueConstraintViolation reports a unique_violation (23505).
+func (a *PostgreSQLAdapter) IsUniqueConstraintViolation(err error) bool {
+	return errors.Is(a.TranslateError(err), store.ErrUniqueConstraint)
+}
+
+// IsForeignKeyViolation reports a foreign_key_violation (23503).
+func (a *PostgreSQLAdapter) IsForeignKeyViolation(err error) bool {
+	return errors.Is(a.TranslateError(err), store.ErrForeignKeyConstraint)
+}
+
+// mysqlSentinels maps MySQL server error numbers to store sentinel errors.
+var mysqlSentinels = map[uint16]error{
+	1062: store.ErrUniqueConstraint,     // ER_DUP_ENTRY
+	1451: store.ErrForeignKeyConstraint, // ER_ROW_IS_REFERENCED_2
+	1452: store.ErrForeignKeyConstraint, // ER_NO_REFERENCED_ROW_2
+	3819: store.ErrCheckConstraint,      // ER_CHECK_CONSTRAINT_VIOLATED
+	1048: store.ErrNotNullConstraint,    // ER_BAD_NULL_ERROR
+	1213: store.ErrTransactionAborted,   // ER_LOCK_DEADLOCK
+	1205: store.ErrTransactionAborted,   // ER_LOCK_WAIT_TIMEOUT
+	1064: store.ErrQuerySyntax,          // ER_PARSE_ERROR
+	3024: store.ErrQueryTimeout,         // ER_QUERY_TIMEOUT
+}
+
+// TranslateError marks err with the store sentinel matching its error number.
+func (a *MySQLAdapter) TranslateError(err error) error {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return withSentinel(mysqlSentinels[myErr.Number], err)
+	}
+	if errors.Is(err, mysql.ErrInvalidConn) {
+		return withSentinel(store.ErrConnectionFailed, err)
+	}
+	return a.BaseSQLAdapter.TranslateError(err)
+}
+
+// IsRetryableTxError reports a deadlock (1213) or lock wait timeout (1205).
+func (a *MySQLAdapter) IsRetryableTxError(err error) bool {
+	return errors.Is(a.TranslateError(err), store.ErrTransactionAborted)
+}
+
+// IsUniqueConstraintViolation reports a duplicate entry (1062).
+func (a *MySQLAdapter) IsUniqueConstraintViolation(err error) bool {
+	return errors.Is(a.TranslateError(err), store.ErrUniqueConstraint)
+}
+
+// IsForeignKeyViolation reports a missing or still referenced row (1451, 1452).
+func (a *MySQLAdapter) IsForeignKeyViolation(err error) bool {
+	return errors.Is(a.TranslateError(err), store.ErrForeignKeyConstraint)
+}
+
+// sqliteSentinels maps SQLite extended result codes to store sentinel errors.
+var sqliteSentinels = map[sqlite3.ErrNoExtended]error{
+	sqlite3.ErrConstraintUnique:     store.ErrUniqueConstraint,
+	sqlite3.ErrConstraintPrimaryKey: store.ErrUniqueConstraint,
+	sqlite3.ErrConstraintForeignKey: store.ErrForeignKeyConstraint,
+	sqlite3.ErrConstraintCheck:      store.ErrCheckConstraint,
+	sqlite3.ErrConstraintNotNull:    store.ErrNotNullConstraint,
+}
+
+// TranslateError marks err with the store sentinel matching its result code.
+func (a *SQLiteAdapter) TranslateError(err error) error {
+	var liteErr sqlite3.Error
+	if !errors.As(err, &liteErr) {
+		return a.BaseSQLAdapter.TranslateError(err)
+	}
+	if sentinel, ok := sqliteSentinels[liteErr.ExtendedCode]; ok {
+		return withSentinel(sentinel, err)
+	}
+	switch liteErr.Code {
+	case sqlite3.ErrBusy, sqlite3.ErrLocked:
+		return withSentinel(store.ErrTransactionAborted, err)
+	case sqlite3.ErrCantOpen, sqlite3.ErrNotADB:
+		return withSentinel(store.ErrConnectionFailed, err)
+	}
+	return err
+}
+
+// IsRetryableTxError reports a busy or locked database.
+func (a *SQLiteAdapter) IsRetryableTxError(err error) bool {
+	return errors.Is(a.TranslateError(err), store.ErrTransactionAborted)
+}
+
+// IsUniqueConstraintViolation reports a UNIQUE or PRIMARY KEY violation.
+func (a *SQLiteAdapter) IsUniqueConstraintViolation(err error) bool {
+	return errors.Is(a.TranslateError(err), store.ErrUniqueConstraint)
+}
+
+// IsForeignKeyViolation reports a FOREIGN KEY violation.
+func (a *SQLiteAdapter) IsForeignKeyViolation(err error) bool {
+	return errors.Is(a.TranslateError(err), store.ErrForeignKeyConstraint)
+}
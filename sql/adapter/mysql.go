@@ -7,7 +7,7 @@ import (
 	"store"
 	"strings"
 
-	_ "github.com/go-sql-driver/mysql" // MySQL driver
+	"github.com/go-sql-driver/mysql"
 )
 
 // MySQLAdapter implements the Adapter interface for MySQL.
@@ -22,10 +22,23 @@ func NewMySQLAdapter() *MySQLAdapter {
 	}
 }
 
-// Connect establishes a connection to MySQL.
+// Connect establishes a connection to MySQL. The driver is wrapped so that
+// cancelling a statement's context also kills the query on the server.
 func (a *MySQLAdapter) Connect(ctx context.Context, config *store.Config) (*sql.DB, error) {
-	connStr := a.ConnectionString(config)
-	return a.BaseSQLAdapter.Connect(ctx, config, connStr)
+	cfg, err := mysql.ParseDSN(a.ConnectionString(config))
+	if err != nil {
+		return nil, store.WrapConnectionError(err, "connect", "mysql", config.Host)
+	}
+	if config.TLS != nil {
+		if cfg.TLS, err = config.TLS.ClientConfig(); err != nil {
+			return nil, err
+		}
+	}
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, store.WrapConnectionError(err, "connect", "mysql", config.Host)
+	}
+	return a.ConnectConnector(ctx, config, newKillConnector(connector))
 }
 
 // ConnectionString constructs a MySQL connection string.
@@ -81,6 +94,16 @@ func (a *MySQLAdapter) ConnectionString(config *store.Config) string {
 		params = append(params, "charset=utf8mb4")
 	}
 
+	// TLS without certificates; Connect applies config.TLS directly
+	if config.TLS == nil {
+		switch sslMode(config) {
+		case "require":
+			params = append(params, "tls=skip-verify")
+		case "verify-ca", "verify-full":
+			params = append(params, "tls=true")
+		}
+	}
+
 	// Add custom options
 	for key, value := range config.Options {
 		params = append(params, fmt.Sprintf("%s=%s", key, value))
@@ -133,6 +156,11 @@ func (a *MySQLAdapter) SupportsUpsert() bool {
 	return true
 }
 
+// SupportsFullTextSearch indicates MySQL supports MATCH ... AGAINST on FULLTEXT indexes.
+func (a *MySQLAdapter) SupportsFullTextSearch() bool {
+	return true
+}
+
 // QuoteIdentifier quotes a MySQL identifier.
 func (a *MySQLAdapter) QuoteIdentifier(identifier string) string {
 	return fmt.Sprintf("`%s`", strings.ReplaceAll(identifier, "`", "``"))
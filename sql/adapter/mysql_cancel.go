@@ -0,0 +1,229 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Server-side cancellation by driver:
+//
+//   - PostgreSQL (lib/pq) sends a cancel request to the server when a
+//     statement's context is cancelled, so the query stops server-side.
+//   - MySQL (go-sql-driver/mysql) only closes the client connection; the
+//     server keeps running the statement. The MySQL adapter therefore wraps
+//     the driver so that a cancelled statement is stopped with KILL QUERY,
+//     issued from a separate connection.
+//   - SQLite (mattn/go-sqlite3) runs in process and interrupts the statement
+//     with sqlite3_interrupt.
+
+// killQueryTimeout bounds the KILL QUERY issued for a cancelled statement.
+const killQueryTimeout = 5 * time.Second
+
+// killConnector wraps a MySQL connector so that cancelling the context of a
+// running statement kills the query on the server.
+type killConnector struct {
+	driver.Connector
+	killer *sql.DB // small separate pool issuing KILL QUERY
+}
+
+func newKillConnector(base driver.Connector) *killConnector {
+	killer := sql.OpenDB(base)
+	killer.SetMaxOpenConns(2)
+	return &killConnector{Connector: base, killer: killer}
+}
+
+func (c *killConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, err := connectionID(ctx, conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &killConn{Conn: conn, kill: func() { c.killQuery(id) }}, nil
+}
+
+// Close releases the kill pool. sql.DB.Close calls it.
+func (c *killConnector) Close() error {
+	return c.killer.Close()
+}
+
+func (c *killConnector) killQuery(id uint64) {
+	ctx, cancel := context.WithTimeout(context.Background(), killQueryTimeout)
+	defer cancel()
+	// Errors are ignored: the statement may already have finished.
+	_, _ = c.killer.ExecContext(ctx, fmt.Sprintf("KILL QUERY %d", id))
+}
+
+// connectionID returns the server thread id of conn.
+func connectionID(ctx context.Context, conn driver.Conn) (uint64, error) {
+	q, ok := conn.(driver.QueryerContext)
+	if !ok {
+		return 0, fmt.Errorf("mysql connection does not support queries")
+	}
+	rows, err := q.QueryContext(ctx, "SELECT CONNECTION_ID()", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		if err == io.EOF {
+			return 0, fmt.Errorf("mysql returned no connection id")
+		}
+		return 0, err
+	}
+	switch v := dest[0].(type) {
+	case int64:
+		return uint64(v), nil
+	case uint64:
+		return v, nil
+	case []byte:
+		var id uint64
+		_, err := fmt.Sscan(string(v), &id)
+		return id, err
+	default:
+		return 0, fmt.Errorf("unexpected connection id type %T", v)
+	}
+}
+
+// watchCancel kills the running query if ctx is cancelled before the
+// returned stop function is called. stop waits for a started kill to finish
+// so it cannot hit a later statement on the same connection.
+func watchCancel(ctx context.Context, kill func()) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		select {
+		case <-ctx.Done():
+			kill()
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// killConn forwards to the MySQL connection, watching statement contexts.
+type killConn struct {
+	driver.Conn
+	kill func()
+}
+
+func (c *killConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	defer watchCancel(ctx, c.kill)()
+	return q.QueryContext(ctx, query, args)
+}
+
+func (c *killConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	defer watchCancel(ctx, c.kill)()
+	return e.ExecContext(ctx, query, args)
+}
+
+func (c *killConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &killStmt{Stmt: stmt, kill: c.kill}, nil
+}
+
+func (c *killConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *killConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *killConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *killConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *killConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// killStmt forwards to a prepared MySQL statement, watching statement contexts.
+type killStmt struct {
+	driver.Stmt
+	kill func()
+}
+
+func (s *killStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		return nil, fmt.Errorf("mysql statement does not support QueryContext")
+	}
+	defer watchCancel(ctx, s.kill)()
+	return q.QueryContext(ctx, args)
+}
+
+func (s *killStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		return nil, fmt.Errorf("mysql statement does not support ExecContext")
+	}
+	defer watchCancel(ctx, s.kill)()
+	return e.ExecContext(ctx, args)
+}
+
+func (s *killStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (s *killStmt) ColumnConverter(idx int) driver.ValueConverter {
+	if c, ok := s.Stmt.(driver.ColumnConverter); ok {
+		return c.ColumnConverter(idx)
+	}
+	return driver.DefaultParameterConverter
+}
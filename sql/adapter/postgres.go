@@ -13,6 +13,8 @@ import (
 // PostgreSQLAdapter implements the Adapter interface for PostgreSQL.
 type PostgreSQLAdapter struct {
 	*BaseSQLAdapter
+
+	postGIS bool
 }
 
 // NewPostgreSQLAdapter creates a new PostgreSQL adapter.
@@ -24,6 +26,10 @@ func NewPostgreSQLAdapter() *PostgreSQLAdapter {
 
 // Connect establishes a connection to PostgreSQL.
 func (a *PostgreSQLAdapter) Connect(ctx context.Context, config *store.Config) (*sql.DB, error) {
+	if tlsConf := config.TLS; tlsConf != nil && tlsConf.ServerName != "" && tlsConf.ServerName != config.Host {
+		// lib/pq verifies the server certificate against the host it dials
+		return nil, store.NewConfigErrorForField("tls.server_name", tlsConf.ServerName, "PostgreSQL verifies the host name; set Host to the certificate's name")
+	}
 	connStr := a.ConnectionString(config)
 	return a.BaseSQLAdapter.Connect(ctx, config, connStr)
 }
@@ -33,36 +39,51 @@ func (a *PostgreSQLAdapter) ConnectionString(config *store.Config) string {
 	var parts []string
 
 	if config.Host != "" {
-		parts = append(parts, fmt.Sprintf("host=%s", config.Host))
+		parts = append(parts, fmt.Sprintf("host=%s", pgValue(config.Host)))
 	}
 	if config.Port > 0 {
 		parts = append(parts, fmt.Sprintf("port=%d", config.Port))
 	}
 	if config.Database != "" {
-		parts = append(parts, fmt.Sprintf("dbname=%s", config.Database))
+		parts = append(parts, fmt.Sprintf("dbname=%s", pgValue(config.Database)))
 	}
 	if config.Username != "" {
-		parts = append(parts, fmt.Sprintf("user=%s", config.Username))
+		parts = append(parts, fmt.Sprintf("user=%s", pgValue(config.Username)))
 	}
 	if config.Password != "" {
-		parts = append(parts, fmt.Sprintf("password=%s", config.Password))
+		parts = append(parts, fmt.Sprintf("password=%s", pgValue(config.Password)))
 	}
 
-	// SSL mode
-	sslMode := config.SSLMode
-	if sslMode == "" {
-		sslMode = "disable"
+	// SSL mode and certificates
+	parts = append(parts, fmt.Sprintf("sslmode=%s", sslMode(config)))
+	if tlsConf := config.TLS; tlsConf != nil {
+		if tlsConf.CAFile != "" {
+			parts = append(parts, fmt.Sprintf("sslrootcert=%s", pgValue(tlsConf.CAFile)))
+		}
+		if tlsConf.CertFile != "" {
+			parts = append(parts, fmt.Sprintf("sslcert=%s", pgValue(tlsConf.CertFile)))
+		}
+		if tlsConf.KeyFile != "" {
+			parts = append(parts, fmt.Sprintf("sslkey=%s", pgValue(tlsConf.KeyFile)))
+		}
 	}
-	parts = append(parts, fmt.Sprintf("sslmode=%s", sslMode))
 
 	// Add additional connection parameters
 	for key, value := range config.Options {
-		parts = append(parts, fmt.Sprintf("%s=%s", key, value))
+		parts = append(parts, fmt.Sprintf("%s=%s", key, pgValue(value)))
 	}
 
 	return strings.Join(parts, " ")
 }
 
+// pgValue quotes a connection string value containing spaces or quotes.
+func pgValue(v string) string {
+	if !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
 // PostgreSQL-specific overrides
 
 // MigrationTableSQL returns PostgreSQL-specific migration table SQL.
@@ -103,6 +124,24 @@ func (a *PostgreSQLAdapter) SupportsUpsert() bool {
 	return true
 }
 
+// SupportsFullTextSearch indicates PostgreSQL supports tsvector/tsquery search.
+func (a *PostgreSQLAdapter) SupportsFullTextSearch() bool {
+	return true
+}
+
+// WithPostGIS returns a copy of the adapter that reports spatial support.
+// The PostGIS extension must be installed in the target database.
+func (a *PostgreSQLAdapter) WithPostGIS() *PostgreSQLAdapter {
+	clone := *a
+	clone.postGIS = true
+	return &clone
+}
+
+// SupportsGeoSpatial indicates whether PostGIS functions are available.
+func (a *PostgreSQLAdapter) SupportsGeoSpatial() bool {
+	return a.postGIS
+}
+
 // QuoteIdentifier quotes a PostgreSQL identifier.
 func (a *PostgreSQLAdapter) QuoteIdentifier(identifier string) string {
 	return fmt.Sprintf(`"%s"`, strings.ReplaceAll(identifier, `"`, `""`))
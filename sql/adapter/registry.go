@@ -24,6 +24,8 @@ func NewRegistry() *Registry {
 	// Register built-in adapters
 	r.Register("postgresql", func() Adapter { return NewPostgreSQLAdapter() })
 	r.Register("postgres", func() Adapter { return NewPostgreSQLAdapter() }) // Alias
+	r.Register("cockroachdb", func() Adapter { return NewCockroachDBAdapter() })
+	r.Register("cockroach", func() Adapter { return NewCockroachDBAdapter() }) // Alias
 	r.Register("mysql", func() Adapter { return NewMySQLAdapter() })
 	r.Register("sqlite", func() Adapter { return NewSQLiteAdapter() })
 	r.Register("sqlite3", func() Adapter { return NewSQLiteAdapter() }) // Alias
@@ -122,6 +122,11 @@ func (a *SQLiteAdapter) SupportsUpsert() bool {
 	return true
 }
 
+// SupportsFullTextSearch indicates SQLite supports MATCH on FTS virtual tables.
+func (a *SQLiteAdapter) SupportsFullTextSearch() bool {
+	return true
+}
+
 // QuoteIdentifier quotes a SQLite identifier.
 func (a *SQLiteAdapter) QuoteIdentifier(identifier string) string {
 	return fmt.Sprintf(`"%s"`, strings.ReplaceAll(identifier, `"`, `""`))
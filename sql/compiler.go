@@ -2,105 +2,340 @@ package sqlstore
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"time"
+
+	"github.com/lib/pq"
 
 	"store"
+	"store/sql/adapter"
 )
 
-// CompileMutation compiles a mutation to SQL - simplified implementation
-func CompileMutation(tableName string, mutation store.Mutation) (*store.CompiledMutation, error) {
-	switch m := mutation.(type) {
-	case store.Insert:
-		return compileInsert(tableName, m)
-	case store.Update:
-		return compileUpdate(tableName, m)
-	case store.Delete:
-		return compileDelete(tableName, m)
-	default:
-		return nil, fmt.Errorf("unsupported mutation type: %T", mutation)
+// DefaultMaxInListSize is the largest number of values emitted in a single
+// IN (...) list before the compiler splits the condition into chunks.
+const DefaultMaxInListSize = 1000
+
+// SQLCompiler compiles store mutations and conditions into SQL.
+type SQLCompiler struct {
+	dialect       Dialect
+	maxInListSize int
+	maxParams     int
+	fullText      bool
+	geoSpatial    bool
+	quoteIdents   bool
+	identQuote    string // overrides the dialect's identifier quote
+	upsert        adapter.UpsertSyntax
+	returning     bool
+	json          bool
+	queries       *queryCache
+}
+
+// NewSQLCompiler creates a PostgreSQL compiler with default settings.
+func NewSQLCompiler() *SQLCompiler {
+	return &SQLCompiler{
+		dialect:       DialectPostgres,
+		maxInListSize: DefaultMaxInListSize,
+		fullText:      true,
+		upsert:        adapter.UpsertOnConflict,
+		returning:     true,
+		json:          true,
 	}
 }
 
-func compileInsert(tableName string, insert store.Insert) (*store.CompiledMutation, error) {
-	if len(insert.Values) == 0 {
-		return nil, fmt.Errorf("insert values cannot be empty")
+// WithDialect returns a copy of the compiler targeting the given dialect,
+// with the upsert clause, RETURNING support and identifier quote of that
+// dialect.
+func (c *SQLCompiler) WithDialect(dialect Dialect) *SQLCompiler {
+	cp := *c
+	cp.dialect = dialect
+	cp.returning = dialect != DialectMySQL
+	cp.identQuote = ""
+	if cp.upsert != adapter.UpsertNone {
+		cp.upsert = dialect.upsertSyntax()
 	}
+	return &cp
+}
 
-	var columns []string
-	var placeholders []string
-	var args []any
+// Dialect returns the dialect targeted by the compiler.
+func (c *SQLCompiler) Dialect() Dialect {
+	return c.dialect
+}
 
-	i := 1
-	for col, val := range insert.Values {
-		columns = append(columns, col)
-		placeholders = append(placeholders, fmt.Sprintf("$%d", i))
-		args = append(args, val)
-		i++
+// WithMaxInListSize returns a copy of the compiler that splits IN lists larger
+// than n into OR-ed chunks (AND-ed for NOT IN). A value <= 0 disables splitting.
+func (c *SQLCompiler) WithMaxInListSize(n int) *SQLCompiler {
+	cp := *c
+	cp.maxInListSize = n
+	return &cp
+}
+
+// MaxInListSize returns the configured maximum IN list size.
+func (c *SQLCompiler) MaxInListSize() int {
+	return c.maxInListSize
+}
+
+// WithMaxParams returns a copy of the compiler that rejects statements binding
+// more than n parameters. A value <= 0 uses the dialect's driver limit.
+func (c *SQLCompiler) WithMaxParams(n int) *SQLCompiler {
+	cp := *c
+	cp.maxParams = n
+	return &cp
+}
+
+// MaxParams returns the effective parameter limit.
+func (c *SQLCompiler) MaxParams() int {
+	if c.maxParams > 0 {
+		return c.maxParams
+	}
+	return c.dialect.maxParams()
+}
+
+// checkParamCount rejects statements with more parameters than the driver accepts.
+func (c *SQLCompiler) checkParamCount(n int) error {
+	if limit := c.MaxParams(); n > limit {
+		return fmt.Errorf("%w: statement binds %d parameters, exceeding the limit of %d; split the operation into smaller batches",
+			store.ErrInvalidQuery, n, limit)
 	}
+	return nil
+}
 
-	sql := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
-		tableName,
-		strings.Join(columns, ", "),
-		strings.Join(placeholders, ", "))
+// WithGeoSpatial returns a copy of the compiler with spatial conditions
+// enabled or disabled. Only PostgreSQL with PostGIS can compile them.
+func (c *SQLCompiler) WithGeoSpatial(enabled bool) *SQLCompiler {
+	cp := *c
+	cp.geoSpatial = enabled
+	return &cp
+}
 
-	return &store.CompiledMutation{
-		SQL:  sql,
-		Args: args,
-	}, nil
+// WithFullTextSearch returns a copy of the compiler with full-text conditions
+// enabled or disabled. Disabled compilers reject store.FullText and
+// store.Match conditions.
+func (c *SQLCompiler) WithFullTextSearch(enabled bool) *SQLCompiler {
+	cp := *c
+	cp.fullText = enabled
+	return &cp
 }
 
-func compileUpdate(tableName string, update store.Update) (*store.CompiledMutation, error) {
-	if len(update.Set) == 0 {
-		return nil, fmt.Errorf("update set values cannot be empty")
+// WithUpsert returns a copy of the compiler with native upserts enabled or
+// disabled. Disabled compilers reject store.Upsert, and MutationExecutor
+// emulates it with a select followed by an update or insert.
+func (c *SQLCompiler) WithUpsert(enabled bool) *SQLCompiler {
+	cp := *c
+	cp.upsert = adapter.UpsertNone
+	if enabled {
+		cp.upsert = c.dialect.upsertSyntax()
 	}
+	return &cp
+}
 
-	var setParts []string
+// WithCapabilities returns a copy of the compiler limited to the features of
+// an adapter: its upsert clause, RETURNING, JSON, full-text and spatial
+// conditions, parameter limit and identifier quote. Set the dialect first,
+// since WithDialect resets the dialect-specific features.
+func (c *SQLCompiler) WithCapabilities(caps adapter.Capabilities) *SQLCompiler {
+	cp := *c
+	cp.upsert = caps.Upsert
+	cp.returning = caps.Returning
+	cp.json = caps.JSON
+	cp.fullText = caps.FullTextSearch
+	cp.geoSpatial = caps.GeoSpatial
+	cp.maxParams = caps.MaxPlaceholders
+	cp.identQuote = caps.IdentifierQuote
+	return &cp
+}
+
+var defaultCompiler = NewSQLCompiler()
+
+// CompileQuery compiles a query builder into a SELECT statement.
+func (c *SQLCompiler) CompileQuery(qb *QueryBuilder) (string, []any, error) {
+	return c.compileQueryAt(qb, 1)
+}
+
+// compileQueryAt compiles a query whose placeholders are numbered from
+// startIndex, so it can be embedded as a subquery.
+func (c *SQLCompiler) compileQueryAt(qb *QueryBuilder, startIndex int) (string, []any, error) {
+	if qb.err != nil {
+		return "", nil, qb.err
+	}
+	if qb.table == "" {
+		return "", nil, fmt.Errorf("%w: query table cannot be empty", store.ErrInvalidQuery)
+	}
+	if err := checkQueryIdentifiers(qb); err != nil {
+		return "", nil, err
+	}
+	if c.quoteIdents {
+		qb = c.quoteQuery(qb)
+	}
+	if err := c.checkConditions(qb.conditions); err != nil {
+		return "", nil, err
+	}
+	for _, node := range qb.nodes {
+		if err := c.checkConditions(store.NodeConditions(node)); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := c.checkConditions(qb.having); err != nil {
+		return "", nil, err
+	}
+
+	columns := "*"
+	if len(qb.columns) > 0 {
+		columns = strings.Join(qb.columns, ", ")
+	}
+	switch {
+	case len(qb.distinctOn) > 0:
+		if c.dialect != DialectPostgres {
+			return "", nil, fmt.Errorf("%w: DISTINCT ON on %s", store.ErrNotSupported, c.dialect)
+		}
+		columns = "DISTINCT ON (" + strings.Join(qb.distinctOn, ", ") + ") " + columns
+	case qb.distinct:
+		columns = "DISTINCT " + columns
+	}
+
+	var sb strings.Builder
 	var args []any
-	i := 1
 
-	// Build SET clause
-	for col, val := range update.Set {
-		setParts = append(setParts, fmt.Sprintf("%s = $%d", col, i))
-		args = append(args, val)
-		i++
+	if len(qb.ctes) > 0 {
+		withSQL, withArgs, err := c.compileWith(qb.ctes, startIndex)
+		if err != nil {
+			return "", nil, err
+		}
+		sb.WriteString(withSQL + " ")
+		args = append(args, withArgs...)
 	}
 
-	sql := fmt.Sprintf("UPDATE %s SET %s", tableName, strings.Join(setParts, ", "))
+	from := qb.table
+	if qb.fromSub != nil {
+		subSQL, subArgs, err := c.compileQueryAt(qb.fromSub, startIndex+len(args))
+		if err != nil {
+			return "", nil, err
+		}
+		from = "(" + subSQL + ") " + qb.table
+		args = append(args, subArgs...)
+	}
+	fmt.Fprintf(&sb, "SELECT %s FROM %s", columns, from)
+	for _, j := range qb.joins {
+		fmt.Fprintf(&sb, " JOIN %s ON %s = %s", j.table, j.left, j.right)
+	}
 
-	// Build WHERE clause if conditions exist
-	if len(update.Where) > 0 {
-		whereSQL, whereArgs := compileConditions(update.Where, i)
-		sql += " WHERE " + whereSQL
+	if whereSQL, whereArgs, err := c.compileWhere(qb.conditions, qb.nodes, startIndex+len(args)); err != nil {
+		return "", nil, err
+	} else if whereSQL != "" {
+		sb.WriteString(" WHERE " + whereSQL)
 		args = append(args, whereArgs...)
 	}
 
-	return &store.CompiledMutation{
-		SQL:  sql,
-		Args: args,
-	}, nil
+	if len(qb.groupBy) > 0 {
+		sb.WriteString(" GROUP BY " + strings.Join(qb.groupBy, ", "))
+	}
+
+	// HAVING placeholders continue after those of the WHERE clause
+	if havingSQL, havingArgs, err := c.compileWhere(qb.having, nil, startIndex+len(args)); err != nil {
+		return "", nil, err
+	} else if havingSQL != "" {
+		sb.WriteString(" HAVING " + havingSQL)
+		args = append(args, havingArgs...)
+	}
+
+	if len(qb.orders) > 0 || qb.random {
+		terms := c.compileOrders(qb.orders)
+		if qb.random {
+			if terms != "" {
+				terms += ", "
+			}
+			terms += c.dialect.randomFunc()
+		}
+		sb.WriteString(" ORDER BY " + terms)
+	}
+
+	sb.WriteString(c.compileLimitOffset(qb.limit, qb.offset))
+
+	if qb.lock != "" {
+		if c.dialect == DialectSQLite {
+			return "", nil, fmt.Errorf("%w: row locking on %s", store.ErrNotSupported, c.dialect)
+		}
+		sb.WriteString(" " + qb.lock)
+	}
+
+	if err := c.checkParamCount(len(args)); err != nil {
+		return "", nil, err
+	}
+	return sb.String(), args, nil
 }
 
-func compileDelete(tableName string, delete store.Delete) (*store.CompiledMutation, error) {
-	sql := fmt.Sprintf("DELETE FROM %s", tableName)
+// compileWith compiles the WITH clause of a query. RECURSIVE is emitted once
+// when any expression is recursive, as PostgreSQL requires.
+func (c *SQLCompiler) compileWith(ctes []cte, startIndex int) (string, []any, error) {
+	var parts []string
 	var args []any
+	recursive := false
+	for _, e := range ctes {
+		head := e.name
+		if len(e.columns) > 0 {
+			head += " (" + strings.Join(e.columns, ", ") + ")"
+		}
 
-	// Build WHERE clause if conditions exist
-	if len(delete.Where) > 0 {
-		whereSQL, whereArgs := compileConditions(delete.Where, 1)
-		sql += " WHERE " + whereSQL
-		args = append(args, whereArgs...)
+		body, bodyArgs, err := c.compileQueryAt(e.query, startIndex+len(args))
+		if err != nil {
+			return "", nil, err
+		}
+		args = append(args, bodyArgs...)
+		if e.recursive != nil {
+			recursive = true
+			step, stepArgs, err := c.compileQueryAt(e.recursive, startIndex+len(args))
+			if err != nil {
+				return "", nil, err
+			}
+			args = append(args, stepArgs...)
+			body += " UNION ALL " + step
+		}
+		parts = append(parts, head+" AS ("+body+")")
 	}
 
-	return &store.CompiledMutation{
-		SQL:  sql,
-		Args: args,
-	}, nil
+	keyword := "WITH "
+	if recursive {
+		keyword = "WITH RECURSIVE "
+	}
+	return keyword + strings.Join(parts, ", "), args, nil
 }
 
-// compileConditions compiles a list of conditions to SQL WHERE clause (all ANDed together)
-func compileConditions(conditions []store.Condition, startIndex int) (string, []any) {
+// compileOrders compiles ORDER BY terms.
+func (c *SQLCompiler) compileOrders(orders []store.Order) string {
+	parts := make([]string, 0, len(orders))
+	for _, o := range orders {
+		dir := "ASC"
+		if o.Desc {
+			dir = "DESC"
+		}
+		parts = append(parts, o.Field+" "+dir)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// compileLimitOffset compiles LIMIT / OFFSET. MySQL and SQLite require a LIMIT
+// whenever OFFSET is present, so an unbounded limit is emitted in that case.
+func (c *SQLCompiler) compileLimitOffset(limit, offset int) string {
+	var sql string
+	switch {
+	case limit > 0:
+		sql = fmt.Sprintf(" LIMIT %d", limit)
+	case offset > 0 && c.dialect == DialectMySQL:
+		sql = " LIMIT 18446744073709551615"
+	case offset > 0 && c.dialect == DialectSQLite:
+		sql = " LIMIT -1"
+	}
+	if offset > 0 {
+		sql += fmt.Sprintf(" OFFSET %d", offset)
+	}
+	return sql
+}
+
+// compileConditions compiles a list of conditions to SQL WHERE clause (all
+// ANDed together). It fails only when a subquery does not compile.
+func (c *SQLCompiler) compileConditions(conditions []store.Condition, startIndex int) (string, []any, error) {
 	if len(conditions) == 0 {
-		return "", nil
+		return "", nil, nil
 	}
 
 	var parts []string
@@ -108,52 +343,357 @@ func compileConditions(conditions []store.Condition, startIndex int) (string, []
 	i := startIndex
 
 	for _, cond := range conditions {
+		if ref, ok := cond.Value.(store.FieldRef); ok {
+			parts = append(parts, fmt.Sprintf("%s %s %s", cond.Field, comparisonOperators[cond.Op], ref))
+			continue
+		}
+
 		switch cond.Op {
 		case store.OpEq:
-			parts = append(parts, fmt.Sprintf("%s = $%d", cond.Field, i))
+			parts = append(parts, fmt.Sprintf("%s = %s", cond.Field, c.dialect.placeholder(i)))
 			args = append(args, cond.Value)
 			i++
 		case store.OpNe:
-			parts = append(parts, fmt.Sprintf("%s != $%d", cond.Field, i))
+			parts = append(parts, fmt.Sprintf("%s != %s", cond.Field, c.dialect.placeholder(i)))
 			args = append(args, cond.Value)
 			i++
 		case store.OpGt:
-			parts = append(parts, fmt.Sprintf("%s > $%d", cond.Field, i))
+			parts = append(parts, fmt.Sprintf("%s > %s", cond.Field, c.dialect.placeholder(i)))
 			args = append(args, cond.Value)
 			i++
 		case store.OpGe:
-			parts = append(parts, fmt.Sprintf("%s >= $%d", cond.Field, i))
+			parts = append(parts, fmt.Sprintf("%s >= %s", cond.Field, c.dialect.placeholder(i)))
 			args = append(args, cond.Value)
 			i++
 		case store.OpLt:
-			parts = append(parts, fmt.Sprintf("%s < $%d", cond.Field, i))
+			parts = append(parts, fmt.Sprintf("%s < %s", cond.Field, c.dialect.placeholder(i)))
 			args = append(args, cond.Value)
 			i++
 		case store.OpLe:
-			parts = append(parts, fmt.Sprintf("%s <= $%d", cond.Field, i))
+			parts = append(parts, fmt.Sprintf("%s <= %s", cond.Field, c.dialect.placeholder(i)))
+			args = append(args, cond.Value)
+			i++
+		case store.OpDistinctFrom:
+			parts = append(parts, c.dialect.distinctFrom(cond.Field, c.dialect.placeholder(i)))
+			args = append(args, cond.Value)
+			i++
+		case store.OpPrefix, store.OpSuffix, store.OpContains:
+			parts = append(parts, fmt.Sprintf("%s LIKE %s %s", cond.Field, c.dialect.placeholder(i), c.dialect.likeEscape()))
+			args = append(args, likePattern(cond))
+			i++
+		case store.OpLike, store.OpILike:
+			parts = append(parts, c.dialect.likeMatch(cond.Field, c.dialect.placeholder(i), cond.Op == store.OpILike))
+			args = append(args, cond.Value)
+			i++
+		case store.OpRegex:
+			parts = append(parts, c.dialect.regexMatch(cond.Field, c.dialect.placeholder(i)))
 			args = append(args, cond.Value)
 			i++
 		case store.OpIsNull:
 			parts = append(parts, fmt.Sprintf("%s IS NULL", cond.Field))
 		case store.OpNotNull:
 			parts = append(parts, fmt.Sprintf("%s IS NOT NULL", cond.Field))
-		case store.OpIn:
-			if values, ok := cond.Value.([]any); ok && len(values) > 0 {
-				var placeholders []string
-				for _, val := range values {
-					placeholders = append(placeholders, fmt.Sprintf("$%d", i))
-					args = append(args, val)
-					i++
+		case store.OpFullText, store.OpMatch:
+			parts = append(parts, c.dialect.fullTextMatch(cond.Field, c.dialect.placeholder(i), cond.Op == store.OpFullText))
+			args = append(args, cond.Value)
+			i++
+		case store.OpWithinBBox:
+			box, _ := cond.Value.(store.BBox)
+			parts = append(parts, fmt.Sprintf("ST_Within(%s, ST_MakeEnvelope(%s, %s, %s, %s, 4326))", cond.Field,
+				c.dialect.placeholder(i), c.dialect.placeholder(i+1), c.dialect.placeholder(i+2), c.dialect.placeholder(i+3)))
+			args = append(args, box.MinLng, box.MinLat, box.MaxLng, box.MaxLat)
+			i += 4
+		case store.OpArrayOverlaps:
+			values, _ := cond.Value.([]any)
+			parts = append(parts, fmt.Sprintf("%s && %s", cond.Field, c.dialect.placeholder(i)))
+			args = append(args, pq.Array(values))
+			i++
+		case store.OpJSONEq:
+			jp, _ := cond.Value.(store.JSONPath)
+			parts = append(parts, fmt.Sprintf("%s = %s", c.dialect.jsonExtract(cond.Field, jp.Path), c.dialect.placeholder(i)))
+			args = append(args, jp.Value)
+			i++
+		case store.OpWithinLast:
+			d, _ := cond.Value.(time.Duration)
+			parts = append(parts, fmt.Sprintf("%s >= %s", cond.Field, c.dialect.nowMinus(c.dialect.placeholder(i))))
+			args = append(args, c.dialect.intervalArg(d))
+			i++
+		case store.OpBetween:
+			r, _ := store.BetweenBounds(cond.Value)
+			lo, hi := c.dialect.placeholder(i), c.dialect.placeholder(i+1)
+			if r.IncLow && r.IncHigh {
+				parts = append(parts, fmt.Sprintf("%s BETWEEN %s AND %s", cond.Field, lo, hi))
+			} else {
+				lowOp, highOp := ">", "<"
+				if r.IncLow {
+					lowOp = ">="
+				}
+				if r.IncHigh {
+					highOp = "<="
+				}
+				parts = append(parts, fmt.Sprintf("(%s %s %s AND %s %s %s)", cond.Field, lowOp, lo, cond.Field, highOp, hi))
+			}
+			args = append(args, r.From, r.To)
+			i += 2
+		case store.OpIn, store.OpNotIn:
+			if sub, ok := subqueryOf(cond.Value); ok {
+				subSQL, subArgs, err := c.compileQueryAt(sub, i)
+				if err != nil {
+					return "", nil, err
+				}
+				keyword := "IN"
+				if cond.Op == store.OpNotIn {
+					keyword = "NOT IN"
 				}
-				parts = append(parts, fmt.Sprintf("%s IN (%s)", cond.Field, strings.Join(placeholders, ", ")))
+				parts = append(parts, fmt.Sprintf("%s %s (%s)", cond.Field, keyword, subSQL))
+				args = append(args, subArgs...)
+				i += len(subArgs)
+				break
 			}
+			values, _ := cond.Value.([]any)
+			if len(values) == 0 {
+				// An empty list matches no rows for IN and every row for NOT IN
+				if cond.Op == store.OpIn {
+					parts = append(parts, "1 = 0")
+				} else {
+					parts = append(parts, "1 = 1")
+				}
+				break
+			}
+			var inSQL string
+			var inArgs []any
+			inSQL, inArgs, i = c.compileInList(cond.Field, cond.Op == store.OpNotIn, values, i)
+			parts = append(parts, inSQL)
+			args = append(args, inArgs...)
 		default:
-			// For unsupported operators, just do equality
-			parts = append(parts, fmt.Sprintf("%s = $%d", cond.Field, i))
-			args = append(args, cond.Value)
+			return "", nil, fmt.Errorf("%w: operator %s", store.ErrNotSupported, cond.Op)
+		}
+	}
+
+	return strings.Join(parts, " AND "), args, nil
+}
+
+// compileWhere compiles plain conditions and filter trees, all ANDed together.
+func (c *SQLCompiler) compileWhere(conditions []store.Condition, nodes []store.Node, startIndex int) (string, []any, error) {
+	var parts []string
+	var args []any
+	i := startIndex
+
+	if len(conditions) > 0 {
+		condSQL, condArgs, err := c.compileConditions(conditions, i)
+		if err != nil {
+			return "", nil, err
+		}
+		parts = append(parts, condSQL)
+		args = append(args, condArgs...)
+		i += len(condArgs)
+	}
+
+	for _, node := range nodes {
+		nodeSQL, nodeArgs, err := c.compileNode(node, i)
+		if err != nil {
+			return "", nil, err
+		}
+		parts = append(parts, nodeSQL)
+		args = append(args, nodeArgs...)
+		i += len(nodeArgs)
+	}
+
+	return strings.Join(parts, " AND "), args, nil
+}
+
+// compileNode compiles a filter tree. Groups are parenthesized so the
+// output can be combined with other terms safely.
+func (c *SQLCompiler) compileNode(node store.Node, startIndex int) (string, []any, error) {
+	switch n := node.(type) {
+	case store.Condition:
+		return c.compileConditions([]store.Condition{n}, startIndex)
+	case store.AndNode:
+		return c.compileNodeGroup(n.Nodes, " AND ", "1 = 1", startIndex)
+	case store.OrNode:
+		return c.compileNodeGroup(n.Nodes, " OR ", "1 = 0", startIndex)
+	case store.NotNode:
+		inner, args, err := c.compileNode(n.Node, startIndex)
+		if err != nil {
+			return "", nil, err
+		}
+		if _, leaf := n.Node.(store.Condition); leaf {
+			inner = "(" + inner + ")"
+		}
+		return "NOT " + inner, args, nil
+	default:
+		return "", nil, fmt.Errorf("%w: unknown node type %T", store.ErrInvalidQuery, node)
+	}
+}
+
+// compileNodeGroup joins child nodes with sep. Empty groups compile to the
+// constant predicate given by empty.
+func (c *SQLCompiler) compileNodeGroup(nodes []store.Node, sep, empty string, startIndex int) (string, []any, error) {
+	if len(nodes) == 0 {
+		return empty, nil, nil
+	}
+
+	parts := make([]string, 0, len(nodes))
+	var args []any
+	i := startIndex
+	for _, child := range nodes {
+		sql, childArgs, err := c.compileNode(child, i)
+		if err != nil {
+			return "", nil, err
+		}
+		parts = append(parts, sql)
+		args = append(args, childArgs...)
+		i += len(childArgs)
+	}
+
+	return "(" + strings.Join(parts, sep) + ")", args, nil
+}
+
+// compiledOperators are the operators compileConditions can express.
+var compiledOperators = map[store.Operator]bool{
+	store.OpEq: true, store.OpNe: true, store.OpGt: true, store.OpGe: true, store.OpLt: true, store.OpLe: true,
+	store.OpIn: true, store.OpNotIn: true, store.OpBetween: true,
+	store.OpPrefix: true, store.OpSuffix: true, store.OpContains: true,
+	store.OpLike: true, store.OpILike: true, store.OpRegex: true,
+	store.OpIsNull: true, store.OpNotNull: true,
+	store.OpFullText: true, store.OpMatch: true,
+	store.OpWithinLast: true, store.OpWithinBBox: true,
+	store.OpDistinctFrom: true, store.OpArrayOverlaps: true, store.OpJSONEq: true,
+}
+
+// comparisonOperators maps the operators usable between two fields to SQL.
+var comparisonOperators = map[store.Operator]string{
+	store.OpEq: "=",
+	store.OpNe: "!=",
+	store.OpGt: ">",
+	store.OpGe: ">=",
+	store.OpLt: "<",
+	store.OpLe: "<=",
+}
+
+// checkConditions rejects conditions the compiler cannot express.
+func (c *SQLCompiler) checkConditions(conditions []store.Condition) error {
+	for _, cond := range conditions {
+		if !compiledOperators[cond.Op] {
+			return fmt.Errorf("%w: operator %s", store.ErrNotSupported, cond.Op)
+		}
+		if cond.Op == store.OpRegex && c.dialect == DialectSQLite {
+			return fmt.Errorf("%w: regex condition on %s", store.ErrNotSupported, cond.Field)
+		}
+		if (cond.Op == store.OpFullText || cond.Op == store.OpMatch) && !c.fullText {
+			return fmt.Errorf("%w: full-text search on %s", store.ErrNotSupported, cond.Field)
+		}
+		if cond.Op == store.OpWithinBBox && (!c.geoSpatial || c.dialect != DialectPostgres) {
+			return fmt.Errorf("%w: spatial condition on %s", store.ErrNotSupported, cond.Field)
+		}
+		if cond.Op == store.OpArrayOverlaps && c.dialect != DialectPostgres {
+			return fmt.Errorf("%w: array condition on %s", store.ErrNotSupported, cond.Field)
+		}
+		if _, ok := cond.Value.(store.FieldRef); ok && comparisonOperators[cond.Op] == "" {
+			return fmt.Errorf("%w: operator %s cannot compare fields", store.ErrInvalidQuery, cond.Op)
+		}
+		if _, ok := subqueryOf(cond.Value); ok && cond.Op != store.OpIn && cond.Op != store.OpNotIn {
+			// The subquery itself is checked when it is compiled
+			return fmt.Errorf("%w: subquery on %s requires IN or NOT IN", store.ErrInvalidQuery, cond.Field)
+		}
+		if cond.Op == store.OpJSONEq && !c.json {
+			return fmt.Errorf("%w: json condition on %s", store.ErrNotSupported, cond.Field)
+		}
+		if jp, ok := cond.Value.(store.JSONPath); cond.Op == store.OpJSONEq && (!ok || !jsonPathPattern.MatchString(jp.Path)) {
+			return fmt.Errorf("%w: json condition on %s expects a store.JSONPath such as $.key[0]", store.ErrInvalidQuery, cond.Field)
+		}
+		if _, ok := store.BetweenBounds(cond.Value); cond.Op == store.OpBetween && !ok {
+			return fmt.Errorf("%w: between on %s expects [2]any or store.Range bounds", store.ErrInvalidQuery, cond.Field)
+		}
+	}
+	return nil
+}
+
+// likePattern returns the LIKE pattern of an OpPrefix, OpSuffix or
+// OpContains condition.
+func likePattern(cond store.Condition) string {
+	pattern := escapeLike(fmt.Sprint(cond.Value))
+	switch cond.Op {
+	case store.OpPrefix:
+		return pattern + "%"
+	case store.OpSuffix:
+		return "%" + pattern
+	default:
+		return "%" + pattern + "%"
+	}
+}
+
+// subqueryOf returns the query builder of a subquery condition value.
+func subqueryOf(value any) (*QueryBuilder, bool) {
+	switch v := value.(type) {
+	case *QueryBuilder:
+		return v, v != nil
+	case store.Query:
+		return queryBuilderFrom(v), true
+	case *store.Query:
+		if v == nil {
+			return nil, false
+		}
+		return queryBuilderFrom(*v), true
+	}
+	return nil, false
+}
+
+// nodesConditions returns the leaf conditions of filter trees, in order.
+func nodesConditions(nodes []store.Node) []store.Condition {
+	var conds []store.Condition
+	for _, node := range nodes {
+		conds = append(conds, store.NodeConditions(node)...)
+	}
+	return conds
+}
+
+// compileInList compiles an IN / NOT IN list, splitting it into chunks of at
+// most maxInListSize values. IN chunks are OR-ed and NOT IN chunks are AND-ed,
+// and the whole expression is parenthesized when more than one chunk is emitted.
+// It returns the SQL, the arguments and the next placeholder index.
+func (c *SQLCompiler) compileInList(field string, negate bool, values []any, startIndex int) (string, []any, int) {
+	keyword, joiner := "IN", " OR "
+	if negate {
+		keyword, joiner = "NOT IN", " AND "
+	}
+
+	chunkSize := c.maxInListSize
+	if chunkSize <= 0 {
+		chunkSize = len(values)
+	}
+
+	var chunks []string
+	args := make([]any, 0, len(values))
+	i := startIndex
+
+	for start := 0; start < len(values); start += chunkSize {
+		end := start + chunkSize
+		if end > len(values) {
+			end = len(values)
+		}
+
+		placeholders := make([]string, 0, end-start)
+		for _, val := range values[start:end] {
+			placeholders = append(placeholders, c.dialect.placeholder(i))
+			args = append(args, val)
 			i++
 		}
+		chunks = append(chunks, fmt.Sprintf("%s %s (%s)", field, keyword, strings.Join(placeholders, ", ")))
 	}
 
-	return strings.Join(parts, " AND "), args
+	if len(chunks) == 1 {
+		return chunks[0], args, i
+	}
+	return "(" + strings.Join(chunks, joiner) + ")", args, i
+}
+
+// sortedKeys returns the keys of m in sorted order so compiled SQL is deterministic.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
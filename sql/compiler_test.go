@@ -0,0 +1,880 @@
+package sqlstore_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"store"
+	sqlstore "store/sql"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestCompileInListSplitting(t *testing.T) {
+	values := make([]any, 2000)
+	for i := range values {
+		values[i] = i
+	}
+
+	compiler := sqlstore.NewSQLCompiler().WithMaxInListSize(500)
+	compiled, err := compiler.CompileMutation("items", store.NewDelete(store.In("id", values...)))
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+
+	if got := strings.Count(compiled.SQL, "id IN ("); got != 4 {
+		t.Fatalf("expected 4 IN chunks, got %d: %s", got, compiled.SQL)
+	}
+	if got := strings.Count(compiled.SQL, ") OR id IN ("); got != 3 {
+		t.Errorf("expected chunks to be OR-ed, got %d joins", got)
+	}
+	if !strings.HasPrefix(compiled.SQL, "DELETE FROM items WHERE (id IN ($1, ") || !strings.HasSuffix(compiled.SQL, ", $2000))") {
+		t.Errorf("unexpected SQL boundaries: %s", compiled.SQL)
+	}
+	if !strings.Contains(compiled.SQL, "$500) OR id IN ($501,") {
+		t.Errorf("placeholder numbering not continuous across chunks")
+	}
+	if len(compiled.Args) != 2000 || compiled.Args[1999] != 1999 {
+		t.Errorf("unexpected args: len=%d", len(compiled.Args))
+	}
+}
+
+func TestCompileInListSplittingMatchesRows(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	if _, err := db.ExecContext(ctx, "CREATE TABLE items (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	for i := 0; i < 2500; i++ {
+		if _, err := db.ExecContext(ctx, "INSERT INTO items (id) VALUES ($1)", i); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+
+	values := make([]any, 2000)
+	for i := range values {
+		values[i] = i
+	}
+
+	compiler := sqlstore.NewSQLCompiler().WithMaxInListSize(300)
+	compiled, err := compiler.CompileMutation("items", store.NewDelete(store.In("id", values...)))
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	res, err := db.ExecContext(ctx, compiled.SQL, compiled.Args...)
+	if err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n != 2000 {
+		t.Errorf("expected 2000 rows deleted, got %d", n)
+	}
+
+	var remaining int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM items").Scan(&remaining); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if remaining != 500 {
+		t.Errorf("expected 500 remaining rows, got %d", remaining)
+	}
+}
+
+func TestCompileNotInListSplitting(t *testing.T) {
+	values := make([]any, 5)
+	for i := range values {
+		values[i] = fmt.Sprintf("v%d", i)
+	}
+
+	compiler := sqlstore.NewSQLCompiler().WithMaxInListSize(2)
+	compiled, err := compiler.CompileMutation("items", store.NewDelete(store.NotIn("name", values...)))
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+
+	want := "DELETE FROM items WHERE (name NOT IN ($1, $2) AND name NOT IN ($3, $4) AND name NOT IN ($5))"
+	if compiled.SQL != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", compiled.SQL, want)
+	}
+}
+
+func TestCompileUpdateFromPerDialect(t *testing.T) {
+	mutation := store.NewUpdateFrom("price_feed",
+		map[string]string{"sku": "sku"},
+		map[string]string{"price": "new_price"},
+		store.Eq("price_feed.active", true),
+	)
+	mutation.Set = map[string]any{"source": "feed"}
+
+	tests := []struct {
+		dialect sqlstore.Dialect
+		want    string
+	}{
+		{sqlstore.DialectPostgres, "UPDATE products SET price = price_feed.new_price, source = $1 FROM price_feed WHERE products.sku = price_feed.sku AND price_feed.active = $2"},
+		{sqlstore.DialectSQLite, "UPDATE products SET price = price_feed.new_price, source = $1 FROM price_feed WHERE products.sku = price_feed.sku AND price_feed.active = $2"},
+		{sqlstore.DialectMySQL, "UPDATE products JOIN price_feed ON products.sku = price_feed.sku SET products.price = price_feed.new_price, products.source = ? WHERE price_feed.active = ?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *testing.T) {
+			compiled, err := sqlstore.NewSQLCompiler().WithDialect(tt.dialect).CompileMutation("products", mutation)
+			if err != nil {
+				t.Fatalf("compile failed: %v", err)
+			}
+			if compiled.SQL != tt.want {
+				t.Errorf("unexpected SQL:\n got: %s\nwant: %s", compiled.SQL, tt.want)
+			}
+			if len(compiled.Args) != 2 || compiled.Args[0] != "feed" || compiled.Args[1] != true {
+				t.Errorf("unexpected args: %v", compiled.Args)
+			}
+		})
+	}
+}
+
+func TestUpdateFromAppliesJoinedValues(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	setup := []string{
+		"CREATE TABLE products (sku TEXT PRIMARY KEY, price INTEGER)",
+		"CREATE TABLE price_feed (sku TEXT, new_price INTEGER, active BOOLEAN)",
+		"INSERT INTO products VALUES ('a', 1), ('b', 2), ('c', 3)",
+		"INSERT INTO price_feed VALUES ('a', 10, 1), ('b', 20, 0), ('c', 30, 1)",
+	}
+	for _, stmt := range setup {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	mutation := store.NewUpdateFrom("price_feed",
+		map[string]string{"sku": "sku"},
+		map[string]string{"price": "new_price"},
+		store.Eq("price_feed.active", true),
+	)
+	compiled, err := sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectSQLite).CompileMutation("products", mutation)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, compiled.SQL, compiled.Args...); err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+
+	want := map[string]int{"a": 10, "b": 2, "c": 30}
+	for sku, price := range want {
+		var got int
+		if err := db.QueryRowContext(ctx, "SELECT price FROM products WHERE sku = $1", sku).Scan(&got); err != nil {
+			t.Fatalf("select: %v", err)
+		}
+		if got != price {
+			t.Errorf("sku %s: expected price %d, got %d", sku, price, got)
+		}
+	}
+}
+
+func TestCompileWithinLastPerDialect(t *testing.T) {
+	tests := []struct {
+		dialect sqlstore.Dialect
+		want    string
+		arg     any
+	}{
+		{sqlstore.DialectPostgres, "SELECT * FROM events WHERE created_at >= NOW() - $1 * INTERVAL '1 second'", int64(604800)},
+		{sqlstore.DialectMySQL, "SELECT * FROM events WHERE created_at >= NOW() - INTERVAL ? SECOND", int64(604800)},
+		{sqlstore.DialectSQLite, "SELECT * FROM events WHERE created_at >= datetime('now', $1)", "-604800 seconds"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *testing.T) {
+			qb := sqlstore.NewQueryBuilder("events").WhereCondition(store.WithinLast("created_at", 7*24*time.Hour))
+			query, args, err := sqlstore.NewSQLCompiler().WithDialect(tt.dialect).CompileQuery(qb)
+			if err != nil {
+				t.Fatalf("compile failed: %v", err)
+			}
+			if query != tt.want {
+				t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, tt.want)
+			}
+			if len(args) != 1 || args[0] != tt.arg {
+				t.Errorf("unexpected args: %v", args)
+			}
+		})
+	}
+}
+
+func TestWithinLastFiltersRows(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	setup := []string{
+		"CREATE TABLE events (id INTEGER PRIMARY KEY, created_at TEXT)",
+		"INSERT INTO events VALUES (1, datetime('now', '-1 hours')), (2, datetime('now', '-3 days')), (3, datetime('now', '-10 days'))",
+	}
+	for _, stmt := range setup {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	qb := sqlstore.NewQueryBuilder("events").Select("COUNT(*)").WhereCondition(store.WithinLast("created_at", 7*24*time.Hour))
+	query, args, err := sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectSQLite).CompileQuery(qb)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+
+	var count int
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 events in the last 7 days, got %d", count)
+	}
+}
+
+func TestInsertSelectCopiesFilteredRows(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	setup := []string{
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, customer TEXT, total INTEGER, status TEXT)",
+		"CREATE TABLE archived_orders (order_id INTEGER, customer TEXT)",
+		"INSERT INTO orders VALUES (1, 'ann', 10, 'done'), (2, 'bob', 20, 'open'), (3, 'cid', 30, 'done'), (4, 'dan', 5, 'done')",
+	}
+	for _, stmt := range setup {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	mutation := store.NewInsertSelect([]string{"order_id", "customer"}, store.Query{
+		From:    "orders",
+		Columns: []string{"id", "customer"},
+		Where:   []store.Condition{store.Eq("status", "done"), store.Ge("total", 10)},
+		OrderBy: []store.Order{store.Asc("id")},
+	})
+
+	compiled, err := sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectSQLite).CompileMutation("archived_orders", mutation)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	want := "INSERT INTO archived_orders (order_id, customer) SELECT id, customer FROM orders WHERE status = $1 AND total >= $2 ORDER BY id ASC"
+	if compiled.SQL != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", compiled.SQL, want)
+	}
+
+	res, err := db.ExecContext(ctx, compiled.SQL, compiled.Args...)
+	if err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n != 2 {
+		t.Errorf("expected 2 rows copied, got %d", n)
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT order_id, customer FROM archived_orders ORDER BY order_id")
+	if err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	defer rows.Close()
+
+	var got []string
+	for rows.Next() {
+		var id int
+		var customer string
+		if err := rows.Scan(&id, &customer); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		got = append(got, fmt.Sprintf("%d:%s", id, customer))
+	}
+	if strings.Join(got, ",") != "1:ann,3:cid" {
+		t.Errorf("unexpected archived rows: %v", got)
+	}
+}
+
+func TestInsertSelectColumnMismatch(t *testing.T) {
+	mutation := store.NewInsertSelect([]string{"a", "b"}, store.Query{From: "src", Columns: []string{"a"}})
+	if _, err := sqlstore.NewSQLCompiler().CompileMutation("dst", mutation); err == nil {
+		t.Error("expected an error for mismatched column counts")
+	}
+}
+
+func TestCompileRejectsTooManyParams(t *testing.T) {
+	values := make([]any, 70000)
+	for i := range values {
+		values[i] = i
+	}
+
+	_, err := sqlstore.NewSQLCompiler().CompileMutation("items", store.NewDelete(store.In("id", values...)))
+	if !errors.Is(err, store.ErrInvalidQuery) {
+		t.Fatalf("expected ErrInvalidQuery for 70000 parameters, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "65535") {
+		t.Errorf("expected the error to mention the limit, got %v", err)
+	}
+
+	qb := sqlstore.NewQueryBuilder("items").WhereCondition(store.In("id", values[:40000]...))
+	if _, _, err := sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectSQLite).CompileQuery(qb); !errors.Is(err, store.ErrInvalidQuery) {
+		t.Errorf("expected SQLite limit to reject 40000 parameters, got %v", err)
+	}
+}
+
+func TestCompileMaxParamsConfigurable(t *testing.T) {
+	compiler := sqlstore.NewSQLCompiler().WithMaxParams(3)
+
+	if _, err := compiler.CompileMutation("items", store.NewDelete(store.In("id", 1, 2, 3))); err != nil {
+		t.Fatalf("expected 3 parameters to be allowed: %v", err)
+	}
+	if _, err := compiler.CompileMutation("items", store.NewDelete(store.In("id", 1, 2, 3, 4))); !errors.Is(err, store.ErrInvalidQuery) {
+		t.Fatalf("expected ErrInvalidQuery above the configured limit, got %v", err)
+	}
+}
+
+func TestCompileUpdateCase(t *testing.T) {
+	mutation := store.NewUpdateCase("id",
+		store.CaseRow{Key: "a", Set: map[string]any{"name": "alpha", "rank": 1}},
+		store.CaseRow{Key: "b", Set: map[string]any{"name": "beta"}},
+	)
+
+	compiled, err := sqlstore.NewSQLCompiler().CompileMutation("gadgets", mutation)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+
+	want := "UPDATE gadgets SET " +
+		"name = CASE id WHEN $1 THEN $2 WHEN $3 THEN $4 ELSE name END, " +
+		"rank = CASE id WHEN $5 THEN $6 ELSE rank END " +
+		"WHERE id IN ($7, $8)"
+	if compiled.SQL != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", compiled.SQL, want)
+	}
+	wantArgs := []any{"a", "alpha", "b", "beta", "a", 1, "a", "b"}
+	if fmt.Sprint(compiled.Args) != fmt.Sprint(wantArgs) {
+		t.Errorf("unexpected args: %v", compiled.Args)
+	}
+}
+
+type unknownMutation struct{ store.Delete }
+
+func TestCompileMutationPerDialect(t *testing.T) {
+	values := map[string]any{"name": "alpha", "id": "a", "rank": 1}
+	tests := []struct {
+		dialect    sqlstore.Dialect
+		wantInsert string
+		wantUpdate string
+		wantDelete string
+	}{
+		{sqlstore.DialectPostgres,
+			"INSERT INTO gadgets (id, name, rank) VALUES ($1, $2, $3)",
+			"UPDATE gadgets SET id = $1, name = $2, rank = $3 WHERE id = $4",
+			"DELETE FROM gadgets WHERE id = $1"},
+		{sqlstore.DialectMySQL,
+			"INSERT INTO gadgets (id, name, rank) VALUES (?, ?, ?)",
+			"UPDATE gadgets SET id = ?, name = ?, rank = ? WHERE id = ?",
+			"DELETE FROM gadgets WHERE id = ?"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *testing.T) {
+			compiler := sqlstore.NewSQLCompiler().WithDialect(tt.dialect)
+			for _, c := range []struct {
+				mutation store.Mutation
+				want     string
+			}{
+				{store.NewInsert(values), tt.wantInsert},
+				{store.NewUpdate(values, store.Eq("id", "a")), tt.wantUpdate},
+				{store.NewDelete(store.Eq("id", "a")), tt.wantDelete},
+			} {
+				// Columns are sorted, so repeated compilations are identical
+				for i := 0; i < 5; i++ {
+					compiled, err := compiler.CompileMutation("gadgets", c.mutation)
+					if err != nil {
+						t.Fatalf("compile failed: %v", err)
+					}
+					if compiled.SQL != c.want {
+						t.Fatalf("unexpected SQL:\n got: %s\nwant: %s", compiled.SQL, c.want)
+					}
+				}
+			}
+		})
+	}
+
+	if _, err := sqlstore.NewSQLCompiler().CompileMutation("gadgets", unknownMutation{}); !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("expected an unknown mutation type to fail with ErrNotSupported, got %v", err)
+	}
+}
+
+func TestCompileReturning(t *testing.T) {
+	insert := store.NewInsert(map[string]any{"name": "alpha"}).WithReturning("id", "name")
+
+	compiled, err := sqlstore.NewSQLCompiler().CompileMutation("gadgets", insert)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if want := "INSERT INTO gadgets (name) VALUES ($1) RETURNING id, name"; compiled.SQL != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", compiled.SQL, want)
+	}
+
+	mysql := sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectMySQL)
+	compiled, err = mysql.CompileMutation("gadgets", insert)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if want := "INSERT INTO gadgets (name) VALUES (?)"; compiled.SQL != want {
+		t.Errorf("expected MySQL to emulate RETURNING:\n got: %s\nwant: %s", compiled.SQL, want)
+	}
+	if want := "SELECT id, name FROM gadgets WHERE id = ?"; compiled.Hints["returning_lookup"] != want {
+		t.Errorf("unexpected lookup: %v", compiled.Hints["returning_lookup"])
+	}
+
+	keyed := store.NewInsert(map[string]any{"code": "a1", "name": "alpha"}).WithReturning("id", "name").WithKey("code")
+	compiled, err = mysql.CompileMutation("gadgets", keyed)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if want := "SELECT id, name FROM gadgets WHERE code = ?"; compiled.Hints["returning_lookup"] != want {
+		t.Errorf("expected the row to be read back by its inserted key: %v", compiled.Hints["returning_lookup"])
+	}
+	if _, err := mysql.CompileMutation("gadgets", keyed.WithKey("tenant", "code")); !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("expected a compound key without values to fail with ErrNotSupported, got %v", err)
+	}
+
+	update := store.NewUpdate(map[string]any{"name": "beta"}, store.Eq("id", 1)).WithReturning("id")
+	if _, err := mysql.CompileMutation("gadgets", update); !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("expected RETURNING on a MySQL update to fail with ErrNotSupported, got %v", err)
+	}
+	if _, err := sqlstore.NewSQLCompiler().CompileMutation("gadgets", insert.WithReturning("id; DROP TABLE gadgets")); !errors.Is(err, store.ErrInvalidQuery) {
+		t.Errorf("expected an unsafe returning column to fail with ErrInvalidQuery, got %v", err)
+	}
+}
+
+func TestExecuteReturning(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "CREATE TABLE gadgets (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT)"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	exec := sqlstore.NewMutationExecutor(db).WithCompiler(sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectSQLite))
+
+	for _, name := range []string{"alpha", "beta"} {
+		result, err := exec.ExecuteForTable(ctx, "gadgets", store.NewInsert(map[string]any{"name": name}).WithReturning("id"))
+		if err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+		if len(result.Returning) != 1 || result.Returning[0]["id"] == nil {
+			t.Fatalf("expected the generated id to be returned, got %v", result.Returning)
+		}
+	}
+
+	result, err := exec.ExecuteForTable(ctx, "gadgets", store.NewUpdate(map[string]any{"name": "renamed"}).WithReturning("id", "name"))
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if result.RowsAffected != 2 || fmt.Sprint(result.Returning) != "[map[id:1 name:renamed] map[id:2 name:renamed]]" {
+		t.Errorf("unexpected update result: %+v", result)
+	}
+
+	result, err = exec.ExecuteForTable(ctx, "gadgets", store.NewDelete(store.Eq("id", 2)).WithReturning("name"))
+	if err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if fmt.Sprint(result.Returning) != "[map[name:renamed]]" {
+		t.Errorf("unexpected delete result: %+v", result)
+	}
+}
+
+func TestExecuteReturningEmulation(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "CREATE TABLE gadgets (id TEXT PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	// SQLite accepts the MySQL statements, so the emulation runs as on MySQL
+	exec := sqlstore.NewMutationExecutor(db).WithCompiler(sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectMySQL))
+
+	result, err := exec.ExecuteForTable(ctx, "gadgets", store.NewInsert(map[string]any{"id": "g1", "name": "alpha"}).WithReturning("id", "name"))
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if fmt.Sprint(result.Returning) != "[map[id:g1 name:alpha]]" {
+		t.Errorf("expected the row read back by its string id, got %v", result.Returning)
+	}
+
+	// Without its key value the row cannot be found, and is not kept
+	_, err = exec.ExecuteForTable(ctx, "gadgets", store.NewInsert(map[string]any{"name": "beta"}).WithReturning("id"))
+	if !errors.Is(err, store.ErrNotSupported) {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+	var count int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM gadgets").Scan(&count); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("expected the failed insert to be rolled back, found %d rows", count)
+	}
+}
+
+func TestCompileUpsertPerDialect(t *testing.T) {
+	upsert := store.NewUpsert(map[string]any{"sku": "a1", "name": "alpha", "price": 3}, "sku")
+	tests := []struct {
+		dialect sqlstore.Dialect
+		want    string
+	}{
+		{sqlstore.DialectPostgres, "INSERT INTO products (name, price, sku) VALUES ($1, $2, $3) ON CONFLICT (sku) DO UPDATE SET name = EXCLUDED.name, price = EXCLUDED.price"},
+		{sqlstore.DialectSQLite, "INSERT INTO products (name, price, sku) VALUES ($1, $2, $3) ON CONFLICT (sku) DO UPDATE SET name = EXCLUDED.name, price = EXCLUDED.price"},
+		{sqlstore.DialectMySQL, "INSERT INTO products (name, price, sku) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE name = VALUES(name), price = VALUES(price)"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *testing.T) {
+			compiled, err := sqlstore.NewSQLCompiler().WithDialect(tt.dialect).CompileMutation("products", upsert)
+			if err != nil {
+				t.Fatalf("compile failed: %v", err)
+			}
+			if compiled.SQL != tt.want {
+				t.Errorf("unexpected SQL:\n got: %s\nwant: %s", compiled.SQL, tt.want)
+			}
+		})
+	}
+
+	keep := store.Upsert{Values: map[string]any{"sku": "a1"}, ConflictColumns: []string{"sku"}}
+	compiled, err := sqlstore.NewSQLCompiler().CompileMutation("products", keep)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if want := "INSERT INTO products (sku) VALUES ($1) ON CONFLICT (sku) DO NOTHING"; compiled.SQL != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", compiled.SQL, want)
+	}
+
+	if _, err := sqlstore.NewSQLCompiler().WithUpsert(false).CompileMutation("products", upsert); !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("expected a compiler without native upserts to fail with ErrNotSupported, got %v", err)
+	}
+}
+
+func TestCompileSetExpressions(t *testing.T) {
+	update := store.NewUpdate(map[string]any{
+		"hits":  store.Increment("hits", 1),
+		"name":  "alpha",
+		"price": store.Expr("price * ?", 1.1),
+	}, store.Eq("id", 7))
+	tests := []struct {
+		dialect sqlstore.Dialect
+		want    string
+	}{
+		{sqlstore.DialectPostgres, "UPDATE products SET hits = hits + $1, name = $2, price = price * $3 WHERE id = $4"},
+		{sqlstore.DialectMySQL, "UPDATE products SET hits = hits + ?, name = ?, price = price * ? WHERE id = ?"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *testing.T) {
+			compiled, err := sqlstore.NewSQLCompiler().WithDialect(tt.dialect).CompileMutation("products", update)
+			if err != nil {
+				t.Fatalf("compile failed: %v", err)
+			}
+			if compiled.SQL != tt.want {
+				t.Errorf("unexpected SQL:\n got: %s\nwant: %s", compiled.SQL, tt.want)
+			}
+			if fmt.Sprint(compiled.Args) != "[1 alpha 1.1 7]" {
+				t.Errorf("unexpected args: %v", compiled.Args)
+			}
+		})
+	}
+
+	bad := []store.SetExpr{
+		store.Expr("price * ?"),
+		store.Expr("0; DROP TABLE products"),
+		store.Expr("'x'"),
+	}
+	for _, expr := range bad {
+		if _, err := sqlstore.NewSQLCompiler().CompileMutation("products", store.NewUpdate(map[string]any{"price": expr})); !errors.Is(err, store.ErrInvalidQuery) {
+			t.Errorf("expected %q to fail with ErrInvalidQuery, got %v", expr.SQL, err)
+		}
+	}
+
+	// The incremented column is quoted, and ?? is the jsonb ? operator
+	update = store.NewUpdate(map[string]any{
+		"order": store.Increment("order", 1),
+		"vip":   store.Expr("tags ?? ?", "vip"),
+	})
+	compiled, err := sqlstore.NewSQLCompiler().WithQuotedIdentifiers(true).CompileMutation("products", update)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if want := `UPDATE "products" SET "order" = "order" + $1, "vip" = tags ? $2`; compiled.SQL != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", compiled.SQL, want)
+	}
+
+	// MySQL and SQLite placeholders are a bare ?, so ?? cannot be told apart
+	for _, dialect := range []sqlstore.Dialect{sqlstore.DialectMySQL, sqlstore.DialectSQLite} {
+		if _, err := sqlstore.NewSQLCompiler().WithDialect(dialect).CompileMutation("products", update); !errors.Is(err, store.ErrNotSupported) {
+			t.Errorf("expected ?? on %s to fail with ErrNotSupported, got %v", dialect, err)
+		}
+	}
+
+	// Only updates compile set expressions
+	others := []store.Mutation{
+		store.NewInsert(map[string]any{"hits": store.Increment("hits", 1)}),
+		store.UpdateCase{Key: "id", Rows: []store.CaseRow{{Key: 1, Set: map[string]any{"hits": store.Expr("hits * 2")}}}},
+	}
+	for _, m := range others {
+		if _, err := sqlstore.NewSQLCompiler().CompileMutation("products", m); !errors.Is(err, store.ErrInvalidQuery) {
+			t.Errorf("expected a set expression in %T to fail with ErrInvalidQuery, got %v", m, err)
+		}
+	}
+}
+
+func TestIncrementIsAtomic(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "CREATE TABLE counters (id INTEGER PRIMARY KEY, hits INTEGER)"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO counters (id, hits) VALUES (1, 0), (2, 0)"); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	exec := sqlstore.NewMutationExecutor(db).WithCompiler(sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectSQLite))
+
+	for range 3 {
+		if _, err := exec.ExecuteForTable(ctx, "counters", store.NewUpdate(map[string]any{"hits": store.Increment("hits", 2)}, store.Eq("id", 1))); err != nil {
+			t.Fatalf("increment: %v", err)
+		}
+	}
+	if _, err := exec.ExecuteForTable(ctx, "counters", store.NewUpdate(map[string]any{"hits": store.Decrement("hits", 1)}, store.Eq("id", 1))); err != nil {
+		t.Fatalf("decrement: %v", err)
+	}
+
+	var one, two int
+	if err := db.QueryRowContext(ctx, "SELECT (SELECT hits FROM counters WHERE id = 1), (SELECT hits FROM counters WHERE id = 2)").Scan(&one, &two); err != nil {
+		t.Fatalf("read counters: %v", err)
+	}
+	if one != 5 || two != 0 {
+		t.Errorf("expected counters 5 and 0, got %d and %d", one, two)
+	}
+}
+
+func TestCompileStoreQueryCache(t *testing.T) {
+	queries := []store.Query{
+		{From: "orders", Where: []store.Condition{store.Eq("customer", "alice"), store.Gt("amount", 5)}, Limit: 10},
+		{From: "orders", Where: []store.Condition{store.Eq("customer", "bob"), store.Gt("amount", 1)}, Limit: 10},
+		{From: "orders", Where: []store.Condition{{Field: "customer", Op: store.OpPrefix, Value: "a%"}}},
+		{From: "orders", Where: []store.Condition{{Field: "customer", Op: store.OpPrefix, Value: "b"}}},
+		{From: "orders", Where: []store.Condition{{Field: "id", Op: store.OpIn, Value: []any{1, 2}}}},
+		{From: "orders", Where: []store.Condition{{Field: "id", Op: store.OpIn, Value: []any{1, 2, 3}}}},
+		{From: "orders", Where: []store.Condition{{Field: "amount", Op: store.OpBetween, Value: [2]any{1, 9}}}},
+		{From: "orders", Where: []store.Condition{store.InQuery("customer", store.Query{From: "vips", Columns: []string{"name"}, Where: []store.Condition{store.Eq("tier", 2)}})}},
+	}
+
+	plain := sqlstore.NewSQLCompiler()
+	cached := sqlstore.NewSQLCompiler().WithQueryCache(16)
+	for round := range 2 {
+		for i, q := range queries {
+			wantSQL, wantArgs, err := plain.CompileStoreQuery(q)
+			if err != nil {
+				t.Fatalf("query %d: compile failed: %v", i, err)
+			}
+			gotSQL, gotArgs, err := cached.CompileStoreQuery(q)
+			if err != nil {
+				t.Fatalf("query %d round %d: cached compile failed: %v", i, round, err)
+			}
+			if gotSQL != wantSQL || fmt.Sprint(gotArgs) != fmt.Sprint(wantArgs) {
+				t.Errorf("query %d round %d: got %s %v, want %s %v", i, round, gotSQL, gotArgs, wantSQL, wantArgs)
+			}
+		}
+	}
+	if n := cached.CachedQueries(); n != 6 {
+		t.Errorf("expected 6 cached shapes, got %d", n)
+	}
+
+	mysql := cached.WithDialect(sqlstore.DialectMySQL)
+	query, _, err := mysql.CompileStoreQuery(queries[0])
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if want := "SELECT * FROM orders WHERE customer = ? AND amount > ? LIMIT 10"; query != want {
+		t.Errorf("expected the dialect to be part of the shape:\n got: %s\nwant: %s", query, want)
+	}
+
+	bad := store.Query{From: "orders", Where: []store.Condition{{Field: "amount", Op: store.OpBetween, Value: 3}}}
+	if _, _, err := cached.CompileStoreQuery(bad); !errors.Is(err, store.ErrInvalidQuery) {
+		t.Errorf("expected invalid bounds to fail with ErrInvalidQuery, got %v", err)
+	}
+}
+
+func TestCompileBulkInsert(t *testing.T) {
+	rows := []map[string]any{
+		{"id": "a", "name": "alpha"},
+		{"id": "b", "name": "beta"},
+		{"id": "c", "name": "gamma"},
+	}
+
+	compiled, err := sqlstore.NewSQLCompiler().CompileMutation("gadgets", store.NewBulkInsert(rows...))
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	want := "INSERT INTO gadgets (id, name) VALUES ($1, $2), ($3, $4), ($5, $6)"
+	if compiled.SQL != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", compiled.SQL, want)
+	}
+	if fmt.Sprint(compiled.Args) != "[a alpha b beta c gamma]" {
+		t.Errorf("unexpected args: %v", compiled.Args)
+	}
+
+	// A chunk size of 2 splits the rows; a limit of 3 parameters then allows
+	// only one row per statement.
+	for _, tt := range []struct {
+		compiler *sqlstore.SQLCompiler
+		want     []string
+	}{
+		{sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectMySQL), []string{
+			"INSERT INTO gadgets (id, name) VALUES (?, ?), (?, ?)",
+			"INSERT INTO gadgets (id, name) VALUES (?, ?)",
+		}},
+		{sqlstore.NewSQLCompiler().WithMaxParams(3), []string{
+			"INSERT INTO gadgets (id, name) VALUES ($1, $2)",
+			"INSERT INTO gadgets (id, name) VALUES ($1, $2)",
+			"INSERT INTO gadgets (id, name) VALUES ($1, $2)",
+		}},
+	} {
+		statements, err := tt.compiler.CompileBulkInsert("gadgets", store.NewBulkInsert(rows...), 2)
+		if err != nil {
+			t.Fatalf("compile failed: %v", err)
+		}
+		var got []string
+		for _, stmt := range statements {
+			got = append(got, stmt.SQL)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("unexpected statements:\n got: %v\nwant: %v", got, tt.want)
+		}
+	}
+
+	ragged := store.NewBulkInsert(map[string]any{"id": "a", "name": "alpha"}, map[string]any{"id": "b", "rank": 2})
+	if _, err := sqlstore.NewSQLCompiler().CompileMutation("gadgets", ragged); !errors.Is(err, store.ErrInvalidQuery) {
+		t.Errorf("expected rows with different columns to fail with ErrInvalidQuery, got %v", err)
+	}
+}
+
+func TestCompileRejectsUnsafeIdentifiers(t *testing.T) {
+	tests := []struct {
+		name    string
+		compile func() error
+	}{
+		{"table", func() error {
+			_, _, err := sqlstore.NewQueryBuilder("users; DROP TABLE users").Build()
+			return err
+		}},
+		{"where field", func() error {
+			_, _, err := sqlstore.NewQueryBuilder("users").WhereCondition(store.Eq("1=1 OR id", 1)).Build()
+			return err
+		}},
+		{"field reference", func() error {
+			_, _, err := sqlstore.NewQueryBuilder("users").WhereCondition(store.FieldCompare("a", store.OpEq, "b--")).Build()
+			return err
+		}},
+		{"order by", func() error {
+			_, _, err := sqlstore.NewQueryBuilder("users").OrderBy("name; DELETE FROM users", "ASC").Build()
+			return err
+		}},
+		{"select expression", func() error {
+			_, _, err := sqlstore.NewQueryBuilder("users").Select("name /* x */").Build()
+			return err
+		}},
+		{"insert column", func() error {
+			_, err := sqlstore.CompileMutation("users", store.NewInsert(map[string]any{"name) VALUES ('x'); --": 1}))
+			return err
+		}},
+		{"update table", func() error {
+			_, _, err := sqlstore.NewUpdateBuilder(`users" SET admin = true --`).Set("name", "x").Build()
+			return err
+		}},
+		{"delete node field", func() error {
+			_, _, err := sqlstore.NewDeleteBuilder("users").WhereOr(store.Eq("id", 1), store.Eq("x' OR '1", 1)).Build()
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.compile(); !errors.Is(err, store.ErrInvalidQuery) {
+				t.Fatalf("expected ErrInvalidQuery, got %v", err)
+			}
+		})
+	}
+
+	// Qualified names and aggregate expressions remain valid
+	if _, _, err := sqlstore.NewQueryBuilder("public.users").SelectCount("*", "n").WhereCondition(store.Eq("users.id", 1)).Build(); err != nil {
+		t.Errorf("expected qualified identifiers to compile, got %v", err)
+	}
+}
+
+func TestCompileQuotedIdentifiersPerDialect(t *testing.T) {
+	qb := sqlstore.NewQueryBuilder("order").
+		Select("group", "COUNT(*)").
+		WhereCondition(store.Eq("public.order.select", 1)).
+		GroupBy("group").
+		OrderBy("group", "ASC")
+
+	tests := []struct {
+		dialect sqlstore.Dialect
+		want    string
+	}{
+		{sqlstore.DialectPostgres, `SELECT "group", COUNT(*) FROM "order" WHERE "public"."order"."select" = $1 GROUP BY "group" ORDER BY "group" ASC`},
+		{sqlstore.DialectMySQL, "SELECT `group`, COUNT(*) FROM `order` WHERE `public`.`order`.`select` = ? GROUP BY `group` ORDER BY `group` ASC"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *testing.T) {
+			compiler := sqlstore.NewSQLCompiler().WithDialect(tt.dialect).WithQuotedIdentifiers(true)
+			query, _, err := compiler.CompileQuery(qb)
+			if err != nil {
+				t.Fatalf("compile failed: %v", err)
+			}
+			if query != tt.want {
+				t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotedIdentifiersAllowReservedWords(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `CREATE TABLE "order" ("select" TEXT, "group" INTEGER)`); err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+	compiler := sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectSQLite).WithQuotedIdentifiers(true)
+	exec := sqlstore.NewMutationExecutor(db)
+
+	for i, name := range []string{"a", "b", "c"} {
+		insert, err := compiler.CompileMutation("order", store.NewInsert(map[string]any{"select": name, "group": i % 2}))
+		if err != nil {
+			t.Fatalf("compile insert: %v", err)
+		}
+		if _, err := exec.ExecuteCompiled(ctx, *insert); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+
+	update, err := sqlstore.NewUpdateBuilder("order").Set("group", 5).Where("select", "=", "b").Compile(compiler)
+	if err != nil {
+		t.Fatalf("compile update: %v", err)
+	}
+	if _, err := exec.ExecuteCompiled(ctx, *update); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	qe := sqlstore.NewQueryExecutor(db, compiler)
+	n, err := qe.Count(ctx, sqlstore.NewQueryBuilder("order").Where("group", "=", 0))
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 rows in group 0, got %d", n)
+	}
+}
@@ -0,0 +1,65 @@
+package sqlstore
+
+import (
+	"sync"
+	"time"
+)
+
+// countCache holds cached row totals per table. Each invalidation bumps the
+// table's generation, so a count read before a write commits is not cached
+// after the write invalidated the table.
+type countCache struct {
+	mu          sync.Mutex
+	entries     map[string]countEntry
+	generations map[string]uint64
+}
+
+type countEntry struct {
+	count   int64
+	expires time.Time
+}
+
+func newCountCache() *countCache {
+	return &countCache{entries: make(map[string]countEntry), generations: make(map[string]uint64)}
+}
+
+// get returns the cached count for table if it has not expired.
+func (c *countCache) get(table string) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[table]
+	if !ok || time.Now().After(entry.expires) {
+		return 0, false
+	}
+	return entry.count, true
+}
+
+// generation returns the current generation of table, to pass to set.
+func (c *countCache) generation(table string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generations[table]
+}
+
+// set caches count for table for ttl, unless table was invalidated since
+// generation was read.
+func (c *countCache) set(table string, count int64, ttl time.Duration, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generations[table] != generation {
+		return
+	}
+	c.entries[table] = countEntry{count: count, expires: time.Now().Add(ttl)}
+}
+
+// invalidate drops the cached count for table.
+func (c *countCache) invalidate(table string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, table)
+	c.generations[table]++
+}
@@ -0,0 +1,214 @@
+package sqlstore
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"store/sql/adapter"
+)
+
+// Dialect identifies the SQL flavor targeted by the compiler.
+// Values match the adapters' GetDialect() strings.
+type Dialect string
+
+const (
+	DialectPostgres Dialect = "postgresql"
+	DialectMySQL    Dialect = "mysql"
+	DialectSQLite   Dialect = "sqlite"
+)
+
+// Driver limits on the number of bound parameters in a single statement.
+const (
+	maxParamsPostgres = 65535
+	maxParamsMySQL    = 65535
+	maxParamsSQLite   = 32766 // SQLITE_MAX_VARIABLE_NUMBER since SQLite 3.32
+)
+
+// DialectOf returns the dialect reported by an adapter, defaulting to PostgreSQL.
+func DialectOf(adpt adapter.Adapter) Dialect {
+	if d, ok := adpt.(interface{ GetDialect() string }); ok {
+		return Dialect(d.GetDialect())
+	}
+	return DialectPostgres
+}
+
+// upsertSyntax returns the native upsert clause of the dialect.
+func (d Dialect) upsertSyntax() adapter.UpsertSyntax {
+	if d == DialectMySQL {
+		return adapter.UpsertOnDuplicateKey
+	}
+	return adapter.UpsertOnConflict
+}
+
+// placeholder returns the positional parameter marker for index i (1-based).
+func (d Dialect) placeholder(i int) string {
+	if d == DialectMySQL {
+		return "?"
+	}
+	return fmt.Sprintf("$%d", i)
+}
+
+// nowMinus returns an expression for the database's current time minus the
+// interval bound to param.
+func (d Dialect) nowMinus(param string) string {
+	switch d {
+	case DialectMySQL:
+		return fmt.Sprintf("NOW() - INTERVAL %s SECOND", param)
+	case DialectSQLite:
+		return fmt.Sprintf("datetime('now', %s)", param)
+	default:
+		return fmt.Sprintf("NOW() - %s * INTERVAL '1 second'", param)
+	}
+}
+
+// intervalArg returns the bound argument for nowMinus, truncated to whole seconds.
+// SQLite takes a datetime modifier string; the other dialects take a number of seconds.
+func (d Dialect) intervalArg(dur time.Duration) any {
+	secs := int64(dur / time.Second)
+	if d == DialectSQLite {
+		return fmt.Sprintf("-%d seconds", secs)
+	}
+	return secs
+}
+
+// fullTextMatch returns a full-text match of field against the query bound
+// to param. Plain queries are matched as words; others are parsed with the
+// backend's search syntax. On SQLite the field must be a column of an FTS
+// virtual table, which always parses the query.
+func (d Dialect) fullTextMatch(field, param string, plain bool) string {
+	switch d {
+	case DialectMySQL:
+		if !plain {
+			return fmt.Sprintf("MATCH(%s) AGAINST(%s IN BOOLEAN MODE)", field, param)
+		}
+		return fmt.Sprintf("MATCH(%s) AGAINST(%s)", field, param)
+	case DialectSQLite:
+		return fmt.Sprintf("%s MATCH %s", field, param)
+	default:
+		if !plain {
+			return fmt.Sprintf("to_tsvector(%s) @@ to_tsquery(%s)", field, param)
+		}
+		return fmt.Sprintf("to_tsvector(%s) @@ plainto_tsquery(%s)", field, param)
+	}
+}
+
+// likeMatch returns a LIKE match of field against the pattern bound to param.
+// Case-insensitive matches use ILIKE on PostgreSQL and compare lowercased
+// values elsewhere.
+func (d Dialect) likeMatch(field, param string, insensitive bool) string {
+	switch {
+	case !insensitive:
+		return fmt.Sprintf("%s LIKE %s %s", field, param, d.likeEscape())
+	case d == DialectPostgres:
+		return fmt.Sprintf("%s ILIKE %s %s", field, param, d.likeEscape())
+	default:
+		return fmt.Sprintf("LOWER(%s) LIKE LOWER(%s) %s", field, param, d.likeEscape())
+	}
+}
+
+// regexMatch returns a regular expression match of field against the pattern
+// bound to param. SQLite has no built-in REGEXP function, so checkConditions
+// rejects regex conditions there.
+func (d Dialect) regexMatch(field, param string) string {
+	if d == DialectMySQL {
+		return fmt.Sprintf("%s REGEXP %s", field, param)
+	}
+	return fmt.Sprintf("%s ~ %s", field, param)
+}
+
+// distinctFrom returns a null-safe inequality of field and the value bound to param.
+func (d Dialect) distinctFrom(field, param string) string {
+	if d == DialectMySQL {
+		return fmt.Sprintf("NOT (%s <=> %s)", field, param)
+	}
+	return fmt.Sprintf("%s IS DISTINCT FROM %s", field, param)
+}
+
+// jsonPathPattern matches the JSON paths accepted by store.JSONEq. Keys are
+// plain identifiers, so a valid path can be written into SQL as a literal.
+var jsonPathPattern = regexp.MustCompile(`^\$(\.[A-Za-z_][A-Za-z0-9_]*|\[[0-9]+\])*$`)
+
+// jsonPathSteps matches the steps of a valid JSON path.
+var jsonPathSteps = regexp.MustCompile(`\.[A-Za-z_][A-Za-z0-9_]*|\[[0-9]+\]`)
+
+// jsonExtract returns the text value at a JSON path of field. PostgreSQL
+// follows the path with -> operators; MySQL and SQLite take it as written.
+func (d Dialect) jsonExtract(field, path string) string {
+	switch d {
+	case DialectMySQL:
+		return fmt.Sprintf("JSON_UNQUOTE(JSON_EXTRACT(%s, '%s'))", field, path)
+	case DialectSQLite:
+		return fmt.Sprintf("json_extract(%s, '%s')", field, path)
+	default:
+		steps := jsonPathSteps.FindAllString(path, -1)
+		if len(steps) == 0 {
+			return field + "::text"
+		}
+		var sb strings.Builder
+		sb.WriteString(field)
+		for i, step := range steps {
+			op := "->"
+			if i == len(steps)-1 {
+				op = "->>"
+			}
+			if step[0] == '.' {
+				fmt.Fprintf(&sb, "%s'%s'", op, step[1:])
+			} else {
+				sb.WriteString(op + step[1:len(step)-1])
+			}
+		}
+		return sb.String()
+	}
+}
+
+// likeEscape is the ESCAPE clause matching escapeLike. MySQL treats the
+// backslash as an escape in string literals, so it is doubled there.
+func (d Dialect) likeEscape() string {
+	if d == DialectMySQL {
+		return `ESCAPE '\\'`
+	}
+	return `ESCAPE '\'`
+}
+
+// likeEscaper escapes LIKE metacharacters with a backslash.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike returns s escaped for literal matching in a LIKE pattern.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// maxParams returns the driver's limit on bound parameters per statement.
+func (d Dialect) maxParams() int {
+	switch d {
+	case DialectMySQL:
+		return maxParamsMySQL
+	case DialectSQLite:
+		return maxParamsSQLite
+	default:
+		return maxParamsPostgres
+	}
+}
+
+// randomFunc returns the function producing a random value per row.
+func (d Dialect) randomFunc() string {
+	if d == DialectMySQL {
+		return "RAND()"
+	}
+	return "RANDOM()"
+}
+
+// tableExistsQuery returns a query counting the tables of the current
+// schema named by its single parameter.
+func (d Dialect) tableExistsQuery() string {
+	switch d {
+	case DialectMySQL:
+		return "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?"
+	case DialectSQLite:
+		return "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = $1"
+	default:
+		return "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1"
+	}
+}
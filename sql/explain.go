@@ -0,0 +1,231 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"store"
+)
+
+// QueryPlan is the execution plan of a query as reported by the database.
+type QueryPlan struct {
+	SQL  string
+	Args []any
+	// Nodes are the root steps of the plan.
+	Nodes []PlanNode
+	// Raw is the database's own output: JSON on PostgreSQL, the text tree of
+	// EXPLAIN ANALYZE on MySQL, and the detail lines on SQLite.
+	Raw string
+}
+
+// PlanNode is one step of a query plan. Fields the database does not
+// report are left zero.
+type PlanNode struct {
+	Operation     string  // e.g. "Seq Scan", "ALL", "SEARCH"
+	Table         string  // relation the step reads, if any
+	Detail        string  // index used, filter or the database's description
+	EstimatedRows float64 // planner row estimate
+	Cost          float64 // planner total cost (PostgreSQL)
+	ActualRows    float64 // rows produced, with analyze (PostgreSQL)
+	ActualTimeMs  float64 // total time in milliseconds, with analyze (PostgreSQL)
+	Children      []PlanNode
+}
+
+// Explain returns the execution plan of the compiled query. With analyze the
+// query is also executed, so actual row counts and timings are reported;
+// note that this takes any locks the query requests. PostgreSQL reports a
+// full plan tree, MySQL one node per table access (analyze returns only the
+// raw text tree, MySQL 8.0.18 or later), and SQLite its query plan; SQLite
+// cannot analyze and fails with store.ErrNotSupported.
+func (qe *QueryExecutor) Explain(ctx context.Context, qb *QueryBuilder, analyze bool) (*QueryPlan, error) {
+	query, args, err := qe.compiler.CompileQuery(qb)
+	if err != nil {
+		return nil, err
+	}
+	plan := &QueryPlan{SQL: query, Args: args}
+
+	var explain string
+	switch dialect := qe.compiler.dialect; {
+	case dialect == DialectSQLite && analyze:
+		return nil, fmt.Errorf("%w: EXPLAIN ANALYZE on %s", store.ErrNotSupported, dialect)
+	case dialect == DialectSQLite:
+		explain = "EXPLAIN QUERY PLAN " + query
+	case dialect == DialectMySQL && analyze:
+		explain = "EXPLAIN ANALYZE " + query
+	case dialect == DialectMySQL:
+		explain = "EXPLAIN " + query
+	case analyze:
+		explain = "EXPLAIN (ANALYZE, FORMAT JSON) " + query
+	default:
+		explain = "EXPLAIN (FORMAT JSON) " + query
+	}
+
+	rows, err := qe.conn(ctx).QueryContext(ctx, explain, args...)
+	if err != nil {
+		return nil, store.WrapQueryError(err, "explain", qb.table, explain, args)
+	}
+	defer rows.Close()
+
+	switch {
+	case qe.compiler.dialect == DialectSQLite:
+		err = scanSQLitePlan(rows, plan)
+	case qe.compiler.dialect == DialectMySQL && analyze:
+		err = scanRawPlan(rows, plan)
+	case qe.compiler.dialect == DialectMySQL:
+		err = scanMySQLPlan(rows, plan)
+	default:
+		err = scanPostgresPlan(rows, plan)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		return nil, store.WrapQueryError(err, "explain", qb.table, explain, args)
+	}
+	return plan, nil
+}
+
+// pgPlan is a node of PostgreSQL's EXPLAIN (FORMAT JSON) output.
+type pgPlan struct {
+	NodeType        string   `json:"Node Type"`
+	RelationName    string   `json:"Relation Name"`
+	IndexName       string   `json:"Index Name"`
+	Filter          string   `json:"Filter"`
+	TotalCost       float64  `json:"Total Cost"`
+	PlanRows        float64  `json:"Plan Rows"`
+	ActualRows      float64  `json:"Actual Rows"`
+	ActualTotalTime float64  `json:"Actual Total Time"`
+	Plans           []pgPlan `json:"Plans"`
+}
+
+func (p pgPlan) node() PlanNode {
+	node := PlanNode{
+		Operation:     p.NodeType,
+		Table:         p.RelationName,
+		Detail:        p.Filter,
+		EstimatedRows: p.PlanRows,
+		Cost:          p.TotalCost,
+		ActualRows:    p.ActualRows,
+		ActualTimeMs:  p.ActualTotalTime,
+	}
+	if p.IndexName != "" {
+		node.Detail = "using index " + p.IndexName
+	}
+	for _, child := range p.Plans {
+		node.Children = append(node.Children, child.node())
+	}
+	return node
+}
+
+func scanPostgresPlan(rows *sql.Rows, plan *QueryPlan) error {
+	if !rows.Next() {
+		return fmt.Errorf("EXPLAIN returned no plan")
+	}
+	var raw []byte
+	if err := rows.Scan(&raw); err != nil {
+		return err
+	}
+	var out []struct {
+		Plan pgPlan `json:"Plan"`
+	}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return fmt.Errorf("decode plan: %w", err)
+	}
+	plan.Raw = string(raw)
+	for _, p := range out {
+		plan.Nodes = append(plan.Nodes, p.Plan.node())
+	}
+	return nil
+}
+
+// scanMySQLPlan reads the tabular EXPLAIN output, one row per table access.
+func scanMySQLPlan(rows *sql.Rows, plan *QueryPlan) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	var lines []string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]any, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		row := make(map[string]string, len(columns))
+		for i, col := range columns {
+			row[col] = values[i].String
+		}
+		node := PlanNode{Operation: row["type"], Table: row["table"], Detail: row["Extra"]}
+		if row["key"] != "" {
+			node.Detail = strings.TrimPrefix(node.Detail+"; using index "+row["key"], "; ")
+		}
+		node.EstimatedRows, _ = strconv.ParseFloat(row["rows"], 64)
+		plan.Nodes = append(plan.Nodes, node)
+		lines = append(lines, fmt.Sprintf("%s %s %s", node.Operation, node.Table, node.Detail))
+	}
+	plan.Raw = strings.Join(lines, "\n")
+	return nil
+}
+
+// scanRawPlan reads single-column text output such as MySQL's EXPLAIN ANALYZE.
+func scanRawPlan(rows *sql.Rows, plan *QueryPlan) error {
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return err
+		}
+		lines = append(lines, line)
+	}
+	plan.Raw = strings.Join(lines, "\n")
+	return nil
+}
+
+// scanSQLitePlan builds the plan tree from EXPLAIN QUERY PLAN rows of
+// (id, parent, notused, detail).
+func scanSQLitePlan(rows *sql.Rows, plan *QueryPlan) error {
+	type entry struct {
+		id, parent int
+		node       PlanNode
+	}
+	var entries []entry
+	var lines []string
+	for rows.Next() {
+		var e entry
+		var notUsed int
+		if err := rows.Scan(&e.id, &e.parent, &notUsed, &e.node.Detail); err != nil {
+			return err
+		}
+		fields := strings.Fields(e.node.Detail)
+		if len(fields) > 0 {
+			e.node.Operation = fields[0]
+		}
+		if (e.node.Operation == "SCAN" || e.node.Operation == "SEARCH") && len(fields) > 1 {
+			e.node.Table = fields[1]
+			if e.node.Table == "TABLE" && len(fields) > 2 {
+				e.node.Table = fields[2]
+			}
+		}
+		entries = append(entries, e)
+		lines = append(lines, e.node.Detail)
+	}
+	plan.Raw = strings.Join(lines, "\n")
+
+	// Children follow their parent, so attach them bottom-up
+	children := make(map[int][]PlanNode)
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		e.node.Children = children[e.id]
+		children[e.parent] = append([]PlanNode{e.node}, children[e.parent]...)
+	}
+	plan.Nodes = children[0]
+	return nil
+}
@@ -0,0 +1,408 @@
+package sqlstore
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"store"
+)
+
+// identPattern matches plain and dot-qualified identifiers such as
+// "users" or "public.users".
+var identPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// unsafeExpressionTokens may end a statement, start a comment or open a
+// string or quoted identifier, so they are rejected in column expressions.
+var unsafeExpressionTokens = []string{";", "--", "/*", "*/", "'", `"`, "`", `\`}
+
+// checkIdentifier rejects names that are not plain identifiers. Tables,
+// written columns, WHERE fields, GROUP BY and ORDER BY terms must be plain
+// identifiers.
+func checkIdentifier(kind, name string) error {
+	if !identPattern.MatchString(name) {
+		return fmt.Errorf("%w: invalid %s identifier %q", store.ErrInvalidQuery, kind, name)
+	}
+	return nil
+}
+
+// checkExpression rejects selected columns and HAVING fields that could
+// break out of the expression. Aggregates such as "SUM(amount) AS total"
+// are allowed.
+func checkExpression(kind, expr string) error {
+	if strings.TrimSpace(expr) == "" {
+		return fmt.Errorf("%w: empty %s expression", store.ErrInvalidQuery, kind)
+	}
+	for _, tok := range unsafeExpressionTokens {
+		if strings.Contains(expr, tok) {
+			return fmt.Errorf("%w: invalid %s expression %q", store.ErrInvalidQuery, kind, expr)
+		}
+	}
+	return nil
+}
+
+// QuoteIdentifier quotes each part of a dot-qualified identifier, with
+// backticks on MySQL and double quotes elsewhere.
+func (d Dialect) QuoteIdentifier(name string) string {
+	q := `"`
+	if d == DialectMySQL {
+		q = "`"
+	}
+	return quoteIdentifier(name, q)
+}
+
+// quoteIdentifier quotes each part of a dot-qualified identifier with q.
+func quoteIdentifier(name, q string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = q + strings.ReplaceAll(part, q, q+q) + q
+	}
+	return strings.Join(parts, ".")
+}
+
+// WithQuotedIdentifiers returns a copy of the compiler that quotes table and
+// column names, so reserved words can be used as identifiers. Expressions
+// such as "COUNT(*)" are left as written.
+func (c *SQLCompiler) WithQuotedIdentifiers(enabled bool) *SQLCompiler {
+	cp := *c
+	cp.quoteIdents = enabled
+	return &cp
+}
+
+// quote quotes name when identifier quoting is enabled and name is a plain identifier.
+func (c *SQLCompiler) quote(name string) string {
+	if !c.quoteIdents || !identPattern.MatchString(name) {
+		return name
+	}
+	if c.identQuote != "" {
+		return quoteIdentifier(name, c.identQuote)
+	}
+	return c.dialect.QuoteIdentifier(name)
+}
+
+// checkQueryIdentifiers validates the identifiers and expressions of a query.
+func checkQueryIdentifiers(qb *QueryBuilder) error {
+	if err := checkIdentifier("table", qb.table); err != nil {
+		return err
+	}
+	for _, e := range qb.ctes {
+		for _, name := range append([]string{e.name}, e.columns...) {
+			if err := checkIdentifier("common table expression", name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, j := range qb.joins {
+		for _, name := range []string{j.table, j.left, j.right} {
+			if err := checkIdentifier("join", name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, col := range qb.distinctOn {
+		if err := checkIdentifier("distinct on", col); err != nil {
+			return err
+		}
+	}
+	for _, col := range qb.columns {
+		if err := checkExpression("column", col); err != nil {
+			return err
+		}
+	}
+	if err := checkConditionIdentifiers(qb.conditions); err != nil {
+		return err
+	}
+	if err := checkConditionIdentifiers(nodesConditions(qb.nodes)); err != nil {
+		return err
+	}
+	for _, cond := range qb.having {
+		if err := checkExpression("having", cond.Field); err != nil {
+			return err
+		}
+	}
+	for _, col := range qb.groupBy {
+		if err := checkIdentifier("group by", col); err != nil {
+			return err
+		}
+	}
+	for _, o := range qb.orders {
+		if err := checkIdentifier("order by", o.Field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkMutationIdentifiers validates the identifiers of a mutation. The
+// source query of an InsertSelect is validated when it is compiled.
+func checkMutationIdentifiers(table string, mutation store.Mutation) error {
+	if err := checkIdentifier("table", table); err != nil {
+		return err
+	}
+	if err := checkSetExprs(mutation); err != nil {
+		return err
+	}
+	var columns []string
+	switch m := mutation.(type) {
+	case store.Insert:
+		columns = sortedKeys(m.Values)
+	case store.BulkInsert:
+		for _, row := range m.Rows {
+			columns = append(columns, sortedKeys(row)...)
+		}
+	case store.Upsert:
+		columns = append(sortedKeys(m.Values), m.ConflictColumns...)
+		columns = append(columns, m.UpdateColumns...)
+	case store.Update:
+		columns = sortedKeys(m.Set)
+		for _, o := range m.OrderBy {
+			columns = append(columns, o.Field)
+		}
+		for _, col := range sortedKeys(m.Set) {
+			if expr, ok := m.Set[col].(store.SetExpr); ok {
+				if err := checkExpression("set", expr.SQL); err != nil {
+					return err
+				}
+				if expr.Column != "" {
+					columns = append(columns, expr.Column)
+				}
+			}
+		}
+	case store.Delete:
+		for _, o := range m.OrderBy {
+			columns = append(columns, o.Field)
+		}
+	case store.UpdateFrom:
+		columns = append(columns, m.From)
+		for _, pairs := range []map[string]string{m.On, m.SetFrom} {
+			for _, k := range sortedKeys(pairs) {
+				columns = append(columns, k, pairs[k])
+			}
+		}
+		columns = append(columns, sortedKeys(m.Set)...)
+	case store.UpdateCase:
+		columns = append(columns, m.Key)
+		for _, row := range m.Rows {
+			columns = append(columns, sortedKeys(row.Set)...)
+		}
+	case store.InsertSelect:
+		columns = m.Columns
+	}
+	columns = append(columns, returningColumns(mutation)...)
+	if insert, ok := mutation.(store.Insert); ok {
+		keys, _ := insert.Hints[hintKey].([]string)
+		columns = append(columns, keys...)
+	}
+	for _, col := range columns {
+		if err := checkIdentifier("column", col); err != nil {
+			return err
+		}
+	}
+	if _, ok := mutation.(store.InsertSelect); ok {
+		return nil
+	}
+	return checkConditionIdentifiers(mutationConditions(mutation))
+}
+
+// checkSetExprs rejects store.SetExpr values outside Update, the only
+// mutation that compiles them; elsewhere they would be bound as arguments.
+func checkSetExprs(mutation store.Mutation) error {
+	var rows []map[string]any
+	switch m := mutation.(type) {
+	case store.Insert:
+		rows = append(rows, m.Values)
+	case store.BulkInsert:
+		rows = m.Rows
+	case store.Upsert:
+		rows = append(rows, m.Values)
+	case store.UpdateFrom:
+		rows = append(rows, m.Set)
+	case store.UpdateCase:
+		for _, row := range m.Rows {
+			rows = append(rows, row.Set)
+		}
+	}
+	for _, row := range rows {
+		for _, col := range sortedKeys(row) {
+			if _, ok := row[col].(store.SetExpr); ok {
+				return fmt.Errorf("%w: set expression for column %s is only supported in updates", store.ErrInvalidQuery, col)
+			}
+		}
+	}
+	return nil
+}
+
+// checkConditionIdentifiers validates WHERE fields and field references.
+func checkConditionIdentifiers(conditions []store.Condition) error {
+	for _, cond := range conditions {
+		if err := checkIdentifier("field", cond.Field); err != nil {
+			return err
+		}
+		if ref, ok := cond.Value.(store.FieldRef); ok {
+			if err := checkIdentifier("field", string(ref)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// quoteQuery returns a copy of qb with its identifiers quoted.
+func (c *SQLCompiler) quoteQuery(qb *QueryBuilder) *QueryBuilder {
+	cp := *qb
+	cp.table = c.quote(qb.table)
+	cp.ctes = make([]cte, len(qb.ctes))
+	for i, e := range qb.ctes {
+		e.name = c.quote(e.name)
+		e.columns = c.quoteAll(e.columns)
+		cp.ctes[i] = e
+	}
+	cp.joins = make([]join, len(qb.joins))
+	for i, j := range qb.joins {
+		cp.joins[i] = join{table: c.quote(j.table), left: c.quote(j.left), right: c.quote(j.right)}
+	}
+	cp.distinctOn = c.quoteAll(qb.distinctOn)
+	cp.columns = c.quoteAll(qb.columns)
+	cp.conditions = c.quoteConditions(qb.conditions)
+	cp.nodes = c.quoteNodes(qb.nodes)
+	cp.having = c.quoteConditions(qb.having)
+	cp.groupBy = c.quoteAll(qb.groupBy)
+	cp.orders = c.quoteOrders(qb.orders)
+	return &cp
+}
+
+// quoteMutation returns a copy of mutation with its identifiers quoted. The
+// source query of an InsertSelect is quoted when it is compiled.
+func (c *SQLCompiler) quoteMutation(mutation store.Mutation) store.Mutation {
+	switch m := mutation.(type) {
+	case store.Insert:
+		m.Values = c.quoteKeys(m.Values)
+		return m
+	case store.Upsert:
+		m.Values = c.quoteKeys(m.Values)
+		m.ConflictColumns = c.quoteAll(m.ConflictColumns)
+		m.UpdateColumns = c.quoteAll(m.UpdateColumns)
+		return m
+	case store.BulkInsert:
+		rows := make([]map[string]any, len(m.Rows))
+		for i, row := range m.Rows {
+			rows[i] = c.quoteKeys(row)
+		}
+		m.Rows = rows
+		return m
+	case store.Update:
+		m.Set = c.quoteKeys(m.Set)
+		m.Where = c.quoteConditions(m.Where)
+		m.Nodes = c.quoteNodes(m.Nodes)
+		m.OrderBy = c.quoteOrders(m.OrderBy)
+		return m
+	case store.Delete:
+		m.Where = c.quoteConditions(m.Where)
+		m.Nodes = c.quoteNodes(m.Nodes)
+		m.OrderBy = c.quoteOrders(m.OrderBy)
+		return m
+	case store.UpdateFrom:
+		m.From = c.quote(m.From)
+		m.On = c.quotePairs(m.On)
+		m.SetFrom = c.quotePairs(m.SetFrom)
+		m.Set = c.quoteKeys(m.Set)
+		m.Where = c.quoteConditions(m.Where)
+		return m
+	case store.UpdateCase:
+		m.Key = c.quote(m.Key)
+		rows := make([]store.CaseRow, len(m.Rows))
+		for i, row := range m.Rows {
+			rows[i] = store.CaseRow{Key: row.Key, Set: c.quoteKeys(row.Set)}
+		}
+		m.Rows = rows
+		return m
+	case store.InsertSelect:
+		m.Columns = c.quoteAll(m.Columns)
+		return m
+	default:
+		return mutation
+	}
+}
+
+func (c *SQLCompiler) quoteOrders(orders []store.Order) []store.Order {
+	if orders == nil {
+		return nil
+	}
+	out := make([]store.Order, len(orders))
+	for i, o := range orders {
+		out[i] = store.Order{Field: c.quote(o.Field), Desc: o.Desc}
+	}
+	return out
+}
+
+func (c *SQLCompiler) quoteAll(names []string) []string {
+	if names == nil {
+		return nil
+	}
+	out := make([]string, len(names))
+	for i, name := range names {
+		out[i] = c.quote(name)
+	}
+	return out
+}
+
+func (c *SQLCompiler) quoteKeys(m map[string]any) map[string]any {
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[c.quote(k)] = v
+	}
+	return out
+}
+
+func (c *SQLCompiler) quotePairs(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[c.quote(k)] = c.quote(v)
+	}
+	return out
+}
+
+func (c *SQLCompiler) quoteConditions(conditions []store.Condition) []store.Condition {
+	if conditions == nil {
+		return nil
+	}
+	out := make([]store.Condition, len(conditions))
+	for i, cond := range conditions {
+		out[i] = c.quoteCondition(cond)
+	}
+	return out
+}
+
+func (c *SQLCompiler) quoteCondition(cond store.Condition) store.Condition {
+	cond.Field = c.quote(cond.Field)
+	if ref, ok := cond.Value.(store.FieldRef); ok {
+		cond.Value = store.FieldRef(c.quote(string(ref)))
+	}
+	return cond
+}
+
+func (c *SQLCompiler) quoteNodes(nodes []store.Node) []store.Node {
+	if nodes == nil {
+		return nil
+	}
+	out := make([]store.Node, len(nodes))
+	for i, node := range nodes {
+		out[i] = c.quoteNode(node)
+	}
+	return out
+}
+
+func (c *SQLCompiler) quoteNode(node store.Node) store.Node {
+	switch n := node.(type) {
+	case store.Condition:
+		return c.quoteCondition(n)
+	case store.AndNode:
+		return store.AndNode{Nodes: c.quoteNodes(n.Nodes)}
+	case store.OrNode:
+		return store.OrNode{Nodes: c.quoteNodes(n.Nodes)}
+	case store.NotNode:
+		return store.NotNode{Node: c.quoteNode(n.Node)}
+	default:
+		return node
+	}
+}
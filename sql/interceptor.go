@@ -0,0 +1,93 @@
+package sqlstore
+
+import (
+	"context"
+	"time"
+)
+
+// QueryEvent describes an executed statement.
+type QueryEvent struct {
+	SQL      string
+	Args     []any // as bound, not redacted
+	Duration time.Duration
+	Err      error
+}
+
+// Interceptor runs around every statement a service executes, through its
+// query executors, repositories and raw SQL methods. BeforeQuery may rewrite
+// the statement and return a context carrying, for example, a trace span;
+// AfterQuery or OnError then receives that context and the outcome.
+type Interceptor interface {
+	BeforeQuery(ctx context.Context, query string, args []any) (context.Context, string, []any)
+	AfterQuery(ctx context.Context, event QueryEvent)
+	OnError(ctx context.Context, event QueryEvent)
+}
+
+// InterceptorFuncs implements Interceptor with optional functions.
+type InterceptorFuncs struct {
+	Before func(ctx context.Context, query string, args []any) (context.Context, string, []any)
+	After  func(ctx context.Context, event QueryEvent)
+	Error  func(ctx context.Context, event QueryEvent)
+}
+
+// BeforeQuery calls f.Before, if set.
+func (f InterceptorFuncs) BeforeQuery(ctx context.Context, query string, args []any) (context.Context, string, []any) {
+	if f.Before == nil {
+		return ctx, query, args
+	}
+	return f.Before(ctx, query, args)
+}
+
+// AfterQuery calls f.After, if set.
+func (f InterceptorFuncs) AfterQuery(ctx context.Context, event QueryEvent) {
+	if f.After != nil {
+		f.After(ctx, event)
+	}
+}
+
+// OnError calls f.Error, if set.
+func (f InterceptorFuncs) OnError(ctx context.Context, event QueryEvent) {
+	if f.Error != nil {
+		f.Error(ctx, event)
+	}
+}
+
+// queryRewriteFunc lets interceptors see and rewrite a statement before it runs.
+type queryRewriteFunc func(ctx context.Context, query string, args []any) (context.Context, string, []any)
+
+// serviceBinder is implemented by interceptors taking defaults from the
+// service they are added to.
+type serviceBinder interface {
+	bind(s *Service) Interceptor
+}
+
+// Use adds interceptors to the service. BeforeQuery hooks run in the order
+// added and AfterQuery and OnError hooks in reverse, so the first interceptor
+// wraps all others. Add interceptors before the service is used concurrently.
+func (s *Service) Use(interceptors ...Interceptor) {
+	for _, ic := range interceptors {
+		if b, ok := ic.(serviceBinder); ok {
+			ic = b.bind(s)
+		}
+		s.interceptors = append(s.interceptors, ic)
+	}
+}
+
+// beforeQuery passes a statement through the BeforeQuery hooks.
+func (s *Service) beforeQuery(ctx context.Context, query string, args []any) (context.Context, string, []any) {
+	for _, ic := range s.interceptors {
+		ctx, query, args = ic.BeforeQuery(ctx, query, args)
+	}
+	return ctx, query, args
+}
+
+// afterQuery reports the outcome of a statement to the interceptors.
+func (s *Service) afterQuery(ctx context.Context, event QueryEvent) {
+	for i := len(s.interceptors) - 1; i >= 0; i-- {
+		if event.Err != nil {
+			s.interceptors[i].OnError(ctx, event)
+		} else {
+			s.interceptors[i].AfterQuery(ctx, event)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"store"
+)
+
+// Migration lock timing. A lock row older than migrationLockStale is assumed
+// to be left behind by a crashed migrator and is taken over.
+const (
+	migrationLockPoll  = 50 * time.Millisecond
+	migrationLockStale = 15 * time.Minute
+)
+
+// lock waits until this migrator holds the migration lock and returns a
+// function releasing it. PostgreSQL uses a session advisory lock; other
+// databases insert a row into a lock table that only one migrator can hold.
+func (m *Migrator) lock(ctx context.Context) (release func(), err error) {
+	if m.dialect == DialectPostgres {
+		return m.advisoryLock(ctx)
+	}
+	return m.rowLock(ctx)
+}
+
+// lockTableName is the table holding the migration lock row.
+func (m *Migrator) lockTableName() string {
+	return m.adapter.MigrationTableName() + "_lock"
+}
+
+// advisoryLock takes a PostgreSQL advisory lock keyed by the migration table
+// name. The lock belongs to a session, so it is held on a dedicated connection.
+func (m *Migrator) advisoryLock(ctx context.Context) (func(), error) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(m.adapter.MigrationTableName()))
+	key := int64(h.Sum64())
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, store.WrapConnectionError(err, "migration_lock", string(m.adapter.Name()), "")
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+		_ = conn.Close()
+		return nil, store.WrapQueryError(err, "migration_lock", m.adapter.MigrationTableName(), "SELECT pg_advisory_lock($1)", []any{key})
+	}
+
+	return func() {
+		_, _ = conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", key)
+		_ = conn.Close()
+	}, nil
+}
+
+// rowLock inserts the single lock row, retrying while another migrator holds
+// it. A stale row is taken over by a conditional update on the locked_at value
+// that was read, so only one of several waiting migrators succeeds.
+func (m *Migrator) rowLock(ctx context.Context) (func(), error) {
+	table := m.lockTableName()
+	ddl := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id INTEGER PRIMARY KEY, locked_at BIGINT NOT NULL)", table)
+	if _, err := m.db.ExecContext(ctx, ddl); err != nil {
+		return nil, store.WrapQueryError(err, "create_migration_lock_table", table, ddl, nil)
+	}
+
+	insert := fmt.Sprintf("INSERT INTO %s (id, locked_at) VALUES (1, %s)", table, m.dialect.placeholder(1))
+	holder := fmt.Sprintf("SELECT locked_at FROM %s WHERE id = 1", table)
+	takeOver := fmt.Sprintf("UPDATE %s SET locked_at = %s WHERE id = 1 AND locked_at = %s AND locked_at < %s",
+		table, m.dialect.placeholder(1), m.dialect.placeholder(2), m.dialect.placeholder(3))
+
+	var lockedAt int64
+	for {
+		lockedAt = time.Now().Unix()
+		_, err := m.db.ExecContext(ctx, insert, lockedAt)
+		if err == nil {
+			break
+		}
+		if !m.adapter.IsUniqueConstraintViolation(err) {
+			return nil, store.WrapQueryError(err, "migration_lock", table, insert, nil)
+		}
+
+		var held int64
+		err = m.db.QueryRowContext(ctx, holder).Scan(&held)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// Released since the insert; retry at once
+			continue
+		case err != nil:
+			return nil, store.WrapQueryError(err, "migration_lock", table, holder, nil)
+		}
+
+		stale := time.Now().Add(-migrationLockStale).Unix()
+		if held < stale {
+			res, err := m.db.ExecContext(ctx, takeOver, lockedAt, held, stale)
+			if err != nil {
+				return nil, store.WrapQueryError(err, "migration_lock", table, takeOver, nil)
+			}
+			if n, err := res.RowsAffected(); err == nil && n == 1 {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(migrationLockPoll):
+		}
+	}
+
+	release := fmt.Sprintf("DELETE FROM %s WHERE id = 1 AND locked_at = %s", table, m.dialect.placeholder(1))
+	return func() {
+		// Leave the row alone if another migrator took it over
+		_, _ = m.db.ExecContext(context.WithoutCancel(ctx), release, lockedAt)
+	}, nil
+}
@@ -0,0 +1,637 @@
+package sqlstore
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"store"
+	"store/sql/adapter"
+)
+
+// Migration is a versioned schema change. The SQL of each direction is read
+// lazily and executed statement by statement.
+type Migration struct {
+	Version string
+	Name    string
+
+	up   sqlSource
+	down sqlSource
+}
+
+// sqlSource opens the SQL text of one migration direction.
+type sqlSource func() (io.ReadCloser, error)
+
+// NewMigration creates a migration from SQL strings. down may be empty.
+func NewMigration(version, name, up, down string) Migration {
+	m := Migration{Version: version, Name: name, up: stringSource(up)}
+	if down != "" {
+		m.down = stringSource(down)
+	}
+	return m
+}
+
+// HasDown reports whether the migration can be rolled back.
+func (m Migration) HasDown() bool {
+	return m.down != nil
+}
+
+func stringSource(s string) sqlSource {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(s)), nil
+	}
+}
+
+func fsSource(fsys fs.FS, name string) sqlSource {
+	return func() (io.ReadCloser, error) {
+		return fsys.Open(name)
+	}
+}
+
+// Migrator applies versioned migrations and records them in the adapter's
+// migration table.
+type Migrator struct {
+	db         *sql.DB
+	adapter    adapter.Adapter
+	dialect    Dialect
+	migrations []Migration
+}
+
+// NewMigrator creates a migrator for the given database.
+func NewMigrator(db *sql.DB, adpt adapter.Adapter) *Migrator {
+	return &Migrator{db: db, adapter: adpt, dialect: DialectOf(adpt)}
+}
+
+// Add registers migrations. They are kept ordered by version.
+func (m *Migrator) Add(migrations ...Migration) error {
+	for _, mig := range migrations {
+		if mig.Version == "" || mig.up == nil {
+			return fmt.Errorf("%w: migration %q needs a version and up SQL", store.ErrInvalidInput, mig.Name)
+		}
+		for _, existing := range m.migrations {
+			if existing.Version == mig.Version {
+				return fmt.Errorf("%w: duplicate migration version %s", store.ErrInvalidInput, mig.Version)
+			}
+		}
+		m.migrations = append(m.migrations, mig)
+	}
+
+	sort.SliceStable(m.migrations, func(i, j int) bool {
+		return versionLess(m.migrations[i].Version, m.migrations[j].Version)
+	})
+	return nil
+}
+
+// FromFS registers the migrations stored as .sql files in dir of fsys.
+// Files are named <version>_<name>.up.sql with an optional matching
+// <version>_<name>.down.sql; a plain <version>_<name>.sql is an up migration.
+func (m *Migrator) FromFS(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return fmt.Errorf("read migrations from %s: %w", dir, err)
+	}
+
+	byVersion := make(map[string]*Migration)
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+
+		base := strings.TrimSuffix(entry.Name(), ".sql")
+		isDown := strings.HasSuffix(base, ".down")
+		base = strings.TrimSuffix(strings.TrimSuffix(base, ".down"), ".up")
+
+		version, name, _ := strings.Cut(base, "_")
+		if version == "" {
+			return fmt.Errorf("%w: migration file %s has no version prefix", store.ErrInvalidInput, entry.Name())
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: name}
+			byVersion[version] = mig
+		}
+
+		source := fsSource(fsys, path.Join(dir, entry.Name()))
+		if isDown {
+			if mig.down != nil {
+				return fmt.Errorf("%w: duplicate down migration for version %s", store.ErrInvalidInput, version)
+			}
+			mig.down = source
+			continue
+		}
+		if mig.up != nil {
+			return fmt.Errorf("%w: duplicate migration version %s", store.ErrInvalidInput, version)
+		}
+		mig.up = source
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, version := range sortedKeys(byVersion) {
+		mig := byVersion[version]
+		if mig.up == nil {
+			return fmt.Errorf("%w: down migration for version %s has no up migration", store.ErrInvalidInput, version)
+		}
+		migrations = append(migrations, *mig)
+	}
+
+	return m.Add(migrations...)
+}
+
+// Migrations returns the registered migrations in version order.
+func (m *Migrator) Migrations() []Migration {
+	return append([]Migration(nil), m.migrations...)
+}
+
+// Up applies all pending migrations in version order and returns how many were
+// applied. Each migration runs in its own transaction together with the
+// insertion of its version row, so a failed migration leaves no trace.
+// Up holds the migration lock throughout, so concurrent migrators wait for
+// each other and each migration is applied once.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return 0, err
+	}
+
+	release, err := m.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	pending, err := m.pending(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, mig := range pending {
+		if err := m.apply(ctx, mig); err != nil {
+			return i, err
+		}
+	}
+	return len(pending), nil
+}
+
+// Down rolls back the last steps applied migrations, newest first, and returns
+// how many were rolled back. Each runs its down SQL in a transaction together
+// with the removal of its version row. Every migration to roll back must be
+// registered with down SQL; otherwise Down fails before changing anything.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	if steps <= 0 {
+		return 0, nil
+	}
+	if err := m.ensureTable(ctx); err != nil {
+		return 0, err
+	}
+
+	release, err := m.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	versions := make([]string, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versionLess(versions[j], versions[i])
+	})
+	if steps < len(versions) {
+		versions = versions[:steps]
+	}
+
+	registered := make(map[string]Migration, len(m.migrations))
+	for _, mig := range m.migrations {
+		registered[mig.Version] = mig
+	}
+
+	rollback := make([]Migration, 0, len(versions))
+	for _, version := range versions {
+		mig, ok := registered[version]
+		if !ok {
+			return 0, fmt.Errorf("%w: applied migration %s is not registered", store.ErrInvalidInput, version)
+		}
+		if !mig.HasDown() {
+			return 0, fmt.Errorf("%w: migration %s_%s has no down SQL", store.ErrInvalidInput, mig.Version, mig.Name)
+		}
+		rollback = append(rollback, mig)
+	}
+
+	for i, mig := range rollback {
+		if err := m.revert(ctx, mig); err != nil {
+			return i, err
+		}
+	}
+	return len(rollback), nil
+}
+
+// revert runs the down SQL of mig and removes its version row.
+func (m *Migrator) revert(ctx context.Context, mig Migration) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return store.WrapTransactionError(err, "begin_migration")
+	}
+
+	err = m.execSource(ctx, tx, mig.down)
+	if err == nil {
+		del := fmt.Sprintf("DELETE FROM %s WHERE version = %s", m.adapter.MigrationTableName(), m.dialect.placeholder(1))
+		_, err = tx.ExecContext(ctx, del, mig.Version)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("rollback migration %s_%s: %w", mig.Version, mig.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return store.WrapTransactionError(err, "commit_migration")
+	}
+	return nil
+}
+
+// Plan returns the migrations Up would apply, in order, without running them.
+// A database without the migration table has nothing applied; Plan does not
+// create the table.
+func (m *Migrator) Plan(ctx context.Context) ([]Migration, error) {
+	exists, err := m.tableExists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		// Nothing has been applied yet
+		return slices.Clone(m.migrations), nil
+	}
+	return m.pending(ctx)
+}
+
+// PrintPlan writes the migrations Up would apply to w, one per line.
+func (m *Migrator) PrintPlan(ctx context.Context, w io.Writer) error {
+	plan, err := m.Plan(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(plan) == 0 {
+		_, err := fmt.Fprintln(w, "No pending migrations.")
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "%d pending migration(s):\n", len(plan)); err != nil {
+		return err
+	}
+	for _, mig := range plan {
+		if _, err := fmt.Fprintf(w, "  %s %s\n", mig.Version, mig.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CurrentVersion returns the highest applied migration version, or "" if no
+// migration has been applied, including when the migration table does not
+// exist. It does not create the table.
+func (m *Migrator) CurrentVersion(ctx context.Context) (string, error) {
+	exists, err := m.tableExists(ctx)
+	if err != nil || !exists {
+		return "", err
+	}
+
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	current := ""
+	for version := range applied {
+		if current == "" || versionLess(current, version) {
+			current = version
+		}
+	}
+	return current, nil
+}
+
+// RequireVersion returns an error wrapping store.ErrSchemaVersion unless the
+// database is at the expected schema version.
+func (m *Migrator) RequireVersion(ctx context.Context, expected string) error {
+	current, err := m.CurrentVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current != expected {
+		return fmt.Errorf("%w: database is at %q, expected %q", store.ErrSchemaVersion, current, expected)
+	}
+	return nil
+}
+
+// pending returns the registered migrations not yet recorded as applied.
+func (m *Migrator) pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, mig := range m.migrations {
+		if !applied[mig.Version] {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// apply runs the up SQL of mig and records its version.
+func (m *Migrator) apply(ctx context.Context, mig Migration) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return store.WrapTransactionError(err, "begin_migration")
+	}
+
+	err = m.execSource(ctx, tx, mig.up)
+	if err == nil {
+		insert := fmt.Sprintf("INSERT INTO %s (version) VALUES (%s)", m.adapter.MigrationTableName(), m.dialect.placeholder(1))
+		_, err = tx.ExecContext(ctx, insert, mig.Version)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("migration %s_%s: %w", mig.Version, mig.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return store.WrapTransactionError(err, "commit_migration")
+	}
+	return nil
+}
+
+// execSource executes the statements read from source one at a time.
+func (m *Migrator) execSource(ctx context.Context, tx *sql.Tx, source sqlSource) error {
+	r, err := source()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return splitStatements(r, func(stmt string) error {
+		_, err := tx.ExecContext(ctx, stmt)
+		return err
+	})
+}
+
+// ensureTable creates the migration table if needed.
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	if _, err := m.db.ExecContext(ctx, m.adapter.MigrationTableSQL()); err != nil {
+		return store.WrapQueryError(err, "create_migration_table", m.adapter.MigrationTableName(), m.adapter.MigrationTableSQL(), nil)
+	}
+	return nil
+}
+
+// tableExists reports whether the migration table has been created, so
+// read-only calls do not have to create it.
+func (m *Migrator) tableExists(ctx context.Context) (bool, error) {
+	query := m.dialect.tableExistsQuery()
+	var n int
+	if err := m.db.QueryRowContext(ctx, query, m.adapter.MigrationTableName()).Scan(&n); err != nil {
+		return false, store.WrapQueryError(err, "migration_table_exists", m.adapter.MigrationTableName(), query, nil)
+	}
+	return n > 0, nil
+}
+
+// appliedVersions returns the versions recorded in the migration table.
+func (m *Migrator) appliedVersions(ctx context.Context) (map[string]bool, error) {
+	query := "SELECT version FROM " + m.adapter.MigrationTableName()
+	rows, err := m.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, store.WrapQueryError(err, "applied_migrations", m.adapter.MigrationTableName(), query, nil)
+	}
+	defer rows.Close()
+
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// versionLess orders numeric versions numerically and others lexically.
+func versionLess(a, b string) bool {
+	ai, errA := strconv.ParseUint(a, 10, 64)
+	bi, errB := strconv.ParseUint(b, 10, 64)
+	if errA == nil && errB == nil {
+		return ai < bi
+	}
+	return a < b
+}
+
+// splitStatements reads SQL from r and calls fn for each statement, without
+// the trailing semicolon. Semicolons inside quotes, comments and
+// dollar-quoted bodies do not end a statement, and a CREATE TRIGGER statement
+// with a BEGIN body continues until the END closing it.
+func splitStatements(r io.Reader, fn func(stmt string) error) error {
+	br := bufio.NewReader(r)
+	var sb strings.Builder
+	var trigger triggerState
+	var word strings.Builder
+
+	emit := func() error {
+		stmt := strings.TrimSpace(sb.String())
+		sb.Reset()
+		trigger = triggerState{}
+		if stmt == "" {
+			return nil
+		}
+		return fn(stmt)
+	}
+
+	endWord := func() {
+		if word.Len() > 0 {
+			trigger.word(strings.ToUpper(word.String()))
+			word.Reset()
+		}
+	}
+
+	// readUntil copies runes up to and including the next terminator.
+	readUntil := func(term string) error {
+		for n := 0; n < len(term) || !strings.HasSuffix(sb.String(), term); {
+			c, size, err := br.ReadRune()
+			if err != nil {
+				return err
+			}
+			sb.WriteRune(c)
+			n += size
+		}
+		return nil
+	}
+
+	for {
+		c, _, err := br.ReadRune()
+		if errors.Is(err, io.EOF) {
+			return emit()
+		}
+		if err != nil {
+			return err
+		}
+
+		if c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c) {
+			sb.WriteRune(c)
+			word.WriteRune(c)
+			continue
+		}
+		endWord()
+
+		switch c {
+		case '\'', '"', '`':
+			sb.WriteRune(c)
+			err = readUntil(string(c))
+		case '-':
+			sb.WriteRune(c)
+			if next, _ := br.Peek(1); len(next) == 1 && next[0] == '-' {
+				// A line comment ends at the end of the line or of the input
+				if err = readUntil("\n"); errors.Is(err, io.EOF) {
+					return emit()
+				}
+			}
+		case '/':
+			sb.WriteRune(c)
+			if next, _ := br.Peek(1); len(next) == 1 && next[0] == '*' {
+				_, _ = br.ReadByte()
+				sb.WriteByte('*')
+				err = readUntil("*/")
+			}
+		case '$':
+			sb.WriteRune(c)
+			tag, tagErr := readDollarTag(br)
+			if tagErr != nil {
+				err = tagErr
+				break
+			}
+			sb.WriteString(tag)
+			if strings.HasSuffix(tag, "$") {
+				err = readUntil("$" + tag)
+			}
+		case ';':
+			if trigger.inBody() {
+				sb.WriteRune(c)
+				continue
+			}
+			err = emit()
+		default:
+			sb.WriteRune(c)
+		}
+
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("%w: unterminated quote or comment in migration", store.ErrQuerySyntax)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// triggerState follows the words of a statement, outside quotes and
+// comments, to tell whether a semicolon falls inside the BEGIN ... END body
+// of a CREATE TRIGGER. Triggers without a BEGIN body, such as PostgreSQL's
+// EXECUTE FUNCTION, end at their first semicolon.
+type triggerState struct {
+	words   int  // words read so far
+	create  bool // the statement starts with CREATE
+	trigger bool // the statement is a CREATE TRIGGER
+	depth   int  // open BEGIN blocks of the body
+	cases   int  // open CASE expressions or statements within the body
+	end     bool // END read; the next word tells what it closes
+}
+
+// word records the next word of the statement, uppercased.
+func (t *triggerState) word(w string) {
+	t.words++
+	if !t.trigger {
+		// CREATE [OR REPLACE] [TEMP] TRIGGER
+		t.create = t.create || t.words == 1 && w == "CREATE"
+		t.trigger = t.create && t.words <= 4 && w == "TRIGGER"
+		return
+	}
+
+	if t.end {
+		t.end = false
+		switch w {
+		case "IF", "LOOP", "WHILE", "REPEAT":
+			return // END IF and the like close MySQL control statements
+		case "CASE":
+			t.cases-- // END CASE
+			return
+		}
+		t.closeEnd()
+	}
+	switch w {
+	case "BEGIN":
+		t.depth++
+	case "CASE":
+		if t.depth > 0 {
+			t.cases++
+		}
+	case "END":
+		t.end = t.depth > 0
+	}
+}
+
+// closeEnd applies a plain END, which closes a CASE expression before the
+// enclosing BEGIN.
+func (t *triggerState) closeEnd() {
+	if t.cases > 0 {
+		t.cases--
+	} else {
+		t.depth--
+	}
+}
+
+// inBody reports whether a semicolon read now falls inside a trigger body.
+func (t *triggerState) inBody() bool {
+	if t.end {
+		t.end = false
+		t.closeEnd()
+	}
+	return t.depth > 0
+}
+
+// readDollarTag reads the rest of a dollar-quote opener ("tag$" or "$")
+// following a '$'. For positional parameters such as $1 it returns the
+// digits read, without a trailing '$'.
+func readDollarTag(br *bufio.Reader) (string, error) {
+	var tag strings.Builder
+	for {
+		next, err := br.Peek(1)
+		if err != nil {
+			return tag.String(), nil
+		}
+		b := next[0]
+		switch {
+		case b == '$':
+			_, _ = br.ReadByte()
+			tag.WriteByte('$')
+			return tag.String(), nil
+		case b == '_' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9':
+			_, _ = br.ReadByte()
+			tag.WriteByte(b)
+		default:
+			return tag.String(), nil
+		}
+	}
+}
@@ -0,0 +1,545 @@
+package sqlstore_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+
+	"store"
+	sqlstore "store/sql"
+	"store/sql/adapter"
+)
+
+func migrationFS() fstest.MapFS {
+	return fstest.MapFS{
+		"migrations/010_add_index.up.sql": {Data: []byte("CREATE INDEX idx_users_email ON users (email);")},
+		"migrations/002_add_email.up.sql": {Data: []byte(`
+			-- statements are executed one at a time
+			ALTER TABLE users ADD COLUMN email TEXT;
+			UPDATE users SET email = name || '@example.com; legacy';
+		`)},
+		"migrations/002_add_email.down.sql": {Data: []byte("ALTER TABLE users DROP COLUMN email;")},
+		"migrations/001_init.up.sql": {Data: []byte(`
+			CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+			CREATE TABLE audit (msg TEXT);
+			CREATE TRIGGER users_audit AFTER INSERT ON users BEGIN
+				INSERT INTO audit (msg) VALUES ('inserted; ' || NEW.name);
+			END;
+			INSERT INTO users (name) VALUES ('ann');
+		`)},
+		"migrations/README.md": {Data: []byte("not a migration")},
+	}
+}
+
+func TestMigratorFromFSAppliesInOrder(t *testing.T) {
+	svc, _ := openTestService(t)
+	ctx := context.Background()
+
+	migrator := svc.Migrator()
+	if err := migrator.FromFS(migrationFS(), "migrations"); err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+
+	var versions []string
+	for _, m := range migrator.Migrations() {
+		versions = append(versions, m.Version)
+	}
+	if got := strings.Join(versions, ","); got != "001,002,010" {
+		t.Fatalf("unexpected migration order: %s", got)
+	}
+	if migrations := migrator.Migrations(); migrations[0].HasDown() || !migrations[1].HasDown() {
+		t.Errorf("down migrations not attached to the right versions")
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if applied != 3 {
+		t.Errorf("expected 3 migrations applied, got %d", applied)
+	}
+
+	var email, audit string
+	if err := svc.DB().QueryRowContext(ctx, "SELECT email FROM users WHERE name = 'ann'").Scan(&email); err != nil {
+		t.Fatalf("select email: %v", err)
+	}
+	if email != "ann@example.com; legacy" {
+		t.Errorf("unexpected email: %q", email)
+	}
+	if err := svc.DB().QueryRowContext(ctx, "SELECT msg FROM audit").Scan(&audit); err != nil {
+		t.Fatalf("select audit: %v", err)
+	}
+	if audit != "inserted; ann" {
+		t.Errorf("trigger body was split: %q", audit)
+	}
+
+	again := svc.Migrator()
+	if err := again.FromFS(migrationFS(), "migrations"); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if n, err := again.Up(ctx); err != nil || n != 0 {
+		t.Errorf("expected re-running to skip applied migrations, got %d, %v", n, err)
+	}
+}
+
+func TestMigratorFailedMigrationIsNotRecorded(t *testing.T) {
+	svc, _ := openTestService(t)
+	ctx := context.Background()
+
+	migrator := svc.Migrator()
+	err := migrator.Add(
+		sqlstore.NewMigration("1", "ok", "CREATE TABLE a (id INTEGER)", ""),
+		sqlstore.NewMigration("2", "broken", "CREATE TABLE b (id INTEGER); INSERT INTO missing VALUES (1)", ""),
+	)
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err == nil {
+		t.Fatal("expected the broken migration to fail")
+	}
+	if applied != 1 {
+		t.Errorf("expected 1 migration applied before the failure, got %d", applied)
+	}
+
+	var count int
+	if err := svc.DB().QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'b'").Scan(&count); err != nil {
+		t.Fatalf("inspect schema: %v", err)
+	}
+	if count != 0 {
+		t.Error("expected the failed migration to be rolled back")
+	}
+}
+
+func TestMigratorPlanListsPendingInOrder(t *testing.T) {
+	svc, _ := openTestService(t)
+	ctx := context.Background()
+
+	fresh := svc.Migrator()
+	if err := fresh.FromFS(migrationFS(), "migrations"); err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if plan, err := fresh.Plan(ctx); err != nil || len(plan) != 3 {
+		t.Fatalf("expected every migration pending on a fresh database, got %d, %v", len(plan), err)
+	}
+	var tables int
+	if err := svc.DB().QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'schema_migrations'").Scan(&tables); err != nil {
+		t.Fatalf("count tables: %v", err)
+	}
+	if tables != 0 {
+		t.Error("expected Plan not to create the migration table")
+	}
+
+	first := svc.Migrator()
+	if err := first.Add(sqlstore.NewMigration("001", "init", "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)", "")); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if _, err := first.Up(ctx); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	migrator := svc.Migrator()
+	if err := migrator.FromFS(migrationFS(), "migrations"); err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		plan, err := migrator.Plan(ctx)
+		if err != nil {
+			t.Fatalf("plan: %v", err)
+		}
+		var versions []string
+		for _, m := range plan {
+			versions = append(versions, m.Version)
+		}
+		if got := strings.Join(versions, ","); got != "002,010" {
+			t.Fatalf("plan %d: unexpected pending migrations: %s", i, got)
+		}
+	}
+
+	var buf strings.Builder
+	if err := migrator.PrintPlan(ctx, &buf); err != nil {
+		t.Fatalf("print plan: %v", err)
+	}
+	want := "2 pending migration(s):\n  002 add_email\n  010 add_index\n"
+	if buf.String() != want {
+		t.Errorf("unexpected plan output:\n%s", buf.String())
+	}
+
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	buf.Reset()
+	if err := migrator.PrintPlan(ctx, &buf); err != nil {
+		t.Fatalf("print plan: %v", err)
+	}
+	if buf.String() != "No pending migrations.\n" {
+		t.Errorf("unexpected plan output after up: %q", buf.String())
+	}
+}
+
+func TestMigratorVersion(t *testing.T) {
+	svc, _ := openTestService(t)
+	ctx := context.Background()
+
+	migrator := svc.Migrator()
+	if current, err := migrator.CurrentVersion(ctx); err != nil || current != "" {
+		t.Fatalf("expected no version before migrating, got %q, %v", current, err)
+	}
+	var tables int
+	if err := svc.DB().QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'schema_migrations'").Scan(&tables); err != nil {
+		t.Fatalf("count tables: %v", err)
+	}
+	if tables != 0 {
+		t.Error("expected CurrentVersion not to create the migration table")
+	}
+
+	// 10 sorts after 9 numerically, not lexically.
+	err := migrator.Add(
+		sqlstore.NewMigration("9", "a", "CREATE TABLE a (id INTEGER)", ""),
+		sqlstore.NewMigration("10", "b", "CREATE TABLE b (id INTEGER)", ""),
+	)
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	if current, err := migrator.CurrentVersion(ctx); err != nil || current != "10" {
+		t.Errorf("expected current version 10, got %q, %v", current, err)
+	}
+	if err := migrator.RequireVersion(ctx, "10"); err != nil {
+		t.Errorf("expected matching version to pass, got %v", err)
+	}
+	if err := migrator.RequireVersion(ctx, "11"); !errors.Is(err, store.ErrSchemaVersion) {
+		t.Errorf("expected ErrSchemaVersion for mismatched version, got %v", err)
+	}
+}
+
+func TestMigratorDown(t *testing.T) {
+	svc, _ := openTestService(t)
+	ctx := context.Background()
+
+	migrator := svc.Migrator()
+	err := migrator.Add(
+		sqlstore.NewMigration("1", "users", "CREATE TABLE users (id INTEGER PRIMARY KEY)", "DROP TABLE users"),
+		sqlstore.NewMigration("2", "posts", "CREATE TABLE posts (id INTEGER PRIMARY KEY)", "DROP TABLE posts"),
+		sqlstore.NewMigration("3", "tags", "CREATE TABLE tags (id INTEGER PRIMARY KEY); INSERT INTO users (id) VALUES (1)",
+			"DELETE FROM users WHERE id = 1; DROP TABLE tags"),
+	)
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	reverted, err := migrator.Down(ctx, 2)
+	if err != nil {
+		t.Fatalf("down: %v", err)
+	}
+	if reverted != 2 {
+		t.Errorf("expected 2 migrations rolled back, got %d", reverted)
+	}
+
+	tableExists := func(name string) bool {
+		var n int
+		if err := svc.DB().QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = $1", name).Scan(&n); err != nil {
+			t.Fatalf("inspect schema: %v", err)
+		}
+		return n == 1
+	}
+	if !tableExists("users") || tableExists("posts") || tableExists("tags") {
+		t.Error("unexpected schema after rolling back two migrations")
+	}
+	var users int
+	if err := svc.DB().QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&users); err != nil || users != 0 {
+		t.Errorf("expected data change of migration 3 to be reverted, got %d rows, %v", users, err)
+	}
+	if current, err := migrator.CurrentVersion(ctx); err != nil || current != "1" {
+		t.Errorf("expected version 1 after rollback, got %q, %v", current, err)
+	}
+
+	// Rolled-back migrations are pending again.
+	if applied, err := migrator.Up(ctx); err != nil || applied != 2 {
+		t.Errorf("expected to reapply 2 migrations, got %d, %v", applied, err)
+	}
+}
+
+func TestMigratorDownRequiresDownSQL(t *testing.T) {
+	svc, _ := openTestService(t)
+	ctx := context.Background()
+
+	migrator := svc.Migrator()
+	err := migrator.Add(
+		sqlstore.NewMigration("1", "a", "CREATE TABLE a (id INTEGER)", ""),
+		sqlstore.NewMigration("2", "b", "CREATE TABLE b (id INTEGER)", "DROP TABLE b"),
+	)
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	if _, err := migrator.Down(ctx, 2); !errors.Is(err, store.ErrInvalidInput) {
+		t.Fatalf("expected missing down SQL to be rejected, got %v", err)
+	}
+	if current, _ := migrator.CurrentVersion(ctx); current != "2" {
+		t.Errorf("expected nothing rolled back, current version is %q", current)
+	}
+}
+
+func init() {
+	sql.Register("sqlite3_sleep", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("sleep_ms", func(ms int64) int64 {
+				time.Sleep(time.Duration(ms) * time.Millisecond)
+				return ms
+			}, false)
+		},
+	})
+}
+
+// sleepAdapter connects through a SQLite driver providing sleep_ms(n).
+type sleepAdapter struct {
+	*adapter.SQLiteAdapter
+}
+
+func (a sleepAdapter) Connect(ctx context.Context, config *store.Config) (*sql.DB, error) {
+	return sql.Open("sqlite3_sleep", a.ConnectionString(config))
+}
+
+func TestConcurrentMigratorsApplyOnce(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "migrate.db")
+
+	// The first migration pauses before writing anything, so without the lock
+	// the second migrator reads the applied versions while it is running.
+	slow := "SELECT sleep_ms(200); CREATE TABLE counter (n INTEGER); INSERT INTO counter (n) VALUES (1);"
+
+	var migrators []*sqlstore.Migrator
+	for i := 0; i < 2; i++ {
+		config := store.SQLiteConfig(path)
+		config.Options = map[string]string{"_busy_timeout": "5000"}
+		svc, err := sqlstore.Open(ctx, sleepAdapter{adapter.NewSQLiteAdapter()}, &config)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		t.Cleanup(func() { _ = svc.Close() })
+
+		migrator := svc.Migrator()
+		err = migrator.Add(
+			sqlstore.NewMigration("1", "counter", slow, ""),
+			sqlstore.NewMigration("2", "fill", "INSERT INTO counter (n) VALUES (1);", ""),
+		)
+		if err != nil {
+			t.Fatalf("add: %v", err)
+		}
+
+		migrators = append(migrators, migrator)
+	}
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	results := make(chan int, len(migrators))
+	for _, migrator := range migrators {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			applied, err := migrator.Up(ctx)
+			if err != nil {
+				t.Errorf("up: %v", err)
+			}
+			results <- applied
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(results)
+
+	total := 0
+	for applied := range results {
+		total += applied
+	}
+	if total != 2 {
+		t.Errorf("expected 2 migrations applied across both migrators, got %d", total)
+	}
+
+	config := store.SQLiteConfig(path)
+	svc, err := sqlstore.Open(ctx, adapter.NewSQLiteAdapter(), &config)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer svc.Close()
+
+	var rows int
+	if err := svc.DB().QueryRowContext(ctx, "SELECT COUNT(*) FROM counter").Scan(&rows); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if rows != 2 {
+		t.Errorf("expected each migration to run once (2 rows), got %d rows", rows)
+	}
+}
+
+func TestMigratorTakesOverStaleLock(t *testing.T) {
+	svc, _ := openTestService(t)
+	ctx := context.Background()
+
+	// A migrator that crashed an hour ago left its lock row behind
+	setup := []string{
+		"CREATE TABLE schema_migrations_lock (id INTEGER PRIMARY KEY, locked_at BIGINT NOT NULL)",
+		"INSERT INTO schema_migrations_lock (id, locked_at) VALUES (1, " + strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10) + ")",
+	}
+	for _, stmt := range setup {
+		if err := svc.ExecuteSQL(ctx, stmt); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	migrator := svc.Migrator()
+	if err := migrator.Add(sqlstore.NewMigration("1", "a", "CREATE TABLE a (id INTEGER)", "")); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	upCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if applied, err := migrator.Up(upCtx); err != nil || applied != 1 {
+		t.Fatalf("expected the stale lock to be taken over, got %d applied, %v", applied, err)
+	}
+
+	var rows int
+	if err := svc.DB().QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations_lock").Scan(&rows); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if rows != 0 {
+		t.Errorf("expected the lock to be released, got %d rows", rows)
+	}
+}
+
+// recordedStatements holds the statements executed through the record
+// driver, which stands in for PostgreSQL by skipping EXECUTE FUNCTION
+// triggers that SQLite cannot run.
+var recordedStatements struct {
+	sync.Mutex
+	list []string
+}
+
+func init() {
+	sql.Register("sqlite3_record", recordDriver{})
+}
+
+type recordDriver struct{}
+
+func (recordDriver) Open(name string) (driver.Conn, error) {
+	conn, err := (&sqlite3.SQLiteDriver{}).Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return recordConn{Conn: conn}, nil
+}
+
+type recordConn struct {
+	driver.Conn
+}
+
+func (c recordConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	recordedStatements.Lock()
+	recordedStatements.list = append(recordedStatements.list, query)
+	recordedStatements.Unlock()
+	if strings.Contains(query, "EXECUTE FUNCTION") {
+		return driver.RowsAffected(0), nil
+	}
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+// recordAdapter connects through the record driver.
+type recordAdapter struct {
+	*adapter.SQLiteAdapter
+}
+
+func (a recordAdapter) Connect(ctx context.Context, config *store.Config) (*sql.DB, error) {
+	return sql.Open("sqlite3_record", a.ConnectionString(config))
+}
+
+func TestMigratorSplitsStatements(t *testing.T) {
+	tests := []struct {
+		name  string
+		up    string
+		check string // a query returning 1 when the migration ran as intended
+	}{
+		{
+			name: "case expression in trigger body",
+			up: `CREATE TABLE log (msg TEXT);
+				INSERT INTO log (msg) VALUES ('none');
+				CREATE TRIGGER items_log AFTER INSERT ON items BEGIN
+					UPDATE log SET msg = CASE WHEN NEW.kind = 'a' THEN 'kind a; ok' ELSE 'other' END;
+				END;
+				INSERT INTO items (id, kind) VALUES (1, 'a');`,
+			check: "SELECT COUNT(*) FROM log WHERE msg = 'kind a; ok'",
+		},
+		{
+			name: "trigger without a BEGIN body",
+			up: `CREATE TRIGGER items_audit AFTER INSERT ON items FOR EACH ROW EXECUTE FUNCTION audit();
+				CREATE TABLE after_trigger (id INTEGER);`,
+			check: "SELECT COUNT(*) FROM sqlite_master WHERE name = 'after_trigger'",
+		},
+		{
+			name:  "trailing comment without newline",
+			up:    "CREATE TABLE commented (id INTEGER); -- done",
+			check: "SELECT COUNT(*) FROM sqlite_master WHERE name = 'commented'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			config := store.SQLiteConfig(filepath.Join(t.TempDir(), "split.db"))
+			svc, err := sqlstore.Open(ctx, recordAdapter{adapter.NewSQLiteAdapter()}, &config)
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			t.Cleanup(func() { _ = svc.Close() })
+
+			migrator := svc.Migrator()
+			err = migrator.Add(
+				sqlstore.NewMigration("1", "items", "CREATE TABLE items (id INTEGER, kind TEXT)", ""),
+				sqlstore.NewMigration("2", "split", tt.up, ""),
+			)
+			if err != nil {
+				t.Fatalf("add: %v", err)
+			}
+			if n, err := migrator.Up(ctx); err != nil || n != 2 {
+				t.Fatalf("expected 2 migrations applied, got %d, %v", n, err)
+			}
+
+			var ok int
+			if err := svc.DB().QueryRowContext(ctx, tt.check).Scan(&ok); err != nil {
+				t.Fatalf("check: %v", err)
+			}
+			if ok != 1 {
+				t.Errorf("migration was split wrongly: %s returned %d", tt.check, ok)
+			}
+		})
+	}
+
+	recordedStatements.Lock()
+	defer recordedStatements.Unlock()
+	for _, stmt := range recordedStatements.list {
+		if strings.Contains(stmt, "EXECUTE FUNCTION") && !strings.HasSuffix(stmt, "audit()") {
+			t.Errorf("expected the function trigger as its own statement, got %q", stmt)
+		}
+	}
+}
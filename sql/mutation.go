@@ -4,18 +4,36 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
 
 	"store"
+	"store/sql/adapter"
 )
 
 // MutationExecutor handles execution of compiled mutations for SQL databases.
 type MutationExecutor struct {
-	db *sql.DB
+	db          *sql.DB
+	compiler    *SQLCompiler
+	logQuery    queryLogFunc
+	beforeQuery queryRewriteFunc
 }
 
-// NewMutationExecutor creates a new SQL mutation executor.
+// NewMutationExecutor creates a new SQL mutation executor compiling with the
+// default (PostgreSQL) compiler.
 func NewMutationExecutor(db *sql.DB) *MutationExecutor {
-	return &MutationExecutor{db: db}
+	return &MutationExecutor{db: db, compiler: defaultCompiler}
+}
+
+// WithCompiler returns a copy of the executor that compiles mutations with
+// compiler, so they follow its dialect.
+func (me *MutationExecutor) WithCompiler(compiler *SQLCompiler) *MutationExecutor {
+	cp := *me
+	cp.compiler = compiler
+	return &cp
 }
 
 // Execute executes a mutation and returns result metadata.
@@ -25,16 +43,119 @@ func (me *MutationExecutor) Execute(ctx context.Context, mutation store.Mutation
 	return store.MutationResult{}, store.NewValidationError("Execute requires table name, use ExecuteForTable")
 }
 
-// ExecuteCompiled executes a pre-compiled mutation.
+// Hints set on compiled mutations.
+const (
+	// hintReturning holds the columns a statement returns.
+	hintReturning = "returning"
+	// hintReturningLookup holds the SELECT emulating RETURNING on MySQL. It
+	// binds hintReturningArgs, or the statement's LastInsertId without them.
+	hintReturningLookup = "returning_lookup"
+	hintReturningArgs   = "returning_args"
+	// hintKey holds the key columns of an insert, set with Insert.WithKey.
+	hintKey = "key"
+)
+
+// ExecuteCompiled executes a pre-compiled mutation. Columns requested with
+// WithReturning are captured in MutationResult.Returning, one map per row.
 func (me *MutationExecutor) ExecuteCompiled(ctx context.Context, compiled store.CompiledMutation) (store.MutationResult, error) {
-	// For simplicity, we'll handle RETURNING clauses later
-	// Right now, just do regular execution
+	if lookup, ok := compiled.Hints[hintReturningLookup].(string); ok {
+		return me.executeLookup(ctx, compiled, lookup)
+	}
+	if _, ok := compiled.Hints[hintReturning]; ok {
+		return me.executeReturning(ctx, compiled)
+	}
 	return me.executeRegular(ctx, compiled)
 }
 
+// executeReturning executes a mutation with a RETURNING clause and scans the
+// returned rows.
+func (me *MutationExecutor) executeReturning(ctx context.Context, compiled store.CompiledMutation) (store.MutationResult, error) {
+	returning, err := me.queryMaps(ctx, compiled.SQL, compiled.Args)
+	if err != nil {
+		return store.MutationResult{}, err
+	}
+	return store.MutationResult{
+		RowsAffected: int64(len(returning)),
+		Returning:    returning,
+	}, nil
+}
+
+// executeLookup executes an insert and reads the new row back with lookup,
+// emulating RETURNING on databases without it. Both run in one transaction,
+// the context's or a new one, so an insert whose row cannot be read back is
+// rolled back rather than committed behind the error.
+func (me *MutationExecutor) executeLookup(ctx context.Context, compiled store.CompiledMutation, lookup string) (store.MutationResult, error) {
+	if tx, ok := TransactionFromContext(ctx); ok && tx != nil {
+		return me.insertAndLookup(ctx, compiled, lookup)
+	}
+
+	tx, err := me.db.BeginTx(ctx, nil)
+	if err != nil {
+		return store.MutationResult{}, store.WrapTransactionError(err, "begin_returning")
+	}
+	result, err := me.insertAndLookup(context.WithValue(ctx, txContextKey{}, tx), compiled, lookup)
+	if err != nil {
+		_ = tx.Rollback()
+		return store.MutationResult{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return store.MutationResult{}, store.WrapTransactionError(err, "commit_returning")
+	}
+	return result, nil
+}
+
+func (me *MutationExecutor) insertAndLookup(ctx context.Context, compiled store.CompiledMutation, lookup string) (store.MutationResult, error) {
+	result, err := me.executeRegular(ctx, compiled)
+	if err != nil {
+		return store.MutationResult{}, err
+	}
+
+	args, ok := compiled.Hints[hintReturningArgs].([]any)
+	if !ok {
+		id, err := strconv.ParseInt(result.LastInsertID, 10, 64)
+		if err != nil || id == 0 {
+			return store.MutationResult{}, fmt.Errorf("%w: RETURNING emulation needs an AUTO_INCREMENT id or the inserted key values", store.ErrNotSupported)
+		}
+		args = []any{id}
+	}
+
+	result.Returning, err = me.queryMaps(ctx, lookup, args)
+	if err != nil {
+		return store.MutationResult{}, err
+	}
+	if len(result.Returning) == 0 {
+		return store.MutationResult{}, fmt.Errorf("%w: RETURNING emulation could not read the inserted row back by its key", store.ErrNotSupported)
+	}
+	return result, nil
+}
+
+// queryMaps runs query in the context's transaction, if any, and scans each
+// row into a map keyed by lowercased column name.
+func (me *MutationExecutor) queryMaps(ctx context.Context, query string, args []any) ([]map[string]any, error) {
+	var q queryer = me.db
+	if tx, ok := TransactionFromContext(ctx); ok && tx != nil {
+		q = tx
+	}
+	rows, err := withQueryLog(q, me.beforeQuery, me.logQuery).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []map[string]any
+	for rows.Next() {
+		values, err := scanRowToValues(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, values)
+	}
+	return out, rows.Err()
+}
+
 // ExecuteForTable executes a mutation for a specific table.
 func (me *MutationExecutor) ExecuteForTable(ctx context.Context, table string, mutation store.Mutation) (store.MutationResult, error) {
-	compiled, err := CompileMutation(table, mutation)
+	compiled, err := me.compiler.CompileMutation(table, mutation)
 	if err != nil {
 		return store.MutationResult{}, err
 	}
@@ -47,11 +168,18 @@ func (me *MutationExecutor) executeRegular(ctx context.Context, compiled store.C
 	var result sql.Result
 	var err error
 
+	if me.beforeQuery != nil {
+		ctx, compiled.SQL, compiled.Args = me.beforeQuery(ctx, compiled.SQL, compiled.Args)
+	}
+	start := time.Now()
 	if tx, ok := TransactionFromContext(ctx); ok && tx != nil {
 		result, err = tx.ExecContext(ctx, compiled.SQL, compiled.Args...)
 	} else {
 		result, err = me.db.ExecContext(ctx, compiled.SQL, compiled.Args...)
 	}
+	if me.logQuery != nil {
+		me.logQuery(ctx, compiled.SQL, compiled.Args, start, err)
+	}
 
 	if err != nil {
 		return store.MutationResult{}, err
@@ -113,6 +241,165 @@ func (me *MutationExecutor) executeBatchInTx(ctx context.Context, tx *sql.Tx, mu
 	return results, nil
 }
 
+// CopyFrom bulk loads rows into table with PostgreSQL's COPY FROM STDIN,
+// which is much faster than INSERT for large loads. Each row holds the values
+// of columns, in order. It runs in the transaction from ctx, or in its own
+// transaction otherwise, and returns the number of rows loaded. Only the
+// PostgreSQL driver supports COPY; other databases fail with
+// store.ErrNotSupported.
+func (me *MutationExecutor) CopyFrom(ctx context.Context, table string, columns []string, rows [][]any) (int64, error) {
+	if _, ok := me.db.Driver().(*pq.Driver); !ok {
+		return 0, fmt.Errorf("%w: COPY FROM requires PostgreSQL", store.ErrNotSupported)
+	}
+	if err := checkIdentifier("table", table); err != nil {
+		return 0, err
+	}
+	if len(columns) == 0 {
+		return 0, fmt.Errorf("%w: copy columns cannot be empty", store.ErrInvalidQuery)
+	}
+	for _, col := range columns {
+		if err := checkIdentifier("column", col); err != nil {
+			return 0, err
+		}
+	}
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			return 0, fmt.Errorf("%w: copy row %d has %d values, expected %d", store.ErrInvalidQuery, i, len(row), len(columns))
+		}
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	if tx, ok := TransactionFromContext(ctx); ok && tx != nil {
+		return me.copyInTx(ctx, tx, table, columns, rows)
+	}
+
+	tx, err := me.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, store.WrapTransactionError(err, "begin_copy")
+	}
+	n, err := me.copyInTx(ctx, tx, table, columns, rows)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, store.WrapTransactionError(err, "commit_copy")
+	}
+	return n, nil
+}
+
+// copyInTx streams rows to a COPY statement within tx. Schema-qualified
+// tables are quoted per part.
+func (me *MutationExecutor) copyInTx(ctx context.Context, tx *sql.Tx, table string, columns []string, rows [][]any) (int64, error) {
+	query := pq.CopyIn(table, columns...)
+	if schema, name, ok := strings.Cut(table, "."); ok {
+		query = pq.CopyInSchema(schema, name, columns...)
+	}
+
+	if me.beforeQuery != nil {
+		ctx, query, _ = me.beforeQuery(ctx, query, nil)
+	}
+	start := time.Now()
+	n, err := copyRows(ctx, tx, query, rows)
+	if me.logQuery != nil {
+		me.logQuery(ctx, query, nil, start, err)
+	}
+	if err != nil {
+		return 0, store.WrapQueryError(err, "copy_from", table, query, nil)
+	}
+	return n, nil
+}
+
+func copyRows(ctx context.Context, tx *sql.Tx, query string, rows [][]any) (int64, error) {
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return 0, err
+		}
+	}
+	// The final Exec without arguments flushes the buffered rows
+	result, err := stmt.ExecContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Upsert inserts m's row or updates the row it conflicts with. When the
+// compiler has native upserts disabled it is emulated inside a transaction:
+// the conflicting row is selected (and locked where the database supports
+// row locks), then updated or inserted. The emulation does not lock rows
+// that do not exist yet, so a concurrent insert of the same key can still
+// fail with a unique constraint violation.
+func (me *MutationExecutor) Upsert(ctx context.Context, table string, m store.Upsert) (store.MutationResult, error) {
+	if me.compiler.upsert != adapter.UpsertNone {
+		return me.ExecuteForTable(ctx, table, m)
+	}
+	if tx, ok := TransactionFromContext(ctx); ok && tx != nil {
+		return me.emulateUpsert(ctx, table, m)
+	}
+
+	tx, err := me.db.BeginTx(ctx, nil)
+	if err != nil {
+		return store.MutationResult{}, store.WrapTransactionError(err, "begin_upsert")
+	}
+	result, err := me.emulateUpsert(context.WithValue(ctx, txContextKey{}, tx), table, m)
+	if err != nil {
+		_ = tx.Rollback()
+		return store.MutationResult{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return store.MutationResult{}, store.WrapTransactionError(err, "commit_upsert")
+	}
+	return result, nil
+}
+
+func (me *MutationExecutor) emulateUpsert(ctx context.Context, table string, m store.Upsert) (store.MutationResult, error) {
+	if len(m.ConflictColumns) == 0 {
+		return store.MutationResult{}, fmt.Errorf("%w: upsert conflict columns cannot be empty", store.ErrInvalidQuery)
+	}
+	conditions := make([]store.Condition, len(m.ConflictColumns))
+	for i, col := range m.ConflictColumns {
+		value, ok := m.Values[col]
+		if !ok {
+			return store.MutationResult{}, fmt.Errorf("%w: upsert has no value for conflict column %s", store.ErrInvalidQuery, col)
+		}
+		conditions[i] = store.Eq(col, value)
+	}
+
+	qb := NewQueryBuilder(table).Select("1").WhereCondition(conditions...).Limit(1)
+	if me.compiler.dialect != DialectSQLite {
+		qb.LockForUpdate()
+	}
+	query, args, err := me.compiler.CompileQuery(qb)
+	if err != nil {
+		return store.MutationResult{}, err
+	}
+	existing, err := me.queryMaps(ctx, query, args)
+	if err != nil {
+		return store.MutationResult{}, err
+	}
+	if len(existing) == 0 {
+		return me.ExecuteForTable(ctx, table, store.Insert{Values: m.Values, Hints: m.Hints})
+	}
+
+	set := make(map[string]any)
+	for _, col := range upsertUpdateColumns(m) {
+		set[col] = m.Values[col]
+	}
+	if len(set) == 0 {
+		return store.MutationResult{}, nil
+	}
+	return me.ExecuteForTable(ctx, table, store.Update{Set: set, Where: conditions, Hints: m.Hints})
+}
+
 // Specialized mutation methods
 
 // Insert executes an INSERT mutation.
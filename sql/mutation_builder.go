@@ -0,0 +1,180 @@
+package sqlstore
+
+import (
+	"strings"
+
+	"store"
+)
+
+// UpdateBuilder builds UPDATE statements fluently. Like QueryBuilder, it keeps
+// conditions as store values and compiles them with a SQLCompiler.
+type UpdateBuilder struct {
+	table    string
+	mutation store.Update
+	err      error
+}
+
+// NewUpdateBuilder creates a builder updating rows of the given table.
+func NewUpdateBuilder(table string) *UpdateBuilder {
+	return &UpdateBuilder{table: table, mutation: store.Update{Set: map[string]any{}}}
+}
+
+// Set sets column to value.
+func (ub *UpdateBuilder) Set(column string, value any) *UpdateBuilder {
+	ub.mutation.Set[column] = value
+	return ub
+}
+
+// Increment adds by to column atomically, without reading the row first.
+func (ub *UpdateBuilder) Increment(column string, by any) *UpdateBuilder {
+	return ub.Set(column, store.Increment(column, by))
+}
+
+// SetExpr sets column to the SQL expression expr, binding args to its ?
+// markers, e.g. SetExpr("price", "price * ?", 1.1).
+func (ub *UpdateBuilder) SetExpr(column, expr string, args ...any) *UpdateBuilder {
+	return ub.Set(column, store.Expr(expr, args...))
+}
+
+// Where adds a condition using a SQL operator, as QueryBuilder.Where does.
+func (ub *UpdateBuilder) Where(field, op string, value any) *UpdateBuilder {
+	cond, err := parseCondition(field, op, value)
+	if err != nil {
+		if ub.err == nil {
+			ub.err = err
+		}
+		return ub
+	}
+	ub.mutation.Where = append(ub.mutation.Where, cond)
+	return ub
+}
+
+// WhereCondition adds store conditions (all ANDed together).
+func (ub *UpdateBuilder) WhereCondition(conditions ...store.Condition) *UpdateBuilder {
+	ub.mutation.Where = append(ub.mutation.Where, conditions...)
+	return ub
+}
+
+// WhereNode adds filter trees, ANDed with each other and the conditions.
+func (ub *UpdateBuilder) WhereNode(nodes ...store.Node) *UpdateBuilder {
+	ub.mutation.Nodes = append(ub.mutation.Nodes, nodes...)
+	return ub
+}
+
+// WhereOr adds a parenthesized group matching when any of nodes matches.
+func (ub *UpdateBuilder) WhereOr(nodes ...store.Node) *UpdateBuilder {
+	return ub.WhereNode(store.Or(nodes...))
+}
+
+// OrderBy adds an ORDER BY term choosing which rows Limit updates.
+// Direction is "ASC" or "DESC".
+func (ub *UpdateBuilder) OrderBy(field, direction string) *UpdateBuilder {
+	ub.mutation.OrderBy = append(ub.mutation.OrderBy, store.Order{Field: field, Desc: strings.EqualFold(direction, "DESC")})
+	return ub
+}
+
+// Limit updates at most limit rows, the first ones in OrderBy order.
+func (ub *UpdateBuilder) Limit(limit int) *UpdateBuilder {
+	ub.mutation.Limit = limit
+	return ub
+}
+
+// Mutation returns the update built so far, or the first builder error.
+func (ub *UpdateBuilder) Mutation() (store.Update, error) {
+	return ub.mutation, ub.err
+}
+
+// Compile compiles the update with compiler.
+func (ub *UpdateBuilder) Compile(compiler *SQLCompiler) (*store.CompiledMutation, error) {
+	if ub.err != nil {
+		return nil, ub.err
+	}
+	return compiler.CompileMutation(ub.table, ub.mutation)
+}
+
+// Build compiles the update using the default (PostgreSQL) compiler.
+func (ub *UpdateBuilder) Build() (string, []any, error) {
+	return buildMutation(ub.Compile(defaultCompiler))
+}
+
+// DeleteBuilder builds DELETE statements fluently.
+type DeleteBuilder struct {
+	table    string
+	mutation store.Delete
+	err      error
+}
+
+// NewDeleteBuilder creates a builder deleting rows of the given table.
+func NewDeleteBuilder(table string) *DeleteBuilder {
+	return &DeleteBuilder{table: table}
+}
+
+// Where adds a condition using a SQL operator, as QueryBuilder.Where does.
+func (del *DeleteBuilder) Where(field, op string, value any) *DeleteBuilder {
+	cond, err := parseCondition(field, op, value)
+	if err != nil {
+		if del.err == nil {
+			del.err = err
+		}
+		return del
+	}
+	del.mutation.Where = append(del.mutation.Where, cond)
+	return del
+}
+
+// WhereCondition adds store conditions (all ANDed together).
+func (del *DeleteBuilder) WhereCondition(conditions ...store.Condition) *DeleteBuilder {
+	del.mutation.Where = append(del.mutation.Where, conditions...)
+	return del
+}
+
+// WhereNode adds filter trees, ANDed with each other and the conditions.
+func (del *DeleteBuilder) WhereNode(nodes ...store.Node) *DeleteBuilder {
+	del.mutation.Nodes = append(del.mutation.Nodes, nodes...)
+	return del
+}
+
+// WhereOr adds a parenthesized group matching when any of nodes matches.
+func (del *DeleteBuilder) WhereOr(nodes ...store.Node) *DeleteBuilder {
+	return del.WhereNode(store.Or(nodes...))
+}
+
+// OrderBy adds an ORDER BY term choosing which rows Limit deletes.
+// Direction is "ASC" or "DESC".
+func (del *DeleteBuilder) OrderBy(field, direction string) *DeleteBuilder {
+	del.mutation.OrderBy = append(del.mutation.OrderBy, store.Order{Field: field, Desc: strings.EqualFold(direction, "DESC")})
+	return del
+}
+
+// Limit deletes at most limit rows, the first ones in OrderBy order.
+// Repeating a limited delete until it affects no rows removes large sets of
+// rows in small transactions.
+func (del *DeleteBuilder) Limit(limit int) *DeleteBuilder {
+	del.mutation.Limit = limit
+	return del
+}
+
+// Mutation returns the delete built so far, or the first builder error.
+func (del *DeleteBuilder) Mutation() (store.Delete, error) {
+	return del.mutation, del.err
+}
+
+// Compile compiles the delete with compiler.
+func (del *DeleteBuilder) Compile(compiler *SQLCompiler) (*store.CompiledMutation, error) {
+	if del.err != nil {
+		return nil, del.err
+	}
+	return compiler.CompileMutation(del.table, del.mutation)
+}
+
+// Build compiles the delete using the default (PostgreSQL) compiler.
+func (del *DeleteBuilder) Build() (string, []any, error) {
+	return buildMutation(del.Compile(defaultCompiler))
+}
+
+func buildMutation(compiled *store.CompiledMutation, err error) (string, []any, error) {
+	if err != nil {
+		return "", nil, err
+	}
+	return compiled.SQL, compiled.Args, nil
+}
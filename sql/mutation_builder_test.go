@@ -0,0 +1,204 @@
+package sqlstore_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"store"
+	sqlstore "store/sql"
+)
+
+func TestMutationBuildersCompileOrGroups(t *testing.T) {
+	tests := []struct {
+		name     string
+		build    func() (string, []any, error)
+		wantSQL  string
+		wantArgs int
+	}{
+		{
+			name: "update",
+			build: sqlstore.NewUpdateBuilder("orders").
+				Set("status", "archived").
+				Where("amount", "<", 10).
+				WhereOr(store.Eq("status", "open"), store.And(store.Eq("customer", "bob"), store.Eq("status", "paid"))).
+				Build,
+			wantSQL:  "UPDATE orders SET status = $1 WHERE amount < $2 AND (status = $3 OR (customer = $4 AND status = $5))",
+			wantArgs: 5,
+		},
+		{
+			name: "increment",
+			build: sqlstore.NewUpdateBuilder("orders").
+				Increment("amount", 5).
+				SetExpr("status", "UPPER(status)").
+				Where("customer", "=", "bob").
+				Build,
+			wantSQL:  "UPDATE orders SET amount = amount + $1, status = UPPER(status) WHERE customer = $2",
+			wantArgs: 2,
+		},
+		{
+			name: "delete",
+			build: sqlstore.NewDeleteBuilder("orders").
+				WhereOr(store.Eq("customer", "dave"), store.IsNull("customer")).
+				Where("status", "=", "open").
+				Build,
+			wantSQL:  "DELETE FROM orders WHERE status = $1 AND (customer = $2 OR customer IS NULL)",
+			wantArgs: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := tt.build()
+			if err != nil {
+				t.Fatalf("build failed: %v", err)
+			}
+			if query != tt.wantSQL {
+				t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, tt.wantSQL)
+			}
+			if len(args) != tt.wantArgs {
+				t.Errorf("expected %d args, got %v", tt.wantArgs, args)
+			}
+		})
+	}
+
+	if _, _, err := sqlstore.NewDeleteBuilder("orders").Where("id", "~~", 1).Build(); !errors.Is(err, store.ErrInvalidQuery) {
+		t.Errorf("expected unsupported operator to fail with ErrInvalidQuery, got %v", err)
+	}
+}
+
+func TestMutationBuildersExecuteOrGroups(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	setup := []string{
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, customer TEXT, status TEXT, amount INTEGER)",
+		`INSERT INTO orders (customer, status, amount) VALUES
+			('alice', 'paid', 10), ('bob', 'paid', 5), ('bob', 'open', 7), ('dave', 'open', 1)`,
+	}
+	for _, stmt := range setup {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	compiler := sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectSQLite)
+	exec := sqlstore.NewMutationExecutor(db)
+
+	update, err := sqlstore.NewUpdateBuilder("orders").
+		Set("status", "flagged").
+		WhereOr(store.Eq("customer", "alice"), store.And(store.Eq("customer", "bob"), store.Eq("status", "open"))).
+		Compile(compiler)
+	if err != nil {
+		t.Fatalf("compile update: %v", err)
+	}
+	res, err := exec.ExecuteCompiled(ctx, *update)
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if res.RowsAffected != 2 {
+		t.Errorf("expected 2 updated rows, got %d", res.RowsAffected)
+	}
+
+	del, err := sqlstore.NewDeleteBuilder("orders").
+		Where("amount", "<", 8).
+		WhereOr(store.Eq("status", "flagged"), store.Eq("customer", "dave")).
+		Compile(compiler)
+	if err != nil {
+		t.Fatalf("compile delete: %v", err)
+	}
+	res, err = exec.ExecuteCompiled(ctx, *del)
+	if err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if res.RowsAffected != 2 {
+		t.Errorf("expected 2 deleted rows (bob's open order and dave's), got %d", res.RowsAffected)
+	}
+
+	var left int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM orders").Scan(&left); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if left != 2 {
+		t.Errorf("expected 2 remaining rows, got %d", left)
+	}
+}
+
+func TestMutationBuildersLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect sqlstore.Dialect
+		compile func(*sqlstore.SQLCompiler) (*store.CompiledMutation, error)
+		want    string
+	}{
+		{"mysql update", sqlstore.DialectMySQL,
+			sqlstore.NewUpdateBuilder("jobs").Set("state", "done").Where("state", "=", "stale").OrderBy("id", "ASC").Limit(100).Compile,
+			"UPDATE jobs SET state = ? WHERE state = ? ORDER BY id ASC LIMIT 100"},
+		{"mysql delete", sqlstore.DialectMySQL,
+			sqlstore.NewDeleteBuilder("events").Where("created_at", "<", "2024-01-01").OrderBy("created_at", "ASC").Limit(500).Compile,
+			"DELETE FROM events WHERE created_at < ? ORDER BY created_at ASC LIMIT 500"},
+		{"postgres delete", sqlstore.DialectPostgres,
+			sqlstore.NewDeleteBuilder("events").Where("created_at", "<", "2024-01-01").OrderBy("created_at", "ASC").Limit(500).Compile,
+			"DELETE FROM events WHERE ctid IN (SELECT ctid FROM events WHERE created_at < $1 ORDER BY created_at ASC LIMIT 500)"},
+		{"sqlite update", sqlstore.DialectSQLite,
+			sqlstore.NewUpdateBuilder("jobs").Set("state", "done").Where("state", "=", "stale").OrderBy("id", "DESC").Limit(10).Compile,
+			"UPDATE jobs SET state = $1 WHERE rowid IN (SELECT rowid FROM jobs WHERE state = $2 ORDER BY id DESC LIMIT 10)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiled, err := tt.compile(sqlstore.NewSQLCompiler().WithDialect(tt.dialect))
+			if err != nil {
+				t.Fatalf("compile failed: %v", err)
+			}
+			if compiled.SQL != tt.want {
+				t.Errorf("unexpected SQL:\n got: %s\nwant: %s", compiled.SQL, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatchedDeleteWithLimit(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	setup := []string{
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, customer TEXT, status TEXT, amount INTEGER)",
+		`INSERT INTO orders (customer, status, amount) VALUES
+			('alice', 'paid', 10), ('alice', 'paid', 20), ('bob', 'paid', 5),
+			('bob', 'open', 7), ('carol', 'paid', 30), ('dave', 'open', 1)`,
+	}
+	for _, stmt := range setup {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	compiler := sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectSQLite)
+	exec := sqlstore.NewMutationExecutor(db).WithCompiler(compiler)
+	qe := sqlstore.NewQueryExecutor(db, compiler)
+
+	// Delete the paid orders two at a time, smallest first
+	var batches []int64
+	for {
+		del, err := sqlstore.NewDeleteBuilder("orders").Where("status", "=", "paid").OrderBy("amount", "ASC").Limit(2).Compile(compiler)
+		if err != nil {
+			t.Fatalf("compile: %v", err)
+		}
+		res, err := exec.ExecuteCompiled(ctx, *del)
+		if err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+		if res.RowsAffected == 0 {
+			break
+		}
+		batches = append(batches, res.RowsAffected)
+
+		if len(batches) == 1 {
+			n, err := qe.Count(ctx, sqlstore.NewQueryBuilder("orders").Where("status", "=", "paid").Where("amount", "<", 20))
+			if err != nil {
+				t.Fatalf("count: %v", err)
+			}
+			if n != 0 {
+				t.Errorf("expected the first batch to remove the two smallest paid orders, %d remain", n)
+			}
+		}
+	}
+	if len(batches) != 2 || batches[0] != 2 || batches[1] != 2 {
+		t.Errorf("expected two batches of 2, got %v", batches)
+	}
+}
@@ -0,0 +1,575 @@
+package sqlstore
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"store"
+	"store/sql/adapter"
+)
+
+// Mutation compilation. Every mutation is compiled by SQLCompiler, so
+// placeholders, identifier quoting and dialect-specific syntax follow the
+// compiler's configuration. Columns are emitted in sorted order so the
+// compiled SQL is deterministic.
+
+// CompileMutation compiles a mutation to SQL using the default (PostgreSQL)
+// compiler. Code with a service should use its compiler instead, through
+// Service.Compiler or a repository.
+func CompileMutation(tableName string, mutation store.Mutation) (*store.CompiledMutation, error) {
+	return defaultCompiler.CompileMutation(tableName, mutation)
+}
+
+// CompileMutation compiles a mutation to SQL for the compiler's dialect.
+// Identifiers are validated, and quoted when quoting is enabled, and the
+// statement may bind at most MaxParams parameters.
+func (c *SQLCompiler) CompileMutation(tableName string, mutation store.Mutation) (*store.CompiledMutation, error) {
+	if err := checkMutationIdentifiers(tableName, mutation); err != nil {
+		return nil, err
+	}
+	if err := c.checkConditions(mutationConditions(mutation)); err != nil {
+		return nil, err
+	}
+	if c.quoteIdents {
+		tableName = c.quote(tableName)
+		mutation = c.quoteMutation(mutation)
+	}
+
+	var compiled *store.CompiledMutation
+	var err error
+
+	switch m := mutation.(type) {
+	case store.Insert:
+		compiled, err = c.compileInsert(tableName, m)
+	case store.BulkInsert:
+		compiled, err = c.compileBulkInsert(tableName, m)
+	case store.Upsert:
+		compiled, err = c.compileUpsert(tableName, m)
+	case store.Update:
+		compiled, err = c.compileUpdate(tableName, m)
+	case store.Delete:
+		compiled, err = c.compileDelete(tableName, m)
+	case store.UpdateFrom:
+		compiled, err = c.compileUpdateFrom(tableName, m)
+	case store.InsertSelect:
+		compiled, err = c.compileInsertSelect(tableName, m)
+	case store.UpdateCase:
+		compiled, err = c.compileUpdateCase(tableName, m)
+	default:
+		return nil, fmt.Errorf("%w: mutation type %T", store.ErrNotSupported, mutation)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if columns := returningColumns(mutation); len(columns) > 0 {
+		if err := c.addReturning(tableName, mutation, compiled, columns); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.checkParamCount(len(compiled.Args)); err != nil {
+		return nil, err
+	}
+	return compiled, nil
+}
+
+// addReturning adds the RETURNING clause requested by a mutation's
+// "returning" hint. Without RETURNING, as on MySQL, an insert instead carries a
+// SELECT reading the new row back by its key columns (see Insert.WithKey):
+// by the inserted key values when the insert sets them, else by the
+// AUTO_INCREMENT id, which MutationExecutor binds from LastInsertId. Updates
+// and deletes cannot be emulated and fail with store.ErrNotSupported.
+func (c *SQLCompiler) addReturning(tableName string, mutation store.Mutation, compiled *store.CompiledMutation, columns []string) error {
+	columns = c.quoteAll(columns)
+	if compiled.Hints == nil {
+		compiled.Hints = map[string]any{}
+	}
+	compiled.Hints[hintReturning] = columns
+
+	if c.returning {
+		compiled.SQL += " RETURNING " + strings.Join(columns, ", ")
+		return nil
+	}
+	insert, ok := mutation.(store.Insert)
+	if !ok {
+		return fmt.Errorf("%w: RETURNING on %T for %s", store.ErrNotSupported, mutation, c.dialect)
+	}
+
+	keys, _ := insert.Hints[hintKey].([]string)
+	if len(keys) == 0 {
+		keys = []string{"id"}
+	}
+	var conds []string
+	var args []any
+	for i, key := range keys {
+		value, ok := insert.Values[key]
+		if !ok {
+			break
+		}
+		conds = append(conds, fmt.Sprintf("%s = %s", c.quote(key), c.dialect.placeholder(i+1)))
+		args = append(args, value)
+	}
+	switch {
+	case len(conds) == len(keys):
+		compiled.Hints[hintReturningArgs] = args
+	case len(keys) == 1:
+		conds = []string{fmt.Sprintf("%s = %s", c.quote(keys[0]), c.dialect.placeholder(1))}
+	default:
+		return fmt.Errorf("%w: RETURNING emulation needs the values of key %s", store.ErrNotSupported, strings.Join(keys, ", "))
+	}
+	compiled.Hints[hintReturningLookup] = fmt.Sprintf("SELECT %s FROM %s WHERE %s",
+		strings.Join(columns, ", "), tableName, strings.Join(conds, " AND "))
+	return nil
+}
+
+// returningColumns returns the columns of a mutation's "returning" hint.
+func returningColumns(mutation store.Mutation) []string {
+	var hints map[string]any
+	switch m := mutation.(type) {
+	case store.Insert:
+		hints = m.Hints
+	case store.Update:
+		hints = m.Hints
+	case store.Delete:
+		hints = m.Hints
+	case store.Upsert:
+		hints = m.Hints
+	}
+	columns, _ := hints[hintReturning].([]string)
+	return columns
+}
+
+func (c *SQLCompiler) compileInsert(tableName string, insert store.Insert) (*store.CompiledMutation, error) {
+	if len(insert.Values) == 0 {
+		return nil, fmt.Errorf("insert values cannot be empty")
+	}
+
+	columns := sortedKeys(insert.Values)
+	placeholders := make([]string, len(columns))
+	args := make([]any, len(columns))
+	for i, col := range columns {
+		placeholders[i] = c.dialect.placeholder(i + 1)
+		args[i] = insert.Values[col]
+	}
+
+	sql := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		tableName,
+		strings.Join(columns, ", "),
+		strings.Join(placeholders, ", "))
+
+	return &store.CompiledMutation{
+		SQL:  sql,
+		Args: args,
+	}, nil
+}
+
+// compileUpsert compiles INSERT ... ON CONFLICT (...) DO UPDATE on
+// PostgreSQL and SQLite and INSERT ... ON DUPLICATE KEY UPDATE on MySQL,
+// which resolves conflicts on any unique key rather than the given columns.
+func (c *SQLCompiler) compileUpsert(tableName string, m store.Upsert) (*store.CompiledMutation, error) {
+	if c.upsert == adapter.UpsertNone {
+		return nil, fmt.Errorf("%w: native upsert on %s", store.ErrNotSupported, c.dialect)
+	}
+	if len(m.ConflictColumns) == 0 {
+		return nil, fmt.Errorf("%w: upsert conflict columns cannot be empty", store.ErrInvalidQuery)
+	}
+
+	compiled, err := c.compileInsert(tableName, store.Insert{Values: m.Values})
+	if err != nil {
+		return nil, err
+	}
+
+	onDuplicateKey := c.upsert == adapter.UpsertOnDuplicateKey
+	updates := upsertUpdateColumns(m)
+	sets := make([]string, len(updates))
+	for i, col := range updates {
+		if onDuplicateKey {
+			sets[i] = fmt.Sprintf("%s = VALUES(%s)", col, col)
+		} else {
+			sets[i] = fmt.Sprintf("%s = EXCLUDED.%s", col, col)
+		}
+	}
+
+	switch {
+	case onDuplicateKey && len(sets) == 0:
+		// A no-op assignment keeps the existing row
+		compiled.SQL += fmt.Sprintf(" ON DUPLICATE KEY UPDATE %s = %s", m.ConflictColumns[0], m.ConflictColumns[0])
+	case onDuplicateKey:
+		compiled.SQL += " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+	case len(sets) == 0:
+		compiled.SQL += fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", strings.Join(m.ConflictColumns, ", "))
+	default:
+		compiled.SQL += fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(m.ConflictColumns, ", "), strings.Join(sets, ", "))
+	}
+	return compiled, nil
+}
+
+// upsertUpdateColumns returns the columns an upsert overwrites on conflict.
+func upsertUpdateColumns(m store.Upsert) []string {
+	if len(m.UpdateColumns) > 0 {
+		return m.UpdateColumns
+	}
+	var columns []string
+	for _, col := range sortedKeys(m.Values) {
+		if !slices.Contains(m.ConflictColumns, col) {
+			columns = append(columns, col)
+		}
+	}
+	return columns
+}
+
+// CompileBulkInsert compiles a bulk insert into multi-row INSERT statements
+// of at most chunkSize rows each. Chunks are made smaller when needed to stay
+// within MaxParams; a chunkSize <= 0 is limited by MaxParams alone.
+func (c *SQLCompiler) CompileBulkInsert(tableName string, m store.BulkInsert, chunkSize int) ([]*store.CompiledMutation, error) {
+	if len(m.Rows) == 0 {
+		return nil, fmt.Errorf("bulk insert rows cannot be empty")
+	}
+	if len(m.Rows[0]) == 0 {
+		return nil, fmt.Errorf("insert values cannot be empty")
+	}
+
+	if limit := c.MaxParams() / len(m.Rows[0]); chunkSize <= 0 || chunkSize > limit {
+		chunkSize = max(limit, 1)
+	}
+
+	var statements []*store.CompiledMutation
+	for rows := range slices.Chunk(m.Rows, chunkSize) {
+		compiled, err := c.CompileMutation(tableName, store.BulkInsert{Rows: rows})
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, compiled)
+	}
+	return statements, nil
+}
+
+func (c *SQLCompiler) compileBulkInsert(tableName string, m store.BulkInsert) (*store.CompiledMutation, error) {
+	if len(m.Rows) == 0 {
+		return nil, fmt.Errorf("bulk insert rows cannot be empty")
+	}
+	columns := sortedKeys(m.Rows[0])
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("insert values cannot be empty")
+	}
+
+	var tuples []string
+	args := make([]any, 0, len(m.Rows)*len(columns))
+	for i, row := range m.Rows {
+		if len(row) != len(columns) {
+			return nil, fmt.Errorf("%w: bulk insert row %d sets %d columns, expected %d", store.ErrInvalidQuery, i, len(row), len(columns))
+		}
+		placeholders := make([]string, len(columns))
+		for j, col := range columns {
+			val, ok := row[col]
+			if !ok {
+				return nil, fmt.Errorf("%w: bulk insert row %d is missing column %s", store.ErrInvalidQuery, i, col)
+			}
+			args = append(args, val)
+			placeholders[j] = c.dialect.placeholder(len(args))
+		}
+		tuples = append(tuples, "("+strings.Join(placeholders, ", ")+")")
+	}
+
+	sql := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s",
+		tableName,
+		strings.Join(columns, ", "),
+		strings.Join(tuples, ", "))
+
+	return &store.CompiledMutation{
+		SQL:  sql,
+		Args: args,
+	}, nil
+}
+
+func (c *SQLCompiler) compileUpdate(tableName string, update store.Update) (*store.CompiledMutation, error) {
+	if len(update.Set) == 0 {
+		return nil, fmt.Errorf("update set values cannot be empty")
+	}
+
+	var setParts []string
+	var args []any
+	i := 1
+
+	// Build SET clause
+	for _, col := range sortedKeys(update.Set) {
+		if expr, ok := update.Set[col].(store.SetExpr); ok {
+			exprSQL, err := c.compileSetExpr(expr, i)
+			if err != nil {
+				return nil, err
+			}
+			setParts = append(setParts, fmt.Sprintf("%s = %s", col, exprSQL))
+			args = append(args, expr.Args...)
+			i += len(expr.Args)
+			continue
+		}
+		setParts = append(setParts, fmt.Sprintf("%s = %s", col, c.dialect.placeholder(i)))
+		args = append(args, update.Set[col])
+		i++
+	}
+
+	sql := fmt.Sprintf("UPDATE %s SET %s", tableName, strings.Join(setParts, ", "))
+
+	// Build WHERE clause if conditions exist
+	whereSQL, whereArgs, err := c.compileWhere(update.Where, update.Nodes, i)
+	if err != nil {
+		return nil, err
+	}
+	sql += c.compileLimitedWhere(tableName, whereSQL, update.OrderBy, update.Limit)
+	args = append(args, whereArgs...)
+
+	return &store.CompiledMutation{
+		SQL:  sql,
+		Args: args,
+	}, nil
+}
+
+// compileSetExpr replaces the ? markers of a SET expression with the
+// dialect's placeholders, numbered from startIndex, and ?? with a literal ?.
+// Only PostgreSQL placeholders are distinct from a literal ?, so ?? is
+// rejected with store.ErrNotSupported on other dialects. The expression has been checked to contain no quotes or comments, so every
+// other ? is a parameter. The expression's column, if any, is quoted and
+// written first.
+func (c *SQLCompiler) compileSetExpr(expr store.SetExpr, startIndex int) (string, error) {
+	var sb strings.Builder
+	if expr.Column != "" {
+		sb.WriteString(c.quote(expr.Column))
+		sb.WriteByte(' ')
+	}
+	i := startIndex
+	for j := 0; j < len(expr.SQL); j++ {
+		switch {
+		case strings.HasPrefix(expr.SQL[j:], "??"):
+			if c.dialect != DialectPostgres {
+				return "", fmt.Errorf("%w: ?? in set expression %q on %s", store.ErrNotSupported, expr.SQL, c.dialect)
+			}
+			sb.WriteByte('?')
+			j++
+		case expr.SQL[j] == '?':
+			sb.WriteString(c.dialect.placeholder(i))
+			i++
+		default:
+			sb.WriteByte(expr.SQL[j])
+		}
+	}
+	if n := i - startIndex; n != len(expr.Args) {
+		return "", fmt.Errorf("%w: set expression %q has %d placeholders but %d arguments", store.ErrInvalidQuery, expr.SQL, n, len(expr.Args))
+	}
+	return sb.String(), nil
+}
+
+// compileUpdateFrom compiles a correlated update. PostgreSQL and SQLite use
+// UPDATE ... SET ... FROM src WHERE ..., MySQL uses UPDATE t JOIN src ON ... SET ....
+func (c *SQLCompiler) compileUpdateFrom(tableName string, update store.UpdateFrom) (*store.CompiledMutation, error) {
+	if update.From == "" {
+		return nil, fmt.Errorf("update from source table cannot be empty")
+	}
+	if len(update.On) == 0 {
+		return nil, fmt.Errorf("update from join columns cannot be empty")
+	}
+	if len(update.SetFrom) == 0 && len(update.Set) == 0 {
+		return nil, fmt.Errorf("update set values cannot be empty")
+	}
+
+	// MySQL qualifies SET targets since both tables are in scope
+	target := func(col string) string {
+		if c.dialect == DialectMySQL {
+			return tableName + "." + col
+		}
+		return col
+	}
+
+	var setParts []string
+	var args []any
+	i := 1
+
+	for _, col := range sortedKeys(update.SetFrom) {
+		setParts = append(setParts, fmt.Sprintf("%s = %s.%s", target(col), update.From, update.SetFrom[col]))
+	}
+	for _, col := range sortedKeys(update.Set) {
+		setParts = append(setParts, fmt.Sprintf("%s = %s", target(col), c.dialect.placeholder(i)))
+		args = append(args, update.Set[col])
+		i++
+	}
+
+	var joinParts []string
+	for _, col := range sortedKeys(update.On) {
+		joinParts = append(joinParts, fmt.Sprintf("%s.%s = %s.%s", tableName, col, update.From, update.On[col]))
+	}
+	joinSQL := strings.Join(joinParts, " AND ")
+
+	var sql string
+	var where []string
+	if c.dialect == DialectMySQL {
+		sql = fmt.Sprintf("UPDATE %s JOIN %s ON %s SET %s",
+			tableName, update.From, joinSQL, strings.Join(setParts, ", "))
+	} else {
+		sql = fmt.Sprintf("UPDATE %s SET %s FROM %s",
+			tableName, strings.Join(setParts, ", "), update.From)
+		where = append(where, joinSQL)
+	}
+
+	if len(update.Where) > 0 {
+		whereSQL, whereArgs, err := c.compileConditions(update.Where, i)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, whereSQL)
+		args = append(args, whereArgs...)
+	}
+	if len(where) > 0 {
+		sql += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	return &store.CompiledMutation{
+		SQL:  sql,
+		Args: args,
+	}, nil
+}
+
+func (c *SQLCompiler) compileDelete(tableName string, delete store.Delete) (*store.CompiledMutation, error) {
+	sql := fmt.Sprintf("DELETE FROM %s", tableName)
+	var args []any
+
+	// Build WHERE clause if conditions exist
+	whereSQL, whereArgs, err := c.compileWhere(delete.Where, delete.Nodes, 1)
+	if err != nil {
+		return nil, err
+	}
+	sql += c.compileLimitedWhere(tableName, whereSQL, delete.OrderBy, delete.Limit)
+	args = append(args, whereArgs...)
+
+	return &store.CompiledMutation{
+		SQL:  sql,
+		Args: args,
+	}, nil
+}
+
+// compileLimitedWhere compiles the WHERE, ORDER BY and LIMIT clauses of an
+// update or delete. MySQL supports ORDER BY and LIMIT on both natively. On
+// PostgreSQL and SQLite a limit selects the affected rows by their physical
+// row identifier (ctid, rowid) in a subquery, and ORDER BY without a limit
+// has no effect, so it is dropped. SQLite tables created WITHOUT ROWID
+// cannot be limited.
+func (c *SQLCompiler) compileLimitedWhere(tableName, whereSQL string, orders []store.Order, limit int) string {
+	var sql string
+	if whereSQL != "" {
+		sql = " WHERE " + whereSQL
+	}
+
+	switch {
+	case c.dialect == DialectMySQL:
+		if len(orders) > 0 {
+			sql += " ORDER BY " + c.compileOrders(orders)
+		}
+		if limit > 0 {
+			sql += fmt.Sprintf(" LIMIT %d", limit)
+		}
+		return sql
+	case limit <= 0:
+		return sql
+	}
+
+	rowID := "ctid"
+	if c.dialect == DialectSQLite {
+		rowID = "rowid"
+	}
+	inner := fmt.Sprintf("SELECT %s FROM %s%s", rowID, tableName, sql)
+	if len(orders) > 0 {
+		inner += " ORDER BY " + c.compileOrders(orders)
+	}
+	inner += fmt.Sprintf(" LIMIT %d", limit)
+	return fmt.Sprintf(" WHERE %s IN (%s)", rowID, inner)
+}
+
+// compileUpdateCase compiles
+// UPDATE t SET col = CASE key WHEN .. THEN .. ELSE col END, ... WHERE key IN (..).
+// ArgRedactor maps the THEN arms to col, so keep this shape when changing it.
+func (c *SQLCompiler) compileUpdateCase(tableName string, m store.UpdateCase) (*store.CompiledMutation, error) {
+	if m.Key == "" {
+		return nil, fmt.Errorf("update case key column cannot be empty")
+	}
+	if len(m.Rows) == 0 {
+		return nil, fmt.Errorf("update case rows cannot be empty")
+	}
+
+	columns := make(map[string]bool)
+	for _, row := range m.Rows {
+		for col := range row.Set {
+			columns[col] = true
+		}
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("update set values cannot be empty")
+	}
+
+	var setParts []string
+	var args []any
+	i := 1
+
+	for _, col := range sortedKeys(columns) {
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%s = CASE %s", col, m.Key)
+		for _, row := range m.Rows {
+			val, ok := row.Set[col]
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(&sb, " WHEN %s THEN %s", c.dialect.placeholder(i), c.dialect.placeholder(i+1))
+			args = append(args, row.Key, val)
+			i += 2
+		}
+		fmt.Fprintf(&sb, " ELSE %s END", col)
+		setParts = append(setParts, sb.String())
+	}
+
+	keys := make([]string, len(m.Rows))
+	for j, row := range m.Rows {
+		keys[j] = c.dialect.placeholder(i)
+		args = append(args, row.Key)
+		i++
+	}
+
+	sql := fmt.Sprintf("UPDATE %s SET %s WHERE %s IN (%s)", tableName, strings.Join(setParts, ", "), m.Key, strings.Join(keys, ", "))
+	return &store.CompiledMutation{SQL: sql, Args: args}, nil
+}
+
+// compileInsertSelect compiles INSERT INTO t (cols) SELECT ... FROM src.
+// The SELECT is the first parameterized part, so its placeholders are used as is.
+func (c *SQLCompiler) compileInsertSelect(tableName string, m store.InsertSelect) (*store.CompiledMutation, error) {
+	if len(m.Columns) == 0 {
+		return nil, fmt.Errorf("insert select columns cannot be empty")
+	}
+	if len(m.Source.Columns) > 0 && len(m.Source.Columns) != len(m.Columns) {
+		return nil, fmt.Errorf("insert select has %d target columns but source selects %d", len(m.Columns), len(m.Source.Columns))
+	}
+
+	selectSQL, args, err := c.CompileQuery(queryBuilderFrom(m.Source))
+	if err != nil {
+		return nil, err
+	}
+
+	sql := fmt.Sprintf("INSERT INTO %s (%s) %s", tableName, strings.Join(m.Columns, ", "), selectSQL)
+
+	return &store.CompiledMutation{
+		SQL:  sql,
+		Args: args,
+	}, nil
+}
+
+// mutationConditions returns the WHERE conditions carried by a mutation.
+func mutationConditions(mutation store.Mutation) []store.Condition {
+	switch m := mutation.(type) {
+	case store.Update:
+		return append(slices.Clip(m.Where), nodesConditions(m.Nodes)...)
+	case store.Delete:
+		return append(slices.Clip(m.Where), nodesConditions(m.Nodes)...)
+	case store.UpdateFrom:
+		return m.Where
+	case store.InsertSelect:
+		return m.Source.Where
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,124 @@
+package sqlstore
+
+import (
+	"fmt"
+	"strings"
+
+	"store"
+)
+
+// BindNamed rewrites the named parameters of query, written as :name, to the
+// dialect's placeholders and returns the arguments in placeholder order.
+// Parameters inside string literals, quoted identifiers, comments and
+// PostgreSQL dollar-quoted bodies are left alone, as are PostgreSQL casts
+// (::type). A name used several times binds
+// one argument on numbered dialects and one per use on MySQL. Every named
+// parameter must be present in params.
+func (d Dialect) BindNamed(query string, params map[string]any) (string, []any, error) {
+	var sb strings.Builder
+	var args []any
+	index := make(map[string]int)
+
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case ch == '\'' || ch == '"' || ch == '`':
+			end := quotedEnd(query, i, ch, d == DialectMySQL && ch != '`')
+			sb.WriteString(query[i:end])
+			i = end - 1
+		case ch == '$' && d == DialectPostgres && dollarTag(query[i:]) != "":
+			tag := dollarTag(query[i:])
+			end := strings.Index(query[i+len(tag):], tag)
+			if end < 0 {
+				end = len(query) - i
+			} else {
+				end += 2 * len(tag)
+			}
+			sb.WriteString(query[i : i+end])
+			i += end - 1
+		case ch == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			sb.WriteString(query[i : i+end])
+			i += end - 1
+		case ch == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query) - i
+			} else {
+				end += 4
+			}
+			sb.WriteString(query[i : i+end])
+			i += end - 1
+		case ch == ':' && strings.HasPrefix(query[i:], "::"):
+			sb.WriteString("::")
+			i++
+		case ch == ':' && i+1 < len(query) && isNameStart(query[i+1]):
+			j := i + 1
+			for j < len(query) && isNamePart(query[j]) {
+				j++
+			}
+			name := query[i+1 : j]
+			value, ok := params[name]
+			if !ok {
+				return "", nil, fmt.Errorf("%w: missing named parameter %q", store.ErrInvalidQuery, name)
+			}
+			if n, seen := index[name]; seen && d != DialectMySQL {
+				sb.WriteString(d.placeholder(n))
+			} else {
+				args = append(args, value)
+				index[name] = len(args)
+				sb.WriteString(d.placeholder(len(args)))
+			}
+			i = j - 1
+		default:
+			sb.WriteByte(ch)
+		}
+	}
+	return sb.String(), args, nil
+}
+
+// quotedEnd returns the index just past the quoted section starting at
+// start. A doubled quote character escapes itself, as does a backslash
+// before any character when backslash is set (MySQL string literals).
+func quotedEnd(query string, start int, quote byte, backslash bool) int {
+	for i := start + 1; i < len(query); i++ {
+		if backslash && query[i] == '\\' {
+			i++
+			continue
+		}
+		if query[i] != quote {
+			continue
+		}
+		if i+1 < len(query) && query[i+1] == quote {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return len(query)
+}
+
+// dollarTag returns the PostgreSQL dollar-quote delimiter, $$ or $tag$,
+// that s starts with, or "" if it does not start with one. Positional
+// parameters such as $1 are not delimiters.
+func dollarTag(s string) string {
+	j := 1
+	for j < len(s) && (j == 1 && isNameStart(s[j]) || j > 1 && isNamePart(s[j])) {
+		j++
+	}
+	if j < len(s) && s[j] == '$' {
+		return s[:j+1]
+	}
+	return ""
+}
+
+func isNameStart(ch byte) bool {
+	return ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z'
+}
+
+func isNamePart(ch byte) bool {
+	return isNameStart(ch) || ch >= '0' && ch <= '9'
+}
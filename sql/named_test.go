@@ -0,0 +1,118 @@
+package sqlstore_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"store"
+	sqlstore "store/sql"
+)
+
+func TestBindNamedPerDialect(t *testing.T) {
+	query := `SELECT id::text, ':skipped' FROM orders -- :comment
+		WHERE status = :status AND (amount > :min OR customer = :status) /* :block */`
+	params := map[string]any{"status": "paid", "min": 5, "unused": true}
+
+	tests := []struct {
+		dialect  sqlstore.Dialect
+		wantSQL  string
+		wantArgs []any
+	}{
+		{
+			sqlstore.DialectPostgres,
+			`SELECT id::text, ':skipped' FROM orders -- :comment
+		WHERE status = $1 AND (amount > $2 OR customer = $1) /* :block */`,
+			[]any{"paid", 5},
+		},
+		{
+			sqlstore.DialectMySQL,
+			`SELECT id::text, ':skipped' FROM orders -- :comment
+		WHERE status = ? AND (amount > ? OR customer = ?) /* :block */`,
+			[]any{"paid", 5, "paid"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *testing.T) {
+			got, args, err := tt.dialect.BindNamed(query, params)
+			if err != nil {
+				t.Fatalf("bind failed: %v", err)
+			}
+			if got != tt.wantSQL {
+				t.Errorf("unexpected SQL:\n got: %s\nwant: %s", got, tt.wantSQL)
+			}
+			if fmt.Sprint(args) != fmt.Sprint(tt.wantArgs) {
+				t.Errorf("expected args %v, got %v", tt.wantArgs, args)
+			}
+		})
+	}
+
+	if _, _, err := sqlstore.DialectPostgres.BindNamed("SELECT * FROM orders WHERE id = :id", nil); !errors.Is(err, store.ErrInvalidQuery) {
+		t.Errorf("expected a missing parameter to fail with ErrInvalidQuery, got %v", err)
+	}
+}
+
+func TestBindNamedSkipsDialectQuoting(t *testing.T) {
+	params := map[string]any{"id": 7}
+
+	// Dollar-quoted bodies are opaque on PostgreSQL; $1 is not a delimiter
+	query := `DO $$ BEGIN PERFORM :inner; END $$; SELECT $body$ :x $body$, :id`
+	got, args, err := sqlstore.DialectPostgres.BindNamed(query, params)
+	if err != nil {
+		t.Fatalf("bind failed: %v", err)
+	}
+	if want := `DO $$ BEGIN PERFORM :inner; END $$; SELECT $body$ :x $body$, $1`; got != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", got, want)
+	}
+	if len(args) != 1 || args[0] != 7 {
+		t.Errorf("unexpected args: %v", args)
+	}
+
+	// MySQL string literals escape quotes with a backslash
+	query = `SELECT 'it\'s :skipped' FROM t WHERE id = :id`
+	got, _, err = sqlstore.DialectMySQL.BindNamed(query, params)
+	if err != nil {
+		t.Fatalf("bind failed: %v", err)
+	}
+	if want := `SELECT 'it\'s :skipped' FROM t WHERE id = ?`; got != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestRawQueryAndExec(t *testing.T) {
+	svc, repo := openTestService(t)
+	ctx := context.Background()
+	table := repo.TableName()
+
+	for _, name := range []string{"alpha", "beta", "gamma"} {
+		if _, err := svc.RawExec(ctx, "INSERT INTO "+table+" (id, name) VALUES (:id, :name)", map[string]any{"id": name[:1], "name": name}); err != nil {
+			t.Fatalf("raw exec: %v", err)
+		}
+	}
+
+	res, err := svc.RawExec(ctx, "UPDATE "+table+" SET name = :name || '!' WHERE id = :id OR name = :name", map[string]any{"id": "a", "name": "gamma"})
+	if err != nil {
+		t.Fatalf("raw exec update: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n != 2 {
+		t.Errorf("expected 2 updated rows, got %d", n)
+	}
+
+	rows, err := svc.RawQuery(ctx, "SELECT name FROM "+table+" WHERE name LIKE :suffix ORDER BY name", map[string]any{"suffix": "%!"})
+	if err != nil {
+		t.Fatalf("raw query: %v", err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		names = append(names, name)
+	}
+	if fmt.Sprint(names) != "[gamma! gamma!]" {
+		t.Errorf("unexpected rows: %v", names)
+	}
+}
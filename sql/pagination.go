@@ -0,0 +1,205 @@
+package sqlstore
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"core/entity"
+	"store"
+)
+
+// listPaginator bounds List pages by the CursorParams limit of 1000 rather
+// than the default maximum page size.
+var listPaginator = store.NewPaginatorWithConfig(func() store.PaginationConfig {
+	cfg := store.DefaultPaginationConfig()
+	cfg.MaxPageSize = 1000
+	return cfg
+}())
+
+// SQLPaginator pages through a table with keyset pagination: rows are
+// ordered by a list of columns ending with the ID, and each page continues
+// after the position of the last row of the previous page, as carried by its
+// cursor. Unlike OFFSET, pages stay stable while rows are inserted and the
+// database never rescans the rows skipped; the ID breaks ties between rows
+// equal on the other columns. Backward pages read the rows before the cursor
+// in reverse order and return them in the forward order.
+type SQLPaginator struct {
+	paginator *store.Paginator
+	orders    []store.Order
+
+	// legacy accepts version 1 cursors, whose (timestamp, id) position
+	// matches an ordering by a time column, then the ID
+	legacy bool
+}
+
+// NewSQLPaginator creates a paginator ordering rows by timeColumn, then
+// idColumn. A nil paginator bounds page sizes as Repository.List does.
+func NewSQLPaginator(paginator *store.Paginator, timeColumn, idColumn string) *SQLPaginator {
+	p := NewSQLPaginatorWithOrder(paginator, []store.Order{{Field: timeColumn}}, idColumn)
+	p.legacy = true
+	return p
+}
+
+// NewSQLPaginatorWithOrder creates a paginator ordering rows by orders, then
+// idColumn, which makes the ordering total. Its cursors carry the value of
+// every ordering column. A nil paginator bounds page sizes as
+// Repository.List does.
+func NewSQLPaginatorWithOrder(paginator *store.Paginator, orders []store.Order, idColumn string) *SQLPaginator {
+	if paginator == nil {
+		paginator = listPaginator
+	}
+	orders = slices.DeleteFunc(slices.Clone(orders), func(o store.Order) bool {
+		return strings.EqualFold(o.Field, idColumn)
+	})
+	return &SQLPaginator{paginator: paginator, orders: append(orders, store.Order{Field: idColumn})}
+}
+
+// Apply adds the ordering, limit and keyset condition of the page described
+// by params to qb. It fetches one row more than the page size, which Page
+// uses to tell whether more pages follow, and returns params with the page
+// size bounded and Backward set when params or the cursor page backwards. A
+// malformed or expired cursor, or one made for another ordering, is a
+// validation error.
+func (p *SQLPaginator) Apply(qb *QueryBuilder, params store.CursorParams) (*QueryBuilder, store.CursorParams, error) {
+	backward := params.Backward
+	params = p.paginator.ParseParams(params.PageSize, params.Cursor)
+
+	cursor, err := p.paginator.DecodeCursor(params.Cursor)
+	if err != nil {
+		return nil, params, store.WrapValidationError(err, "cursor", params.Cursor)
+	}
+	params.Backward = backward || (cursor != nil && cursor.Backward)
+
+	// Backward pages walk the ordering in reverse
+	orders := slices.Clone(p.orders)
+	for i := range orders {
+		orders[i].Desc = orders[i].Desc != params.Backward
+	}
+
+	if cursor != nil {
+		values, err := p.position(cursor)
+		if err != nil {
+			return nil, params, store.WrapValidationError(err, "cursor", params.Cursor)
+		}
+		qb = qb.WhereNode(keysetAfter(orders, values))
+	}
+	for _, o := range orders {
+		direction := "ASC"
+		if o.Desc {
+			direction = "DESC"
+		}
+		qb = qb.OrderBy(o.Field, direction)
+	}
+	return qb.Limit(int(params.PageSize) + 1), params, nil
+}
+
+// position returns the values of the ordering columns at cursor, checking
+// that the cursor was made for the ordering of p.
+func (p *SQLPaginator) position(cursor *store.Cursor) ([]any, error) {
+	if len(cursor.Keys) == 0 {
+		if !p.legacy {
+			return nil, fmt.Errorf("cursor does not match the ordering")
+		}
+		return []any{cursor.LastTimestamp, cursor.LastID}, nil
+	}
+
+	if len(cursor.Keys) != len(p.orders) {
+		return nil, fmt.Errorf("cursor does not match the ordering")
+	}
+	values := make([]any, len(cursor.Keys))
+	for i, key := range cursor.Keys {
+		if !strings.EqualFold(key.Field, p.orders[i].Field) || key.Desc != p.orders[i].Desc {
+			return nil, fmt.Errorf("cursor does not match the ordering at %s", key.Field)
+		}
+		if isNull(key.Value) {
+			return nil, fmt.Errorf("cursor position at %s is NULL", key.Field)
+		}
+		values[i] = key.Value
+	}
+	return values, nil
+}
+
+// Page builds the result of a page from the rows fetched with the query
+// prepared by Apply. It fails when an item lacks an ordering column or
+// holds a value a cursor cannot carry.
+func (p *SQLPaginator) Page(items []entity.Entity, params store.CursorParams) (store.CursorResult[entity.Entity], error) {
+	hasMore := len(items) > int(params.PageSize)
+	if hasMore {
+		items = items[:params.PageSize]
+	}
+	if items == nil {
+		items = []entity.Entity{}
+	}
+	if params.Backward {
+		slices.Reverse(items)
+	}
+	if p.legacy {
+		return store.BuildCursorPage(p.paginator, items, params, hasMore, -1)
+	}
+	return store.BuildKeyedCursorPage(p.paginator, items, params, hasMore, -1, p.keys)
+}
+
+// keys returns the cursor position of ent in the ordering of p.
+func (p *SQLPaginator) keys(ent entity.Entity) ([]store.CursorKey, error) {
+	return orderKeys(ent, p.orders)
+}
+
+// orderKeys returns the position of ent in orders: the value of each
+// ordering column, matched to entity fields regardless of case. NULL values
+// are rejected, since keysetAfter cannot compare with them.
+func orderKeys(ent entity.Entity, orders []store.Order) ([]store.CursorKey, error) {
+	fields := make(map[string]any)
+	for name, value := range entity.ToMap(ent) {
+		fields[strings.ToLower(name)] = value
+	}
+
+	keys := make([]store.CursorKey, len(orders))
+	for i, o := range orders {
+		value, ok := fields[strings.ToLower(o.Field)]
+		if !ok {
+			return nil, fmt.Errorf("entity has no field for ordering column %s", o.Field)
+		}
+		if isNull(value) {
+			return nil, fmt.Errorf("%w: keyset pagination over NULL in ordering column %s", store.ErrNotSupported, o.Field)
+		}
+		keys[i] = store.CursorKey{Field: o.Field, Value: value, Desc: o.Desc}
+	}
+	return keys, nil
+}
+
+// keysetAfter matches the rows that come after values in the given order:
+// (a, b) > (x, y) expands to a > x OR (a = x AND b > y), which every
+// dialect can serve from an index on the ordering columns. values must not
+// be NULL: a = NULL and a > NULL match no row.
+func keysetAfter(orders []store.Order, values []any) store.Node {
+	branches := make([]store.Node, 0, len(orders))
+	for i, o := range orders {
+		terms := make([]store.Node, 0, i+1)
+		for j := range i {
+			terms = append(terms, store.Eq(orders[j].Field, values[j]))
+		}
+		if o.Desc {
+			terms = append(terms, store.Lt(o.Field, values[i]))
+		} else {
+			terms = append(terms, store.Gt(o.Field, values[i]))
+		}
+		branches = append(branches, store.And(terms...))
+	}
+	return store.Or(branches...)
+}
+
+// isNull reports whether v binds as SQL NULL.
+func isNull(v any) bool {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() || rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return true
+	}
+	if valuer, ok := v.(driver.Valuer); ok {
+		value, err := valuer.Value()
+		return err == nil && value == nil
+	}
+	return false
+}
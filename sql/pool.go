@@ -0,0 +1,188 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"store"
+)
+
+// PoolStats is a snapshot of one connection pool.
+type PoolStats struct {
+	Role string // "primary" or "replica"
+
+	MaxOpen int // maximum open connections, 0 when unlimited
+	Open    int // open connections, in use and idle
+	InUse   int
+	Idle    int
+
+	WaitCount    int64         // connections waited for
+	WaitDuration time.Duration // total time spent waiting for connections
+
+	MaxIdleClosed     int64 // connections closed by SetMaxIdleConns
+	MaxIdleTimeClosed int64 // connections closed by SetConnMaxIdleTime
+	MaxLifetimeClosed int64 // connections closed by SetConnMaxLifetime
+}
+
+func poolStats(role string, db *sql.DB) PoolStats {
+	st := db.Stats()
+	return PoolStats{
+		Role:              role,
+		MaxOpen:           st.MaxOpenConnections,
+		Open:              st.OpenConnections,
+		InUse:             st.InUse,
+		Idle:              st.Idle,
+		WaitCount:         st.WaitCount,
+		WaitDuration:      st.WaitDuration,
+		MaxIdleClosed:     st.MaxIdleClosed,
+		MaxIdleTimeClosed: st.MaxIdleTimeClosed,
+		MaxLifetimeClosed: st.MaxLifetimeClosed,
+	}
+}
+
+// PoolStats returns the statistics of the primary pool followed by those of
+// the read replicas.
+func (s *Service) PoolStats() []PoolStats {
+	if s.db == nil {
+		return nil
+	}
+	stats := []PoolStats{poolStats("primary", s.db)}
+	for _, db := range s.replicas.dbs() {
+		stats = append(stats, poolStats("replica", db))
+	}
+	return stats
+}
+
+// ConnectionLeak describes rows left open longer than the leak threshold,
+// holding their connection.
+type ConnectionLeak struct {
+	SQL   string
+	Held  time.Duration
+	Stack []byte // stack of the goroutine that ran the query
+}
+
+// leakDetector reports rows that are still open after a threshold.
+type leakDetector struct {
+	threshold time.Duration
+	report    func(ConnectionLeak)
+	leaks     atomic.Int64
+}
+
+// watch checks rows once the threshold has passed. Capturing the stack makes
+// each query slower, so leak detection is meant for debugging.
+func (d *leakDetector) watch(query string, rows *sql.Rows) {
+	if d == nil || rows == nil {
+		return
+	}
+	stack := debug.Stack()
+	start := time.Now()
+	time.AfterFunc(d.threshold, func() {
+		// Columns fails once the rows are closed, including after Next
+		// returned false or the context was canceled
+		if _, err := rows.Columns(); err != nil {
+			return
+		}
+		d.leaks.Add(1)
+		d.report(ConnectionLeak{SQL: query, Held: time.Since(start), Stack: stack})
+	})
+}
+
+// logLeak is the default leak report, written to the service logger.
+func (s *Service) logLeak(leak ConnectionLeak) {
+	s.logger.Log(context.Background(), slog.LevelWarn, "rows left open, holding a connection",
+		"sql", leak.SQL, "held", leak.Held, "stack", string(leak.Stack))
+}
+
+// SetLeakDetection reports the rows returned by QueryExecutor.Query and
+// RawQuery that are still open after threshold, with the stack of the caller
+// that ran the query. A nil report writes the leak to the service logger; a
+// threshold <= 0 disables
+// detection.
+func (s *Service) SetLeakDetection(threshold time.Duration, report func(ConnectionLeak)) {
+	if threshold <= 0 {
+		s.leaks = nil
+		return
+	}
+	if report == nil {
+		report = s.logLeak
+	}
+	s.leaks = &leakDetector{threshold: threshold, report: report}
+}
+
+// ConnectionLeaks returns the number of leaks detected so far.
+func (s *Service) ConnectionLeaks() int64 {
+	if s.leaks == nil {
+		return 0
+	}
+	return s.leaks.leaks.Load()
+}
+
+// poolGauges are the PoolStats fields exported as gauges.
+var poolGauges = map[string]func(PoolStats) float64{
+	"pool_max_open_connections": func(st PoolStats) float64 { return float64(st.MaxOpen) },
+	"pool_open_connections":     func(st PoolStats) float64 { return float64(st.Open) },
+	"pool_in_use_connections":   func(st PoolStats) float64 { return float64(st.InUse) },
+	"pool_idle_connections":     func(st PoolStats) float64 { return float64(st.Idle) },
+	"pool_wait_count":           func(st PoolStats) float64 { return float64(st.WaitCount) },
+	"pool_wait_seconds":         func(st PoolStats) float64 { return st.WaitDuration.Seconds() },
+}
+
+// registerPoolGauges registers the gauges of the primary and replica pools
+// with the metrics recorder, replacing those registered before.
+func (s *Service) registerPoolGauges() {
+	s.unregisterPoolGauges()
+	if s.metrics == nil || s.db == nil {
+		return
+	}
+
+	pools := map[string]*sql.DB{"primary": s.db}
+	for i, db := range s.replicas.dbs() {
+		pools["replica-"+strconv.Itoa(i)] = db
+	}
+	for pool, db := range pools {
+		labels := map[string]string{"backend": string(s.adapter.Name()), "database": s.config.Database, "pool": pool}
+		for name, value := range poolGauges {
+			s.unregister = append(s.unregister, s.metrics.RegisterGauge(name, labels, func() float64 {
+				return value(poolStats(pool, db))
+			}))
+		}
+	}
+}
+
+func (s *Service) unregisterPoolGauges() {
+	for _, unregister := range s.unregister {
+		unregister()
+	}
+	s.unregister = nil
+}
+
+// SetMetrics sets the recorder receiving the statement counts, latencies
+// and pool gauges of the service, replacing store.DefaultMetrics selected
+// by Config.EnableMetrics. A nil recorder disables metrics.
+func (s *Service) SetMetrics(recorder store.MetricsRecorder) {
+	s.unregisterPoolGauges()
+	s.metrics = recorder
+	s.registerPoolGauges()
+}
+
+// statementVerb returns the lowercased first keyword of query, used as the
+// operation of statement metrics.
+func statementVerb(query string) string {
+	verb := strings.TrimSpace(query)
+	if i := strings.IndexFunc(verb, unicode.IsSpace); i >= 0 {
+		verb = verb[:i]
+	}
+	verb = strings.ToLower(verb)
+	switch verb {
+	case "select", "insert", "update", "delete", "with", "create", "alter", "drop":
+		return verb
+	}
+	return "other"
+}
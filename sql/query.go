@@ -0,0 +1,119 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+
+	"store"
+)
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// QueryExecutor executes query builders against a SQL database.
+// Queries run inside the transaction stored in the context when present.
+type QueryExecutor struct {
+	db          *sql.DB
+	compiler    *SQLCompiler
+	logQuery    queryLogFunc
+	beforeQuery queryRewriteFunc
+	stmts       *stmtCache
+	replicas    *replicaSet
+	leaks       *leakDetector
+}
+
+// NewQueryExecutor creates a new SQL query executor.
+func NewQueryExecutor(db *sql.DB, compiler *SQLCompiler) *QueryExecutor {
+	if compiler == nil {
+		compiler = defaultCompiler
+	}
+	return &QueryExecutor{db: db, compiler: compiler}
+}
+
+// conn returns the transaction from context, the read replicas or the
+// database handle.
+func (qe *QueryExecutor) conn(ctx context.Context) queryer {
+	if tx, ok := TransactionFromContext(ctx); ok && tx != nil {
+		return withQueryLog(withStmtCache(tx, qe.stmts, tx), qe.beforeQuery, qe.logQuery)
+	}
+	if qe.replicas.len() > 0 {
+		return withQueryLog(qe.replicas, qe.beforeQuery, qe.logQuery)
+	}
+	return qe.primary()
+}
+
+// queryConn returns conn(ctx) unless qb locks rows, which only the primary can do.
+func (qe *QueryExecutor) queryConn(ctx context.Context, qb *QueryBuilder) queryer {
+	if _, ok := TransactionFromContext(ctx); !ok && qb.lock != "" {
+		return qe.primary()
+	}
+	return qe.conn(ctx)
+}
+
+func (qe *QueryExecutor) primary() queryer {
+	return withQueryLog(withStmtCache(qe.db, qe.stmts, nil), qe.beforeQuery, qe.logQuery)
+}
+
+// Query executes the query and returns the resulting rows.
+func (qe *QueryExecutor) Query(ctx context.Context, qb *QueryBuilder) (*sql.Rows, error) {
+	query, args, err := qe.compiler.CompileQuery(qb)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := qe.queryConn(ctx, qb).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, store.WrapQueryError(err, "query", qb.table, query, args)
+	}
+	qe.leaks.watch(query, rows)
+	return rows, nil
+}
+
+// QueryRow executes a query expected to return at most one row.
+func (qe *QueryExecutor) QueryRow(ctx context.Context, qb *QueryBuilder) (*sql.Row, error) {
+	query, args, err := qe.compiler.CompileQuery(qb)
+	if err != nil {
+		return nil, err
+	}
+	return qe.queryConn(ctx, qb).QueryRowContext(ctx, query, args...), nil
+}
+
+// Count returns the number of rows in the query table matching its WHERE
+// conditions. Selected columns, grouping and limits are ignored; use
+// CountSubquery for DISTINCT or GROUP BY queries.
+func (qe *QueryExecutor) Count(ctx context.Context, qb *QueryBuilder) (int64, error) {
+	countQB := NewQueryBuilder(qb.table).Select("COUNT(*)").WhereCondition(qb.conditions...).WhereNode(qb.nodes...)
+	countQB.fromSub = qb.fromSub
+	countQB.ctes = qb.ctes
+	countQB.joins = qb.joins
+	countQB.err = qb.err
+
+	query, args, err := qe.compiler.CompileQuery(countQB)
+	if err != nil {
+		return 0, err
+	}
+	return qe.scanCount(ctx, "count", qb.table, query, args)
+}
+
+// CountSubquery returns the number of rows produced by the full query by
+// wrapping it as SELECT COUNT(*) FROM (<query>) sub. This gives correct counts
+// for grouped and distinct queries.
+func (qe *QueryExecutor) CountSubquery(ctx context.Context, qb *QueryBuilder) (int64, error) {
+	inner, args, err := qe.compiler.CompileQuery(qb)
+	if err != nil {
+		return 0, err
+	}
+	return qe.scanCount(ctx, "count_subquery", qb.table, "SELECT COUNT(*) FROM ("+inner+") sub", args)
+}
+
+// scanCount executes a single-value COUNT query.
+func (qe *QueryExecutor) scanCount(ctx context.Context, operation, table, query string, args []any) (int64, error) {
+	var count int64
+	if err := qe.conn(ctx).QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		return 0, store.WrapQueryError(err, operation, table, query, args)
+	}
+	return count, nil
+}
@@ -0,0 +1,335 @@
+package sqlstore
+
+import (
+	"fmt"
+	"strings"
+
+	"store"
+)
+
+// QueryBuilder builds SELECT statements fluently.
+// Conditions are kept as store.Condition values and compiled by a SQLCompiler,
+// so placeholders follow the compiler's dialect.
+type QueryBuilder struct {
+	table      string
+	fromSub    *QueryBuilder
+	ctes       []cte
+	joins      []join
+	distinct   bool
+	distinctOn []string
+	columns    []string
+	conditions []store.Condition
+	nodes      []store.Node
+	groupBy    []string
+	having     []store.Condition
+	orders     []store.Order
+	random     bool
+	lock       string
+	limit      int
+	offset     int
+	err        error
+}
+
+// cte is a common table expression. Recursive ones union the anchor query
+// with the recursive step.
+type cte struct {
+	name      string
+	columns   []string
+	query     *QueryBuilder
+	recursive *QueryBuilder
+}
+
+// join is an inner join on column equality.
+type join struct {
+	table       string
+	left, right string
+}
+
+// NewQueryBuilder creates a query builder selecting from the given table.
+func NewQueryBuilder(table string) *QueryBuilder {
+	return &QueryBuilder{table: table}
+}
+
+// NewSubqueryBuilder creates a query builder selecting from the rows of sub,
+// as in SELECT ... FROM (<sub>) alias.
+func NewSubqueryBuilder(sub *QueryBuilder, alias string) *QueryBuilder {
+	return &QueryBuilder{table: alias, fromSub: sub}
+}
+
+// queryBuilderFrom creates a query builder from a store.Query.
+func queryBuilderFrom(q store.Query) *QueryBuilder {
+	var fromSub *QueryBuilder
+	if q.FromQuery != nil {
+		fromSub = queryBuilderFrom(*q.FromQuery)
+	}
+	return &QueryBuilder{
+		table:      q.From,
+		fromSub:    fromSub,
+		columns:    q.Columns,
+		conditions: q.Where,
+		orders:     q.OrderBy,
+		limit:      q.Limit,
+		offset:     q.Offset,
+	}
+}
+
+// sqlOperators maps SQL comparison operators accepted by Where to store operators.
+var sqlOperators = map[string]store.Operator{
+	"=":           store.OpEq,
+	"!=":          store.OpNe,
+	"<>":          store.OpNe,
+	">":           store.OpGt,
+	">=":          store.OpGe,
+	"<":           store.OpLt,
+	"<=":          store.OpLe,
+	"IN":          store.OpIn,
+	"NOT IN":      store.OpNotIn,
+	"IS NULL":     store.OpIsNull,
+	"IS NOT NULL": store.OpNotNull,
+}
+
+// Select sets the selected columns. Defaults to * when not called.
+func (qb *QueryBuilder) Select(columns ...string) *QueryBuilder {
+	qb.columns = append(qb.columns, columns...)
+	return qb
+}
+
+// Distinct removes duplicate rows from the result (SELECT DISTINCT). Count
+// ignores it; use CountSubquery to count distinct rows.
+func (qb *QueryBuilder) Distinct() *QueryBuilder {
+	qb.distinct = true
+	return qb
+}
+
+// DistinctOn keeps the first row of each distinct combination of columns
+// (SELECT DISTINCT ON), as picked by the leading ORDER BY terms, which must
+// match columns. It is only supported on PostgreSQL.
+func (qb *QueryBuilder) DistinctOn(columns ...string) *QueryBuilder {
+	qb.distinctOn = append(qb.distinctOn, columns...)
+	return qb
+}
+
+// Where adds a condition using a SQL operator ("=", ">=", "IN", ...).
+// For IN / NOT IN the value must be a []any.
+// Unknown operators are reported by Build.
+func (qb *QueryBuilder) Where(field, op string, value any) *QueryBuilder {
+	cond, err := parseCondition(field, op, value)
+	if err != nil {
+		if qb.err == nil {
+			qb.err = err
+		}
+		return qb
+	}
+	qb.conditions = append(qb.conditions, cond)
+	return qb
+}
+
+// parseCondition builds a condition from a SQL operator accepted by Where.
+func parseCondition(field, op string, value any) (store.Condition, error) {
+	storeOp, ok := sqlOperators[strings.ToUpper(strings.TrimSpace(op))]
+	if !ok {
+		return store.Condition{}, fmt.Errorf("%w: unsupported operator %q", store.ErrInvalidQuery, op)
+	}
+	return store.Condition{Field: field, Op: storeOp, Value: value}, nil
+}
+
+// WhereCondition adds store conditions (all ANDed together).
+func (qb *QueryBuilder) WhereCondition(conditions ...store.Condition) *QueryBuilder {
+	qb.conditions = append(qb.conditions, conditions...)
+	return qb
+}
+
+// WhereNode adds filter trees built with store.And, store.Or and store.Not.
+// They are ANDed with each other and with the plain conditions.
+func (qb *QueryBuilder) WhereNode(nodes ...store.Node) *QueryBuilder {
+	qb.nodes = append(qb.nodes, nodes...)
+	return qb
+}
+
+// WhereIn adds field IN (values...), one placeholder per value. An empty
+// list matches no rows.
+func (qb *QueryBuilder) WhereIn(field string, values ...any) *QueryBuilder {
+	return qb.WhereCondition(store.In(field, values...))
+}
+
+// WhereNotIn adds field NOT IN (values...). An empty list matches every row.
+func (qb *QueryBuilder) WhereNotIn(field string, values ...any) *QueryBuilder {
+	return qb.WhereCondition(store.NotIn(field, values...))
+}
+
+// WhereBetween adds field BETWEEN from AND to, bounds included.
+func (qb *QueryBuilder) WhereBetween(field string, from, to any) *QueryBuilder {
+	return qb.WhereCondition(store.Between(field, from, to))
+}
+
+// WhereInSubquery adds field IN (<sub>). The subquery's placeholders are
+// renumbered to follow the outer query's.
+func (qb *QueryBuilder) WhereInSubquery(field string, sub *QueryBuilder) *QueryBuilder {
+	return qb.WhereCondition(store.Condition{Field: field, Op: store.OpIn, Value: sub})
+}
+
+// WhereNotInSubquery adds field NOT IN (<sub>).
+func (qb *QueryBuilder) WhereNotInSubquery(field string, sub *QueryBuilder) *QueryBuilder {
+	return qb.WhereCondition(store.Condition{Field: field, Op: store.OpNotIn, Value: sub})
+}
+
+// With adds a common table expression, WITH name AS (<sub>), which the
+// query can select from or join by name.
+func (qb *QueryBuilder) With(name string, sub *QueryBuilder) *QueryBuilder {
+	qb.ctes = append(qb.ctes, cte{name: name, query: sub})
+	return qb
+}
+
+// WithRecursive adds a recursive common table expression,
+// WITH RECURSIVE name (columns) AS (<anchor> UNION ALL <step>), where step
+// joins name to produce the next level of rows. PostgreSQL, SQLite and
+// MySQL 8.0 or later support recursive expressions.
+func (qb *QueryBuilder) WithRecursive(name string, columns []string, anchor, step *QueryBuilder) *QueryBuilder {
+	qb.ctes = append(qb.ctes, cte{name: name, columns: columns, query: anchor, recursive: step})
+	return qb
+}
+
+// Join adds an inner join, JOIN table ON left = right. Columns may be
+// qualified with their table names.
+func (qb *QueryBuilder) Join(table, left, right string) *QueryBuilder {
+	qb.joins = append(qb.joins, join{table: table, left: left, right: right})
+	return qb
+}
+
+// WhereOr adds a parenthesized group matching when any of nodes matches,
+// ANDed with the other conditions. Nodes may themselves be store.And groups.
+func (qb *QueryBuilder) WhereOr(nodes ...store.Node) *QueryBuilder {
+	return qb.WhereNode(store.Or(nodes...))
+}
+
+// GroupBy adds GROUP BY columns.
+func (qb *QueryBuilder) GroupBy(columns ...string) *QueryBuilder {
+	qb.groupBy = append(qb.groupBy, columns...)
+	return qb
+}
+
+// Having adds conditions on groups, ANDed together. Fields may be aggregate
+// expressions such as "SUM(amount)" or aliases of selected aggregates.
+func (qb *QueryBuilder) Having(conditions ...store.Condition) *QueryBuilder {
+	qb.having = append(qb.having, conditions...)
+	return qb
+}
+
+// SelectCount selects COUNT(column), named alias when alias is not empty.
+// Use "*" to count rows.
+func (qb *QueryBuilder) SelectCount(column, alias string) *QueryBuilder {
+	return qb.selectAggregate("COUNT", column, alias)
+}
+
+// SelectSum selects SUM(column), named alias when alias is not empty.
+func (qb *QueryBuilder) SelectSum(column, alias string) *QueryBuilder {
+	return qb.selectAggregate("SUM", column, alias)
+}
+
+// SelectAvg selects AVG(column), named alias when alias is not empty.
+func (qb *QueryBuilder) SelectAvg(column, alias string) *QueryBuilder {
+	return qb.selectAggregate("AVG", column, alias)
+}
+
+// SelectMin selects MIN(column), named alias when alias is not empty.
+func (qb *QueryBuilder) SelectMin(column, alias string) *QueryBuilder {
+	return qb.selectAggregate("MIN", column, alias)
+}
+
+// SelectMax selects MAX(column), named alias when alias is not empty.
+func (qb *QueryBuilder) SelectMax(column, alias string) *QueryBuilder {
+	return qb.selectAggregate("MAX", column, alias)
+}
+
+func (qb *QueryBuilder) selectAggregate(fn, column, alias string) *QueryBuilder {
+	expr := fn + "(" + column + ")"
+	if alias != "" {
+		expr += " AS " + alias
+	}
+	return qb.Select(expr)
+}
+
+// OrderBy adds an ORDER BY term. Direction is "ASC" or "DESC".
+func (qb *QueryBuilder) OrderBy(field, direction string) *QueryBuilder {
+	qb.orders = append(qb.orders, store.Order{Field: field, Desc: strings.EqualFold(direction, "DESC")})
+	return qb
+}
+
+// OrderByRandom orders rows randomly, after any OrderBy terms. It uses
+// RANDOM() on PostgreSQL and SQLite and RAND() on MySQL.
+func (qb *QueryBuilder) OrderByRandom() *QueryBuilder {
+	qb.random = true
+	return qb
+}
+
+// LockOption controls what a locking read does when rows are already locked.
+type LockOption string
+
+const (
+	// NoWait fails the query instead of waiting for locked rows.
+	NoWait LockOption = "NOWAIT"
+	// SkipLocked leaves locked rows out of the result, so workers can claim
+	// queued rows concurrently.
+	SkipLocked LockOption = "SKIP LOCKED"
+)
+
+// LockForUpdate locks the selected rows against updates and other locking
+// reads until the transaction ends (SELECT ... FOR UPDATE). It only has an
+// effect inside a transaction. SQLite has no row locks, so compiling the
+// query for it fails with store.ErrNotSupported.
+func (qb *QueryBuilder) LockForUpdate(opts ...LockOption) *QueryBuilder {
+	return qb.lockRows("FOR UPDATE", opts)
+}
+
+// LockForShare locks the selected rows against updates while allowing other
+// shared locks (SELECT ... FOR SHARE). MySQL supports it from 8.0.
+func (qb *QueryBuilder) LockForShare(opts ...LockOption) *QueryBuilder {
+	return qb.lockRows("FOR SHARE", opts)
+}
+
+func (qb *QueryBuilder) lockRows(clause string, opts []LockOption) *QueryBuilder {
+	if len(opts) > 1 {
+		if qb.err == nil {
+			qb.err = fmt.Errorf("%w: NOWAIT and SKIP LOCKED cannot be combined", store.ErrInvalidQuery)
+		}
+		return qb
+	}
+	for _, opt := range opts {
+		if opt != NoWait && opt != SkipLocked {
+			if qb.err == nil {
+				qb.err = fmt.Errorf("%w: unknown lock option %q", store.ErrInvalidQuery, opt)
+			}
+			return qb
+		}
+		clause += " " + string(opt)
+	}
+	qb.lock = clause
+	return qb
+}
+
+// Limit sets the maximum number of rows returned.
+func (qb *QueryBuilder) Limit(limit int) *QueryBuilder {
+	qb.limit = limit
+	return qb
+}
+
+// Offset sets the number of rows skipped.
+func (qb *QueryBuilder) Offset(offset int) *QueryBuilder {
+	qb.offset = offset
+	return qb
+}
+
+// Table returns the table the query selects from.
+func (qb *QueryBuilder) Table() string {
+	return qb.table
+}
+
+// Conditions returns the WHERE conditions of the query.
+func (qb *QueryBuilder) Conditions() []store.Condition {
+	return qb.conditions
+}
+
+// Build compiles the query using the default (PostgreSQL) compiler.
+func (qb *QueryBuilder) Build() (string, []any, error) {
+	return defaultCompiler.CompileQuery(qb)
+}
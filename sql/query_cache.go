@@ -0,0 +1,232 @@
+package sqlstore
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"store"
+)
+
+// queryCache keeps the SQL text of the most recently compiled store.Query
+// shapes. Two queries have the same shape when they differ only in their
+// argument values, so a hit only needs the arguments collected again.
+type queryCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // of *queryEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type queryEntry struct {
+	shape string
+	sql   string
+}
+
+func newQueryCache(size int) *queryCache {
+	return &queryCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *queryCache) get(shape string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[shape]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*queryEntry).sql, true
+}
+
+func (c *queryCache) put(shape, sql string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[shape]; ok {
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[shape] = c.order.PushFront(&queryEntry{shape: shape, sql: sql})
+	for c.order.Len() > c.size {
+		el := c.order.Back()
+		c.order.Remove(el)
+		delete(c.entries, el.Value.(*queryEntry).shape)
+	}
+}
+
+func (c *queryCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// WithQueryCache returns a copy of the compiler that caches the SQL text of
+// up to n store.Query shapes compiled by CompileStoreQuery, evicting the
+// least recently used. A value <= 0 disables the cache.
+func (c *SQLCompiler) WithQueryCache(n int) *SQLCompiler {
+	cp := *c
+	cp.queries = nil
+	if n > 0 {
+		cp.queries = newQueryCache(n)
+	}
+	return &cp
+}
+
+// CachedQueries returns the number of query shapes in the query cache.
+func (c *SQLCompiler) CachedQueries() int {
+	if c.queries == nil {
+		return 0
+	}
+	return c.queries.len()
+}
+
+// CompileStoreQuery compiles a store.Query into a SELECT statement. With a
+// query cache, queries of an already compiled shape reuse its SQL text and
+// only have their arguments collected.
+func (c *SQLCompiler) CompileStoreQuery(q store.Query) (string, []any, error) {
+	if c.queries == nil {
+		return c.CompileQuery(queryBuilderFrom(q))
+	}
+
+	var sb strings.Builder
+	// Copies made by the With* methods share the cache, so the settings
+	// affecting the output are part of the shape
+	fmt.Fprintf(&sb, "%s %d %d %t %q %t %t %t|", c.dialect, c.maxInListSize, c.maxParams, c.quoteIdents, c.identQuote, c.fullText, c.geoSpatial, c.json)
+	if !writeQueryShape(&sb, q) {
+		return c.CompileQuery(queryBuilderFrom(q))
+	}
+	shape := sb.String()
+
+	if sql, ok := c.queries.get(shape); ok {
+		return sql, c.storeQueryArgs(q, nil), nil
+	}
+	sql, args, err := c.CompileQuery(queryBuilderFrom(q))
+	if err != nil {
+		return "", nil, err
+	}
+	c.queries.put(shape, sql)
+	return sql, args, nil
+}
+
+// writeQueryShape writes everything of q that affects its SQL text, leaving
+// out argument values. It reports false for queries that cannot be cached,
+// such as those with *QueryBuilder subqueries, and for values whose type
+// alone makes compilation fail.
+func writeQueryShape(sb *strings.Builder, q store.Query) bool {
+	fmt.Fprintf(sb, "from %q", q.From)
+	if q.FromQuery != nil {
+		sb.WriteString(" (")
+		if !writeQueryShape(sb, *q.FromQuery) {
+			return false
+		}
+		sb.WriteString(")")
+	}
+	fmt.Fprintf(sb, " columns %q where", q.Columns)
+	for _, cond := range q.Where {
+		fmt.Fprintf(sb, " %q %s ", cond.Field, cond.Op)
+		if ref, ok := cond.Value.(store.FieldRef); ok {
+			fmt.Fprintf(sb, "ref %q", string(ref))
+			continue
+		}
+		switch cond.Op {
+		case store.OpIn, store.OpNotIn:
+			if _, ok := cond.Value.(*QueryBuilder); ok {
+				return false
+			}
+			if sub, ok := storeSubquery(cond.Value); ok {
+				sb.WriteString("(")
+				if !writeQueryShape(sb, sub) {
+					return false
+				}
+				sb.WriteString(")")
+				continue
+			}
+			values, _ := cond.Value.([]any)
+			fmt.Fprintf(sb, "list %d", len(values))
+		case store.OpBetween:
+			r, ok := store.BetweenBounds(cond.Value)
+			if !ok {
+				return false
+			}
+			fmt.Fprintf(sb, "range %t %t", r.IncLow, r.IncHigh)
+		case store.OpJSONEq:
+			jp, ok := cond.Value.(store.JSONPath)
+			if !ok || !jsonPathPattern.MatchString(jp.Path) {
+				return false
+			}
+			fmt.Fprintf(sb, "json %q", jp.Path)
+		default:
+			if _, ok := subqueryOf(cond.Value); ok {
+				// Rejected by checkConditions, which a hit would skip
+				return false
+			}
+			sb.WriteString("?")
+		}
+	}
+	fmt.Fprintf(sb, " order %v limit %d offset %d", q.OrderBy, q.Limit, q.Offset)
+	return true
+}
+
+// storeSubquery returns the store.Query of a subquery condition value.
+func storeSubquery(value any) (store.Query, bool) {
+	switch v := value.(type) {
+	case store.Query:
+		return v, true
+	case *store.Query:
+		if v != nil {
+			return *v, true
+		}
+	}
+	return store.Query{}, false
+}
+
+// storeQueryArgs appends the arguments of q to args in the order
+// compileQueryAt binds them.
+func (c *SQLCompiler) storeQueryArgs(q store.Query, args []any) []any {
+	if q.FromQuery != nil {
+		args = c.storeQueryArgs(*q.FromQuery, args)
+	}
+	for _, cond := range q.Where {
+		args = c.conditionArgs(cond, args)
+	}
+	return args
+}
+
+// conditionArgs appends the arguments of cond to args, matching compileConditions.
+func (c *SQLCompiler) conditionArgs(cond store.Condition, args []any) []any {
+	if _, ok := cond.Value.(store.FieldRef); ok {
+		return args
+	}
+	switch cond.Op {
+	case store.OpIsNull, store.OpNotNull:
+		return args
+	case store.OpPrefix, store.OpSuffix, store.OpContains:
+		return append(args, likePattern(cond))
+	case store.OpWithinBBox:
+		box, _ := cond.Value.(store.BBox)
+		return append(args, box.MinLng, box.MinLat, box.MaxLng, box.MaxLat)
+	case store.OpArrayOverlaps:
+		values, _ := cond.Value.([]any)
+		return append(args, pq.Array(values))
+	case store.OpWithinLast:
+		d, _ := cond.Value.(time.Duration)
+		return append(args, c.dialect.intervalArg(d))
+	case store.OpBetween:
+		r, _ := store.BetweenBounds(cond.Value)
+		return append(args, r.From, r.To)
+	case store.OpJSONEq:
+		jp, _ := cond.Value.(store.JSONPath)
+		return append(args, jp.Value)
+	case store.OpIn, store.OpNotIn:
+		if sub, ok := storeSubquery(cond.Value); ok {
+			return c.storeQueryArgs(sub, args)
+		}
+		values, _ := cond.Value.([]any)
+		return append(args, values...)
+	default:
+		return append(args, cond.Value)
+	}
+}
@@ -0,0 +1,313 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QueryLogEntry describes one executed statement.
+type QueryLogEntry struct {
+	SQL      string
+	Args     []any
+	Duration time.Duration
+	Err      error
+}
+
+// QueryLogger receives the statements executed by a service. Arguments bound
+// to sensitive columns are already redacted.
+type QueryLogger interface {
+	LogQuery(ctx context.Context, entry QueryLogEntry)
+}
+
+// QueryLoggerFunc adapts a function to the QueryLogger interface.
+type QueryLoggerFunc func(ctx context.Context, entry QueryLogEntry)
+
+// LogQuery calls f.
+func (f QueryLoggerFunc) LogQuery(ctx context.Context, entry QueryLogEntry) {
+	f(ctx, entry)
+}
+
+// SensitiveColumner is implemented by entities whose column values must not
+// appear in query logs.
+type SensitiveColumner interface {
+	SensitiveColumns() []string
+}
+
+// RedactedArg replaces the value of a sensitive argument in query logs.
+const RedactedArg = "[REDACTED]"
+
+// ArgRedactor hides the arguments bound to sensitive columns. The column of
+// each argument is inferred from the statement: the column list of an INSERT,
+// or the column compared or assigned to the placeholder elsewhere.
+type ArgRedactor struct {
+	mu      sync.RWMutex
+	columns map[string]bool
+}
+
+// NewArgRedactor creates a redactor for the given column names.
+func NewArgRedactor(columns ...string) *ArgRedactor {
+	r := &ArgRedactor{columns: make(map[string]bool)}
+	r.AddColumns(columns...)
+	return r
+}
+
+// AddColumns marks more columns as sensitive. Names are case-insensitive.
+func (r *ArgRedactor) AddColumns(columns ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, column := range columns {
+		r.columns[strings.ToLower(column)] = true
+	}
+}
+
+// IsSensitive reports whether column is marked as sensitive.
+func (r *ArgRedactor) IsSensitive(column string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.columns[strings.ToLower(column)]
+}
+
+// Redact returns a copy of args with the values bound to sensitive columns
+// replaced by RedactedArg.
+func (r *ArgRedactor) Redact(query string, args []any) []any {
+	redacted := append([]any(nil), args...)
+
+	r.mu.RLock()
+	empty := len(r.columns) == 0
+	r.mu.RUnlock()
+	if empty {
+		return redacted
+	}
+
+	for i, column := range argColumns(query, len(args)) {
+		if column != "" && r.IsSensitive(column) {
+			redacted[i] = RedactedArg
+		}
+	}
+	return redacted
+}
+
+var sqlTokenPattern = regexp.MustCompile("\\$\\d+|\\?|'(?:[^']|'')*'|\"[^\"]*\"|`[^`]*`|[A-Za-z_][A-Za-z0-9_.]*|<>|!=|>=|<=|\\S")
+
+// argSkipTokens may appear between a column and its placeholder.
+var argSkipTokens = map[string]bool{
+	",": true, "(": true, ")": true, "=": true, "<>": true, "!=": true,
+	">=": true, "<=": true, ">": true, "<": true,
+	"LIKE": true, "ILIKE": true, "IN": true, "NOT": true, "BETWEEN": true, "AND": true,
+}
+
+// argStopTokens are keywords that end the search for a placeholder's column.
+var argStopTokens = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "SET": true, "VALUES": true,
+	"LIMIT": true, "OFFSET": true, "OR": true, "ON": true, "RETURNING": true,
+}
+
+// argColumns returns, for each of the n arguments of query, the column it is
+// bound to, or "" when that cannot be determined.
+func argColumns(query string, n int) []string {
+	columns := make([]string, n)
+	tokens := sqlTokenPattern.FindAllString(query, -1)
+
+	var insertColumns []string
+	valuesStart := -1
+	if len(tokens) > 0 && strings.EqualFold(tokens[0], "INSERT") {
+		insertColumns, valuesStart = insertColumnList(tokens)
+	}
+
+	sequential := 0
+	depth, position := 0, 0
+	inValues := valuesStart >= 0
+
+	for i, tok := range tokens {
+		if inValues && i > valuesStart {
+			switch tok {
+			case "(":
+				depth++
+				if depth == 1 {
+					position = 0
+				}
+			case ")":
+				depth--
+			case ",":
+				if depth == 1 {
+					position++
+				}
+			default:
+				if depth == 0 {
+					inValues = false
+				}
+			}
+		}
+
+		index, ok := placeholderIndex(tok, &sequential)
+		if !ok || index < 0 || index >= n {
+			continue
+		}
+
+		if inValues && i > valuesStart && depth > 0 {
+			if position < len(insertColumns) {
+				columns[index] = insertColumns[position]
+			}
+			continue
+		}
+		columns[index] = precedingColumn(tokens, i)
+	}
+	return columns
+}
+
+// insertColumnList returns the column list of an INSERT statement and the
+// index of its VALUES token, or -1 when it has none.
+func insertColumnList(tokens []string) ([]string, int) {
+	var columns []string
+	i := 0
+	for i < len(tokens) && tokens[i] != "(" {
+		i++
+	}
+	for i++; i < len(tokens) && tokens[i] != ")"; i++ {
+		if tokens[i] != "," {
+			columns = append(columns, unquoteIdentifier(tokens[i]))
+		}
+	}
+	if i+1 < len(tokens) && strings.EqualFold(tokens[i+1], "VALUES") {
+		return columns, i + 1
+	}
+	return columns, -1
+}
+
+// placeholderIndex returns the zero-based argument index of a placeholder
+// token. Question marks are numbered in order of appearance.
+func placeholderIndex(tok string, sequential *int) (int, bool) {
+	switch {
+	case tok == "?":
+		*sequential++
+		return *sequential - 1, true
+	case strings.HasPrefix(tok, "$"):
+		n, err := strconv.Atoi(tok[1:])
+		return n - 1, err == nil
+	}
+	return 0, false
+}
+
+// precedingColumn walks back from the placeholder at i, over operators,
+// function calls and sibling placeholders, to the column it is compared with
+// or assigned to. In col = CASE key WHEN $1 THEN $2 ..., the WHEN arms are
+// bound to key and the THEN and ELSE arms to col.
+func precedingColumn(tokens []string, i int) string {
+	var ignored int
+	for j := i - 1; j >= 0; j-- {
+		tok := tokens[j]
+		upper := strings.ToUpper(tok)
+		if _, ok := placeholderIndex(tok, &ignored); ok || argSkipTokens[upper] {
+			continue
+		}
+		switch upper {
+		case "WHEN":
+			// A simple CASE compares its operand; a searched CASE has no
+			// column left of WHEN
+			if c := enclosingCase(tokens, j); c >= 0 && c+1 < j && !strings.EqualFold(tokens[c+1], "WHEN") {
+				return columnName(tokens[c+1])
+			}
+			return ""
+		case "THEN", "ELSE":
+			if c := enclosingCase(tokens, j); c >= 0 {
+				return precedingColumn(tokens, c)
+			}
+			return ""
+		}
+		if argStopTokens[upper] || !isIdentifierToken(tok) {
+			return ""
+		}
+		if j+1 < i && tokens[j+1] == "(" {
+			continue // function name, as in lower($1)
+		}
+		return columnName(tok)
+	}
+	return ""
+}
+
+// enclosingCase returns the index of the CASE keyword whose arm contains the
+// token at i, or -1.
+func enclosingCase(tokens []string, i int) int {
+	depth := 0
+	for j := i - 1; j >= 0; j-- {
+		switch strings.ToUpper(tokens[j]) {
+		case "END":
+			depth++
+		case "CASE":
+			if depth == 0 {
+				return j
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// columnName returns the unqualified, unquoted name of a column token.
+func columnName(tok string) string {
+	if !isIdentifierToken(tok) {
+		return ""
+	}
+	name := unquoteIdentifier(tok)
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		name = name[dot+1:]
+	}
+	return name
+}
+
+func isIdentifierToken(tok string) bool {
+	c := tok[0]
+	return c == '"' || c == '`' || c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func unquoteIdentifier(tok string) string {
+	return strings.Trim(tok, "\"`")
+}
+
+// loggingQueryer passes the queries run through q to a service's
+// interceptors and logger.
+type loggingQueryer struct {
+	q      queryer
+	before queryRewriteFunc
+	log    queryLogFunc
+}
+
+// queryLogFunc records one executed statement.
+type queryLogFunc func(ctx context.Context, query string, args []any, start time.Time, err error)
+
+func (l loggingQueryer) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if l.before != nil {
+		ctx, query, args = l.before(ctx, query, args)
+	}
+	start := time.Now()
+	rows, err := l.q.QueryContext(ctx, query, args...)
+	l.log(ctx, query, args, start, err)
+	return rows, err
+}
+
+func (l loggingQueryer) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	if l.before != nil {
+		ctx, query, args = l.before(ctx, query, args)
+	}
+	start := time.Now()
+	row := l.q.QueryRowContext(ctx, query, args...)
+	l.log(ctx, query, args, start, row.Err())
+	return row
+}
+
+// withQueryLog wraps q so its queries pass through before and are logged,
+// when set.
+func withQueryLog(q queryer, before queryRewriteFunc, log queryLogFunc) queryer {
+	if before == nil && log == nil {
+		return q
+	}
+	if log == nil {
+		log = func(context.Context, string, []any, time.Time, error) {}
+	}
+	return loggingQueryer{q: q, before: before, log: log}
+}
@@ -0,0 +1,345 @@
+package sqlstore_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"core/entity"
+	sqlstore "store/sql"
+)
+
+type account struct {
+	ID        string    `json:"id" db:"id"`
+	Email     string    `json:"email" db:"email"`
+	Password  string    `json:"password" db:"password"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+func (a *account) GetID() string              { return a.ID }
+func (a *account) SetID(id string)            { a.ID = id }
+func (a *account) GetCreatedAt() time.Time    { return a.CreatedAt }
+func (a *account) SetCreatedAt(t time.Time)   { a.CreatedAt = t }
+func (a *account) GetUpdatedAt() time.Time    { return a.UpdatedAt }
+func (a *account) SetUpdatedAt(t time.Time)   { a.UpdatedAt = t }
+func (a *account) SensitiveColumns() []string { return []string{"password"} }
+
+type logRecorder struct {
+	mu      sync.Mutex
+	entries []sqlstore.QueryLogEntry
+}
+
+func (l *logRecorder) LogQuery(_ context.Context, entry sqlstore.QueryLogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, entry)
+}
+
+// find returns the first logged entry whose SQL starts with prefix.
+func (l *logRecorder) find(prefix string) (sqlstore.QueryLogEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, entry := range l.entries {
+		if strings.HasPrefix(entry.SQL, prefix) {
+			return entry, true
+		}
+	}
+	return sqlstore.QueryLogEntry{}, false
+}
+
+func TestQueryLogRedactsSensitiveColumns(t *testing.T) {
+	svc, _ := openTestService(t)
+	ctx := context.Background()
+
+	ddl := `CREATE TABLE accounts (
+		id TEXT PRIMARY KEY,
+		email TEXT,
+		password TEXT,
+		created_at TIMESTAMP,
+		updated_at TIMESTAMP
+	)`
+	if err := svc.ExecuteSQL(ctx, ddl); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+
+	logs := &logRecorder{}
+	svc.SetQueryLogger(logs)
+	repo := svc.Repository(&account{})
+
+	acct := &account{ID: "acct-1", Email: "a@example.com", Password: "hunter2"}
+	if err := repo.Create(ctx, acct); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := repo.FindOneBy(ctx, "password", "hunter2"); err != nil {
+		t.Fatalf("find: %v", err)
+	}
+
+	second := &account{ID: "acct-2", Email: "b@example.com", Password: "swordfish"}
+	if err := repo.Create(ctx, second); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	acct.Password, second.Password = "hunter3", "swordfish2"
+	if err := repo.UpdateBatch(ctx, []entity.Entity{acct, second}); err != nil {
+		t.Fatalf("update batch: %v", err)
+	}
+
+	for _, prefix := range []string{"INSERT", "SELECT", "UPDATE"} {
+		entry, ok := logs.find(prefix)
+		if !ok {
+			t.Fatalf("no %s statement logged", prefix)
+		}
+		var sawID bool
+		for _, arg := range entry.Args {
+			if arg == "hunter2" || arg == "hunter3" || arg == "swordfish2" {
+				t.Errorf("%s: password arg was logged: %v", prefix, entry.Args)
+			}
+			if arg == "acct-1" {
+				sawID = true
+			}
+		}
+		if prefix == "INSERT" && !sawID {
+			t.Errorf("INSERT: id arg was not logged: %v", entry.Args)
+		}
+	}
+}
+
+func TestArgRedactor(t *testing.T) {
+	redactor := sqlstore.NewArgRedactor("password", "token")
+
+	tests := []struct {
+		name  string
+		query string
+		args  []any
+		want  []any
+	}{
+		{
+			name:  "insert columns",
+			query: "INSERT INTO users (id, password, email) VALUES ($1, $2, $3)",
+			args:  []any{"1", "secret", "a@example.com"},
+			want:  []any{"1", sqlstore.RedactedArg, "a@example.com"},
+		},
+		{
+			name:  "multi-row insert",
+			query: "INSERT INTO users (id, password) VALUES (?, ?), (?, ?)",
+			args:  []any{"1", "s1", "2", "s2"},
+			want:  []any{"1", sqlstore.RedactedArg, "2", sqlstore.RedactedArg},
+		},
+		{
+			name:  "update and where",
+			query: "UPDATE users SET password = $1, name = $2 WHERE id = $3",
+			args:  []any{"secret", "bob", "7"},
+			want:  []any{sqlstore.RedactedArg, "bob", "7"},
+		},
+		{
+			name:  "in list and qualified column",
+			query: `SELECT * FROM sessions s WHERE s.token IN ($1, $2) AND "id" = $3 LIMIT $4`,
+			args:  []any{"t1", "t2", "9", 10},
+			want:  []any{sqlstore.RedactedArg, sqlstore.RedactedArg, "9", 10},
+		},
+		{
+			name:  "case update",
+			query: "UPDATE users SET name = CASE id WHEN $1 THEN $2 ELSE name END, password = CASE id WHEN $3 THEN $4 WHEN $5 THEN $6 ELSE password END WHERE id IN ($7, $8)",
+			args:  []any{"1", "bob", "1", "s1", "2", "s2", "1", "2"},
+			want:  []any{"1", "bob", "1", sqlstore.RedactedArg, "2", sqlstore.RedactedArg, "1", "2"},
+		},
+		{
+			name:  "searched case",
+			query: "UPDATE users SET token = CASE WHEN id = ? THEN ? ELSE token END",
+			args:  []any{"1", "t1"},
+			want:  []any{"1", sqlstore.RedactedArg},
+		},
+		{
+			name:  "function call",
+			query: "SELECT * FROM users WHERE lower(password)=lower($1) AND name = $2",
+			args:  []any{"secret", "bob"},
+			want:  []any{sqlstore.RedactedArg, "bob"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := redactor.Redact(tt.query, tt.args)
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("arg %d: got %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+			if tt.args[0] == sqlstore.RedactedArg {
+				t.Error("Redact modified the caller's args")
+			}
+		})
+	}
+}
+
+type traceKey struct{}
+
+func TestInterceptors(t *testing.T) {
+	svc, repo := openTestService(t)
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var calls []string
+	var events []sqlstore.QueryEvent
+	record := func(call string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, call)
+	}
+
+	svc.Use(
+		sqlstore.InterceptorFuncs{
+			Before: func(ctx context.Context, query string, args []any) (context.Context, string, []any) {
+				record("outer.before")
+				return context.WithValue(ctx, traceKey{}, "span"), strings.Replace(query, "FROM nowhere", "FROM "+repo.TableName(), 1), args
+			},
+			After: func(ctx context.Context, event sqlstore.QueryEvent) {
+				record("outer.after")
+				if ctx.Value(traceKey{}) != "span" {
+					t.Error("expected the context returned by BeforeQuery")
+				}
+				mu.Lock()
+				events = append(events, event)
+				mu.Unlock()
+			},
+			Error: func(ctx context.Context, event sqlstore.QueryEvent) {
+				record("outer.error")
+				mu.Lock()
+				events = append(events, event)
+				mu.Unlock()
+			},
+		},
+		sqlstore.InterceptorFuncs{
+			Before: func(ctx context.Context, query string, args []any) (context.Context, string, []any) {
+				record("inner.before")
+				return ctx, query, args
+			},
+			After: func(context.Context, sqlstore.QueryEvent) { record("inner.after") },
+		},
+	)
+
+	rows, err := svc.RawQuery(ctx, "SELECT id FROM nowhere", nil)
+	if err != nil {
+		t.Fatalf("expected the rewritten query to run, got %v", err)
+	}
+	rows.Close()
+	if got := strings.Join(calls, " "); got != "outer.before inner.before inner.after outer.after" {
+		t.Errorf("unexpected hook order: %s", got)
+	}
+	if !strings.Contains(events[0].SQL, repo.TableName()) {
+		t.Errorf("expected events to carry the rewritten SQL, got %s", events[0].SQL)
+	}
+
+	if err := repo.Create(ctx, &gadget{ID: "g1", Name: "widget"}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if last := events[len(events)-1]; !strings.HasPrefix(last.SQL, "INSERT") || len(last.Args) == 0 {
+		t.Errorf("expected repository writes to be intercepted, got %+v", last)
+	}
+
+	calls = nil
+	if err := svc.ExecuteSQL(ctx, "SELECT * FROM missing"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if last := events[len(events)-1]; last.Err == nil || calls[len(calls)-1] != "outer.error" {
+		t.Errorf("expected OnError with the error, got %v after %v", last.Err, calls)
+	}
+}
+
+func TestSlowQueryLogger(t *testing.T) {
+	svc, _ := openTestService(t)
+	ctx := context.Background()
+	if err := svc.ExecuteSQL(ctx, "CREATE TABLE accounts (id TEXT PRIMARY KEY, email TEXT, password TEXT, created_at TIMESTAMP, updated_at TIMESTAMP)"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	repo := svc.Repository(&account{})
+
+	var slow, never []sqlstore.SlowQuery
+	svc.Use(
+		sqlstore.NewSlowQueryLogger(0, func(_ context.Context, q sqlstore.SlowQuery) { slow = append(slow, q) }).
+			WithRedactor(svc.Redactor()),
+		sqlstore.NewSlowQueryLogger(time.Hour, func(_ context.Context, q sqlstore.SlowQuery) { never = append(never, q) }),
+	)
+
+	if err := repo.Create(ctx, &account{ID: "a1", Password: "hunter2"}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := repo.FindOneBy(ctx, "password", "hunter2"); err != nil {
+		t.Fatalf("find: %v", err)
+	}
+
+	if len(never) != 0 {
+		t.Errorf("expected no statement above an hour, got %+v", never)
+	}
+	if len(slow) != 2 {
+		t.Fatalf("expected the INSERT and SELECT, got %+v", slow)
+	}
+	query := slow[1]
+	if !strings.HasPrefix(query.SQL, "SELECT") || query.Duration <= 0 {
+		t.Errorf("unexpected slow query: %+v", query)
+	}
+	if len(query.Args) != 1 || query.Args[0] != sqlstore.RedactedArg {
+		t.Errorf("expected redacted arguments, got %v", query.Args)
+	}
+	if !strings.Contains(query.Location, "query_log_test.go:") {
+		t.Errorf("expected the test as the location, got %q", query.Location)
+	}
+
+	// Batch updates assign the password through CASE arms
+	if err := repo.UpdateBatch(ctx, []entity.Entity{&account{ID: "a1", Password: "hunter3"}}); err != nil {
+		t.Fatalf("update batch: %v", err)
+	}
+	update := slow[len(slow)-1]
+	if !strings.HasPrefix(update.SQL, "UPDATE") || !strings.Contains(update.SQL, "CASE") {
+		t.Fatalf("expected the batch UPDATE, got %s", update.SQL)
+	}
+	for _, arg := range update.Args {
+		if arg == "hunter3" {
+			t.Errorf("password arg was reported: %v", update.Args)
+		}
+	}
+}
+
+func TestSlowQueryLoggerDefaultsToService(t *testing.T) {
+	svc, _ := openTestService(t)
+	ctx := context.Background()
+	if err := svc.ExecuteSQL(ctx, "CREATE TABLE accounts (id TEXT PRIMARY KEY, email TEXT, password TEXT, created_at TIMESTAMP, updated_at TIMESTAMP)"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	repo := svc.Repository(&account{})
+
+	var buf bytes.Buffer
+	svc.SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+	svc.Use(sqlstore.NewSlowQueryLogger(0, nil))
+
+	if err := repo.Create(ctx, &account{ID: "a1", Password: "hunter2"}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "level=WARN") || !strings.Contains(out, "slow query") || !strings.Contains(out, "INSERT") {
+		t.Errorf("expected the INSERT logged to the service logger, got %q", out)
+	}
+	if strings.Contains(out, "hunter2") || !strings.Contains(out, sqlstore.RedactedArg) {
+		t.Errorf("expected the service redactor to hide the password, got %q", out)
+	}
+}
+
+func TestSlowQueryLoggerSampling(t *testing.T) {
+	svc, _ := openTestService(t)
+	ctx := context.Background()
+	count := 0
+	svc.Use(sqlstore.NewSlowQueryLogger(0, func(context.Context, sqlstore.SlowQuery) { count++ }).WithSampleRate(0))
+
+	for range 10 {
+		if err := svc.ExecuteSQL(ctx, "SELECT 1"); err != nil {
+			t.Fatalf("exec: %v", err)
+		}
+	}
+	if count != 0 {
+		t.Errorf("expected no reports at sample rate 0, got %d", count)
+	}
+}
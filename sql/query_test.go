@@ -0,0 +1,1320 @@
+package sqlstore_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"store"
+	sqlstore "store/sql"
+	"store/sql/adapter"
+)
+
+func newOrdersExecutor(t *testing.T) *sqlstore.QueryExecutor {
+	t.Helper()
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	setup := []string{
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, customer TEXT, status TEXT, amount INTEGER)",
+		`INSERT INTO orders (customer, status, amount) VALUES
+			('alice', 'paid', 10), ('alice', 'paid', 20), ('bob', 'paid', 5),
+			('bob', 'open', 7), ('carol', 'paid', 30), ('dave', 'open', 1)`,
+	}
+	for _, stmt := range setup {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	compiler := sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectSQLite)
+	return sqlstore.NewQueryExecutor(db, compiler)
+}
+
+func TestCountSubqueryGroupBy(t *testing.T) {
+	qe := newOrdersExecutor(t)
+	ctx := context.Background()
+
+	qb := sqlstore.NewQueryBuilder("orders").
+		Select("customer").
+		Where("status", "=", "paid").
+		GroupBy("customer")
+
+	groups, err := qe.CountSubquery(ctx, qb)
+	if err != nil {
+		t.Fatalf("count subquery failed: %v", err)
+	}
+	if groups != 3 {
+		t.Errorf("expected 3 paying customers, got %d", groups)
+	}
+
+	rows, err := qe.Count(ctx, qb)
+	if err != nil {
+		t.Fatalf("count failed: %v", err)
+	}
+	if rows != 4 {
+		t.Errorf("expected Count to ignore grouping and return 4 rows, got %d", rows)
+	}
+}
+
+func TestCompileGroupByHaving(t *testing.T) {
+	qb := sqlstore.NewQueryBuilder("orders").
+		Select("customer").
+		SelectCount("*", "orders").
+		SelectSum("amount", "total").
+		SelectAvg("amount", "").
+		Where("status", "=", "paid").
+		GroupBy("customer").
+		Having(store.Gt("SUM(amount)", 10), store.Le("COUNT(*)", 5)).
+		OrderBy("total", "DESC")
+
+	tests := []struct {
+		dialect sqlstore.Dialect
+		want    string
+	}{
+		{sqlstore.DialectPostgres, "SELECT customer, COUNT(*) AS orders, SUM(amount) AS total, AVG(amount) FROM orders WHERE status = $1 GROUP BY customer HAVING SUM(amount) > $2 AND COUNT(*) <= $3 ORDER BY total DESC"},
+		{sqlstore.DialectMySQL, "SELECT customer, COUNT(*) AS orders, SUM(amount) AS total, AVG(amount) FROM orders WHERE status = ? GROUP BY customer HAVING SUM(amount) > ? AND COUNT(*) <= ? ORDER BY total DESC"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *testing.T) {
+			query, args, err := sqlstore.NewSQLCompiler().WithDialect(tt.dialect).CompileQuery(qb)
+			if err != nil {
+				t.Fatalf("compile failed: %v", err)
+			}
+			if query != tt.want {
+				t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, tt.want)
+			}
+			if len(args) != 3 || args[0] != "paid" || args[1] != 10 || args[2] != 5 {
+				t.Errorf("unexpected args: %v", args)
+			}
+		})
+	}
+}
+
+func TestQueryGroupByHaving(t *testing.T) {
+	qe := newOrdersExecutor(t)
+	ctx := context.Background()
+
+	qb := sqlstore.NewQueryBuilder("orders").
+		Select("customer").
+		SelectSum("amount", "total").
+		SelectMax("amount", "largest").
+		Where("status", "=", "paid").
+		GroupBy("customer").
+		Having(store.Ge("SUM(amount)", 30)).
+		OrderBy("customer", "ASC")
+
+	rows, err := qe.Query(ctx, qb)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var got []string
+	for rows.Next() {
+		var customer string
+		var total, largest int
+		if err := rows.Scan(&customer, &total, &largest); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		got = append(got, fmt.Sprintf("%s:%d:%d", customer, total, largest))
+	}
+	if want := []string{"alice:30:20", "carol:30:30"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected groups %v, got %v", want, got)
+	}
+}
+
+func TestQueryBuilderBuild(t *testing.T) {
+	query, args, err := sqlstore.NewQueryBuilder("users").
+		Select("id", "name").
+		Where("age", ">=", 18).
+		Where("status", "IN", []any{"active", "pending"}).
+		OrderBy("created_at", "DESC").
+		Limit(10).
+		Offset(20).
+		Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	want := "SELECT id, name FROM users WHERE age >= $1 AND status IN ($2, $3) ORDER BY created_at DESC LIMIT 10 OFFSET 20"
+	if query != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, want)
+	}
+	if len(args) != 3 {
+		t.Errorf("expected 3 args, got %v", args)
+	}
+
+	if _, _, err := sqlstore.NewQueryBuilder("users").Where("age", "~~", 1).Build(); err == nil {
+		t.Error("expected unsupported operator to fail")
+	}
+}
+
+func TestQueryBuilderWhereInBetween(t *testing.T) {
+	query, args, err := sqlstore.NewQueryBuilder("orders").
+		Where("customer", "!=", "eve").
+		WhereIn("status", "paid", "open").
+		WhereNotIn("id", 7, 8, 9).
+		WhereBetween("amount", 5, 20).
+		Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	want := "SELECT * FROM orders WHERE customer != $1 AND status IN ($2, $3) AND id NOT IN ($4, $5, $6) AND amount BETWEEN $7 AND $8"
+	if query != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, want)
+	}
+	if len(args) != 8 || args[1] != "paid" || args[5] != 9 || args[6] != 5 || args[7] != 20 {
+		t.Errorf("unexpected args: %v", args)
+	}
+
+	qe := newOrdersExecutor(t)
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		qb   *sqlstore.QueryBuilder
+		want int64
+	}{
+		{"in", sqlstore.NewQueryBuilder("orders").WhereIn("customer", "alice", "bob"), 4},
+		{"not in", sqlstore.NewQueryBuilder("orders").WhereNotIn("customer", "alice", "bob"), 2},
+		{"between", sqlstore.NewQueryBuilder("orders").WhereBetween("amount", 5, 20), 4},
+		{"empty in", sqlstore.NewQueryBuilder("orders").WhereIn("customer"), 0},
+		{"empty not in", sqlstore.NewQueryBuilder("orders").WhereNotIn("customer"), 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := qe.Count(ctx, tt.qb)
+			if err != nil {
+				t.Fatalf("count: %v", err)
+			}
+			if n != tt.want {
+				t.Errorf("expected %d rows, got %d", tt.want, n)
+			}
+		})
+	}
+}
+
+func TestQueryBuilderWhereOr(t *testing.T) {
+	query, args, err := sqlstore.NewQueryBuilder("orders").
+		Where("amount", ">", 5).
+		WhereOr(store.Eq("status", "open"), store.And(store.Eq("customer", "bob"), store.Ne("status", "void"))).
+		Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	want := "SELECT * FROM orders WHERE amount > $1 AND (status = $2 OR (customer = $3 AND status != $4))"
+	if query != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, want)
+	}
+	if len(args) != 4 {
+		t.Errorf("expected 4 args, got %v", args)
+	}
+}
+
+func TestCompileFullTextPerDialect(t *testing.T) {
+	tests := []struct {
+		dialect sqlstore.Dialect
+		want    string
+	}{
+		{sqlstore.DialectPostgres, "SELECT * FROM articles WHERE to_tsvector(body) @@ plainto_tsquery($1)"},
+		{sqlstore.DialectMySQL, "SELECT * FROM articles WHERE MATCH(body) AGAINST(?)"},
+		{sqlstore.DialectSQLite, "SELECT * FROM articles WHERE body MATCH $1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *testing.T) {
+			qb := sqlstore.NewQueryBuilder("articles").WhereCondition(store.FullText("body", "quick fox"))
+			query, args, err := sqlstore.NewSQLCompiler().WithDialect(tt.dialect).CompileQuery(qb)
+			if err != nil {
+				t.Fatalf("compile failed: %v", err)
+			}
+			if query != tt.want {
+				t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, tt.want)
+			}
+			if len(args) != 1 || args[0] != "quick fox" {
+				t.Errorf("unexpected args: %v", args)
+			}
+		})
+	}
+}
+
+func TestCompilePatternsPerDialect(t *testing.T) {
+	qb := sqlstore.NewQueryBuilder("articles").
+		WhereCondition(store.Like("title", "go%")).
+		WhereCondition(store.Condition{Field: "body", Op: store.OpILike, Value: "%fox%"})
+	tests := []struct {
+		dialect sqlstore.Dialect
+		want    string
+	}{
+		{sqlstore.DialectPostgres, `SELECT * FROM articles WHERE title LIKE $1 ESCAPE '\' AND body ILIKE $2 ESCAPE '\'`},
+		{sqlstore.DialectMySQL, `SELECT * FROM articles WHERE title LIKE ? ESCAPE '\\' AND LOWER(body) LIKE LOWER(?) ESCAPE '\\'`},
+		{sqlstore.DialectSQLite, `SELECT * FROM articles WHERE title LIKE $1 ESCAPE '\' AND LOWER(body) LIKE LOWER($2) ESCAPE '\'`},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *testing.T) {
+			query, _, err := sqlstore.NewSQLCompiler().WithDialect(tt.dialect).CompileQuery(qb)
+			if err != nil {
+				t.Fatalf("compile failed: %v", err)
+			}
+			if query != tt.want {
+				t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, tt.want)
+			}
+		})
+	}
+
+	regex := sqlstore.NewQueryBuilder("articles").WhereCondition(store.Condition{Field: "title", Op: store.OpRegex, Value: "^go"})
+	if query, _, err := sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectMySQL).CompileQuery(regex); err != nil || query != "SELECT * FROM articles WHERE title REGEXP ?" {
+		t.Errorf("unexpected MySQL regex: %s, %v", query, err)
+	}
+	if query, _, err := sqlstore.NewSQLCompiler().CompileQuery(regex); err != nil || query != "SELECT * FROM articles WHERE title ~ $1" {
+		t.Errorf("unexpected PostgreSQL regex: %s, %v", query, err)
+	}
+}
+
+func TestCompileMatchPerDialect(t *testing.T) {
+	tests := []struct {
+		dialect sqlstore.Dialect
+		query   string
+		want    string
+	}{
+		{sqlstore.DialectPostgres, "quick & !lazy", "SELECT * FROM articles WHERE to_tsvector(body) @@ to_tsquery($1)"},
+		{sqlstore.DialectMySQL, "+quick -lazy", "SELECT * FROM articles WHERE MATCH(body) AGAINST(? IN BOOLEAN MODE)"},
+		{sqlstore.DialectSQLite, "quick NOT lazy", "SELECT * FROM articles WHERE body MATCH $1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *testing.T) {
+			qb := sqlstore.NewQueryBuilder("articles").WhereCondition(store.Match("body", tt.query))
+			query, args, err := sqlstore.NewSQLCompiler().WithDialect(tt.dialect).CompileQuery(qb)
+			if err != nil {
+				t.Fatalf("compile failed: %v", err)
+			}
+			if query != tt.want {
+				t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, tt.want)
+			}
+			if len(args) != 1 || args[0] != tt.query {
+				t.Errorf("unexpected args: %v", args)
+			}
+		})
+	}
+
+	qb := sqlstore.NewQueryBuilder("articles").WhereCondition(store.Match("body", "fox"))
+	if _, _, err := sqlstore.NewSQLCompiler().WithFullTextSearch(false).CompileQuery(qb); !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported without full-text support, got %v", err)
+	}
+}
+
+func TestCompileJSONPathPerDialect(t *testing.T) {
+	tests := []struct {
+		dialect sqlstore.Dialect
+		want    string
+	}{
+		{sqlstore.DialectPostgres, "SELECT * FROM products WHERE metadata->'sizes'->0->>'name' = $1"},
+		{sqlstore.DialectMySQL, "SELECT * FROM products WHERE JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.sizes[0].name')) = ?"},
+		{sqlstore.DialectSQLite, "SELECT * FROM products WHERE json_extract(metadata, '$.sizes[0].name') = $1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *testing.T) {
+			qb := sqlstore.NewQueryBuilder("products").WhereCondition(store.JSONEq("metadata", "$.sizes[0].name", "large"))
+			query, args, err := sqlstore.NewSQLCompiler().WithDialect(tt.dialect).CompileQuery(qb)
+			if err != nil {
+				t.Fatalf("compile failed: %v", err)
+			}
+			if query != tt.want {
+				t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, tt.want)
+			}
+			if len(args) != 1 || args[0] != "large" {
+				t.Errorf("unexpected args: %v", args)
+			}
+		})
+	}
+
+	for _, path := range []string{"color", "$.color'; DROP TABLE products", "$..color", "$[x]"} {
+		qb := sqlstore.NewQueryBuilder("products").WhereCondition(store.JSONEq("metadata", path, "red"))
+		if _, _, err := sqlstore.NewSQLCompiler().CompileQuery(qb); !errors.Is(err, store.ErrInvalidQuery) {
+			t.Errorf("expected path %q to fail with ErrInvalidQuery, got %v", path, err)
+		}
+	}
+}
+
+func TestJSONPathFiltersRows(t *testing.T) {
+	svc, _ := openTestService(t)
+	ctx := context.Background()
+
+	setup := []string{
+		"CREATE TABLE products (name TEXT, metadata TEXT)",
+		`INSERT INTO products (name, metadata) VALUES ('shirt', '{"color": "red", "sizes": [{"name": "small"}]}'), ('hat', '{"color": "blue"}'), ('scarf', '{"color": "red", "sizes": [{"name": "large"}]}')`,
+	}
+	for _, stmt := range setup {
+		if err := svc.ExecuteSQL(ctx, stmt); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		cond store.Condition
+		want string
+	}{
+		{store.JSONEq("metadata", "$.color", "red"), "[scarf shirt]"},
+		{store.JSONEq("metadata", "$.sizes[0].name", "large"), "[scarf]"},
+	}
+	for _, tt := range tests {
+		qb := sqlstore.NewQueryBuilder("products").Select("name").WhereCondition(tt.cond).OrderBy("name", "ASC")
+		rows, err := svc.QueryExecutor().Query(ctx, qb)
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		var names []string
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				t.Fatalf("scan: %v", err)
+			}
+			names = append(names, name)
+		}
+		rows.Close()
+		if got := fmt.Sprint(names); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.cond.Value, tt.want, got)
+		}
+	}
+}
+
+func TestFullTextRejectedWithoutCapability(t *testing.T) {
+	qb := sqlstore.NewQueryBuilder("articles").WhereCondition(store.FullText("body", "fox"))
+	_, _, err := sqlstore.NewSQLCompiler().WithFullTextSearch(false).CompileQuery(qb)
+	if !errors.Is(err, store.ErrNotSupported) {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+
+	_, err = sqlstore.NewSQLCompiler().WithFullTextSearch(false).CompileMutation("articles", store.NewDelete(store.FullText("body", "fox")))
+	if !errors.Is(err, store.ErrNotSupported) {
+		t.Fatalf("expected ErrNotSupported for mutation, got %v", err)
+	}
+}
+
+// SQLite is built with FTS3/FTS4 by default; FTS5 needs the sqlite_fts5 build tag.
+// Both use the same MATCH syntax.
+func TestFullTextSearchSQLite(t *testing.T) {
+	svc, _ := openTestService(t)
+	ctx := context.Background()
+
+	setup := []string{
+		"CREATE VIRTUAL TABLE articles USING fts4(title, body)",
+		"INSERT INTO articles (title, body) VALUES ('one', 'the quick brown fox'), ('two', 'a lazy dog sleeps'), ('three', 'foxes are quick')",
+	}
+	for _, stmt := range setup {
+		if err := svc.ExecuteSQL(ctx, stmt); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	qb := sqlstore.NewQueryBuilder("articles").Select("title").WhereCondition(store.FullText("body", "quick")).OrderBy("title", "ASC")
+	rows, err := svc.QueryExecutor().Query(ctx, qb)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var titles []string
+	for rows.Next() {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		titles = append(titles, title)
+	}
+	if len(titles) != 2 || titles[0] != "one" || titles[1] != "three" {
+		t.Errorf("unexpected matches: %v", titles)
+	}
+
+	qb = sqlstore.NewQueryBuilder("articles").Select("title").WhereCondition(store.Match("body", "lazy OR brown")).OrderBy("title", "ASC")
+	rows, err = svc.QueryExecutor().Query(ctx, qb)
+	if err != nil {
+		t.Fatalf("match query failed: %v", err)
+	}
+	defer rows.Close()
+
+	titles = nil
+	for rows.Next() {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		titles = append(titles, title)
+	}
+	if len(titles) != 2 || titles[0] != "one" || titles[1] != "two" {
+		t.Errorf("unexpected boolean matches: %v", titles)
+	}
+}
+
+// TestFullTextSearchServers runs against the databases named by the
+// <PREFIX>_TEST_HOST, <PREFIX>_TEST_USER, <PREFIX>_TEST_PASSWORD and
+// <PREFIX>_TEST_DATABASE environment variables, skipping each dialect whose
+// host is unset.
+func TestFullTextSearchServers(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		adapter adapter.Adapter
+		config  func(database, user, password string) store.Config
+		create  string
+		match   string // boolean query for store.Match
+	}{
+		{
+			name:    "mysql",
+			prefix:  "MYSQL",
+			adapter: adapter.NewMySQLAdapter(),
+			config:  store.MySQLConfig,
+			create:  "CREATE TABLE fts_articles (title VARCHAR(32), body TEXT, FULLTEXT (body)) ENGINE=InnoDB",
+			match:   "lazy brown",
+		},
+		{
+			name:    "postgres",
+			prefix:  "POSTGRES",
+			adapter: adapter.NewPostgreSQLAdapter(),
+			config:  store.PostgreSQLConfig,
+			create:  "CREATE TABLE fts_articles (title TEXT, body TEXT)",
+			match:   "lazy | brown",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := os.Getenv(tt.prefix + "_TEST_HOST")
+			if host == "" {
+				t.Skip(tt.prefix + "_TEST_HOST not set")
+			}
+			ctx := context.Background()
+
+			config := tt.config(os.Getenv(tt.prefix+"_TEST_DATABASE"), os.Getenv(tt.prefix+"_TEST_USER"), os.Getenv(tt.prefix+"_TEST_PASSWORD"))
+			config.Host = host
+			config.SSLMode = "disable"
+			svc, err := sqlstore.Open(ctx, tt.adapter, &config)
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			t.Cleanup(func() { _ = svc.Close() })
+
+			setup := []string{
+				"DROP TABLE IF EXISTS fts_articles",
+				tt.create,
+				"INSERT INTO fts_articles (title, body) VALUES ('one', 'the quick brown fox'), ('two', 'a lazy dog sleeps'), ('three', 'foxes are quick')",
+			}
+			for _, stmt := range setup {
+				if err := svc.ExecuteSQL(ctx, stmt); err != nil {
+					t.Fatalf("setup failed: %v", err)
+				}
+			}
+			t.Cleanup(func() { _ = svc.ExecuteSQL(ctx, "DROP TABLE fts_articles") })
+
+			titles := func(cond store.Condition) []string {
+				t.Helper()
+				qb := sqlstore.NewQueryBuilder("fts_articles").Select("title").WhereCondition(cond).OrderBy("title", "ASC")
+				rows, err := svc.QueryExecutor().Query(ctx, qb)
+				if err != nil {
+					t.Fatalf("query failed: %v", err)
+				}
+				defer rows.Close()
+
+				var titles []string
+				for rows.Next() {
+					var title string
+					if err := rows.Scan(&title); err != nil {
+						t.Fatalf("scan: %v", err)
+					}
+					titles = append(titles, title)
+				}
+				return titles
+			}
+
+			if got := titles(store.FullText("body", "quick")); len(got) != 2 || got[0] != "one" || got[1] != "three" {
+				t.Errorf("unexpected matches: %v", got)
+			}
+			if got := titles(store.Match("body", tt.match)); len(got) != 2 || got[0] != "one" || got[1] != "two" {
+				t.Errorf("unexpected boolean matches: %v", got)
+			}
+		})
+	}
+}
+
+func TestCompileNotNode(t *testing.T) {
+	qb := sqlstore.NewQueryBuilder("orders").
+		WhereCondition(store.Ne("customer", "dave")).
+		WhereNode(store.Not(store.And(store.Eq("status", "paid"), store.Gt("amount", 10))))
+
+	query, args, err := qb.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	want := "SELECT * FROM orders WHERE customer != $1 AND NOT (status = $2 AND amount > $3)"
+	if query != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, want)
+	}
+	if len(args) != 3 || args[0] != "dave" || args[1] != "paid" || args[2] != 10 {
+		t.Errorf("unexpected args: %v", args)
+	}
+
+	leaf, _, err := sqlstore.NewQueryBuilder("orders").WhereNode(store.Not(store.Eq("status", "open"))).Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if want := "SELECT * FROM orders WHERE NOT (status = $1)"; leaf != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", leaf, want)
+	}
+}
+
+func TestNotNodeMatchesRows(t *testing.T) {
+	qe := newOrdersExecutor(t)
+	ctx := context.Background()
+
+	qb := sqlstore.NewQueryBuilder("orders").
+		WhereNode(store.Not(store.And(store.Eq("status", "paid"), store.Ge("amount", 10))))
+	count, err := qe.Count(ctx, qb)
+	if err != nil {
+		t.Fatalf("count failed: %v", err)
+	}
+	// Paid orders of 10 or more: alice 10, alice 20, carol 30.
+	if count != 3 {
+		t.Errorf("expected 3 rows outside the negated group, got %d", count)
+	}
+}
+
+func TestCompileOrderByRandomPerDialect(t *testing.T) {
+	tests := []struct {
+		dialect sqlstore.Dialect
+		want    string
+	}{
+		{sqlstore.DialectPostgres, "SELECT * FROM orders ORDER BY status ASC, RANDOM() LIMIT 5"},
+		{sqlstore.DialectSQLite, "SELECT * FROM orders ORDER BY status ASC, RANDOM() LIMIT 5"},
+		{sqlstore.DialectMySQL, "SELECT * FROM orders ORDER BY status ASC, RAND() LIMIT 5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *testing.T) {
+			qb := sqlstore.NewQueryBuilder("orders").OrderBy("status", "ASC").OrderByRandom().Limit(5)
+			query, _, err := sqlstore.NewSQLCompiler().WithDialect(tt.dialect).CompileQuery(qb)
+			if err != nil {
+				t.Fatalf("compile failed: %v", err)
+			}
+			if query != tt.want {
+				t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileWithinBBox(t *testing.T) {
+	qb := sqlstore.NewQueryBuilder("places").WhereCondition(store.WithinBBox("geom", 40.0, -74.5, 41.0, -73.5))
+	query, args, err := sqlstore.NewSQLCompiler().WithGeoSpatial(true).CompileQuery(qb)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+
+	want := "SELECT * FROM places WHERE ST_Within(geom, ST_MakeEnvelope($1, $2, $3, $4, 4326))"
+	if query != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, want)
+	}
+	// ST_MakeEnvelope takes x (longitude) before y (latitude).
+	if len(args) != 4 || args[0] != -74.5 || args[1] != 40.0 || args[2] != -73.5 || args[3] != 41.0 {
+		t.Errorf("unexpected args: %v", args)
+	}
+}
+
+func TestWithinBBoxRejectedWithoutCapability(t *testing.T) {
+	cond := store.WithinBBox("geom", 0, 0, 1, 1)
+	compilers := map[string]*sqlstore.SQLCompiler{
+		"postgres without postgis": sqlstore.NewSQLCompiler(),
+		"sqlite":                   sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectSQLite).WithGeoSpatial(true),
+		"mysql":                    sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectMySQL).WithGeoSpatial(true),
+	}
+
+	for name, compiler := range compilers {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := compiler.CompileQuery(sqlstore.NewQueryBuilder("places").WhereCondition(cond))
+			if !errors.Is(err, store.ErrNotSupported) {
+				t.Fatalf("expected ErrNotSupported, got %v", err)
+			}
+		})
+	}
+}
+
+// TestWithinBBoxPostGIS runs against a PostGIS database named by the
+// POSTGIS_TEST_HOST, POSTGIS_TEST_USER, POSTGIS_TEST_PASSWORD and
+// POSTGIS_TEST_DATABASE environment variables.
+func TestWithinBBoxPostGIS(t *testing.T) {
+	host := os.Getenv("POSTGIS_TEST_HOST")
+	if host == "" {
+		t.Skip("POSTGIS_TEST_HOST not set")
+	}
+	ctx := context.Background()
+
+	config := store.PostgreSQLConfig(os.Getenv("POSTGIS_TEST_DATABASE"), os.Getenv("POSTGIS_TEST_USER"), os.Getenv("POSTGIS_TEST_PASSWORD"))
+	config.Host = host
+	config.SSLMode = "disable"
+	svc, err := sqlstore.Open(ctx, adapter.NewPostgreSQLAdapter().WithPostGIS(), &config)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { _ = svc.Close() })
+
+	setup := []string{
+		"CREATE EXTENSION IF NOT EXISTS postgis",
+		"DROP TABLE IF EXISTS bbox_places",
+		"CREATE TABLE bbox_places (name TEXT, geom geometry(Point, 4326))",
+		`INSERT INTO bbox_places (name, geom) VALUES
+			('manhattan', ST_SetSRID(ST_MakePoint(-73.97, 40.78), 4326)),
+			('boston', ST_SetSRID(ST_MakePoint(-71.06, 42.36), 4326))`,
+	}
+	for _, stmt := range setup {
+		if err := svc.ExecuteSQL(ctx, stmt); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	t.Cleanup(func() { _ = svc.ExecuteSQL(ctx, "DROP TABLE bbox_places") })
+
+	qb := sqlstore.NewQueryBuilder("bbox_places").Select("name").WhereCondition(store.WithinBBox("geom", 40.0, -74.5, 41.0, -73.5))
+	rows, err := svc.QueryExecutor().Query(ctx, qb)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		names = append(names, name)
+	}
+	if len(names) != 1 || names[0] != "manhattan" {
+		t.Errorf("unexpected matches: %v", names)
+	}
+}
+
+func TestCompileArrayOverlaps(t *testing.T) {
+	qb := sqlstore.NewQueryBuilder("posts").WhereCondition(store.ArrayOverlaps("tags", []any{"go", "sql"}))
+	query, args, err := sqlstore.NewSQLCompiler().CompileQuery(qb)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+
+	want := "SELECT * FROM posts WHERE tags && $1"
+	if query != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, want)
+	}
+	if len(args) != 1 {
+		t.Fatalf("expected one array argument, got %v", args)
+	}
+	value, err := args[0].(driver.Valuer).Value()
+	if err != nil || value != `{"go","sql"}` {
+		t.Errorf("unexpected array argument %v (err %v)", value, err)
+	}
+
+	for _, dialect := range []sqlstore.Dialect{sqlstore.DialectSQLite, sqlstore.DialectMySQL} {
+		_, _, err := sqlstore.NewSQLCompiler().WithDialect(dialect).CompileQuery(qb)
+		if !errors.Is(err, store.ErrNotSupported) {
+			t.Errorf("%s: expected ErrNotSupported, got %v", dialect, err)
+		}
+	}
+}
+
+// TestArrayOverlapsPostgres runs against a PostgreSQL database named by the
+// POSTGRES_TEST_HOST, POSTGRES_TEST_USER, POSTGRES_TEST_PASSWORD and
+// POSTGRES_TEST_DATABASE environment variables.
+func TestArrayOverlapsPostgres(t *testing.T) {
+	host := os.Getenv("POSTGRES_TEST_HOST")
+	if host == "" {
+		t.Skip("POSTGRES_TEST_HOST not set")
+	}
+	ctx := context.Background()
+
+	config := store.PostgreSQLConfig(os.Getenv("POSTGRES_TEST_DATABASE"), os.Getenv("POSTGRES_TEST_USER"), os.Getenv("POSTGRES_TEST_PASSWORD"))
+	config.Host = host
+	config.SSLMode = "disable"
+	svc, err := sqlstore.Open(ctx, adapter.NewPostgreSQLAdapter(), &config)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { _ = svc.Close() })
+
+	setup := []string{
+		"DROP TABLE IF EXISTS tagged_posts",
+		"CREATE TABLE tagged_posts (title TEXT, tags TEXT[])",
+		`INSERT INTO tagged_posts (title, tags) VALUES
+			('generics', '{go,types}'),
+			('indexes', '{sql,postgres}'),
+			('gardening', '{plants}')`,
+	}
+	for _, stmt := range setup {
+		if err := svc.ExecuteSQL(ctx, stmt); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	t.Cleanup(func() { _ = svc.ExecuteSQL(ctx, "DROP TABLE tagged_posts") })
+
+	qb := sqlstore.NewQueryBuilder("tagged_posts").Select("title").
+		WhereCondition(store.ArrayOverlaps("tags", []any{"go", "sql"})).
+		OrderBy("title", "ASC")
+	rows, err := svc.QueryExecutor().Query(ctx, qb)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var titles []string
+	for rows.Next() {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		titles = append(titles, title)
+	}
+	if len(titles) != 2 || titles[0] != "generics" || titles[1] != "indexes" {
+		t.Errorf("unexpected matches: %v", titles)
+	}
+}
+
+func TestCompileFieldCompare(t *testing.T) {
+	qb := sqlstore.NewQueryBuilder("orders").
+		WhereCondition(store.FieldCompare("shipped_at", store.OpGt, "due_at")).
+		WhereCondition(store.Eq("status", "open"))
+	query, args, err := sqlstore.NewSQLCompiler().CompileQuery(qb)
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+
+	want := "SELECT * FROM orders WHERE shipped_at > due_at AND status = $1"
+	if query != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, want)
+	}
+	if len(args) != 1 || args[0] != "open" {
+		t.Errorf("unexpected args: %v", args)
+	}
+
+	_, _, err = sqlstore.NewSQLCompiler().CompileQuery(sqlstore.NewQueryBuilder("orders").WhereCondition(store.FieldCompare("a", store.OpLike, "b")))
+	if !errors.Is(err, store.ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery, got %v", err)
+	}
+}
+
+func TestFieldCompareMatchesRows(t *testing.T) {
+	qe := newOrdersExecutor(t)
+
+	// Only dave's order (id 6, amount 1) has an amount below its id.
+	count, err := qe.Count(context.Background(), sqlstore.NewQueryBuilder("orders").WhereCondition(store.FieldCompare("amount", store.OpLt, "id")))
+	if err != nil {
+		t.Fatalf("count failed: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 row with amount < id, got %d", count)
+	}
+}
+
+func TestCompileDistinctFromPerDialect(t *testing.T) {
+	tests := []struct {
+		dialect sqlstore.Dialect
+		want    string
+	}{
+		{sqlstore.DialectPostgres, "SELECT * FROM orders WHERE status IS DISTINCT FROM $1"},
+		{sqlstore.DialectSQLite, "SELECT * FROM orders WHERE status IS DISTINCT FROM $1"},
+		{sqlstore.DialectMySQL, "SELECT * FROM orders WHERE NOT (status <=> ?)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *testing.T) {
+			for _, value := range []any{"open", nil} {
+				qb := sqlstore.NewQueryBuilder("orders").WhereCondition(store.DistinctFrom("status", value))
+				query, args, err := sqlstore.NewSQLCompiler().WithDialect(tt.dialect).CompileQuery(qb)
+				if err != nil {
+					t.Fatalf("compile failed: %v", err)
+				}
+				if query != tt.want {
+					t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, tt.want)
+				}
+				if len(args) != 1 || args[0] != value {
+					t.Errorf("unexpected args: %v", args)
+				}
+			}
+		})
+	}
+}
+
+func TestDistinctFromMatchesNullRows(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	setup := []string{
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, status TEXT)",
+		"INSERT INTO orders (status) VALUES ('paid'), ('paid'), ('open'), (NULL)",
+	}
+	for _, stmt := range setup {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	qe := sqlstore.NewQueryExecutor(db, sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectSQLite))
+
+	tests := []struct {
+		name string
+		cond store.Condition
+		want int64
+	}{
+		{"ne excludes null", store.Ne("status", "paid"), 1},
+		{"distinct from includes null", store.DistinctFrom("status", "paid"), 2},
+		{"distinct from null", store.DistinctFrom("status", nil), 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count, err := qe.Count(ctx, sqlstore.NewQueryBuilder("orders").WhereCondition(tt.cond))
+			if err != nil {
+				t.Fatalf("count failed: %v", err)
+			}
+			if count != tt.want {
+				t.Errorf("got %d rows, want %d", count, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileLikeEscapesMetacharacters(t *testing.T) {
+	tests := []struct {
+		dialect sqlstore.Dialect
+		cond    store.Condition
+		want    string
+		arg     string
+	}{
+		{sqlstore.DialectPostgres, store.Contains("label", "50%"), `SELECT * FROM items WHERE label LIKE $1 ESCAPE '\'`, `%50\%%`},
+		{sqlstore.DialectSQLite, store.Prefix("label", "a_b"), `SELECT * FROM items WHERE label LIKE $1 ESCAPE '\'`, `a\_b%`},
+		{sqlstore.DialectMySQL, store.Suffix("label", `c:\dir`), `SELECT * FROM items WHERE label LIKE ? ESCAPE '\\'`, `%c:\\dir`},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *testing.T) {
+			qb := sqlstore.NewQueryBuilder("items").WhereCondition(tt.cond)
+			query, args, err := sqlstore.NewSQLCompiler().WithDialect(tt.dialect).CompileQuery(qb)
+			if err != nil {
+				t.Fatalf("compile failed: %v", err)
+			}
+			if query != tt.want {
+				t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, tt.want)
+			}
+			if len(args) != 1 || args[0] != tt.arg {
+				t.Errorf("unexpected args: %v", args)
+			}
+		})
+	}
+}
+
+func TestLikeMatchesMetacharactersLiterally(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	setup := []string{
+		"CREATE TABLE items (id INTEGER PRIMARY KEY, label TEXT)",
+		`INSERT INTO items (label) VALUES ('50% off'), ('500 off'), ('a_b'), ('axb'), ('100%')`,
+	}
+	for _, stmt := range setup {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	qe := sqlstore.NewQueryExecutor(db, sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectSQLite))
+
+	tests := []struct {
+		name string
+		cond store.Condition
+		want int64
+	}{
+		{"contains percent", store.Contains("label", "0%"), 2},
+		{"prefix percent", store.Prefix("label", "50%"), 1},
+		{"suffix percent", store.Suffix("label", "%"), 1},
+		{"contains underscore", store.Contains("label", "_"), 1},
+		{"prefix underscore", store.Prefix("label", "a_"), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count, err := qe.Count(ctx, sqlstore.NewQueryBuilder("items").WhereCondition(tt.cond))
+			if err != nil {
+				t.Fatalf("count failed: %v", err)
+			}
+			if count != tt.want {
+				t.Errorf("got %d rows, want %d", count, tt.want)
+			}
+		})
+	}
+}
+
+func TestBetweenExBounds(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	setup := []string{
+		"CREATE TABLE readings (id INTEGER PRIMARY KEY, hour INTEGER)",
+		"INSERT INTO readings (hour) VALUES (8), (9), (10), (11), (12)",
+	}
+	for _, stmt := range setup {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	qe := sqlstore.NewQueryExecutor(db, sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectSQLite))
+
+	tests := []struct {
+		name            string
+		incLow, incHigh bool
+		wantSQL         string
+		wantCount       int64
+	}{
+		{"inclusive", true, true, "SELECT * FROM readings WHERE hour BETWEEN $1 AND $2", 3},
+		{"exclusive upper", true, false, "SELECT * FROM readings WHERE (hour >= $1 AND hour < $2)", 2},
+		{"exclusive lower", false, true, "SELECT * FROM readings WHERE (hour > $1 AND hour <= $2)", 2},
+		{"exclusive", false, false, "SELECT * FROM readings WHERE (hour > $1 AND hour < $2)", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cond := store.BetweenEx("hour", 9, 11, tt.incLow, tt.incHigh)
+
+			query, args, err := sqlstore.NewSQLCompiler().CompileQuery(sqlstore.NewQueryBuilder("readings").WhereCondition(cond))
+			if err != nil {
+				t.Fatalf("compile failed: %v", err)
+			}
+			if query != tt.wantSQL {
+				t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, tt.wantSQL)
+			}
+			if len(args) != 2 || args[0] != 9 || args[1] != 11 {
+				t.Errorf("unexpected args: %v", args)
+			}
+
+			count, err := qe.Count(ctx, sqlstore.NewQueryBuilder("readings").WhereCondition(cond))
+			if err != nil {
+				t.Fatalf("count failed: %v", err)
+			}
+			if count != tt.wantCount {
+				t.Errorf("got %d rows, want %d", count, tt.wantCount)
+			}
+		})
+	}
+
+	_, _, err := sqlstore.NewSQLCompiler().CompileQuery(sqlstore.NewQueryBuilder("readings").WhereCondition(store.Condition{Field: "hour", Op: store.OpBetween, Value: 9}))
+	if !errors.Is(err, store.ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery for malformed bounds, got %v", err)
+	}
+}
+
+func TestCompileSubqueries(t *testing.T) {
+	paying := sqlstore.NewQueryBuilder("orders").Select("customer").Where("status", "=", "paid").Where("amount", ">=", 10)
+	qb := sqlstore.NewQueryBuilder("customers").
+		Where("region", "=", "eu").
+		WhereInSubquery("name", paying).
+		WhereCondition(store.NotInQuery("name", store.Query{From: "bans", Columns: []string{"name"}, Where: []store.Condition{store.Eq("active", true)}})).
+		Where("tier", ">", 1)
+
+	query, args, err := qb.Build()
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	want := "SELECT * FROM customers WHERE region = $1 AND name IN (SELECT customer FROM orders WHERE status = $2 AND amount >= $3) AND name NOT IN (SELECT name FROM bans WHERE active = $4) AND tier > $5"
+	if query != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, want)
+	}
+	if fmt.Sprint(args) != "[eu paid 10 true 1]" {
+		t.Errorf("unexpected args: %v", args)
+	}
+
+	totals := sqlstore.NewQueryBuilder("orders").Select("customer").SelectSum("amount", "total").Where("status", "=", "paid").GroupBy("customer")
+	outer := sqlstore.NewSubqueryBuilder(totals, "t").Select("customer").Where("total", ">", 15)
+	query, args, err = outer.Build()
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	want = "SELECT customer FROM (SELECT customer, SUM(amount) AS total FROM orders WHERE status = $1 GROUP BY customer) t WHERE total > $2"
+	if query != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, want)
+	}
+	if fmt.Sprint(args) != "[paid 15]" {
+		t.Errorf("unexpected args: %v", args)
+	}
+
+	bad := sqlstore.NewQueryBuilder("customers").WhereCondition(store.Condition{Field: "name", Op: store.OpEq, Value: paying})
+	if _, _, err := bad.Build(); !errors.Is(err, store.ErrInvalidQuery) {
+		t.Errorf("expected a subquery compared with = to fail with ErrInvalidQuery, got %v", err)
+	}
+
+	invalid := sqlstore.NewQueryBuilder("orders; DROP TABLE orders").Select("customer")
+	for _, qb := range []*sqlstore.QueryBuilder{
+		sqlstore.NewQueryBuilder("customers").WhereInSubquery("name", invalid),
+		sqlstore.NewQueryBuilder("customers").WhereNode(store.Or(store.Eq("vip", true), store.Condition{Field: "name", Op: store.OpIn, Value: invalid})),
+	} {
+		if _, _, err := qb.Build(); !errors.Is(err, store.ErrInvalidQuery) {
+			t.Errorf("expected an invalid subquery to fail with ErrInvalidQuery, got %v", err)
+		}
+	}
+}
+
+func TestSubqueriesFilterRows(t *testing.T) {
+	qe := newOrdersExecutor(t)
+	ctx := context.Background()
+
+	// Orders of customers with at least one open order
+	withOpen := sqlstore.NewQueryBuilder("orders").Select("customer").Where("status", "=", "open")
+	n, err := qe.Count(ctx, sqlstore.NewQueryBuilder("orders").Where("status", "=", "paid").WhereInSubquery("customer", withOpen))
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected bob's single paid order, got %d", n)
+	}
+
+	// Customers whose paid orders total at least 30
+	totals := sqlstore.NewQueryBuilder("orders").Select("customer").SelectSum("amount", "total").Where("status", "=", "paid").GroupBy("customer")
+	n, err = qe.Count(ctx, sqlstore.NewSubqueryBuilder(totals, "t").Where("total", ">=", 30))
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected alice and carol, got %d", n)
+	}
+}
+
+func TestCompileCommonTableExpressions(t *testing.T) {
+	big := sqlstore.NewQueryBuilder("orders").Where("amount", ">=", 10)
+	qb := sqlstore.NewQueryBuilder("big").With("big", big).Where("status", "=", "paid")
+
+	query, args, err := qb.Build()
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	want := "WITH big AS (SELECT * FROM orders WHERE amount >= $1) SELECT * FROM big WHERE status = $2"
+	if query != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, want)
+	}
+	if fmt.Sprint(args) != "[10 paid]" {
+		t.Errorf("unexpected args: %v", args)
+	}
+
+	anchor := sqlstore.NewQueryBuilder("categories").Select("id", "parent_id").Where("id", "=", 1)
+	step := sqlstore.NewQueryBuilder("categories").
+		Select("categories.id", "categories.parent_id").
+		Join("tree", "categories.parent_id", "tree.id").
+		Where("categories.hidden", "=", false)
+	qb = sqlstore.NewQueryBuilder("tree").WithRecursive("tree", []string{"id", "parent_id"}, anchor, step).Where("id", "!=", 1)
+
+	query, args, err = qb.Build()
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	want = "WITH RECURSIVE tree (id, parent_id) AS (SELECT id, parent_id FROM categories WHERE id = $1 UNION ALL " +
+		"SELECT categories.id, categories.parent_id FROM categories JOIN tree ON categories.parent_id = tree.id WHERE categories.hidden = $2) " +
+		"SELECT * FROM tree WHERE id != $3"
+	if query != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, want)
+	}
+	if fmt.Sprint(args) != "[1 false 1]" {
+		t.Errorf("unexpected args: %v", args)
+	}
+
+	bad := sqlstore.NewQueryBuilder("x").With("x; DROP TABLE orders", big)
+	if _, _, err := bad.Build(); !errors.Is(err, store.ErrInvalidQuery) {
+		t.Errorf("expected an unsafe CTE name to fail with ErrInvalidQuery, got %v", err)
+	}
+}
+
+func TestRecursiveCommonTableExpression(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	setup := []string{
+		"CREATE TABLE categories (id INTEGER PRIMARY KEY, parent_id INTEGER, name TEXT)",
+		`INSERT INTO categories (id, parent_id, name) VALUES
+			(1, NULL, 'root'), (2, 1, 'books'), (3, 1, 'music'),
+			(4, 2, 'fiction'), (5, 4, 'crime'), (6, NULL, 'other')`,
+	}
+	for _, stmt := range setup {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	qe := sqlstore.NewQueryExecutor(db, sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectSQLite))
+
+	descendants := func(root int) *sqlstore.QueryBuilder {
+		anchor := sqlstore.NewQueryBuilder("categories").Select("id").Where("id", "=", root)
+		step := sqlstore.NewQueryBuilder("categories").Select("categories.id").Join("tree", "categories.parent_id", "tree.id")
+		return sqlstore.NewQueryBuilder("tree").WithRecursive("tree", []string{"id"}, anchor, step).Where("id", "!=", root)
+	}
+
+	for root, want := range map[int]int64{1: 4, 2: 2, 3: 0, 6: 0} {
+		n, err := qe.Count(ctx, descendants(root))
+		if err != nil {
+			t.Fatalf("count: %v", err)
+		}
+		if n != want {
+			t.Errorf("expected %d descendants of %d, got %d", want, root, n)
+		}
+	}
+}
+
+func TestCompileWindowFunctions(t *testing.T) {
+	qb := sqlstore.NewQueryBuilder("orders").
+		Select("id").
+		SelectWindow(sqlstore.RowNumber().OverPartitionBy("customer").OrderBy("amount", "DESC").OrderBy("id", "ASC"), "rn").
+		SelectWindow(sqlstore.Lag("amount", 1).OrderBy("id", "ASC"), "previous").
+		SelectWindow(sqlstore.Rank(), "")
+
+	query, _, err := qb.Build()
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	want := "SELECT id, ROW_NUMBER() OVER (PARTITION BY customer ORDER BY amount DESC, id ASC) AS rn, " +
+		"LAG(amount, 1) OVER (ORDER BY id ASC) AS previous, RANK() OVER () FROM orders"
+	if query != want {
+		t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, want)
+	}
+
+	bad := sqlstore.NewQueryBuilder("orders").SelectWindow(sqlstore.RowNumber().OverPartitionBy("customer; DROP TABLE orders"), "rn")
+	if _, _, err := bad.Build(); !errors.Is(err, store.ErrInvalidQuery) {
+		t.Errorf("expected an unsafe partition column to fail with ErrInvalidQuery, got %v", err)
+	}
+}
+
+func TestWindowTopNPerGroup(t *testing.T) {
+	qe := newOrdersExecutor(t)
+	ctx := context.Background()
+
+	// Largest order of each customer
+	ranked := sqlstore.NewQueryBuilder("orders").
+		Select("customer", "amount").
+		SelectWindow(sqlstore.RowNumber().OverPartitionBy("customer").OrderBy("amount", "DESC"), "rn")
+	top := sqlstore.NewSubqueryBuilder(ranked, "ranked").Where("rn", "=", 1)
+
+	n, err := qe.Count(ctx, top)
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("expected one order per customer, got %d", n)
+	}
+
+	n, err = qe.Count(ctx, sqlstore.NewSubqueryBuilder(ranked, "ranked").Where("rn", "=", 1).Where("amount", ">=", 20))
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected the top orders of alice and carol, got %d", n)
+	}
+}
+
+func TestCompileRowLocks(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect sqlstore.Dialect
+		qb      *sqlstore.QueryBuilder
+		want    string
+	}{
+		{"update", sqlstore.DialectPostgres, sqlstore.NewQueryBuilder("jobs").Where("id", "=", 1).LockForUpdate(),
+			"SELECT * FROM jobs WHERE id = $1 FOR UPDATE"},
+		{"skip locked", sqlstore.DialectPostgres, sqlstore.NewQueryBuilder("jobs").Where("state", "=", "queued").OrderBy("id", "ASC").Limit(10).LockForUpdate(sqlstore.SkipLocked),
+			"SELECT * FROM jobs WHERE state = $1 ORDER BY id ASC LIMIT 10 FOR UPDATE SKIP LOCKED"},
+		{"share nowait", sqlstore.DialectMySQL, sqlstore.NewQueryBuilder("jobs").Where("id", "=", 1).LockForShare(sqlstore.NoWait),
+			"SELECT * FROM jobs WHERE id = ? FOR SHARE NOWAIT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _, err := sqlstore.NewSQLCompiler().WithDialect(tt.dialect).CompileQuery(tt.qb)
+			if err != nil {
+				t.Fatalf("compile failed: %v", err)
+			}
+			if query != tt.want {
+				t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, tt.want)
+			}
+		})
+	}
+
+	sqlite := sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectSQLite)
+	if _, _, err := sqlite.CompileQuery(sqlstore.NewQueryBuilder("jobs").LockForUpdate()); !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("expected row locking on SQLite to fail with ErrNotSupported, got %v", err)
+	}
+	both := sqlstore.NewQueryBuilder("jobs").LockForUpdate(sqlstore.NoWait, sqlstore.SkipLocked)
+	if _, _, err := both.Build(); !errors.Is(err, store.ErrInvalidQuery) {
+		t.Errorf("expected NOWAIT with SKIP LOCKED to fail with ErrInvalidQuery, got %v", err)
+	}
+}
+
+func TestCompileDistinct(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect sqlstore.Dialect
+		qb      *sqlstore.QueryBuilder
+		want    string
+	}{
+		{"distinct", sqlstore.DialectMySQL, sqlstore.NewQueryBuilder("orders").Distinct().Select("customer").Where("status", "=", "paid"),
+			"SELECT DISTINCT customer FROM orders WHERE status = ?"},
+		{"distinct on", sqlstore.DialectPostgres, sqlstore.NewQueryBuilder("orders").DistinctOn("customer").Select("customer", "amount").OrderBy("customer", "ASC").OrderBy("amount", "DESC"),
+			"SELECT DISTINCT ON (customer) customer, amount FROM orders ORDER BY customer ASC, amount DESC"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _, err := sqlstore.NewSQLCompiler().WithDialect(tt.dialect).CompileQuery(tt.qb)
+			if err != nil {
+				t.Fatalf("compile failed: %v", err)
+			}
+			if query != tt.want {
+				t.Errorf("unexpected SQL:\n got: %s\nwant: %s", query, tt.want)
+			}
+		})
+	}
+
+	mysql := sqlstore.NewSQLCompiler().WithDialect(sqlstore.DialectMySQL)
+	if _, _, err := mysql.CompileQuery(sqlstore.NewQueryBuilder("orders").DistinctOn("customer")); !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("expected DISTINCT ON on MySQL to fail with ErrNotSupported, got %v", err)
+	}
+}
+
+func TestDistinctRows(t *testing.T) {
+	qe := newOrdersExecutor(t)
+	ctx := context.Background()
+
+	n, err := qe.CountSubquery(ctx, sqlstore.NewQueryBuilder("orders").Distinct().Select("customer").Where("status", "=", "paid"))
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 distinct paying customers, got %d", n)
+	}
+}
+
+func TestExplainSQLite(t *testing.T) {
+	qe := newOrdersExecutor(t)
+	ctx := context.Background()
+
+	plan, err := qe.Explain(ctx, sqlstore.NewQueryBuilder("orders").Where("id", "=", 3), false)
+	if err != nil {
+		t.Fatalf("explain: %v", err)
+	}
+	if plan.SQL != "SELECT * FROM orders WHERE id = $1" || len(plan.Args) != 1 {
+		t.Errorf("unexpected compiled query: %s %v", plan.SQL, plan.Args)
+	}
+	if len(plan.Nodes) != 1 || plan.Nodes[0].Operation != "SEARCH" || plan.Nodes[0].Table != "orders" {
+		t.Errorf("expected a primary key search on orders, got %+v (%s)", plan.Nodes, plan.Raw)
+	}
+
+	plan, err = qe.Explain(ctx, sqlstore.NewQueryBuilder("orders").Where("customer", "=", "bob"), false)
+	if err != nil {
+		t.Fatalf("explain: %v", err)
+	}
+	if len(plan.Nodes) != 1 || plan.Nodes[0].Operation != "SCAN" {
+		t.Errorf("expected a full scan without an index on customer, got %+v (%s)", plan.Nodes, plan.Raw)
+	}
+
+	if _, err := qe.Explain(ctx, sqlstore.NewQueryBuilder("orders"), true); !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("expected EXPLAIN ANALYZE on SQLite to fail with ErrNotSupported, got %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"store"
+)
+
+// replicaRetryAfter is how long a replica that failed with a connection
+// error is skipped before reads are routed to it again.
+const replicaRetryAfter = 5 * time.Second
+
+// ReplicaPolicy chooses the replica serving a read. Pick receives the number
+// of healthy replicas and returns an index in [0, n).
+type ReplicaPolicy interface {
+	Pick(n int) int
+}
+
+// ReplicaPolicyFunc adapts a function to the ReplicaPolicy interface.
+type ReplicaPolicyFunc func(n int) int
+
+// Pick calls f.
+func (f ReplicaPolicyFunc) Pick(n int) int {
+	return f(n)
+}
+
+// RoundRobinReplicas returns a policy cycling through the replicas. It is
+// the default policy.
+func RoundRobinReplicas() ReplicaPolicy {
+	var next atomic.Uint64
+	return ReplicaPolicyFunc(func(n int) int {
+		return int((next.Add(1) - 1) % uint64(n))
+	})
+}
+
+// RandomReplicas returns a policy picking a replica at random.
+func RandomReplicas() ReplicaPolicy {
+	return ReplicaPolicyFunc(func(n int) int {
+		return rand.IntN(n)
+	})
+}
+
+type primaryReadsKey struct{}
+
+// WithPrimaryReads returns a context whose reads run on the primary instead
+// of a replica, so that they see the writes made just before despite
+// replication lag.
+func WithPrimaryReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadsKey{}, true)
+}
+
+// readsFromPrimary reports whether ctx was made by WithPrimaryReads.
+func readsFromPrimary(ctx context.Context) bool {
+	v, _ := ctx.Value(primaryReadsKey{}).(bool)
+	return v
+}
+
+// replica is a read replica and the time until which it is considered down.
+type replica struct {
+	db        *sql.DB
+	downUntil atomic.Int64 // unix nanoseconds
+}
+
+// replicaSet routes reads to read replicas, falling back to the primary
+// when none is healthy or the chosen one fails. It satisfies queryer.
+type replicaSet struct {
+	primary *sql.DB
+	// isDown reports whether an error means the replica is unreachable.
+	isDown func(error) bool
+	// onDown is called when a replica is marked down.
+	onDown func(error)
+
+	mu       sync.RWMutex
+	policy   ReplicaPolicy
+	replicas []*replica
+}
+
+func newReplicaSet(primary *sql.DB, isDown func(error) bool, onDown func(error)) *replicaSet {
+	return &replicaSet{primary: primary, isDown: isDown, onDown: onDown, policy: RoundRobinReplicas()}
+}
+
+func (rs *replicaSet) add(db *sql.DB) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.replicas = append(rs.replicas, &replica{db: db})
+}
+
+func (rs *replicaSet) setPolicy(policy ReplicaPolicy) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.policy = policy
+}
+
+// len returns the number of replicas, healthy or not.
+func (rs *replicaSet) len() int {
+	if rs == nil {
+		return 0
+	}
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return len(rs.replicas)
+}
+
+// pick returns a healthy replica chosen by the policy, or nil when there is
+// none or ctx reads from the primary.
+func (rs *replicaSet) pick(ctx context.Context) *replica {
+	if readsFromPrimary(ctx) {
+		return nil
+	}
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	now := time.Now().UnixNano()
+	healthy := make([]*replica, 0, len(rs.replicas))
+	for _, r := range rs.replicas {
+		if r.downUntil.Load() <= now {
+			healthy = append(healthy, r)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	return healthy[rs.policy.Pick(len(healthy))]
+}
+
+// failed reports whether err is a replica failure, marking the replica down
+// when it is.
+func (rs *replicaSet) failed(r *replica, err error) bool {
+	if err == nil || !(errors.Is(err, driver.ErrBadConn) || rs.isDown(err)) {
+		return false
+	}
+	r.downUntil.Store(time.Now().Add(replicaRetryAfter).UnixNano())
+	rs.onDown(err)
+	return true
+}
+
+// QueryContext runs a read on a replica, or on the primary when the replica fails.
+func (rs *replicaSet) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if r := rs.pick(ctx); r != nil {
+		rows, err := r.db.QueryContext(ctx, query, args...)
+		if !rs.failed(r, err) {
+			return rows, err
+		}
+	}
+	return rs.primary.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext runs a single-row read on a replica, or on the primary
+// when the replica fails.
+func (rs *replicaSet) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	if r := rs.pick(ctx); r != nil {
+		row := r.db.QueryRowContext(ctx, query, args...)
+		if !rs.failed(r, row.Err()) {
+			return row
+		}
+	}
+	return rs.primary.QueryRowContext(ctx, query, args...)
+}
+
+// beginRead starts a read-only transaction on a replica, or on the primary
+// when the replica fails.
+func (rs *replicaSet) beginRead(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	if r := rs.pick(ctx); r != nil {
+		tx, err := r.db.BeginTx(ctx, opts)
+		if !rs.failed(r, err) {
+			return tx, err
+		}
+	}
+	return rs.primary.BeginTx(ctx, opts)
+}
+
+// dbs returns the replica handles.
+func (rs *replicaSet) dbs() []*sql.DB {
+	if rs == nil {
+		return nil
+	}
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	dbs := make([]*sql.DB, len(rs.replicas))
+	for i, r := range rs.replicas {
+		dbs[i] = r.db
+	}
+	return dbs
+}
+
+// close closes every replica.
+func (rs *replicaSet) close() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	var errs []error
+	for _, r := range rs.replicas {
+		errs = append(errs, r.db.Close())
+	}
+	rs.replicas = nil
+	return errors.Join(errs...)
+}
+
+// AddReplica routes reads outside write transactions to db as well: queries
+// run through QueryExecutor and repositories, and read-only transactions.
+// Reads under a context made by WithPrimaryReads stay on the primary.
+// Replicas failing with a connection error are skipped for a while, and
+// their reads run on the primary. The service closes db when it is closed.
+func (s *Service) AddReplica(db *sql.DB) {
+	s.replicaSet().add(db)
+	s.registerPoolGauges()
+}
+
+// SetReplicaPolicy sets the policy choosing among healthy replicas.
+func (s *Service) SetReplicaPolicy(policy ReplicaPolicy) {
+	s.replicaSet().setPolicy(policy)
+}
+
+// replicaSet returns the replicas of the service, created on connect so
+// that executors and repositories share them.
+func (s *Service) replicaSet() *replicaSet {
+	if s.replicas == nil {
+		s.replicas = newReplicaSet(s.db, s.isConnectionFailure, s.replicaDown)
+	}
+	return s.replicas
+}
+
+// replicaDown logs a replica marked down after err.
+func (s *Service) replicaDown(err error) {
+	s.logger.Log(context.Background(), slog.LevelWarn, "read replica failed, reading from the primary",
+		"backend", s.adapter.Name(), "retry_after", replicaRetryAfter, "error", err)
+}
+
+// isConnectionFailure reports whether the adapter translates err to a
+// connection failure.
+func (s *Service) isConnectionFailure(err error) bool {
+	return errors.Is(s.adapter.TranslateError(err), store.ErrConnectionFailed)
+}
+
+// Replicas returns the number of read replicas.
+func (s *Service) Replicas() int {
+	return s.replicas.len()
+}
+
+// connectReplicas connects the replicas of the service config.
+func (s *Service) connectReplicas(ctx context.Context) error {
+	for i := range s.config.Replicas {
+		cfg := &s.config.Replicas[i]
+		db, err := s.adapter.Connect(ctx, cfg)
+		if err != nil {
+			return store.WrapConnectionError(err, "connect_replica", string(s.adapter.Name()), cfg.Host)
+		}
+		s.AddReplica(db)
+	}
+	return nil
+}
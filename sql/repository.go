@@ -3,6 +3,12 @@ package sqlstore
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
 	"core/entity"
 	"store"
@@ -13,10 +19,35 @@ type Repository struct {
 	*store.RepositoryBase
 
 	sqlService         *Service
+	compiler           *SQLCompiler
 	transactionHandler *TransactionHandler
 	mutationExecutor   *MutationExecutor
+
+	// tx, when set, is the transaction every operation runs in.
+	tx *sql.Tx
+
+	// fieldNames maps lowercased column names to the entity's field names.
+	fieldNames map[string]string
+
+	// insertChunkSize is the number of rows per INSERT statement in CreateBatch.
+	insertChunkSize int
+
+	// listOrder, when set, is the ordering of List instead of creation time.
+	listOrder []store.Order
+
+	// paginator, when set, bounds List pages and encodes their cursors
+	// instead of listPaginator.
+	paginator *store.Paginator
+
+	// idColumnErr reports an ID column that is not a plain identifier. It is
+	// returned when IDs and entities are validated.
+	idColumnErr error
 }
 
+// defaultInsertChunkSize is the number of rows CreateBatch inserts per
+// statement unless WithInsertChunkSize overrides it.
+const defaultInsertChunkSize = 500
+
 // Ensure Repository implements store.Repository
 var _ store.Repository = (*Repository)(nil)
 
@@ -24,50 +55,173 @@ var _ store.Repository = (*Repository)(nil)
 func NewRepository(service *Service, ent entity.Entity) *Repository {
 	base := store.NewRepositoryBase(ent)
 
+	compiler := service.compiler
+	if compiler == nil {
+		compiler = defaultCompiler
+	}
+
+	if sc, ok := ent.(SensitiveColumner); ok {
+		service.redactor.AddColumns(sc.SensitiveColumns()...)
+	}
+
+	mutationExecutor := NewMutationExecutor(service.db).WithCompiler(compiler)
+	mutationExecutor.logQuery = service.logQuery
+	mutationExecutor.beforeQuery = service.beforeQuery
+
 	return &Repository{
 		RepositoryBase:     base,
 		sqlService:         service,
-		transactionHandler: NewTransactionHandler(service.db, service.adapter),
-		mutationExecutor:   NewMutationExecutor(service.db),
+		compiler:           compiler,
+		transactionHandler: service.TransactionHandler(),
+		mutationExecutor:   mutationExecutor,
+		fieldNames:         fieldNamesOf(ent),
+		idColumnErr:        checkIdentifier("id column", base.IDColumn()),
+	}
+}
+
+// WithIDColumn returns a copy of the repository that uses column as the
+// primary key instead of the entity's default. A column that is not a plain
+// identifier makes the repository's operations fail with
+// store.ErrInvalidQuery.
+func (r *Repository) WithIDColumn(column string) *Repository {
+	clone := *r
+	clone.RepositoryBase = r.RepositoryBase.WithIDColumn(column)
+	clone.idColumnErr = checkIdentifier("id column", column)
+	return &clone
+}
+
+// WithInsertChunkSize returns a copy of the repository whose CreateBatch
+// inserts at most n rows per statement. A value <= 0 uses
+// defaultInsertChunkSize. Chunks are made smaller when a statement would
+// exceed the compiler's parameter limit.
+func (r *Repository) WithInsertChunkSize(n int) *Repository {
+	clone := *r
+	clone.insertChunkSize = n
+	return &clone
+}
+
+// WithListOrder returns a copy of the repository whose List orders entities
+// by orders, then ID, instead of by creation time. Its cursors carry the
+// value of every ordering column, so they only resume lists of the same
+// ordering.
+func (r *Repository) WithListOrder(orders ...store.Order) *Repository {
+	clone := *r
+	clone.listOrder = slices.Clone(orders)
+	return &clone
+}
+
+// WithPaginator returns a copy of the repository whose List bounds page sizes
+// and encodes cursors with p, for instance to sign them with a CursorSecret.
+func (r *Repository) WithPaginator(p *store.Paginator) *Repository {
+	clone := *r
+	clone.paginator = p
+	return &clone
+}
+
+// WithTx returns a copy of the repository bound to tx. All operations on the
+// returned repository run in tx, regardless of the transaction in the context.
+// The caller remains responsible for committing or rolling back tx.
+func (r *Repository) WithTx(tx *sql.Tx) *Repository {
+	clone := *r
+	clone.tx = tx
+	return &clone
+}
+
+// bindTx places the bound transaction, if any, into ctx.
+func (r *Repository) bindTx(ctx context.Context) context.Context {
+	if r.tx == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, txContextKey{}, r.tx)
+}
+
+// conn returns the transaction from context or the database handle.
+func (r *Repository) conn(ctx context.Context) queryer {
+	if tx, ok := TransactionFromContext(ctx); ok && tx != nil {
+		return withQueryLog(tx, r.sqlService.beforeQuery, r.sqlService.logQuery)
 	}
+	if r.sqlService.replicas.len() > 0 {
+		return withQueryLog(r.sqlService.replicas, r.sqlService.beforeQuery, r.sqlService.logQuery)
+	}
+	return withQueryLog(r.sqlService.db, r.sqlService.beforeQuery, r.sqlService.logQuery)
 }
 
 // Core CRUD operations
 
 // Create stores a new entity in the database.
 func (r *Repository) Create(ctx context.Context, ent entity.Entity) error {
+	ctx = r.bindTx(ctx)
+
 	if err := r.Validate(ctx, ent); err != nil {
 		return err
 	}
 
 	r.SetTimestamps(ent, true)
+	r.SetAuditFields(ctx, ent, true)
 
 	return r.transactionHandler.WithTx(ctx, func(ctxTx context.Context) error {
 		values := entity.ToMap(ent)
 		mutation := store.Insert{Values: values}
 
-		compiled, err := CompileMutation(r.TableName(), mutation)
+		compiled, err := r.compiler.CompileMutation(r.TableName(), mutation)
 		if err != nil {
-			return r.HandleUpdateError(err, "create", ent.GetID())
+			return r.handleUpdateError(ctx, err, "create", ent.GetID())
 		}
 
 		_, err = r.mutationExecutor.ExecuteCompiled(ctxTx, *compiled)
-		return r.HandleUpdateError(err, "create", ent.GetID())
+		if err != nil {
+			return r.handleUpdateError(ctx, err, "create", ent.GetID())
+		}
+
+		r.invalidateCountAfterCommit(ctxTx)
+		return nil
 	})
 }
 
+// Upsert creates the entity or, when an entity with the same ID exists,
+// overwrites it. The existing row keeps its created_at timestamp.
+func (r *Repository) Upsert(ctx context.Context, ent entity.Entity) error {
+	ctx = r.bindTx(ctx)
+
+	if err := r.Validate(ctx, ent); err != nil {
+		return err
+	}
+
+	r.SetTimestamps(ent, true)
+	r.SetAuditFields(ctx, ent, true)
+
+	values := entity.ToMap(ent)
+	var updates []string
+	for _, col := range sortedKeys(values) {
+		if col != r.IDColumn() && col != "created_at" {
+			updates = append(updates, col)
+		}
+	}
+	upsert := store.Upsert{Values: values, ConflictColumns: []string{r.IDColumn()}, UpdateColumns: updates}
+
+	if _, err := r.mutationExecutor.Upsert(ctx, r.TableName(), upsert); err != nil {
+		return r.handleUpdateError(ctx, err, "upsert", ent.GetID())
+	}
+	r.invalidateCountAfterCommit(ctx)
+	return nil
+}
+
 // Get retrieves an entity by ID - simplified implementation.
 func (r *Repository) Get(ctx context.Context, id string) (entity.Entity, error) {
+	ctx = r.bindTx(ctx)
+
 	if err := r.ValidateID(id); err != nil {
 		return nil, err
 	}
 
-	// Simple SQL query without complex compilation
-	sqlQuery := "SELECT * FROM " + r.TableName() + " WHERE id = $1"
-	row := r.sqlService.db.QueryRowContext(ctx, sqlQuery, id)
+	sqlQuery, args, err := r.compiler.CompileQuery(NewQueryBuilder(r.TableName()).Where(r.IDColumn(), "=", id))
+	if err != nil {
+		return nil, err
+	}
+	row := r.conn(ctx).QueryRowContext(ctx, sqlQuery, args...)
 
 	result := r.CreateNewEntity()
-	err := entity.ScanEntity(result, row)
+	err = entity.ScanEntity(result, row)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, store.NewRecordNotFoundError(r.EntityName(), id)
@@ -80,29 +234,32 @@ func (r *Repository) Get(ctx context.Context, id string) (entity.Entity, error)
 
 // Update modifies an existing entity in the database.
 func (r *Repository) Update(ctx context.Context, ent entity.Entity) error {
+	ctx = r.bindTx(ctx)
+
 	if err := r.Validate(ctx, ent); err != nil {
 		return err
 	}
 
 	r.SetTimestamps(ent, false)
+	r.SetAuditFields(ctx, ent, false)
 
 	return r.transactionHandler.WithTx(ctx, func(ctxTx context.Context) error {
 		values := entity.ToMap(ent)
-		delete(values, "id") // Don't update the ID
+		delete(values, r.IDColumn()) // Don't update the ID
 
 		mutation := store.Update{
 			Set:   values,
-			Where: []store.Condition{store.Eq("id", ent.GetID())},
+			Where: []store.Condition{store.Eq(r.IDColumn(), ent.GetID())},
 		}
 
-		compiled, err := CompileMutation(r.TableName(), mutation)
+		compiled, err := r.compiler.CompileMutation(r.TableName(), mutation)
 		if err != nil {
-			return r.HandleUpdateError(err, "update", ent.GetID())
+			return r.handleUpdateError(ctx, err, "update", ent.GetID())
 		}
 
 		result, err := r.mutationExecutor.ExecuteCompiled(ctxTx, *compiled)
 		if err != nil {
-			return r.HandleUpdateError(err, "update", ent.GetID())
+			return r.handleUpdateError(ctx, err, "update", ent.GetID())
 		}
 
 		if result.RowsAffected == 0 {
@@ -115,45 +272,52 @@ func (r *Repository) Update(ctx context.Context, ent entity.Entity) error {
 
 // Delete removes an entity by ID.
 func (r *Repository) Delete(ctx context.Context, id string) error {
+	ctx = r.bindTx(ctx)
+
 	if err := r.ValidateID(id); err != nil {
 		return err
 	}
 
 	return r.transactionHandler.WithTx(ctx, func(ctxTx context.Context) error {
 		mutation := store.Delete{
-			Where: []store.Condition{store.Eq("id", id)},
+			Where: []store.Condition{store.Eq(r.IDColumn(), id)},
 		}
 
-		compiled, err := CompileMutation(r.TableName(), mutation)
+		compiled, err := r.compiler.CompileMutation(r.TableName(), mutation)
 		if err != nil {
-			return r.HandleUpdateError(err, "delete", id)
+			return r.handleUpdateError(ctx, err, "delete", id)
 		}
 
 		result, err := r.mutationExecutor.ExecuteCompiled(ctxTx, *compiled)
 		if err != nil {
-			return r.HandleUpdateError(err, "delete", id)
+			return r.handleUpdateError(ctx, err, "delete", id)
 		}
 
 		if result.RowsAffected == 0 {
 			return store.NewRecordNotFoundError(r.EntityName(), id)
 		}
 
+		r.invalidateCountAfterCommit(ctxTx)
 		return nil
 	})
 }
 
 // Exists checks if an entity with the given ID exists.
 func (r *Repository) Exists(ctx context.Context, id string) (bool, error) {
+	ctx = r.bindTx(ctx)
+
 	if err := r.ValidateID(id); err != nil {
 		return false, err
 	}
 
-	// Simple SQL query
-	sqlQuery := "SELECT 1 FROM " + r.TableName() + " WHERE id = $1 LIMIT 1"
-	row := r.sqlService.db.QueryRowContext(ctx, sqlQuery, id)
+	sqlQuery, args, err := r.compiler.CompileQuery(NewQueryBuilder(r.TableName()).Select("1").Where(r.IDColumn(), "=", id).Limit(1))
+	if err != nil {
+		return false, err
+	}
+	row := r.conn(ctx).QueryRowContext(ctx, sqlQuery, args...)
 
 	var exists int
-	err := row.Scan(&exists)
+	err = row.Scan(&exists)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return false, nil
@@ -164,69 +328,294 @@ func (r *Repository) Exists(ctx context.Context, id string) (bool, error) {
 	return true, nil
 }
 
+// AnyExist reports whether at least one of the given IDs exists, using a
+// single EXISTS query. It is meant for cheap dedup guards.
+func (r *Repository) AnyExist(ctx context.Context, ids []string) (bool, error) {
+	ctx = r.bindTx(ctx)
+
+	if len(ids) == 0 {
+		return false, nil
+	}
+
+	inner, args, err := r.compiler.CompileQuery(NewQueryBuilder(r.TableName()).Select("1").WhereCondition(store.In(r.IDColumn(), idValues(ids)...)))
+	if err != nil {
+		return false, err
+	}
+	sqlQuery := "SELECT EXISTS(" + inner + ")"
+
+	var exists bool
+	if err := r.conn(ctx).QueryRowContext(ctx, sqlQuery, args...).Scan(&exists); err != nil {
+		return false, r.HandleQueryError(err, "any_exist", nil)
+	}
+
+	return exists, nil
+}
+
 // Batch operations - simplified implementations
+//
+// Batch items are processed in ID order rather than input order so that
+// concurrent batches touching the same rows acquire locks in a consistent
+// order, which avoids lock-order deadlocks between transactions.
 
-// CreateBatch creates multiple entities in a single transaction.
+// CreateBatch creates multiple entities in a single transaction, using
+// multi-row INSERT statements of up to the insert chunk size.
 func (r *Repository) CreateBatch(ctx context.Context, entities []entity.Entity) error {
+	ctx = r.bindTx(ctx)
+
 	if len(entities) == 0 {
 		return nil
 	}
 
+	rows := make([]map[string]any, 0, len(entities))
+	ids := make([]string, 0, len(entities))
+	for _, ent := range sortedByID(entities) {
+		if err := r.Validate(ctx, ent); err != nil {
+			return err
+		}
+		r.SetTimestamps(ent, true)
+		r.SetAuditFields(ctx, ent, true)
+		rows = append(rows, entity.ToMap(ent))
+		ids = append(ids, ent.GetID())
+	}
+
+	chunkSize := r.insertChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultInsertChunkSize
+	}
+
 	return r.transactionHandler.WithTx(ctx, func(ctxTx context.Context) error {
-		for _, ent := range entities {
-			if err := r.Create(ctxTx, ent); err != nil {
-				return err
+		statements, err := r.compiler.CompileBulkInsert(r.TableName(), store.NewBulkInsert(rows...), chunkSize)
+		if err != nil {
+			return r.handleUpdateError(ctx, err, "create_batch", strings.Join(ids, ","))
+		}
+
+		for _, compiled := range statements {
+			if _, err := r.mutationExecutor.ExecuteCompiled(ctxTx, *compiled); err != nil {
+				return r.handleUpdateError(ctx, err, "create_batch", strings.Join(ids, ","))
 			}
 		}
+
+		r.invalidateCountAfterCommit(ctxTx)
 		return nil
 	})
 }
 
-// UpdateBatch updates multiple entities in a single transaction.
+// CopyBatch bulk loads entities with COPY FROM, which is much faster than
+// CreateBatch for large ingestion jobs. It returns the number of rows loaded
+// and fails with store.ErrNotSupported on databases other than PostgreSQL.
+func (r *Repository) CopyBatch(ctx context.Context, entities []entity.Entity) (int64, error) {
+	ctx = r.bindTx(ctx)
+
+	if len(entities) == 0 {
+		return 0, nil
+	}
+
+	var columns []string
+	rows := make([][]any, 0, len(entities))
+	for _, ent := range entities {
+		if err := r.Validate(ctx, ent); err != nil {
+			return 0, err
+		}
+		r.SetTimestamps(ent, true)
+		r.SetAuditFields(ctx, ent, true)
+
+		values := entity.ToMap(ent)
+		if columns == nil {
+			columns = sortedKeys(values)
+		}
+		row := make([]any, len(columns))
+		for i, col := range columns {
+			row[i] = values[col]
+		}
+		rows = append(rows, row)
+	}
+
+	n, err := r.mutationExecutor.CopyFrom(ctx, r.TableName(), columns, rows)
+	if err != nil {
+		return 0, r.HandleQueryError(err, "copy_batch", map[string]any{"count": len(entities)})
+	}
+	r.invalidateCountAfterCommit(ctx)
+	return n, nil
+}
+
+// UpdateBatch updates multiple entities in a single transaction, with one
+// UPDATE statement per chunk of entities choosing each row's values with CASE
+// on the ID column. Chunks hold up to the insert chunk size of entities, fewer
+// when a statement would exceed the compiler's parameter limit. It fails with
+// a not-found error, and changes nothing, if any of the entities does not
+// exist.
 func (r *Repository) UpdateBatch(ctx context.Context, entities []entity.Entity) error {
+	return r.updateBatch(ctx, entities, false)
+}
+
+// UpdateBatchSingle updates multiple entities with a single UPDATE statement,
+// choosing each row's values with CASE on the ID column. It fails with a
+// not-found error, and changes nothing, if any of the entities does not exist.
+func (r *Repository) UpdateBatchSingle(ctx context.Context, entities []entity.Entity) error {
+	return r.updateBatch(ctx, entities, true)
+}
+
+// updateBatch updates entities with one UPDATE ... CASE statement per chunk
+// of entities, or a single statement when single is set.
+func (r *Repository) updateBatch(ctx context.Context, entities []entity.Entity, single bool) error {
+	ctx = r.bindTx(ctx)
+
 	if len(entities) == 0 {
 		return nil
 	}
 
+	rows := make([]store.CaseRow, 0, len(entities))
+	ids := make([]string, 0, len(entities))
+	for _, ent := range sortedByID(entities) {
+		if err := r.Validate(ctx, ent); err != nil {
+			return err
+		}
+		r.SetTimestamps(ent, false)
+		r.SetAuditFields(ctx, ent, false)
+
+		values := entity.ToMap(ent)
+		delete(values, r.IDColumn())
+		rows = append(rows, store.CaseRow{Key: ent.GetID(), Set: values})
+		ids = append(ids, ent.GetID())
+	}
+
+	chunkSize := len(rows)
+	if !single {
+		chunkSize = r.insertChunkSize
+		if chunkSize <= 0 {
+			chunkSize = defaultInsertChunkSize
+		}
+		// Each row binds its ID once per column and once in the IN list
+		if perRow := 2*len(rows[0].Set) + 1; chunkSize*perRow > r.compiler.MaxParams() {
+			chunkSize = max(1, r.compiler.MaxParams()/perRow)
+		}
+	}
+
 	return r.transactionHandler.WithTx(ctx, func(ctxTx context.Context) error {
-		for _, ent := range entities {
-			if err := r.Update(ctxTx, ent); err != nil {
-				return err
+		for start := 0; start < len(rows); start += chunkSize {
+			end := min(start+chunkSize, len(rows))
+			chunkIDs := ids[start:end]
+
+			compiled, err := r.compiler.CompileMutation(r.TableName(), store.NewUpdateCase(r.IDColumn(), rows[start:end]...))
+			if err != nil {
+				return r.handleUpdateError(ctx, err, "update_batch", strings.Join(chunkIDs, ","))
+			}
+
+			result, err := r.mutationExecutor.ExecuteCompiled(ctxTx, *compiled)
+			if err != nil {
+				return r.handleUpdateError(ctx, err, "update_batch", strings.Join(chunkIDs, ","))
+			}
+
+			if result.RowsAffected < int64(len(slices.Compact(slices.Clone(chunkIDs)))) {
+				return store.NewRecordNotFoundError(r.EntityName(), strings.Join(chunkIDs, ","))
 			}
 		}
 		return nil
 	})
 }
 
-// DeleteBatch deletes multiple entities by IDs.
+// DeleteBatch deletes multiple entities by IDs in a single transaction, with
+// one DELETE ... WHERE id IN statement per chunk of IDs. It fails with a
+// not-found error, and deletes nothing, if any of the IDs does not exist.
 func (r *Repository) DeleteBatch(ctx context.Context, ids []string) error {
+	ctx = r.bindTx(ctx)
+
 	if len(ids) == 0 {
 		return nil
 	}
+	for _, id := range ids {
+		if err := r.ValidateID(id); err != nil {
+			return err
+		}
+	}
 
 	return r.transactionHandler.WithTx(ctx, func(ctxTx context.Context) error {
-		for _, id := range ids {
-			if err := r.Delete(ctxTx, id); err != nil {
-				return err
+		for _, chunk := range r.idChunks(slices.Compact(sortedIDs(ids))) {
+			compiled, err := r.compiler.CompileMutation(r.TableName(), store.NewDelete(store.In(r.IDColumn(), idValues(chunk)...)))
+			if err != nil {
+				return r.handleUpdateError(ctx, err, "delete_batch", strings.Join(chunk, ","))
+			}
+
+			result, err := r.mutationExecutor.ExecuteCompiled(ctxTx, *compiled)
+			if err != nil {
+				return r.handleUpdateError(ctx, err, "delete_batch", strings.Join(chunk, ","))
+			}
+
+			if result.RowsAffected < int64(len(chunk)) {
+				return store.NewRecordNotFoundError(r.EntityName(), strings.Join(chunk, ","))
 			}
 		}
+
+		r.invalidateCountAfterCommit(ctxTx)
 		return nil
 	})
 }
 
-// GetBatch retrieves multiple entities by IDs.
+// InsertStream creates the entities received from ch, inserting them with
+// CreateBatch in batches of batchSize. Pending entities are flushed when ch
+// is closed or ctx is cancelled; in the latter case ctx's error is returned
+// along with the number of entities inserted.
+func (r *Repository) InsertStream(ctx context.Context, ch <-chan entity.Entity, batchSize int) (int64, error) {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+
+	var total int64
+	batch := make([]entity.Entity, 0, batchSize)
+	flush := func(ctx context.Context) error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := r.CreateBatch(ctx, batch); err != nil {
+			return err
+		}
+		total += int64(len(batch))
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := flush(context.WithoutCancel(ctx)); err != nil {
+				return total, err
+			}
+			return total, ctx.Err()
+		case ent, ok := <-ch:
+			if !ok {
+				return total, flush(ctx)
+			}
+			batch = append(batch, ent)
+			if len(batch) < batchSize {
+				continue
+			}
+			if err := flush(ctx); err != nil {
+				return total, err
+			}
+		}
+	}
+}
+
+// GetBatch retrieves multiple entities by IDs with one SELECT ... WHERE id IN
+// query per chunk of IDs. IDs that do not exist are left out of the result.
 func (r *Repository) GetBatch(ctx context.Context, ids []string) (map[string]entity.Entity, error) {
-	result := make(map[string]entity.Entity)
+	ctx = r.bindTx(ctx)
 
+	result := make(map[string]entity.Entity, len(ids))
 	for _, id := range ids {
-		ent, err := r.Get(ctx, id)
+		if err := r.ValidateID(id); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, chunk := range r.idChunks(ids) {
+		qb := NewQueryBuilder(r.TableName()).WhereCondition(store.In(r.IDColumn(), idValues(chunk)...))
+		entities, err := r.queryEntities(ctx, "get_batch", qb)
 		if err != nil {
-			// Skip not found errors, include the entity if found
-			if !store.IsRecordNotFoundError(err) {
-				return nil, err
-			}
-		} else {
-			result[id] = ent
+			return nil, err
+		}
+		for _, ent := range entities {
+			result[ent.GetID()] = ent
 		}
 	}
 
@@ -235,23 +624,42 @@ func (r *Repository) GetBatch(ctx context.Context, ids []string) (map[string]ent
 
 // Query operations
 
-// FindWhere returns entities matching the given conditions.
+// FindWhere returns entities matching all the given conditions.
 func (r *Repository) FindWhere(ctx context.Context, conditions ...store.Condition) ([]entity.Entity, error) {
-	// Simple implementation - for now just return empty slice
-	// This would be enhanced to actually build SQL WHERE clauses from conditions
-	return []entity.Entity{}, nil
+	return r.FindQuery(ctx, store.Query{Where: conditions})
+}
+
+// FindQuery returns the entities selected by q, applying its conditions,
+// ordering, limit and offset. q.From defaults to the repository's table.
+func (r *Repository) FindQuery(ctx context.Context, q store.Query) ([]entity.Entity, error) {
+	ctx = r.bindTx(ctx)
+
+	if q.From == "" && q.FromQuery == nil {
+		q.From = r.TableName()
+	}
+	entities, err := r.queryEntities(ctx, "find_where", queryBuilderFrom(q))
+	if err != nil {
+		return nil, err
+	}
+	if entities == nil {
+		entities = []entity.Entity{}
+	}
+	return entities, nil
 }
 
-// CountWhere returns the count of entities matching the given conditions.
+// CountWhere returns the count of entities matching all the given conditions.
 func (r *Repository) CountWhere(ctx context.Context, conditions ...store.Condition) (int64, error) {
-	// Simple implementation - for now just return total count
-	// This would be enhanced to actually build SQL WHERE clauses from conditions
-	return r.Count(ctx)
+	return r.Count(ctx, conditions...)
 }
 
-// FindFirst returns the first entity matching the given conditions.
+// FindFirst returns the entity with the lowest ID among those matching the
+// given conditions.
 func (r *Repository) FindFirst(ctx context.Context, conditions ...store.Condition) (entity.Entity, error) {
-	entities, err := r.FindWhere(ctx, conditions...)
+	entities, err := r.FindQuery(ctx, store.Query{
+		Where:   conditions,
+		OrderBy: []store.Order{{Field: r.IDColumn()}},
+		Limit:   1,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -261,61 +669,157 @@ func (r *Repository) FindFirst(ctx context.Context, conditions ...store.Conditio
 	return entities[0], nil
 }
 
-// List returns paginated results - simplified implementation.
-func (r *Repository) List(ctx context.Context, params store.CursorParams) (store.CursorResult[entity.Entity], error) {
-	// Simple implementation - just get all records with limit
-	var entities []entity.Entity
+// FindOneBy returns the single entity whose field equals value. It returns a
+// RecordNotFoundError when nothing matches and an error wrapping
+// store.ErrMultipleRecords when more than one row does.
+func (r *Repository) FindOneBy(ctx context.Context, field string, value any) (entity.Entity, error) {
+	ctx = r.bindTx(ctx)
+
+	qb := NewQueryBuilder(r.TableName()).WhereCondition(store.Eq(field, value)).Limit(2)
+	entities, err := r.queryEntities(ctx, "find_one_by", qb)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(entities) {
+	case 0:
+		return nil, store.NewRecordNotFoundError(r.EntityName(), fmt.Sprintf("%s=%v", field, value))
+	case 1:
+		return entities[0], nil
+	default:
+		return nil, r.HandleQueryError(
+			fmt.Errorf("%w: %s=%v matches more than one %s", store.ErrMultipleRecords, field, value, r.EntityName()),
+			"find_one_by", map[string]any{"field": field, "value": value})
+	}
+}
+
+// Random returns up to n entities picked at random. It sorts the whole table,
+// so it is meant for sampling small to medium tables.
+func (r *Repository) Random(ctx context.Context, n int) ([]entity.Entity, error) {
+	ctx = r.bindTx(ctx)
+
+	if n <= 0 {
+		return []entity.Entity{}, nil
+	}
 
-	limit := int(params.PageSize)
-	if limit <= 0 {
-		limit = 100 // Default limit
+	qb := NewQueryBuilder(r.TableName()).OrderByRandom().Limit(n)
+	return r.queryEntities(ctx, "random", qb)
+}
+
+// queryEntities runs qb and scans every row into a new entity.
+func (r *Repository) queryEntities(ctx context.Context, operation string, qb *QueryBuilder) ([]entity.Entity, error) {
+	query, args, err := r.compiler.CompileQuery(qb)
+	if err != nil {
+		return nil, r.HandleQueryError(err, operation, nil)
 	}
 
-	sqlQuery := "SELECT * FROM " + r.TableName() + " LIMIT $1"
-	rows, err := r.sqlService.db.QueryContext(ctx, sqlQuery, limit)
+	rows, err := r.conn(ctx).QueryContext(ctx, query, args...)
 	if err != nil {
-		return store.CursorResult[entity.Entity]{}, r.HandleQueryError(err, "list", nil)
+		return nil, r.HandleQueryError(err, operation, nil)
 	}
 	defer rows.Close()
 
+	var entities []entity.Entity
 	for rows.Next() {
-		ent := r.CreateNewEntity()
-		// ScanEntity expects *sql.Row, but we have *sql.Rows - need to scan manually for now
 		values, err := scanRowToValues(rows)
 		if err != nil {
-			return store.CursorResult[entity.Entity]{}, r.HandleQueryError(err, "list", nil)
+			return nil, r.HandleQueryError(err, operation, nil)
 		}
-		if err := entity.FromMap(ent, values); err != nil {
-			return store.CursorResult[entity.Entity]{}, r.HandleQueryError(err, "list", nil)
+		ent, err := r.entityFromValues(values)
+		if err != nil {
+			return nil, r.HandleQueryError(err, operation, nil)
 		}
 		entities = append(entities, ent)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, r.HandleQueryError(err, operation, nil)
+	}
+
+	return entities, nil
+}
 
-	if err = rows.Err(); err != nil {
-		return store.CursorResult[entity.Entity]{}, r.HandleQueryError(err, "list", nil)
+// List returns a page of entities ordered by creation time, or the order set
+// with WithListOrder, then ID, using keyset pagination: params.Cursor, taken
+// from the NextCursor of the previous page, resumes after its last entity.
+// TotalCount is -1, as it is not computed.
+func (r *Repository) List(ctx context.Context, params store.CursorParams) (store.CursorResult[entity.Entity], error) {
+	ctx = r.bindTx(ctx)
+
+	paginator := NewSQLPaginator(r.paginator, "created_at", r.IDColumn())
+	if len(r.listOrder) > 0 {
+		paginator = NewSQLPaginatorWithOrder(r.paginator, r.listOrder, r.IDColumn())
+	}
+	qb, params, err := paginator.Apply(NewQueryBuilder(r.TableName()), params)
+	if err != nil {
+		return store.CursorResult[entity.Entity]{}, err
 	}
 
-	return store.CursorResult[entity.Entity]{
-		Items:   entities,
-		HasMore: len(entities) == limit, // Simple heuristic
-	}, nil
+	entities, err := r.queryEntities(ctx, "list", qb)
+	if err != nil {
+		return store.CursorResult[entity.Entity]{}, err
+	}
+	return paginator.Page(entities, params)
 }
 
 // Count returns the number of entities matching the conditions.
 func (r *Repository) Count(ctx context.Context, conditions ...store.Condition) (int64, error) {
-	// Simple implementation - count all records
-	sqlQuery := "SELECT COUNT(*) FROM " + r.TableName()
-	row := r.sqlService.db.QueryRowContext(ctx, sqlQuery)
+	ctx = r.bindTx(ctx)
 
-	var count int64
-	err := row.Scan(&count)
+	qb := NewQueryBuilder(r.TableName()).Select("COUNT(*)").WhereCondition(conditions...)
+	sqlQuery, args, err := r.compiler.CompileQuery(qb)
 	if err != nil {
 		return 0, r.HandleQueryError(err, "count", nil)
 	}
 
+	var count int64
+	if err := r.conn(ctx).QueryRowContext(ctx, sqlQuery, args...).Scan(&count); err != nil {
+		return 0, r.HandleQueryError(err, "count", nil)
+	}
+
 	return count, nil
 }
 
+// CachedCount returns the total number of rows, caching it on the service for
+// ttl. The cached value is dropped whenever a repository for the same table
+// creates or deletes rows. Inside a transaction the count is always computed.
+func (r *Repository) CachedCount(ctx context.Context, ttl time.Duration) (int64, error) {
+	ctx = r.bindTx(ctx)
+
+	if _, inTx := TransactionFromContext(ctx); inTx {
+		return r.Count(ctx)
+	}
+
+	if count, ok := r.sqlService.counts.get(r.TableName()); ok {
+		return count, nil
+	}
+
+	// Count on the primary, since a replica may not have the latest writes
+	generation := r.sqlService.counts.generation(r.TableName())
+	count, err := r.Count(WithPrimaryReads(ctx))
+	if err != nil {
+		return 0, err
+	}
+	r.sqlService.counts.set(r.TableName(), count, ttl, generation)
+	return count, nil
+}
+
+// invalidateCount drops the cached total for the repository's table.
+func (r *Repository) invalidateCount() {
+	r.sqlService.counts.invalidate(r.TableName())
+}
+
+// invalidateCountAfterCommit drops the cached total once the transaction in
+// ctx commits, so it is neither refreshed before the write is visible nor
+// dropped for a write that rolls back. Outside a transaction handler's
+// transaction the total is dropped at once: the write has already been
+// applied, or it runs in a *sql.Tx bound with WithTx whose commit cannot be
+// observed.
+func (r *Repository) invalidateCountAfterCommit(ctx context.Context) {
+	if err := RegisterAfterCommit(ctx, func(context.Context) { r.invalidateCount() }); err != nil {
+		r.invalidateCount()
+	}
+}
+
 // HealthCheck performs a basic health check.
 func (r *Repository) HealthCheck(ctx context.Context) error {
 	_, err := r.Count(ctx)
@@ -325,9 +829,149 @@ func (r *Repository) HealthCheck(ctx context.Context) error {
 	return nil
 }
 
-// Helper function for scanning rows - simplified implementation
+// Health reports the readiness of the underlying database.
+func (r *Repository) Health(ctx context.Context) store.HealthStatus {
+	return r.sqlService.Health(ctx)
+}
+
+// ValidateID rejects empty IDs, and any ID while the ID column is invalid.
+func (r *Repository) ValidateID(id string) error {
+	if r.idColumnErr != nil {
+		return r.idColumnErr
+	}
+	return r.RepositoryBase.ValidateID(id)
+}
+
+// Validate validates ent, failing first when the ID column is invalid.
+func (r *Repository) Validate(ctx context.Context, ent entity.Entity) error {
+	if r.idColumnErr != nil {
+		return r.idColumnErr
+	}
+	return r.RepositoryBase.Validate(ctx, ent)
+}
+
+// HandleGetError translates driver errors to store sentinels before wrapping
+// them, so callers can test for store.ErrUniqueConstraint and the like.
+func (r *Repository) HandleGetError(err error, operation, id string) error {
+	return r.RepositoryBase.HandleGetError(r.translateError(err), operation, id)
+}
+
+// HandleUpdateError translates driver errors to store sentinels before wrapping them.
+func (r *Repository) HandleUpdateError(err error, operation, id string) error {
+	return r.RepositoryBase.HandleUpdateError(r.translateError(err), operation, id)
+}
+
+// HandleQueryError translates driver errors to store sentinels before wrapping them.
+func (r *Repository) HandleQueryError(err error, operation string, details map[string]any) error {
+	return r.RepositoryBase.HandleQueryError(r.translateError(err), operation, details)
+}
+
+// handleUpdateError is HandleUpdateError for the repository's own writes. It
+// also logs constraint violations with the context of the write.
+func (r *Repository) handleUpdateError(ctx context.Context, err error, operation, id string) error {
+	err = r.HandleUpdateError(err, operation, id)
+	if store.IsConstraintViolation(err) {
+		r.sqlService.logger.Log(ctx, slog.LevelWarn, "constraint violation",
+			"table", r.TableName(), "error", err)
+	}
+	return err
+}
+
+func (r *Repository) translateError(err error) error {
+	if err == nil || r.sqlService.adapter == nil {
+		return err
+	}
+	return r.sqlService.adapter.TranslateError(err)
+}
+
+// sortedByID returns a copy of entities ordered by ID.
+func sortedByID(entities []entity.Entity) []entity.Entity {
+	sorted := make([]entity.Entity, len(entities))
+	copy(sorted, entities)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].GetID() < sorted[j].GetID()
+	})
+	return sorted
+}
+
+// maxIDChunkSize is the largest number of IDs bound in a single IN list by
+// batch operations, unless the compiler's parameter limit is lower.
+const maxIDChunkSize = 1000
+
+// idChunks splits ids into chunks small enough to bind in one IN list.
+func (r *Repository) idChunks(ids []string) [][]string {
+	return slices.Collect(slices.Chunk(ids, min(maxIDChunkSize, r.compiler.MaxParams())))
+}
+
+// idValues returns ids as IN list values.
+func idValues(ids []string) []any {
+	values := make([]any, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+	return values
+}
+
+// sortedIDs returns a sorted copy of ids.
+func sortedIDs(ids []string) []string {
+	sorted := make([]string, len(ids))
+	copy(sorted, ids)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// entityFromValues creates a new entity from scanned column values. Columns
+// match entity fields regardless of case.
+func (r *Repository) entityFromValues(values map[string]any) (entity.Entity, error) {
+	ent := r.CreateNewEntity()
+	if err := fillEntity(ent, r.fieldNames, values); err != nil {
+		return nil, err
+	}
+	return ent, nil
+}
+
+// fieldNamesOf maps lowercased column names to the field names of ent.
+func fieldNamesOf(ent entity.Entity) map[string]string {
+	fieldNames := make(map[string]string)
+	for name := range entity.ToMap(ent) {
+		fieldNames[strings.ToLower(name)] = name
+	}
+	return fieldNames
+}
+
+// fillEntity sets the fields of ent from scanned column values, using
+// fieldNames to match columns regardless of case.
+func fillEntity(ent entity.Entity, fieldNames map[string]string, values map[string]any) error {
+	fields := make(map[string]any, len(values))
+	for col, val := range values {
+		if name, ok := fieldNames[col]; ok {
+			col = name
+		}
+		fields[col] = val
+	}
+	return entity.FromMap(ent, fields)
+}
+
+// scanRowToValues scans the current row into a map keyed by lowercased
+// column name, since drivers differ in how they case column names.
 func scanRowToValues(rows *sql.Rows) (map[string]any, error) {
-	// This is a placeholder - in a real implementation, we would properly scan
-	// based on the entity's field structure. For now, return empty map.
-	return make(map[string]any), nil
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]any, len(columns))
+	ptrs := make([]any, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]any, len(columns))
+	for i, col := range columns {
+		result[strings.ToLower(col)] = values[i]
+	}
+	return result, nil
 }
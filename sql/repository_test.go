@@ -0,0 +1,1470 @@
+package sqlstore_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+
+	"core/entity"
+	"store"
+	sqlstore "store/sql"
+	"store/sql/adapter"
+)
+
+type gadget struct {
+	ID        string    `json:"id" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+func (g *gadget) GetID() string            { return g.ID }
+func (g *gadget) SetID(id string)          { g.ID = id }
+func (g *gadget) GetCreatedAt() time.Time  { return g.CreatedAt }
+func (g *gadget) SetCreatedAt(t time.Time) { g.CreatedAt = t }
+func (g *gadget) GetUpdatedAt() time.Time  { return g.UpdatedAt }
+func (g *gadget) SetUpdatedAt(t time.Time) { g.UpdatedAt = t }
+
+// openTestService opens an in-memory SQLite service and creates the gadget table.
+func openTestService(t testing.TB) (*sqlstore.Service, *sqlstore.Repository) {
+	t.Helper()
+	ctx := context.Background()
+
+	config := store.SQLiteConfig(":memory:")
+	svc, err := sqlstore.Open(ctx, adapter.NewSQLiteAdapter(), &config)
+	if err != nil {
+		t.Fatalf("failed to open sqlite service: %v", err)
+	}
+	t.Cleanup(func() { _ = svc.Close() })
+
+	repo := svc.Repository(&gadget{})
+	ddl := "CREATE TABLE " + repo.TableName() + ` (
+		id TEXT PRIMARY KEY,
+		name TEXT,
+		created_at TIMESTAMP,
+		updated_at TIMESTAMP
+	)`
+	if err := svc.ExecuteSQL(ctx, ddl); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	return svc, repo
+}
+
+func TestBatchOperationsUseIDOrder(t *testing.T) {
+	svc, repo := openTestService(t)
+	ctx := context.Background()
+	table := repo.TableName()
+
+	setup := []string{
+		"CREATE TABLE lock_log (seq INTEGER PRIMARY KEY AUTOINCREMENT, op TEXT, id TEXT)",
+		"CREATE TRIGGER log_insert AFTER INSERT ON " + table + " BEGIN INSERT INTO lock_log (op, id) VALUES ('insert', NEW.id); END",
+		"CREATE TRIGGER log_update AFTER UPDATE ON " + table + " BEGIN INSERT INTO lock_log (op, id) VALUES ('update', NEW.id); END",
+		"CREATE TRIGGER log_delete AFTER DELETE ON " + table + " BEGIN INSERT INTO lock_log (op, id) VALUES ('delete', OLD.id); END",
+	}
+	for _, stmt := range setup {
+		if err := svc.ExecuteSQL(ctx, stmt); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+
+	input := []entity.Entity{
+		&gadget{ID: "c", Name: "c"},
+		&gadget{ID: "a", Name: "a"},
+		&gadget{ID: "e", Name: "e"},
+		&gadget{ID: "b", Name: "b"},
+		&gadget{ID: "d", Name: "d"},
+	}
+	if err := repo.CreateBatch(ctx, input); err != nil {
+		t.Fatalf("create batch: %v", err)
+	}
+	if input[0].GetID() != "c" {
+		t.Errorf("CreateBatch reordered the caller's slice")
+	}
+
+	// Each batch has its own entities, since UpdateBatch sets their timestamps
+	gadgets := func(ids ...string) []entity.Entity {
+		ents := make([]entity.Entity, len(ids))
+		for i, id := range ids {
+			ents[i] = &gadget{ID: id, Name: id}
+		}
+		return ents
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for _, batch := range [][]entity.Entity{gadgets("c", "a", "e", "b", "d"), gadgets("e", "d", "c", "b", "a")} {
+		wg.Add(1)
+		go func(batch []entity.Entity) {
+			defer wg.Done()
+			errs <- repo.UpdateBatch(ctx, batch)
+		}(batch)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent update batch failed: %v", err)
+		}
+	}
+
+	if err := repo.DeleteBatch(ctx, []string{"d", "b", "e", "a", "c"}); err != nil {
+		t.Fatalf("delete batch: %v", err)
+	}
+
+	rows, err := svc.DB().QueryContext(ctx, "SELECT op, id FROM lock_log ORDER BY seq")
+	if err != nil {
+		t.Fatalf("query log: %v", err)
+	}
+	defer rows.Close()
+
+	byOp := make(map[string][]string)
+	for rows.Next() {
+		var op, id string
+		if err := rows.Scan(&op, &id); err != nil {
+			t.Fatalf("scan log: %v", err)
+		}
+		byOp[op] = append(byOp[op], id)
+	}
+
+	want := []string{"a", "b", "c", "d", "e"}
+	assertIDs := func(op string, got []string) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("%s: expected %d rows, got %v", op, len(want), got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: expected id order %v, got %v", op, want, got)
+				return
+			}
+		}
+	}
+
+	assertIDs("insert", byOp["insert"])
+	assertIDs("delete", byOp["delete"])
+
+	updates := byOp["update"]
+	if len(updates) != 10 {
+		t.Fatalf("expected 10 updates, got %v", updates)
+	}
+	for _, batch := range [][]string{updates[:5], updates[5:]} {
+		if !sort.StringsAreSorted(batch) {
+			t.Errorf("update batch did not lock rows in id order: %v", batch)
+		}
+	}
+}
+
+func TestAnyExist(t *testing.T) {
+	_, repo := openTestService(t)
+	ctx := context.Background()
+
+	if err := repo.Create(ctx, &gadget{ID: "present", Name: "p"}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		ids  []string
+		want bool
+	}{
+		{"empty", nil, false},
+		{"all missing", []string{"x", "y", "z"}, false},
+		{"some present", []string{"x", "present", "z"}, true},
+		{"only present", []string{"present"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.AnyExist(ctx, tt.ids)
+			if err != nil {
+				t.Fatalf("any exist: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+type device struct {
+	UUID      string    `json:"uuid" db:"uuid"`
+	Label     string    `json:"label" db:"label"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+func (d *device) IDColumn() string         { return "uuid" }
+func (d *device) GetID() string            { return d.UUID }
+func (d *device) SetID(id string)          { d.UUID = id }
+func (d *device) GetCreatedAt() time.Time  { return d.CreatedAt }
+func (d *device) SetCreatedAt(t time.Time) { d.CreatedAt = t }
+func (d *device) GetUpdatedAt() time.Time  { return d.UpdatedAt }
+func (d *device) SetUpdatedAt(t time.Time) { d.UpdatedAt = t }
+
+func TestRepositoryUsesEntityIDColumn(t *testing.T) {
+	svc, _ := openTestService(t)
+	ctx := context.Background()
+
+	repo := svc.Repository(&device{})
+	if repo.IDColumn() != "uuid" {
+		t.Fatalf("expected uuid id column, got %q", repo.IDColumn())
+	}
+	ddl := "CREATE TABLE " + repo.TableName() + " (uuid TEXT PRIMARY KEY, label TEXT, created_at TIMESTAMP, updated_at TIMESTAMP)"
+	if err := svc.ExecuteSQL(ctx, ddl); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+
+	if err := repo.Create(ctx, &device{UUID: "d-1", Label: "old"}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := repo.Update(ctx, &device{UUID: "d-1", Label: "new"}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	got, err := repo.Get(ctx, "d-1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if label := got.(*device).Label; label != "new" {
+		t.Errorf("expected updated label, got %q", label)
+	}
+
+	if ok, err := repo.Exists(ctx, "d-1"); err != nil || !ok {
+		t.Fatalf("expected d-1 to exist, got %v, %v", ok, err)
+	}
+	if err := repo.Delete(ctx, "d-1"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if ok, err := repo.Exists(ctx, "d-1"); err != nil || ok {
+		t.Fatalf("expected d-1 to be deleted, got %v, %v", ok, err)
+	}
+}
+
+func TestRepositoryWithIDColumn(t *testing.T) {
+	svc, repo := openTestService(t)
+	ctx := context.Background()
+
+	if err := repo.Create(ctx, &gadget{ID: "g-1", Name: "g"}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	byName := repo.WithIDColumn("name")
+	if repo.IDColumn() != "id" {
+		t.Errorf("WithIDColumn modified the original repository")
+	}
+	ok, err := byName.Exists(ctx, "g")
+	if err != nil {
+		t.Fatalf("exists: %v", err)
+	}
+	if !ok {
+		t.Error("expected lookup by the configured column to find the row")
+	}
+
+	// Lookups by ID are compiled, so the column is quoted and the dialect's
+	// placeholder is used
+	svc.SetCompiler(svc.Compiler().WithQuotedIdentifiers(true))
+	logs := &logRecorder{}
+	svc.SetQueryLogger(logs)
+	quoted := svc.Repository(&gadget{}).WithIDColumn("name")
+	if _, err := quoted.Get(ctx, "g"); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if _, err := quoted.Exists(ctx, "g"); err != nil {
+		t.Fatalf("exists: %v", err)
+	}
+	for _, prefix := range []string{"SELECT * FROM", "SELECT 1 FROM"} {
+		if entry, ok := logs.find(prefix); !ok || !strings.Contains(entry.SQL, `"name" = $1`) {
+			t.Errorf("expected a quoted lookup by name, got %q", entry.SQL)
+		}
+	}
+
+	invalid := repo.WithIDColumn("id; DROP TABLE gadgets")
+	if _, err := invalid.Get(ctx, "g-1"); !errors.Is(err, store.ErrInvalidQuery) {
+		t.Errorf("expected an invalid ID column to fail with ErrInvalidQuery, got %v", err)
+	}
+	if err := invalid.Create(ctx, &gadget{ID: "g-2", Name: "g"}); !errors.Is(err, store.ErrInvalidQuery) {
+		t.Errorf("expected an invalid ID column to fail with ErrInvalidQuery, got %v", err)
+	}
+}
+
+type invoice struct {
+	ID        string    `json:"id" db:"id"`
+	CreatedBy string    `json:"created_by" db:"created_by"`
+	UpdatedBy string    `json:"updated_by" db:"updated_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+func (i *invoice) GetID() string             { return i.ID }
+func (i *invoice) SetID(id string)           { i.ID = id }
+func (i *invoice) GetCreatedAt() time.Time   { return i.CreatedAt }
+func (i *invoice) SetCreatedAt(t time.Time)  { i.CreatedAt = t }
+func (i *invoice) GetUpdatedAt() time.Time   { return i.UpdatedAt }
+func (i *invoice) SetUpdatedAt(t time.Time)  { i.UpdatedAt = t }
+func (i *invoice) SetCreatedBy(actor string) { i.CreatedBy = actor }
+func (i *invoice) SetUpdatedBy(actor string) { i.UpdatedBy = actor }
+
+func TestRepositoryRecordsActor(t *testing.T) {
+	svc, _ := openTestService(t)
+	ctx := context.Background()
+
+	repo := svc.Repository(&invoice{})
+	ddl := "CREATE TABLE " + repo.TableName() + " (id TEXT PRIMARY KEY, created_by TEXT, updated_by TEXT, created_at TIMESTAMP, updated_at TIMESTAMP)"
+	if err := svc.ExecuteSQL(ctx, ddl); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+
+	auditColumns := func() (string, string) {
+		t.Helper()
+		var createdBy, updatedBy string
+		row := svc.DB().QueryRowContext(ctx, "SELECT created_by, updated_by FROM "+repo.TableName()+" WHERE id = $1", "inv-1")
+		if err := row.Scan(&createdBy, &updatedBy); err != nil {
+			t.Fatalf("select audit columns: %v", err)
+		}
+		return createdBy, updatedBy
+	}
+
+	inv := &invoice{ID: "inv-1"}
+	if err := repo.Create(store.WithActor(ctx, "alice"), inv); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if createdBy, updatedBy := auditColumns(); createdBy != "alice" || updatedBy != "alice" {
+		t.Errorf("after create: expected alice/alice, got %s/%s", createdBy, updatedBy)
+	}
+
+	if err := repo.Update(store.WithActor(ctx, "bob"), inv); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if createdBy, updatedBy := auditColumns(); createdBy != "alice" || updatedBy != "bob" {
+		t.Errorf("after update: expected alice/bob, got %s/%s", createdBy, updatedBy)
+	}
+
+	if err := repo.Update(ctx, inv); err != nil {
+		t.Fatalf("update without actor: %v", err)
+	}
+	if _, updatedBy := auditColumns(); updatedBy != "bob" {
+		t.Errorf("update without actor changed updated_by to %q", updatedBy)
+	}
+}
+
+func TestRepositoryWithTxRunsInGivenTransaction(t *testing.T) {
+	svc, repo := openTestService(t)
+	ctx := context.Background()
+
+	tx, err := svc.DB().BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	bound := repo.WithTx(tx)
+
+	if err := bound.Create(ctx, &gadget{ID: "tx-1", Name: "a"}); err != nil {
+		t.Fatalf("create in tx: %v", err)
+	}
+	if err := bound.CreateBatch(ctx, []entity.Entity{&gadget{ID: "tx-2", Name: "b"}}); err != nil {
+		t.Fatalf("create batch in tx: %v", err)
+	}
+	if n, err := bound.Count(ctx); err != nil || n != 2 {
+		t.Fatalf("expected bound repo to see 2 uncommitted rows, got %d, %v", n, err)
+	}
+	if ok, err := bound.Exists(ctx, "tx-1"); err != nil || !ok {
+		t.Fatalf("expected bound repo to see its own write, got %v, %v", ok, err)
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if n, err := repo.Count(ctx); err != nil || n != 0 {
+		t.Errorf("expected rollback to discard bound writes, got %d rows, %v", n, err)
+	}
+
+	tx, err = svc.DB().BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if err := repo.WithTx(tx).Create(ctx, &gadget{ID: "tx-3", Name: "c"}); err != nil {
+		t.Fatalf("create in tx: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if ok, err := repo.Exists(ctx, "tx-3"); err != nil || !ok {
+		t.Errorf("expected committed row to exist, got %v, %v", ok, err)
+	}
+}
+
+func TestCachedCountRefreshesAfterCommit(t *testing.T) {
+	svc := openFileService(t)
+	ctx := context.Background()
+	repo := svc.Repository(&gadget{})
+	ddl := "CREATE TABLE " + repo.TableName() + " (id TEXT PRIMARY KEY, name TEXT, created_at TIMESTAMP, updated_at TIMESTAMP)"
+	if err := svc.ExecuteSQL(ctx, ddl); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+
+	th := svc.TransactionHandler()
+	err := th.WithTx(ctx, func(txCtx context.Context) error {
+		if err := repo.Create(txCtx, &gadget{ID: "a", Name: "a"}); err != nil {
+			return err
+		}
+		// Counted on another connection while the create is uncommitted
+		counted := make(chan int64, 1)
+		go func() {
+			n, err := repo.CachedCount(ctx, time.Minute)
+			if err != nil {
+				t.Errorf("cached count: %v", err)
+			}
+			counted <- n
+		}()
+		if n := <-counted; n != 0 {
+			t.Errorf("expected the uncommitted create to be invisible, got %d", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("transaction: %v", err)
+	}
+	if n, err := repo.CachedCount(ctx, time.Minute); err != nil || n != 1 {
+		t.Errorf("expected the count to refresh after the commit, got %d, %v", n, err)
+	}
+
+	// A create in a transaction that rolls back keeps the cached total
+	err = th.WithTx(ctx, func(txCtx context.Context) error {
+		if err := repo.Create(txCtx, &gadget{ID: "b", Name: "b"}); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("expected the transaction to abort, got %v", err)
+	}
+	if n, err := repo.CachedCount(ctx, time.Minute); err != nil || n != 1 {
+		t.Errorf("expected the cached count to stay at 1, got %d, %v", n, err)
+	}
+}
+
+func TestCachedCount(t *testing.T) {
+	svc, repo := openTestService(t)
+	ctx := context.Background()
+	insertDirect := func(id string) {
+		t.Helper()
+		if err := svc.ExecuteSQL(ctx, "INSERT INTO "+repo.TableName()+" (id, name) VALUES ($1, 'direct')", id); err != nil {
+			t.Fatalf("direct insert: %v", err)
+		}
+	}
+	assertCount := func(want int64) {
+		t.Helper()
+		got, err := repo.CachedCount(ctx, 50*time.Millisecond)
+		if err != nil {
+			t.Fatalf("cached count: %v", err)
+		}
+		if got != want {
+			t.Errorf("expected cached count %d, got %d", want, got)
+		}
+	}
+
+	if err := repo.Create(ctx, &gadget{ID: "a", Name: "a"}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	assertCount(1)
+
+	// Writes that bypass the repository are not seen within the window.
+	insertDirect("direct-1")
+	assertCount(1)
+
+	// Creating through any repository for the table invalidates the cache.
+	if err := svc.Repository(&gadget{}).Create(ctx, &gadget{ID: "b", Name: "b"}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	assertCount(3)
+
+	if err := repo.Delete(ctx, "a"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	assertCount(2)
+
+	// The cached value is refreshed once the ttl has passed.
+	insertDirect("direct-2")
+	assertCount(2)
+	time.Sleep(60 * time.Millisecond)
+	assertCount(3)
+}
+
+func TestFindOneBy(t *testing.T) {
+	_, repo := openTestService(t)
+	ctx := context.Background()
+
+	for _, g := range []*gadget{{ID: "1", Name: "solo"}, {ID: "2", Name: "twin"}, {ID: "3", Name: "twin"}} {
+		if err := repo.Create(ctx, g); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+
+	found, err := repo.FindOneBy(ctx, "name", "solo")
+	if err != nil {
+		t.Fatalf("find solo: %v", err)
+	}
+	if g := found.(*gadget); g.ID != "1" || g.Name != "solo" {
+		t.Errorf("unexpected entity: %+v", g)
+	}
+
+	_, err = repo.FindOneBy(ctx, "name", "nobody")
+	if !store.IsRecordNotFoundError(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+
+	_, err = repo.FindOneBy(ctx, "name", "twin")
+	if !errors.Is(err, store.ErrMultipleRecords) {
+		t.Errorf("expected ErrMultipleRecords, got %v", err)
+	}
+}
+
+func TestFindWhereAndCountWhere(t *testing.T) {
+	_, repo := openTestService(t)
+	ctx := context.Background()
+
+	for _, g := range []*gadget{{ID: "3", Name: "twin"}, {ID: "1", Name: "solo"}, {ID: "2", Name: "twin"}} {
+		if err := repo.Create(ctx, g); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+
+	found, err := repo.FindWhere(ctx, store.Eq("name", "twin"))
+	if err != nil {
+		t.Fatalf("find where: %v", err)
+	}
+	if len(found) != 2 {
+		t.Errorf("expected 2 twins, got %d", len(found))
+	}
+	for _, ent := range found {
+		if g := ent.(*gadget); g.Name != "twin" {
+			t.Errorf("unexpected entity: %+v", g)
+		}
+	}
+
+	if n, err := repo.CountWhere(ctx, store.Eq("name", "twin")); err != nil || n != 2 {
+		t.Errorf("expected 2 twins counted, got %d, %v", n, err)
+	}
+	if n, err := repo.CountWhere(ctx); err != nil || n != 3 {
+		t.Errorf("expected 3 gadgets counted, got %d, %v", n, err)
+	}
+
+	first, err := repo.FindFirst(ctx, store.Eq("name", "twin"))
+	if err != nil {
+		t.Fatalf("find first: %v", err)
+	}
+	if id := first.GetID(); id != "2" {
+		t.Errorf("expected the twin with the lowest ID, got %s", id)
+	}
+	if _, err := repo.FindFirst(ctx, store.Eq("name", "nobody")); !store.IsRecordNotFoundError(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+
+	page, err := repo.FindQuery(ctx, store.Query{
+		OrderBy: []store.Order{{Field: "id", Desc: true}},
+		Limit:   2,
+		Offset:  1,
+	})
+	if err != nil {
+		t.Fatalf("find query: %v", err)
+	}
+	if len(page) != 2 || page[0].GetID() != "2" || page[1].GetID() != "1" {
+		t.Errorf("expected ordering, limit and offset to apply, got %v", page)
+	}
+
+	none, err := repo.FindWhere(ctx, store.Eq("name", "nobody"))
+	if err != nil || none == nil || len(none) != 0 {
+		t.Errorf("expected an empty slice, got %v, %v", none, err)
+	}
+
+	// Patterns match as patterns, not as exact values
+	if found, err := repo.FindWhere(ctx, store.Like("name", "tw%")); err != nil || len(found) != 2 {
+		t.Errorf("expected LIKE to match both twins, got %d, %v", len(found), err)
+	}
+	if n, err := repo.CountWhere(ctx, store.Condition{Field: "name", Op: store.OpILike, Value: "SO%"}); err != nil || n != 1 {
+		t.Errorf("expected ILIKE to match solo, got %d, %v", n, err)
+	}
+	if _, err := repo.FindFirst(ctx, store.Condition{Field: "name", Op: store.OpRegex, Value: "^tw"}); !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("expected regex on SQLite to fail with ErrNotSupported, got %v", err)
+	}
+	if _, err := repo.FindWhere(ctx, store.Condition{Field: "name", Op: "soundex", Value: "twin"}); !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("expected an unknown operator to fail with ErrNotSupported, got %v", err)
+	}
+}
+
+func TestTypedRepository(t *testing.T) {
+	svc, _ := openTestService(t)
+	repo := sqlstore.TypedRepository(svc, &gadget{})
+	ctx := context.Background()
+
+	if err := repo.CreateBatch(ctx, []*gadget{{ID: "1", Name: "solo"}, {ID: "2", Name: "twin"}, {ID: "3", Name: "twin"}}); err != nil {
+		t.Fatalf("create batch: %v", err)
+	}
+
+	g, err := repo.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if g.Name != "solo" {
+		t.Errorf("unexpected gadget: %+v", g)
+	}
+	if _, err := repo.Get(ctx, "missing"); !store.IsRecordNotFoundError(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+
+	twins, err := repo.FindWhere(ctx, store.Eq("name", "twin"))
+	if err != nil {
+		t.Fatalf("find where: %v", err)
+	}
+	if len(twins) != 2 || twins[0].Name != "twin" {
+		t.Errorf("unexpected twins: %+v", twins)
+	}
+
+	page, err := repo.List(ctx, store.CursorParams{PageSize: 10})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(page.Items) != 3 {
+		t.Errorf("expected 3 gadgets listed, got %d", len(page.Items))
+	}
+
+	batch, err := repo.GetBatch(ctx, []string{"2", "missing"})
+	if err != nil || len(batch) != 1 || batch["2"].Name != "twin" {
+		t.Errorf("unexpected batch: %v, %v", batch, err)
+	}
+}
+
+func TestGetBatchQueriesInChunks(t *testing.T) {
+	ctx := context.Background()
+	config := store.SQLiteConfig(":memory:")
+	svc, err := sqlstore.Open(ctx, limitedAdapter{adapter.NewSQLiteAdapter()}, &config)
+	if err != nil {
+		t.Fatalf("failed to open service: %v", err)
+	}
+	t.Cleanup(func() { _ = svc.Close() })
+
+	repo := svc.Repository(&gadget{})
+	if err := svc.ExecuteSQL(ctx, "CREATE TABLE "+repo.TableName()+" (id TEXT PRIMARY KEY, name TEXT, created_at TIMESTAMP, updated_at TIMESTAMP)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	if err := svc.ExecuteSQL(ctx, "INSERT INTO "+repo.TableName()+" (id, name) VALUES ('1', 'g1'), ('2', 'g2'), ('3', 'g3'), ('4', 'g4')"); err != nil {
+		t.Fatalf("failed to insert gadgets: %v", err)
+	}
+
+	var selects int
+	svc.Use(sqlstore.InterceptorFuncs{Before: func(ctx context.Context, query string, args []any) (context.Context, string, []any) {
+		if strings.HasPrefix(query, "SELECT") {
+			selects++
+			if len(args) > 2 {
+				t.Errorf("expected at most 2 IDs per query, got %d", len(args))
+			}
+		}
+		return ctx, query, args
+	}})
+
+	found, err := repo.GetBatch(ctx, []string{"4", "1", "missing", "3"})
+	if err != nil {
+		t.Fatalf("get batch: %v", err)
+	}
+	if len(found) != 3 || found["1"] == nil || found["3"] == nil || found["4"] == nil {
+		t.Errorf("unexpected batch: %v", found)
+	}
+	if g := found["4"].(*gadget); g.Name != "g4" {
+		t.Errorf("expected entities keyed by ID, got %+v under 4", g)
+	}
+	if selects != 2 {
+		t.Errorf("expected one query per chunk of 2 IDs, got %d", selects)
+	}
+}
+
+func TestBatchStatements(t *testing.T) {
+	svc, repo := openTestService(t)
+	ctx := context.Background()
+	repo = repo.WithInsertChunkSize(2)
+
+	gadgets := []entity.Entity{
+		&gadget{ID: "1", Name: "a"}, &gadget{ID: "2", Name: "b"}, &gadget{ID: "3", Name: "c"},
+		&gadget{ID: "4", Name: "d"}, &gadget{ID: "5", Name: "e"},
+	}
+	if err := repo.CreateBatch(ctx, gadgets); err != nil {
+		t.Fatalf("create batch: %v", err)
+	}
+
+	statements := make(map[string]int)
+	svc.Use(sqlstore.InterceptorFuncs{Before: func(ctx context.Context, query string, args []any) (context.Context, string, []any) {
+		statements[strings.Fields(query)[0]]++
+		return ctx, query, args
+	}})
+
+	for _, ent := range gadgets {
+		ent.(*gadget).Name += "!"
+	}
+	if err := repo.UpdateBatch(ctx, gadgets); err != nil {
+		t.Fatalf("update batch: %v", err)
+	}
+	if statements["UPDATE"] != 3 {
+		t.Errorf("expected one UPDATE per chunk of 2 entities, got %d", statements["UPDATE"])
+	}
+	if g, err := repo.Get(ctx, "5"); err != nil || g.(*gadget).Name != "e!" {
+		t.Errorf("expected the last chunk to be updated, got %v, %v", g, err)
+	}
+
+	err := repo.UpdateBatch(ctx, []entity.Entity{&gadget{ID: "1", Name: "x"}, &gadget{ID: "missing", Name: "x"}})
+	if !store.IsRecordNotFoundError(err) {
+		t.Errorf("expected a missing entity to fail the batch, got %v", err)
+	}
+	if g, _ := repo.Get(ctx, "1"); g.(*gadget).Name != "a!" {
+		t.Errorf("expected a failed batch to change nothing, got %+v", g)
+	}
+
+	if err := repo.DeleteBatch(ctx, []string{"1", "missing"}); !store.IsRecordNotFoundError(err) {
+		t.Errorf("expected a missing ID to fail the batch, got %v", err)
+	}
+	if n, _ := repo.Count(ctx); n != 5 {
+		t.Errorf("expected a failed batch to delete nothing, got %d rows", n)
+	}
+
+	statements = make(map[string]int)
+	if err := repo.DeleteBatch(ctx, []string{"5", "1", "3", "1"}); err != nil {
+		t.Fatalf("delete batch: %v", err)
+	}
+	if statements["DELETE"] != 1 {
+		t.Errorf("expected a single DELETE, got %d", statements["DELETE"])
+	}
+	if n, _ := repo.Count(ctx); n != 2 {
+		t.Errorf("expected 2 gadgets left, got %d", n)
+	}
+}
+
+// listAll pages through repo with List and returns the IDs in page order.
+func listAll(t *testing.T, repo *sqlstore.Repository, params store.CursorParams, visit func(page int)) []string {
+	t.Helper()
+	var ids []string
+	for page := 0; ; page++ {
+		if page > 20 {
+			t.Fatal("pagination did not terminate")
+		}
+		result, err := repo.List(context.Background(), params)
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		for _, ent := range result.Items {
+			ids = append(ids, ent.GetID())
+		}
+		if visit != nil {
+			visit(page)
+		}
+		if !result.HasMore {
+			return ids
+		}
+		params.Cursor = result.NextCursor
+	}
+}
+
+func TestListKeysetPagination(t *testing.T) {
+	svc, repo := openTestService(t)
+	ctx := context.Background()
+
+	var gadgets []entity.Entity
+	for i := range 10 {
+		gadgets = append(gadgets, &gadget{ID: fmt.Sprintf("g%02d", i), Name: "g"})
+	}
+	if err := repo.CreateBatch(ctx, gadgets); err != nil {
+		t.Fatalf("create batch: %v", err)
+	}
+	// Give half of the gadgets the same creation time so the ID breaks ties
+	tie := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := svc.DB().ExecContext(ctx, "UPDATE "+repo.TableName()+" SET created_at = $1 WHERE id IN ('g07', 'g02', 'g09', 'g04', 'g05')", tie); err != nil {
+		t.Fatalf("update created_at: %v", err)
+	}
+
+	ids := listAll(t, repo, store.CursorParams{PageSize: 3}, func(page int) {
+		// Rows created while paging come after the ones already listed
+		if page == 1 {
+			if err := repo.Create(ctx, &gadget{ID: "g00a", Name: "late"}); err != nil {
+				t.Fatalf("create: %v", err)
+			}
+		}
+	})
+	want := []string{"g02", "g04", "g05", "g07", "g09", "g00", "g01", "g03", "g06", "g08", "g00a"}
+	if strings.Join(ids, ",") != strings.Join(want, ",") {
+		t.Errorf("expected pages in (created_at, id) order\nwant %v\ngot  %v", want, ids)
+	}
+
+	_, err := repo.List(ctx, store.CursorParams{PageSize: 3, Cursor: "not-a-cursor"})
+	if !store.IsValidationError(err) {
+		t.Errorf("expected a malformed cursor to be rejected, got %v", err)
+	}
+}
+
+func TestListBackwardPagination(t *testing.T) {
+	_, repo := openTestService(t)
+	ctx := context.Background()
+
+	var gadgets []entity.Entity
+	for i := range 7 {
+		gadgets = append(gadgets, &gadget{ID: fmt.Sprintf("g%d", i), Name: "g"})
+	}
+	if err := repo.CreateBatch(ctx, gadgets); err != nil {
+		t.Fatalf("create batch: %v", err)
+	}
+
+	list := func(params store.CursorParams) store.CursorResult[entity.Entity] {
+		t.Helper()
+		result, err := repo.List(ctx, params)
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		return result
+	}
+	expectPage := func(result store.CursorResult[entity.Entity], want string, next, previous bool) {
+		t.Helper()
+		var ids []string
+		for _, ent := range result.Items {
+			ids = append(ids, ent.GetID())
+		}
+		if got := strings.Join(ids, ","); got != want {
+			t.Errorf("expected page %s, got %s", want, got)
+		}
+		if (result.NextCursor != "") != next || (result.PreviousCursor != "") != previous {
+			t.Errorf("page %s: expected next %v and previous %v, got next %q and previous %q",
+				want, next, previous, result.NextCursor, result.PreviousCursor)
+		}
+	}
+
+	first := list(store.CursorParams{PageSize: 3})
+	expectPage(first, "g0,g1,g2", true, false)
+	second := list(store.CursorParams{PageSize: 3, Cursor: first.NextCursor})
+	expectPage(second, "g3,g4,g5", true, true)
+	third := list(store.CursorParams{PageSize: 3, Cursor: second.NextCursor})
+	expectPage(third, "g6", false, true)
+
+	back := list(store.CursorParams{PageSize: 3, Cursor: third.PreviousCursor})
+	expectPage(back, "g3,g4,g5", true, true)
+	back = list(store.CursorParams{PageSize: 3, Cursor: back.PreviousCursor})
+	expectPage(back, "g0,g1,g2", true, false)
+	forward := list(store.CursorParams{PageSize: 3, Cursor: back.NextCursor})
+	expectPage(forward, "g3,g4,g5", true, true)
+
+	last := list(store.CursorParams{PageSize: 3, Backward: true})
+	expectPage(last, "g4,g5,g6", false, true)
+}
+
+func TestListWithOrderPaginatesCompoundKeys(t *testing.T) {
+	svc, repo := openTestService(t)
+	ctx := context.Background()
+
+	names := map[string]string{"g0": "b", "g1": "a", "g2": "c", "g3": "b", "g4": "a", "g5": "b", "g6": "c"}
+	var gadgets []entity.Entity
+	for id, name := range names {
+		gadgets = append(gadgets, &gadget{ID: id, Name: name})
+	}
+	if err := repo.CreateBatch(ctx, gadgets); err != nil {
+		t.Fatalf("create batch: %v", err)
+	}
+
+	byName := repo.WithListOrder(store.Order{Field: "name", Desc: true})
+	var pages []store.CursorResult[entity.Entity]
+	var ids []string
+	params := store.CursorParams{PageSize: 3}
+	for {
+		page, err := byName.List(ctx, params)
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		pages = append(pages, page)
+		for _, ent := range page.Items {
+			ids = append(ids, ent.GetID())
+		}
+		if !page.HasMore {
+			break
+		}
+		params.Cursor = page.NextCursor
+	}
+	want := "g2,g6,g0,g3,g5,g1,g4"
+	if got := strings.Join(ids, ","); got != want {
+		t.Errorf("expected (name DESC, id) order %s, got %s", want, got)
+	}
+
+	back, err := byName.List(ctx, store.CursorParams{PageSize: 3, Cursor: pages[len(pages)-1].PreviousCursor})
+	if err != nil {
+		t.Fatalf("list backward: %v", err)
+	}
+	var backIDs []string
+	for _, ent := range back.Items {
+		backIDs = append(backIDs, ent.GetID())
+	}
+	if got := strings.Join(backIDs, ","); got != "g3,g5,g1" {
+		t.Errorf("expected the previous page g3,g5,g1, got %s", got)
+	}
+
+	// Cursors only resume lists of the ordering they were made for
+	if _, err := repo.List(ctx, store.CursorParams{PageSize: 3, Cursor: pages[0].NextCursor}); !store.IsValidationError(err) {
+		t.Errorf("expected a cursor of another ordering to be rejected, got %v", err)
+	}
+	first, err := repo.List(ctx, store.CursorParams{PageSize: 3})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if _, err := byName.List(ctx, store.CursorParams{PageSize: 3, Cursor: first.NextCursor}); !store.IsValidationError(err) {
+		t.Errorf("expected a creation time cursor to be rejected, got %v", err)
+	}
+
+	// NULL positions cannot be compared with, so they are rejected
+	paginator := store.NewPaginator()
+	nullCursor, err := paginator.EncodeCursor(paginator.CreateKeyedCursor([]store.CursorKey{
+		{Field: "name", Value: nil, Desc: true}, {Field: "id", Value: "g1"},
+	}, 3, false))
+	if err != nil {
+		t.Fatalf("encode cursor: %v", err)
+	}
+	if _, err := byName.List(ctx, store.CursorParams{PageSize: 3, Cursor: nullCursor}); !store.IsValidationError(err) {
+		t.Errorf("expected a NULL cursor position to be rejected, got %v", err)
+	}
+
+	// A page whose cursor cannot be made fails rather than ending the list
+	if err := svc.ExecuteSQL(ctx, "ALTER TABLE "+repo.TableName()+" ADD COLUMN rank INTEGER DEFAULT 0"); err != nil {
+		t.Fatalf("add column: %v", err)
+	}
+	byRank := repo.WithListOrder(store.Order{Field: "rank"})
+	if _, err := byRank.List(ctx, store.CursorParams{PageSize: 3}); err == nil || !strings.Contains(err.Error(), "rank") {
+		t.Errorf("expected List to fail without an entity field for an ordering column, got %v", err)
+	}
+}
+
+func TestListSignsCursors(t *testing.T) {
+	_, repo := openTestService(t)
+	ctx := context.Background()
+
+	for i := range 5 {
+		if err := repo.Create(ctx, &gadget{ID: fmt.Sprintf("g%d", i), Name: "g"}); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+
+	cfg := store.DefaultPaginationConfig()
+	cfg.CursorSecret = []byte("s3cret")
+	signed := repo.WithPaginator(store.NewPaginatorWithConfig(cfg))
+
+	ids := listAll(t, signed, store.CursorParams{PageSize: 2}, nil)
+	if got := strings.Join(ids, ","); got != "g0,g1,g2,g3,g4" {
+		t.Errorf("expected signed cursors to page through all gadgets, got %s", got)
+	}
+
+	// Cursors of the unsigned repository are rejected
+	page, err := repo.List(ctx, store.CursorParams{PageSize: 2})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	_, err = signed.List(ctx, store.CursorParams{PageSize: 2, Cursor: page.NextCursor})
+	if !store.IsValidationError(err) || !errors.Is(err, store.ErrCursorTampered) {
+		t.Errorf("expected an unsigned cursor to be rejected as tampered, got %v", err)
+	}
+}
+
+func TestRandom(t *testing.T) {
+	_, repo := openTestService(t)
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		if err := repo.Create(ctx, &gadget{ID: fmt.Sprintf("g%02d", i), Name: "g"}); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+
+	sample, err := repo.Random(ctx, 3)
+	if err != nil {
+		t.Fatalf("random: %v", err)
+	}
+	if len(sample) != 3 {
+		t.Fatalf("expected 3 entities, got %d", len(sample))
+	}
+	seen := make(map[string]bool)
+	for _, ent := range sample {
+		if seen[ent.GetID()] {
+			t.Errorf("entity %s sampled twice", ent.GetID())
+		}
+		seen[ent.GetID()] = true
+	}
+
+	all, err := repo.Random(ctx, 50)
+	if err != nil {
+		t.Fatalf("random: %v", err)
+	}
+	if len(all) != 10 {
+		t.Errorf("expected sampling more than the table to return all 10 rows, got %d", len(all))
+	}
+}
+
+func TestInsertStream(t *testing.T) {
+	_, repo := openTestService(t)
+	ctx := context.Background()
+
+	waitForCount := func(want int64) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			n, err := repo.Count(ctx)
+			if err != nil {
+				t.Fatalf("count: %v", err)
+			}
+			if n == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d rows, got %d", want, n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	t.Run("flushes full batches and the rest on close", func(t *testing.T) {
+		ch := make(chan entity.Entity)
+		done := make(chan struct{})
+		var total int64
+		var err error
+		go func() {
+			defer close(done)
+			total, err = repo.InsertStream(ctx, ch, 3)
+		}()
+
+		for i := 0; i < 3; i++ {
+			ch <- &gadget{ID: fmt.Sprintf("s%d", i), Name: "s"}
+		}
+		// A full batch is inserted without waiting for the channel to close
+		waitForCount(3)
+
+		ch <- &gadget{ID: "s3", Name: "s"}
+		close(ch)
+		<-done
+		if err != nil {
+			t.Fatalf("insert stream: %v", err)
+		}
+		if total != 4 {
+			t.Errorf("expected 4 inserted, got %d", total)
+		}
+		waitForCount(4)
+	})
+
+	t.Run("flushes pending entities on cancellation", func(t *testing.T) {
+		streamCtx, cancel := context.WithCancel(ctx)
+		ch := make(chan entity.Entity, 2)
+		ch <- &gadget{ID: "c0", Name: "c"}
+		ch <- &gadget{ID: "c1", Name: "c"}
+
+		done := make(chan struct{})
+		var total int64
+		var err error
+		go func() {
+			defer close(done)
+			total, err = repo.InsertStream(streamCtx, ch, 10)
+		}()
+		for len(ch) > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+		<-done
+
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+		if total != 2 {
+			t.Errorf("expected 2 inserted, got %d", total)
+		}
+		waitForCount(6)
+	})
+}
+
+func TestUpdateBatchSingle(t *testing.T) {
+	svc, repo := openTestService(t)
+	ctx := context.Background()
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := repo.Create(ctx, &gadget{ID: id, Name: "old-" + id}); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+
+	logs := &logRecorder{}
+	svc.SetQueryLogger(logs)
+
+	batch := []entity.Entity{
+		&gadget{ID: "c", Name: "new-c"},
+		&gadget{ID: "a", Name: "new-a"},
+	}
+	if err := repo.UpdateBatchSingle(ctx, batch); err != nil {
+		t.Fatalf("update batch: %v", err)
+	}
+
+	updates := 0
+	for _, entry := range logs.entries {
+		if strings.HasPrefix(entry.SQL, "UPDATE") {
+			updates++
+		}
+	}
+	if updates != 1 {
+		t.Errorf("expected one UPDATE statement, got %d", updates)
+	}
+
+	for id, want := range map[string]string{"a": "new-a", "b": "old-b", "c": "new-c"} {
+		ent, err := repo.Get(ctx, id)
+		if err != nil {
+			t.Fatalf("get %s: %v", id, err)
+		}
+		if got := ent.(*gadget).Name; got != want {
+			t.Errorf("%s: got name %q, want %q", id, got, want)
+		}
+	}
+
+	err := repo.UpdateBatchSingle(ctx, []entity.Entity{&gadget{ID: "a", Name: "x"}, &gadget{ID: "missing", Name: "x"}})
+	if !store.IsRecordNotFoundError(err) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	ent, err := repo.Get(ctx, "a")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if ent.(*gadget).Name != "new-a" {
+		t.Errorf("failed batch was not rolled back: %q", ent.(*gadget).Name)
+	}
+}
+
+func TestCreateBatchUsesMultiRowInserts(t *testing.T) {
+	svc, repo := openTestService(t)
+	ctx := context.Background()
+
+	logs := &logRecorder{}
+	svc.SetQueryLogger(logs)
+
+	entities := make([]entity.Entity, 5)
+	for i := range entities {
+		entities[i] = &gadget{ID: fmt.Sprintf("g%d", i), Name: "bulk"}
+	}
+	if err := repo.WithInsertChunkSize(2).CreateBatch(ctx, entities); err != nil {
+		t.Fatalf("create batch: %v", err)
+	}
+
+	inserts := 0
+	for _, entry := range logs.entries {
+		if strings.HasPrefix(entry.SQL, "INSERT") {
+			inserts++
+		}
+	}
+	if inserts != 3 {
+		t.Errorf("expected 3 INSERT statements for 5 rows in chunks of 2, got %d", inserts)
+	}
+
+	count, err := repo.Count(ctx)
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if count != 5 {
+		t.Errorf("expected 5 gadgets, got %d", count)
+	}
+}
+
+func TestUpsertNativeAndEmulated(t *testing.T) {
+	for _, native := range []bool{true, false} {
+		t.Run(fmt.Sprintf("native=%v", native), func(t *testing.T) {
+			svc, repo := openTestService(t)
+			ctx := context.Background()
+			svc.SetCompiler(svc.Compiler().WithUpsert(native))
+			repo = svc.Repository(&gadget{})
+
+			if err := repo.Upsert(ctx, &gadget{ID: "a", Name: "first"}); err != nil {
+				t.Fatalf("upsert insert: %v", err)
+			}
+			created, err := repo.Get(ctx, "a")
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+
+			time.Sleep(5 * time.Millisecond)
+			if err := repo.Upsert(ctx, &gadget{ID: "a", Name: "second"}); err != nil {
+				t.Fatalf("upsert update: %v", err)
+			}
+			updated, err := repo.Get(ctx, "a")
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			if updated.(*gadget).Name != "second" {
+				t.Errorf("expected the upsert to overwrite the name, got %q", updated.(*gadget).Name)
+			}
+			if !updated.(*gadget).CreatedAt.Equal(created.(*gadget).CreatedAt) {
+				t.Errorf("expected created_at to be kept, got %v then %v", created.(*gadget).CreatedAt, updated.(*gadget).CreatedAt)
+			}
+
+			count, err := repo.Count(ctx)
+			if err != nil {
+				t.Fatalf("count: %v", err)
+			}
+			if count != 1 {
+				t.Errorf("expected one gadget, got %d", count)
+			}
+		})
+	}
+}
+
+func TestStreamFetchesPages(t *testing.T) {
+	svc, repo := openTestService(t)
+	ctx := context.Background()
+
+	for i := 0; i < 7; i++ {
+		if err := repo.Create(ctx, &gadget{ID: fmt.Sprintf("g%d", i), Name: "streamed"}); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+
+	logs := &logRecorder{}
+	svc.SetQueryLogger(logs)
+
+	qb := sqlstore.NewQueryBuilder(repo.TableName()).OrderBy("id", "ASC")
+	var ids []string
+	for ent, err := range repo.Stream(ctx, qb, 3) {
+		if err != nil {
+			t.Fatalf("stream: %v", err)
+		}
+		ids = append(ids, ent.GetID())
+	}
+	if fmt.Sprint(ids) != "[g0 g1 g2 g3 g4 g5 g6]" {
+		t.Errorf("unexpected streamed ids: %v", ids)
+	}
+	if len(logs.entries) != 3 {
+		t.Errorf("expected 3 pages of at most 3 rows, got %d queries", len(logs.entries))
+	}
+	for _, entry := range logs.entries {
+		if strings.Contains(entry.SQL, "OFFSET") {
+			t.Errorf("expected keyset pages without OFFSET, got %s", entry.SQL)
+		}
+	}
+
+	// Repositories page unordered queries by the ID
+	ids = nil
+	for ent, err := range repo.Stream(ctx, sqlstore.NewQueryBuilder(repo.TableName()), 3) {
+		if err != nil {
+			t.Fatalf("stream: %v", err)
+		}
+		ids = append(ids, ent.GetID())
+	}
+	if fmt.Sprint(ids) != "[g0 g1 g2 g3 g4 g5 g6]" {
+		t.Errorf("unexpected streamed ids without an ordering: %v", ids)
+	}
+
+	// A query limit caps the pages, and breaking stops fetching
+	ids = nil
+	for ent, err := range repo.Stream(ctx, qb.Limit(5).Offset(1), 2) {
+		if err != nil {
+			t.Fatalf("stream: %v", err)
+		}
+		ids = append(ids, ent.GetID())
+		if len(ids) == 4 {
+			break
+		}
+	}
+	if fmt.Sprint(ids) != "[g1 g2 g3 g4]" {
+		t.Errorf("unexpected streamed ids: %v", ids)
+	}
+
+	qe := svc.QueryExecutor()
+	var names []string
+	for ent, err := range qe.Stream(ctx, sqlstore.NewQueryBuilder(repo.TableName()).Where("id", "=", "g6"), func() entity.Entity { return &gadget{} }, 0) {
+		if err != nil {
+			t.Fatalf("stream: %v", err)
+		}
+		names = append(names, ent.(*gadget).Name)
+	}
+	if fmt.Sprint(names) != "[streamed]" {
+		t.Errorf("unexpected names: %v", names)
+	}
+
+	unordered := sqlstore.NewQueryBuilder(repo.TableName())
+	for _, err := range qe.Stream(ctx, unordered, func() entity.Entity { return &gadget{} }, 2) {
+		if !errors.Is(err, store.ErrInvalidQuery) {
+			t.Errorf("expected an unordered paged stream to fail, got %v", err)
+		}
+	}
+}
+
+func TestCopyBatchRequiresPostgres(t *testing.T) {
+	_, repo := openTestService(t)
+
+	_, err := repo.CopyBatch(context.Background(), []entity.Entity{&gadget{ID: "a", Name: "copied"}})
+	if !errors.Is(err, store.ErrNotSupported) {
+		t.Fatalf("expected ErrNotSupported on SQLite, got %v", err)
+	}
+}
+
+func TestCopyBatchPostgres(t *testing.T) {
+	host := os.Getenv("POSTGRES_TEST_HOST")
+	if host == "" {
+		t.Skip("POSTGRES_TEST_HOST not set")
+	}
+	ctx := context.Background()
+
+	config := store.PostgreSQLConfig(os.Getenv("POSTGRES_TEST_DATABASE"), os.Getenv("POSTGRES_TEST_USER"), os.Getenv("POSTGRES_TEST_PASSWORD"))
+	config.Host = host
+	config.SSLMode = "disable"
+	svc, err := sqlstore.Open(ctx, adapter.NewPostgreSQLAdapter(), &config)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { _ = svc.Close() })
+
+	repo := svc.Repository(&gadget{})
+	setup := []string{
+		"DROP TABLE IF EXISTS " + repo.TableName(),
+		"CREATE TABLE " + repo.TableName() + " (id TEXT PRIMARY KEY, name TEXT, created_at TIMESTAMPTZ, updated_at TIMESTAMPTZ)",
+	}
+	for _, stmt := range setup {
+		if err := svc.ExecuteSQL(ctx, stmt); err != nil {
+			t.Fatalf("setup failed: %v", err)
+		}
+	}
+	t.Cleanup(func() { _ = svc.ExecuteSQL(ctx, "DROP TABLE "+repo.TableName()) })
+
+	entities := make([]entity.Entity, 1000)
+	for i := range entities {
+		entities[i] = &gadget{ID: fmt.Sprintf("g%04d", i), Name: "copied"}
+	}
+	n, err := repo.CopyBatch(ctx, entities)
+	if err != nil {
+		t.Fatalf("copy batch: %v", err)
+	}
+	if n != 1000 {
+		t.Errorf("expected 1000 rows loaded, got %d", n)
+	}
+
+	ent, err := repo.Get(ctx, "g0999")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if ent.(*gadget).Name != "copied" || ent.(*gadget).CreatedAt.IsZero() {
+		t.Errorf("unexpected copied gadget: %+v", ent)
+	}
+}
+
+func benchmarkUpdateBatch(b *testing.B, update func(*sqlstore.Repository, context.Context, []entity.Entity) error) {
+	_, repo := openTestService(b)
+	ctx := context.Background()
+
+	entities := make([]entity.Entity, 200)
+	for i := range entities {
+		entities[i] = &gadget{ID: fmt.Sprintf("g%03d", i), Name: "initial"}
+	}
+	if err := repo.CreateBatch(ctx, entities); err != nil {
+		b.Fatalf("create batch: %v", err)
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i, ent := range entities {
+			ent.(*gadget).Name = fmt.Sprintf("name-%d-%d", n, i)
+		}
+		if err := update(repo, ctx, entities); err != nil {
+			b.Fatalf("update: %v", err)
+		}
+	}
+}
+
+func BenchmarkUpdateBatchLoop(b *testing.B) {
+	benchmarkUpdateBatch(b, (*sqlstore.Repository).UpdateBatch)
+}
+
+func BenchmarkUpdateBatchSingle(b *testing.B) {
+	benchmarkUpdateBatch(b, (*sqlstore.Repository).UpdateBatchSingle)
+}
+
+type widget struct {
+	ID        string    `json:"id" db:"id"`
+	SerialNo  string    `json:"serialNo" db:"serialNo"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+func (w *widget) GetID() string            { return w.ID }
+func (w *widget) SetID(id string)          { w.ID = id }
+func (w *widget) GetCreatedAt() time.Time  { return w.CreatedAt }
+func (w *widget) SetCreatedAt(t time.Time) { w.CreatedAt = t }
+func (w *widget) GetUpdatedAt() time.Time  { return w.UpdatedAt }
+func (w *widget) SetUpdatedAt(t time.Time) { w.UpdatedAt = t }
+
+func TestScanMatchesColumnsCaseInsensitively(t *testing.T) {
+	svc, _ := openTestService(t)
+	ctx := context.Background()
+	repo := svc.Repository(&widget{})
+
+	// SQLite reports column names as declared, so the table's casing differs
+	// from both the lowercase and camelCase field names.
+	ddl := "CREATE TABLE " + repo.TableName() + " (ID TEXT PRIMARY KEY, SERIALNO TEXT, Created_At TIMESTAMP, UPDATED_AT TIMESTAMP)"
+	if err := svc.ExecuteSQL(ctx, ddl); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	if err := repo.Create(ctx, &widget{ID: "w1", SerialNo: "SN-42"}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	ent, err := repo.FindOneBy(ctx, "id", "w1")
+	if err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	w := ent.(*widget)
+	if w.ID != "w1" || w.SerialNo != "SN-42" {
+		t.Errorf("fields not populated from mixed-case columns: %+v", w)
+	}
+	if w.CreatedAt.IsZero() {
+		t.Error("created_at not populated from Created_At column")
+	}
+}
+
+func TestDriverErrorsTranslateToSentinels(t *testing.T) {
+	_, repo := openTestService(t)
+	ctx := context.Background()
+
+	if err := repo.Create(ctx, &gadget{ID: "dup", Name: "first"}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	err := repo.Create(ctx, &gadget{ID: "dup", Name: "second"})
+	if !errors.Is(err, store.ErrUniqueConstraint) {
+		t.Fatalf("expected a duplicate key to be reported as ErrUniqueConstraint, got %v", err)
+	}
+	var liteErr sqlite3.Error
+	if !errors.As(err, &liteErr) {
+		t.Errorf("expected the driver error to stay reachable, got %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		adapter adapter.Adapter
+		err     error
+		want    error
+	}{
+		{"postgres unique", adapter.NewPostgreSQLAdapter(), &pq.Error{Code: "23505"}, store.ErrUniqueConstraint},
+		{"postgres foreign key", adapter.NewPostgreSQLAdapter(), &pq.Error{Code: "23503"}, store.ErrForeignKeyConstraint},
+		{"postgres connection", adapter.NewPostgreSQLAdapter(), &pq.Error{Code: "08006"}, store.ErrConnectionFailed},
+		{"cockroachdb retry", adapter.NewCockroachDBAdapter(), &pq.Error{Code: "40001"}, store.ErrTransactionAborted},
+		{"mysql duplicate", adapter.NewMySQLAdapter(), &mysql.MySQLError{Number: 1062}, store.ErrUniqueConstraint},
+		{"mysql not null", adapter.NewMySQLAdapter(), &mysql.MySQLError{Number: 1048}, store.ErrNotNullConstraint},
+		{"mysql deadlock", adapter.NewMySQLAdapter(), &mysql.MySQLError{Number: 1213}, store.ErrTransactionAborted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrapped := fmt.Errorf("exec: %w", tt.err)
+			if got := tt.adapter.TranslateError(wrapped); !errors.Is(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	other := &pq.Error{Code: "22001", Message: "value too long"}
+	if got := adapter.NewPostgreSQLAdapter().TranslateError(other); got != error(other) {
+		t.Errorf("expected an unrecognized error to be returned unchanged, got %v", got)
+	}
+}
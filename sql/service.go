@@ -3,6 +3,7 @@ package sqlstore
 import (
 	"context"
 	"database/sql"
+	"log/slog"
 	"os"
 	"strconv"
 	"time"
@@ -14,9 +15,26 @@ import (
 
 // Service wraps a SQL adapter and provides the database service interface.
 type Service struct {
-	adapter adapter.Adapter
-	db      *sql.DB
-	config  *store.Config
+	adapter  adapter.Adapter
+	db       *sql.DB
+	config   *store.Config
+	compiler *SQLCompiler
+
+	degradedLatency time.Duration
+	counts          *countCache
+
+	queryLogger QueryLogger
+	redactor    *ArgRedactor
+	stmts       *stmtCache
+	replicas    *replicaSet
+	watcher     *store.HealthWatcher
+	leaks       *leakDetector
+
+	interceptors []Interceptor
+	metrics      store.MetricsRecorder
+	unregister   []func() // removes the pool gauges
+	logger       store.Logger
+	txs          *txTracker
 }
 
 // Ensure Service implements the service interface.
@@ -24,14 +42,40 @@ var _ store.Service = (*Service)(nil)
 
 // NewService creates a new SQL service with the given adapter.
 func NewService(adpt adapter.Adapter, config *store.Config) *Service {
-	return &Service{
-		adapter: adpt,
-		config:  config,
+	compiler := NewSQLCompiler().
+		WithDialect(DialectOf(adpt)).
+		WithCapabilities(adpt.Capabilities())
+
+	s := &Service{
+		adapter:  adpt,
+		config:   config,
+		compiler: compiler,
+
+		degradedLatency: store.DefaultDegradedLatency,
+		counts:          newCountCache(),
+		redactor:        NewArgRedactor(),
+		logger:          store.NopLogger,
+		txs:             &txTracker{},
 	}
+	if config != nil && config.EnableMetrics {
+		s.metrics = store.DefaultMetrics
+	}
+	return s
 }
 
 // Connect establishes the database connection.
 func (s *Service) Connect(ctx context.Context) error {
+	if err := s.connect(ctx); err != nil {
+		s.logger.Log(ctx, slog.LevelError, "database connection failed",
+			"backend", s.adapter.Name(), "host", s.config.Host, "error", err)
+		return err
+	}
+	s.logger.Log(ctx, slog.LevelInfo, "connected to database",
+		"backend", s.adapter.Name(), "host", s.config.Host, "database", s.config.Database, "replicas", s.Replicas())
+	return nil
+}
+
+func (s *Service) connect(ctx context.Context) error {
 	db, err := s.adapter.Connect(ctx, s.config)
 	if err != nil {
 		return store.WrapConnectionError(err, "connect", string(s.adapter.Name()), s.config.Host)
@@ -59,7 +103,53 @@ func (s *Service) Connect(ctx context.Context) error {
 		return store.WrapConnectionError(err, "ping", string(s.adapter.Name()), s.config.Host)
 	}
 
+	if s.config.WarmUp {
+		if err := warmUp(pingCtx, db, s.config.MaxIdleConns, s.config.MaxOpenConns); err != nil {
+			_ = db.Close()
+			return store.WrapConnectionError(err, "warm_up", string(s.adapter.Name()), s.config.Host)
+		}
+	}
+
 	s.db = db
+	// Keep the replicas added before Connect
+	s.replicaSet().primary = db
+	if err := s.connectReplicas(ctx); err != nil {
+		_ = s.Close()
+		return err
+	}
+
+	if s.config.HealthCheckInterval > 0 {
+		s.watcher = store.NewHealthWatcher(s.config.HealthCheckInterval, db.PingContext, s.resetIdleConns)
+		s.watcher.Start()
+	}
+	s.registerPoolGauges()
+	return nil
+}
+
+// warmUp opens idle connections at once, capped by maxOpen when set, and
+// returns them to the pool.
+func warmUp(ctx context.Context, db *sql.DB, idle, maxOpen int) error {
+	if maxOpen > 0 && idle > maxOpen {
+		idle = maxOpen
+	}
+	conns := make([]*sql.Conn, 0, idle)
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+
+	// Hold every connection until all are open so the pool cannot reuse one.
+	for len(conns) < idle {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		conns = append(conns, conn)
+		if err := conn.PingContext(ctx); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -73,20 +163,196 @@ func (s *Service) Adapter() adapter.Adapter {
 	return s.adapter
 }
 
+// Compiler returns the SQL compiler used by repositories of this service.
+func (s *Service) Compiler() *SQLCompiler {
+	return s.compiler
+}
+
+// SetCompiler replaces the SQL compiler used by repositories created afterwards.
+func (s *Service) SetCompiler(compiler *SQLCompiler) {
+	s.compiler = compiler
+}
+
+// SetDegradedLatency sets the ping latency above which Health reports the
+// database as degraded.
+func (s *Service) SetDegradedLatency(d time.Duration) {
+	s.degradedLatency = d
+}
+
+// SetLogger sets the logger receiving connection events, transaction
+// retries and rollbacks, and constraint violations. A nil logger discards
+// them, which is the default.
+func (s *Service) SetLogger(logger store.Logger) {
+	if logger == nil {
+		logger = store.NopLogger
+	}
+	s.logger = logger
+}
+
+// Logger returns the logger of the service.
+func (s *Service) Logger() store.Logger {
+	return s.logger
+}
+
+// SetQueryLogger sets the logger that receives every statement executed
+// through the service and its repositories. A nil logger disables logging.
+func (s *Service) SetQueryLogger(logger QueryLogger) {
+	s.queryLogger = logger
+}
+
+// SetStatementCacheSize enables caching of up to n prepared statements for
+// queries run through QueryExecutor, evicting the least recently used. Hot
+// queries are then parsed and planned once per connection instead of on
+// every call. A value <= 0 disables the cache and closes its statements.
+func (s *Service) SetStatementCacheSize(n int) {
+	if s.stmts != nil {
+		s.stmts.close()
+		s.stmts = nil
+	}
+	if n > 0 {
+		s.stmts = newStmtCache(s.db, n)
+	}
+}
+
+// CachedStatements returns the number of prepared statements in the
+// statement cache.
+func (s *Service) CachedStatements() int {
+	if s.stmts == nil {
+		return 0
+	}
+	return s.stmts.len()
+}
+
+// Redactor returns the redactor applied to logged statement arguments.
+// Columns of entities implementing SensitiveColumner are added to it when
+// their repository is created.
+func (s *Service) Redactor() *ArgRedactor {
+	return s.redactor
+}
+
+// logQuery reports an executed statement to the interceptors and the query
+// logger, if any.
+func (s *Service) logQuery(ctx context.Context, query string, args []any, start time.Time, err error) {
+	duration := time.Since(start)
+	s.afterQuery(ctx, QueryEvent{SQL: query, Args: args, Duration: duration, Err: err})
+	if s.metrics != nil {
+		s.metrics.ObserveOp(string(s.adapter.Name()), statementVerb(query), duration, err)
+	}
+	if s.queryLogger == nil {
+		return
+	}
+	s.queryLogger.LogQuery(ctx, QueryLogEntry{
+		SQL:      query,
+		Args:     s.redactor.Redact(query, args),
+		Duration: duration,
+		Err:      err,
+	})
+}
+
+// healthAttempts is the number of pings Health makes before giving up.
+const healthAttempts = 3
+
+// Health pings the database and classifies the result. A slow ping, or one
+// that only succeeds after a retry, is degraded; a failure the adapter
+// classifies as a connection error is down, and any other failure is degraded.
+func (s *Service) Health(ctx context.Context) store.HealthStatus {
+	if s.db == nil {
+		return store.HealthStatus{State: store.HealthDown, Error: store.ErrConnectionClosed}
+	}
+
+	var status store.HealthStatus
+	for attempt := 1; attempt <= healthAttempts; attempt++ {
+		start := time.Now()
+		err := s.db.PingContext(ctx)
+		status.Latency = time.Since(start)
+		status.Error = err
+
+		if err == nil {
+			if s.watcher != nil {
+				s.watcher.MarkHealthy()
+			}
+			status.State = store.HealthUp
+			if attempt > 1 || status.Latency > s.degradedLatency {
+				status.State = store.HealthDegraded
+			}
+			return status
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	status.State = store.HealthDegraded
+	if s.adapter.IsConnectionError(status.Error) {
+		status.State = store.HealthDown
+	}
+	status.Error = store.WrapConnectionError(status.Error, "health", string(s.adapter.Name()), s.config.Host)
+	return status
+}
+
+// defaultMaxIdleConns is the idle pool size database/sql uses when
+// MaxIdleConns is not configured.
+const defaultMaxIdleConns = 2
+
+// resetIdleConns closes the idle connections of the pool after a failed
+// health check, so queries dial fresh connections instead of being handed
+// ones broken while the database was unreachable.
+func (s *Service) resetIdleConns(ctx context.Context) error {
+	idle := s.config.MaxIdleConns
+	if idle <= 0 {
+		idle = defaultMaxIdleConns
+	}
+	s.logger.Log(ctx, slog.LevelWarn, "database health check failed, closing idle connections",
+		"backend", s.adapter.Name(), "host", s.config.Host)
+	s.db.SetMaxIdleConns(0)
+	s.db.SetMaxIdleConns(idle)
+	return nil
+}
+
+// LastHealthy returns when the database last answered a ping of the health
+// watcher or of Health. It is the zero time unless Config.HealthCheckInterval
+// enables the watcher.
+func (s *Service) LastHealthy() time.Time {
+	if s.watcher == nil {
+		return time.Time{}
+	}
+	return s.watcher.LastHealthy()
+}
+
 // Close closes the database connection.
 func (s *Service) Close() error {
+	if s.watcher != nil {
+		s.watcher.Stop()
+	}
+	s.unregisterPoolGauges()
+	if s.stmts != nil {
+		s.stmts.close()
+	}
+	if s.replicas != nil {
+		_ = s.replicas.close()
+	}
 	if s.db != nil {
+		s.logger.Log(context.Background(), slog.LevelInfo, "closing database connection",
+			"backend", s.adapter.Name(), "host", s.config.Host)
 		return s.db.Close()
 	}
 	return nil
 }
 
-// Stats returns database connection statistics.
+// Stats is the snapshot returned by Service.Stats.
+type Stats struct {
+	sql.DBStats          // primary pool
+	Transactions TxStats // transactions run through the service
+}
+
+// Stats returns a Stats with the pool statistics of the primary and the
+// transaction counts of TxStats. PoolStats covers the replicas as well.
 func (s *Service) Stats() interface{} {
+	stats := Stats{Transactions: s.TxStats()}
 	if s.db != nil {
-		return s.db.Stats()
+		stats.DBStats = s.db.Stats()
 	}
-	return sql.DBStats{}
+	return stats
 }
 
 // NewRepository creates a new repository for the given entity type.
@@ -99,16 +365,40 @@ func (s *Service) Repository(entity entity.Entity) *Repository {
 	return NewRepository(s, entity)
 }
 
+// TypedRepository creates a repository for entities of type T, returning
+// them as T. ent is the prototype of the entity, as for NewRepository.
+func TypedRepository[T entity.Entity](s *Service, ent T) *store.TypedRepository[T] {
+	return store.NewTypedRepository[T](NewRepository(s, ent))
+}
+
 // WithTimeout creates a context with timeout for operations.
 func (s *Service) WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
 	return context.WithTimeout(ctx, timeout)
 }
 
-// QueryExecutor removed - using direct SQL queries for simplicity
+// QueryExecutor returns a query executor using the service compiler.
+func (s *Service) QueryExecutor() *QueryExecutor {
+	qe := NewQueryExecutor(s.db, s.compiler)
+	qe.logQuery = s.logQuery
+	qe.beforeQuery = s.beforeQuery
+	qe.stmts = s.stmts
+	qe.replicas = s.replicas
+	qe.leaks = s.leaks
+	return qe
+}
+
+// Migrator returns a migrator for the service database.
+func (s *Service) Migrator() *Migrator {
+	return NewMigrator(s.db, s.adapter)
+}
 
 // TransactionHandler returns a new transaction handler.
 func (s *Service) TransactionHandler() *TransactionHandler {
-	return NewTransactionHandler(s.db, s.Adapter())
+	t := NewTransactionHandler(s.db, s.Adapter())
+	t.replicas = s.replicas
+	t.logger = s.logger
+	t.txs = s.txs
+	return t
 }
 
 // Transactor returns a backend-agnostic transaction runner.
@@ -118,13 +408,64 @@ func (s *Service) Transactor() store.Transactor {
 
 // ExecuteSQL executes raw SQL (for migrations, table creation, etc.).
 func (s *Service) ExecuteSQL(ctx context.Context, query string, args ...interface{}) error {
+	ctx, query, args = s.beforeQuery(ctx, query, args)
+	start := time.Now()
 	_, err := s.db.ExecContext(ctx, query, args...)
+	s.logQuery(ctx, query, args, start, err)
 	if err != nil {
 		return store.WrapQueryError(err, "execute_sql", "", query, args)
 	}
 	return nil
 }
 
+// RawQuery runs a raw query with named parameters (:name) bound from params,
+// using the placeholders of the service dialect. It runs inside the
+// transaction stored in ctx when present.
+func (s *Service) RawQuery(ctx context.Context, query string, params map[string]any) (*sql.Rows, error) {
+	bound, args, err := s.compiler.Dialect().BindNamed(query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, bound, args = s.beforeQuery(ctx, bound, args)
+	start := time.Now()
+	var rows *sql.Rows
+	if tx, ok := TransactionFromContext(ctx); ok && tx != nil {
+		rows, err = tx.QueryContext(ctx, bound, args...)
+	} else {
+		rows, err = s.db.QueryContext(ctx, bound, args...)
+	}
+	s.logQuery(ctx, bound, args, start, err)
+	if err != nil {
+		return nil, store.WrapQueryError(err, "raw_query", "", bound, args)
+	}
+	s.leaks.watch(bound, rows)
+	return rows, nil
+}
+
+// RawExec executes a raw statement with named parameters (:name) bound from
+// params, like RawQuery.
+func (s *Service) RawExec(ctx context.Context, query string, params map[string]any) (sql.Result, error) {
+	bound, args, err := s.compiler.Dialect().BindNamed(query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, bound, args = s.beforeQuery(ctx, bound, args)
+	start := time.Now()
+	var result sql.Result
+	if tx, ok := TransactionFromContext(ctx); ok && tx != nil {
+		result, err = tx.ExecContext(ctx, bound, args...)
+	} else {
+		result, err = s.db.ExecContext(ctx, bound, args...)
+	}
+	s.logQuery(ctx, bound, args, start, err)
+	if err != nil {
+		return nil, store.WrapQueryError(err, "raw_exec", "", bound, args)
+	}
+	return result, nil
+}
+
 // Open creates and connects a new SQL service using the specified adapter.
 func Open(ctx context.Context, adapter adapter.Adapter, config *store.Config) (*Service, error) {
 	// Validate configuration first
@@ -162,9 +503,18 @@ func OpenWithName(ctx context.Context, adapterName string, config *store.Config,
 }
 
 // OpenFromEnv creates and connects a new SQL service using environment variables.
-// Uses DB_TYPE (required), DB_HOST, DB_PORT, DB_USERNAME, DB_PASSWORD, DB_NAME, DB_SSL_MODE,
+// DB_URL, parsed by store.ParseURL, takes precedence over the individual variables.
+// Otherwise uses DB_TYPE (required), DB_HOST, DB_PORT, DB_USERNAME, DB_PASSWORD, DB_NAME, DB_SSL_MODE,
 // DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS, DB_CONN_MAX_LIFETIME, DB_CONNECT_TIMEOUT.
 func OpenFromEnv(ctx context.Context) (*Service, error) {
+	if rawURL := os.Getenv("DB_URL"); rawURL != "" {
+		config, err := store.ParseURL(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		return OpenWithName(ctx, config.Type, &config)
+	}
+
 	dbType := os.Getenv("DB_TYPE")
 	if dbType == "" {
 		return nil, store.WrapDriverError(
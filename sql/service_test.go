@@ -0,0 +1,831 @@
+package sqlstore_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+
+	"store"
+	sqlstore "store/sql"
+	"store/sql/adapter"
+)
+
+// Probe settings control how connections of the probe driver answer pings.
+var (
+	probeDelay atomic.Int64 // nanoseconds
+	probeDown  atomic.Bool
+)
+
+func init() {
+	sql.Register("sqlite3_probe", probeDriver{})
+}
+
+type probeDriver struct{}
+
+func (probeDriver) Open(name string) (driver.Conn, error) {
+	conn, err := (&sqlite3.SQLiteDriver{}).Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return probeConn{Conn: conn}, nil
+}
+
+type probeConn struct {
+	driver.Conn
+}
+
+func (c probeConn) Ping(ctx context.Context) error {
+	if probeDown.Load() {
+		return driver.ErrBadConn
+	}
+	select {
+	case <-time.After(time.Duration(probeDelay.Load())):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// probeAdapter connects through the probe driver.
+type probeAdapter struct {
+	*adapter.SQLiteAdapter
+}
+
+func (a probeAdapter) Connect(ctx context.Context, config *store.Config) (*sql.DB, error) {
+	return sql.Open("sqlite3_probe", ":memory:")
+}
+
+func openProbeService(t *testing.T) *sqlstore.Service {
+	t.Helper()
+	probeDelay.Store(0)
+	probeDown.Store(false)
+
+	config := store.SQLiteConfig(":memory:")
+	svc, err := sqlstore.Open(context.Background(), probeAdapter{adapter.NewSQLiteAdapter()}, &config)
+	if err != nil {
+		t.Fatalf("failed to open service: %v", err)
+	}
+	t.Cleanup(func() {
+		probeDown.Store(false)
+		_ = svc.Close()
+	})
+	return svc
+}
+
+func TestHealth(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func()
+		want  store.HealthState
+	}{
+		{"healthy", func() {}, store.HealthUp},
+		{"slow", func() { probeDelay.Store(int64(20 * time.Millisecond)) }, store.HealthDegraded},
+		{"down", func() { probeDown.Store(true) }, store.HealthDown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := openProbeService(t)
+			svc.SetDegradedLatency(10 * time.Millisecond)
+			tt.setup()
+
+			status := svc.Health(context.Background())
+			if status.State != tt.want {
+				t.Fatalf("expected %s, got %s (err: %v)", tt.want, status.State, status.Error)
+			}
+			if tt.want == store.HealthDown && status.Error == nil {
+				t.Error("expected an error for a down database")
+			}
+			if tt.want != store.HealthDown && status.Error != nil {
+				t.Errorf("unexpected error: %v", status.Error)
+			}
+			if tt.name == "slow" && status.Latency < 20*time.Millisecond {
+				t.Errorf("expected measured latency of at least 20ms, got %s", status.Latency)
+			}
+		})
+	}
+}
+
+func TestHealthWatcherTracksLastHealthy(t *testing.T) {
+	probeDelay.Store(0)
+	probeDown.Store(false)
+	config := store.SQLiteConfig(":memory:")
+	config.HealthCheckInterval = 5 * time.Millisecond
+	svc, err := sqlstore.Open(context.Background(), probeAdapter{adapter.NewSQLiteAdapter()}, &config)
+	if err != nil {
+		t.Fatalf("failed to open service: %v", err)
+	}
+	t.Cleanup(func() {
+		probeDown.Store(false)
+		_ = svc.Close()
+	})
+
+	waitHealthy := func(after time.Time) bool {
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			if svc.LastHealthy().After(after) {
+				return true
+			}
+			time.Sleep(time.Millisecond)
+		}
+		return false
+	}
+
+	if !waitHealthy(time.Now()) {
+		t.Fatal("expected the watcher to record a healthy ping")
+	}
+
+	probeDown.Store(true)
+	time.Sleep(20 * time.Millisecond) // let a check that started before the outage finish
+	downAt := time.Now()
+	time.Sleep(30 * time.Millisecond)
+	if svc.LastHealthy().After(downAt) {
+		t.Errorf("expected LastHealthy to stop advancing while down, got %s", svc.LastHealthy())
+	}
+
+	probeDown.Store(false)
+	if !waitHealthy(downAt) {
+		t.Error("expected LastHealthy to advance after recovery")
+	}
+}
+
+func TestTimeoutSentinels(t *testing.T) {
+	t.Run("connect", func(t *testing.T) {
+		probeDelay.Store(int64(200 * time.Millisecond))
+		t.Cleanup(func() { probeDelay.Store(0) })
+
+		config := store.SQLiteConfig(":memory:")
+		config.ConnectTimeout = 20 * time.Millisecond
+		_, err := sqlstore.Open(context.Background(), probeAdapter{adapter.NewSQLiteAdapter()}, &config)
+		assertTimeout(t, err, store.ErrConnectionTimeout)
+	})
+
+	t.Run("query", func(t *testing.T) {
+		svc, _ := openTestService(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		// Counts to a billion; interrupted when the deadline passes.
+		err := svc.ExecuteSQL(ctx, "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < 1000000000) SELECT count(*) FROM c")
+		assertTimeout(t, err, store.ErrQueryTimeout)
+	})
+
+	t.Run("transaction", func(t *testing.T) {
+		svc, _ := openTestService(t)
+		opts := store.TxOptions{Timeout: 20 * time.Millisecond}
+
+		err := svc.TransactionHandler().WithTxOptions(context.Background(), opts, func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		assertTimeout(t, err, store.ErrTransactionTimeout)
+	})
+}
+
+// assertTimeout checks that err is a timeout of exactly the wanted kind.
+func assertTimeout(t *testing.T, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Fatalf("expected %v, got %v", want, err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the error to wrap context.DeadlineExceeded: %v", err)
+	}
+	for _, other := range []error{store.ErrConnectionTimeout, store.ErrQueryTimeout, store.ErrTransactionTimeout} {
+		if other != want && errors.Is(err, other) {
+			t.Errorf("error also matches %v: %v", other, err)
+		}
+	}
+}
+
+func TestConnectWarmUpFillsIdlePool(t *testing.T) {
+	tests := []struct {
+		name     string
+		warmUp   bool
+		wantIdle int
+	}{
+		{"warm up", true, 4},
+		{"lazy", false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := store.SQLiteConfig(filepath.Join(t.TempDir(), "pool.db"))
+			config.MaxOpenConns = 8
+			config.MaxIdleConns = 4
+			config.WarmUp = tt.warmUp
+
+			svc, err := sqlstore.Open(context.Background(), adapter.NewSQLiteAdapter(), &config)
+			if err != nil {
+				t.Fatalf("failed to open service: %v", err)
+			}
+			t.Cleanup(func() { _ = svc.Close() })
+
+			stats := svc.Stats().(sqlstore.Stats)
+			if stats.Idle != tt.wantIdle {
+				t.Errorf("idle connections = %d, want %d", stats.Idle, tt.wantIdle)
+			}
+		})
+	}
+}
+
+// TestCancelStopsServerQuery checks that cancelling a statement's context
+// stops the query on the server, not only on the client. It needs running
+// servers and is configured per dialect with the <PREFIX>_TEST_HOST,
+// <PREFIX>_TEST_USER, <PREFIX>_TEST_PASSWORD and <PREFIX>_TEST_DATABASE
+// environment variables, where PREFIX is MYSQL or POSTGRES.
+func TestCancelStopsServerQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		adapter adapter.Adapter
+		config  func(database, user, password string) store.Config
+		sleep   string
+		running string
+	}{
+		{
+			name:    "mysql",
+			prefix:  "MYSQL",
+			adapter: adapter.NewMySQLAdapter(),
+			config:  store.MySQLConfig,
+			sleep:   "SELECT SLEEP(30)",
+			running: "SELECT COUNT(*) FROM information_schema.PROCESSLIST WHERE INFO LIKE 'SELECT SLEEP(30)%'",
+		},
+		{
+			name:    "postgres",
+			prefix:  "POSTGRES",
+			adapter: adapter.NewPostgreSQLAdapter(),
+			config:  store.PostgreSQLConfig,
+			sleep:   "SELECT pg_sleep(30)",
+			running: "SELECT COUNT(*) FROM pg_stat_activity WHERE state = 'active' AND query LIKE 'SELECT pg_sleep(30)%'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := os.Getenv(tt.prefix + "_TEST_HOST")
+			if host == "" {
+				t.Skip(tt.prefix + "_TEST_HOST not set")
+			}
+			ctx := context.Background()
+
+			config := tt.config(os.Getenv(tt.prefix+"_TEST_DATABASE"), os.Getenv(tt.prefix+"_TEST_USER"), os.Getenv(tt.prefix+"_TEST_PASSWORD"))
+			config.Host = host
+			config.SSLMode = "disable"
+			svc, err := sqlstore.Open(ctx, tt.adapter, &config)
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			t.Cleanup(func() { _ = svc.Close() })
+
+			queryCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			if err := svc.ExecuteSQL(queryCtx, tt.sleep); err == nil {
+				t.Fatal("expected the cancelled query to fail")
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Fatalf("cancelled query returned after %v", elapsed)
+			}
+
+			deadline := time.Now().Add(2 * time.Second)
+			for {
+				var n int
+				if err := svc.DB().QueryRowContext(ctx, tt.running).Scan(&n); err != nil {
+					t.Fatalf("count running queries: %v", err)
+				}
+				if n == 0 {
+					return
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("query still running on the server %v after cancellation", time.Since(start))
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestStatementCache(t *testing.T) {
+	svc, repo := openTestService(t)
+	ctx := context.Background()
+	for _, id := range []string{"a", "b", "c"} {
+		if err := repo.Create(ctx, &gadget{ID: id, Name: "cached"}); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+
+	svc.SetStatementCacheSize(2)
+	qe := svc.QueryExecutor()
+	queries := []*sqlstore.QueryBuilder{
+		sqlstore.NewQueryBuilder(repo.TableName()).Where("id", "=", "a"),
+		sqlstore.NewQueryBuilder(repo.TableName()).Where("id", "!=", "a"),
+		sqlstore.NewQueryBuilder(repo.TableName()).Where("name", "=", "cached"),
+	}
+	for round := 0; round < 2; round++ {
+		for i, want := range []int64{1, 2, 3} {
+			n, err := qe.Count(ctx, queries[i])
+			if err != nil {
+				t.Fatalf("count: %v", err)
+			}
+			if n != want {
+				t.Errorf("query %d: expected %d rows, got %d", i, want, n)
+			}
+		}
+	}
+	if n := svc.CachedStatements(); n != 2 {
+		t.Errorf("expected the cache to hold 2 statements, got %d", n)
+	}
+
+	// Cached statements are rebound to the transaction's connection
+	err := svc.TransactionHandler().WithTx(ctx, func(ctx context.Context) error {
+		if _, err := svc.RawExec(ctx, "DELETE FROM "+repo.TableName()+" WHERE id = :id", map[string]any{"id": "b"}); err != nil {
+			return err
+		}
+		n, err := qe.Count(ctx, queries[2])
+		if err != nil {
+			return err
+		}
+		if n != 2 {
+			t.Errorf("expected the transaction to see its own delete, got %d rows", n)
+		}
+		return errors.New("rollback")
+	})
+	if err == nil {
+		t.Fatal("expected the transaction to roll back")
+	}
+
+	// A statement that fails is dropped rather than reused
+	bad := sqlstore.NewQueryBuilder(repo.TableName()).Where("name", "=", struct{}{})
+	if _, err := qe.Count(ctx, bad); err == nil {
+		t.Fatal("expected an unsupported argument to fail")
+	}
+	if n := svc.CachedStatements(); n != 1 {
+		t.Errorf("expected the failed statement to be evicted, got %d statements", n)
+	}
+
+	if err := svc.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if n := svc.CachedStatements(); n != 0 {
+		t.Errorf("expected Close to empty the cache, got %d statements", n)
+	}
+}
+
+// limitedAdapter is a SQLite adapter reporting fewer capabilities.
+type limitedAdapter struct {
+	*adapter.SQLiteAdapter
+}
+
+func (a limitedAdapter) Capabilities() adapter.Capabilities {
+	caps := a.SQLiteAdapter.Capabilities()
+	caps.Upsert = adapter.UpsertNone
+	caps.Returning = false
+	caps.Savepoints = false
+	caps.JSON = false
+	caps.MaxPlaceholders = 2
+	caps.IdentifierQuote = "`"
+	return caps
+}
+
+func TestServiceFollowsAdapterCapabilities(t *testing.T) {
+	ctx := context.Background()
+	config := store.SQLiteConfig(":memory:")
+	svc, err := sqlstore.Open(ctx, limitedAdapter{adapter.NewSQLiteAdapter()}, &config)
+	if err != nil {
+		t.Fatalf("failed to open service: %v", err)
+	}
+	t.Cleanup(func() { _ = svc.Close() })
+
+	compiler := svc.Compiler()
+	if compiler.MaxParams() != 2 {
+		t.Errorf("expected the placeholder limit to come from the adapter, got %d", compiler.MaxParams())
+	}
+	if _, err := compiler.CompileMutation("gadgets", store.NewUpsert(map[string]any{"id": "a"}, "id")); !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("expected upserts to be emulated without native support, got %v", err)
+	}
+	insert, err := compiler.CompileMutation("gadgets", store.NewInsert(map[string]any{"id": "a"}).WithReturning("id"))
+	if err != nil || strings.Contains(insert.SQL, "RETURNING") {
+		t.Errorf("expected RETURNING to be emulated without native support, got %v, %v", insert, err)
+	}
+	if _, _, err := compiler.CompileQuery(sqlstore.NewQueryBuilder("gadgets").WhereCondition(store.JSONEq("meta", "$.a", "b"))); !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("expected JSON conditions to need JSON support, got %v", err)
+	}
+	if query, _, err := compiler.WithQuotedIdentifiers(true).CompileQuery(sqlstore.NewQueryBuilder("gadgets")); err != nil || !strings.Contains(query, "`gadgets`") {
+		t.Errorf("expected the adapter's identifier quote, got %q, %v", query, err)
+	}
+
+	err = svc.TransactionHandler().WithTx(ctx, func(ctx context.Context) error {
+		return svc.TransactionHandler().WithTxOptions(ctx, store.TxOptions{Propagation: store.PropagationNested}, func(context.Context) error {
+			return nil
+		})
+	})
+	if !errors.Is(err, store.ErrNotSupported) {
+		t.Errorf("expected nested transactions to need savepoints, got %v", err)
+	}
+
+	tests := []struct {
+		adapter adapter.Adapter
+		quote   string
+		upsert  adapter.UpsertSyntax
+		ret     bool
+	}{
+		{adapter.NewPostgreSQLAdapter(), `"`, adapter.UpsertOnConflict, true},
+		{adapter.NewCockroachDBAdapter(), `"`, adapter.UpsertOnConflict, true},
+		{adapter.NewMySQLAdapter(), "`", adapter.UpsertOnDuplicateKey, false},
+		{adapter.NewSQLiteAdapter(), `"`, adapter.UpsertOnConflict, true},
+	}
+	for _, tt := range tests {
+		caps := tt.adapter.Capabilities()
+		if caps.IdentifierQuote != tt.quote || caps.Upsert != tt.upsert || caps.Returning != tt.ret || caps.MaxPlaceholders == 0 {
+			t.Errorf("%s: unexpected capabilities %+v", tt.adapter.Name(), caps)
+		}
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate and its key as PEM
+// files and returns their paths.
+func writeTestCertificate(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "db.internal"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSConfiguration(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+	tlsConf := &store.TLSConfig{CAFile: certFile, CertFile: certFile, KeyFile: keyFile, ServerName: "db.internal"}
+
+	clientConf, err := tlsConf.ClientConfig()
+	if err != nil {
+		t.Fatalf("client config: %v", err)
+	}
+	if clientConf.RootCAs == nil || len(clientConf.Certificates) != 1 || clientConf.ServerName != "db.internal" {
+		t.Errorf("unexpected client config: %+v", clientConf)
+	}
+	if _, err := (&store.TLSConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")}).ClientConfig(); err == nil {
+		t.Error("expected a missing CA file to fail")
+	}
+	if _, err := (&store.TLSConfig{CertFile: certFile}).ClientConfig(); err == nil {
+		t.Error("expected a certificate without a key to fail")
+	}
+
+	pgConfig := store.PostgreSQLConfig("app", "app", "secret")
+	pgConfig.Host = "db.internal"
+	pgConfig.TLS = &store.TLSConfig{CAFile: "/etc/ssl/my ca.pem", CertFile: "/etc/ssl/client.crt", KeyFile: "/etc/ssl/client.key"}
+	connStr := adapter.NewPostgreSQLAdapter().ConnectionString(&pgConfig)
+	for _, want := range []string{"sslmode=verify-full", "sslrootcert='/etc/ssl/my ca.pem'", "sslcert=/etc/ssl/client.crt", "sslkey=/etc/ssl/client.key"} {
+		if !strings.Contains(connStr, want) {
+			t.Errorf("expected %q in %q", want, connStr)
+		}
+	}
+
+	pgConfig.TLS = &store.TLSConfig{ServerName: "other.internal"}
+	if _, err := adapter.NewPostgreSQLAdapter().Connect(context.Background(), &pgConfig); err == nil || !strings.Contains(err.Error(), "server_name") {
+		t.Errorf("expected a server name override to be rejected, got %v", err)
+	}
+
+	myConfig := store.MySQLConfig("app", "app", "secret")
+	myConfig.SSLMode = "require"
+	if connStr := adapter.NewMySQLAdapter().ConnectionString(&myConfig); !strings.Contains(connStr, "tls=skip-verify") {
+		t.Errorf("expected sslmode require to encrypt without verification, got %q", connStr)
+	}
+}
+
+func TestPostgreSQLConnectionStringQuotesValues(t *testing.T) {
+	config := store.PostgreSQLConfig("my app", "app user", `p@ss w'rd\`)
+	connStr := adapter.NewPostgreSQLAdapter().ConnectionString(&config)
+	for _, want := range []string{"dbname='my app'", "user='app user'", `password='p@ss w\'rd\\'`} {
+		if !strings.Contains(connStr, want) {
+			t.Errorf("expected %q in %q", want, connStr)
+		}
+	}
+}
+
+// openReplica creates a SQLite database holding one entry with the given id.
+func openReplica(t *testing.T, id string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "replica.db"))
+	if err != nil {
+		t.Fatalf("open replica: %v", err)
+	}
+	for _, stmt := range []string{"CREATE TABLE entries (id TEXT PRIMARY KEY)", "INSERT INTO entries (id) VALUES ('" + id + "')"} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("setup replica: %v", err)
+		}
+	}
+	return db
+}
+
+func TestReadsRouteToReplicas(t *testing.T) {
+	svc := openFileService(t)
+	ctx := context.Background()
+	svc.AddReplica(openReplica(t, "from-replica"))
+	if svc.Replicas() != 1 {
+		t.Fatalf("expected 1 replica, got %d", svc.Replicas())
+	}
+
+	readID := func(ctx context.Context) string {
+		t.Helper()
+		row, err := svc.QueryExecutor().QueryRow(ctx, sqlstore.NewQueryBuilder("entries").Select("id"))
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		var id string
+		if err := row.Scan(&id); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		return id
+	}
+
+	if err := svc.TransactionHandler().WithTx(ctx, func(ctx context.Context) error {
+		if err := insertEntry(ctx, "from-primary"); err != nil {
+			return err
+		}
+		if id := readID(ctx); id != "from-primary" {
+			t.Errorf("expected reads in a write transaction to use the primary, got %s", id)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("write transaction: %v", err)
+	}
+
+	if id := readID(ctx); id != "from-replica" {
+		t.Errorf("expected a plain read to use the replica, got %s", id)
+	}
+	if id := readID(sqlstore.WithPrimaryReads(ctx)); id != "from-primary" {
+		t.Errorf("expected WithPrimaryReads to read from the primary, got %s", id)
+	}
+	if err := svc.TransactionHandler().WithReadTx(sqlstore.WithPrimaryReads(ctx), func(ctx context.Context) error {
+		if id := readID(ctx); id != "from-primary" {
+			t.Errorf("expected a read-only transaction under WithPrimaryReads to use the primary, got %s", id)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("read transaction: %v", err)
+	}
+	if err := svc.TransactionHandler().WithReadTx(ctx, func(ctx context.Context) error {
+		if id := readID(ctx); id != "from-replica" {
+			t.Errorf("expected a read-only transaction to use the replica, got %s", id)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("read transaction: %v", err)
+	}
+}
+
+func TestReplicaFailureFallsBackToPrimary(t *testing.T) {
+	svc := openFileService(t)
+	ctx := context.Background()
+	if err := svc.ExecuteSQL(ctx, "INSERT INTO entries (id) VALUES ('from-primary')"); err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+
+	broken, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "missing", "replica.db"))
+	if err != nil {
+		t.Fatalf("open replica: %v", err)
+	}
+	svc.AddReplica(broken)
+	svc.AddReplica(openReplica(t, "from-replica"))
+	// Always prefer the first healthy replica
+	svc.SetReplicaPolicy(sqlstore.ReplicaPolicyFunc(func(n int) int { return 0 }))
+
+	var got []string
+	for range 2 {
+		rows, err := svc.QueryExecutor().Query(ctx, sqlstore.NewQueryBuilder("entries").Select("id"))
+		if err != nil {
+			t.Fatalf("expected the read to fall back, got %v", err)
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				t.Fatalf("scan: %v", err)
+			}
+			got = append(got, id)
+		}
+		rows.Close()
+	}
+	if fmt.Sprint(got) != "[from-primary from-replica]" {
+		t.Errorf("expected the failed read on the primary, then the healthy replica, got %v", got)
+	}
+}
+
+func TestReplicasAddedBeforeConnect(t *testing.T) {
+	ctx := context.Background()
+	config := store.SQLiteConfig(filepath.Join(t.TempDir(), "primary.db"))
+	svc := sqlstore.NewService(adapter.NewSQLiteAdapter(), &config)
+	svc.AddReplica(openReplica(t, "from-replica"))
+	if err := svc.Connect(ctx); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { _ = svc.Close() })
+
+	if svc.Replicas() != 1 {
+		t.Fatalf("expected the replica to survive Connect, got %d replicas", svc.Replicas())
+	}
+	row, err := svc.QueryExecutor().QueryRow(ctx, sqlstore.NewQueryBuilder("entries").Select("id"))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	var id string
+	if err := row.Scan(&id); err != nil || id != "from-replica" {
+		t.Errorf("expected the read on the replica, got %q (%v)", id, err)
+	}
+}
+
+func TestPoolStats(t *testing.T) {
+	svc := openFileService(t)
+	svc.AddReplica(openReplica(t, "r"))
+	ctx := context.Background()
+
+	conn, err := svc.DB().Conn(ctx)
+	if err != nil {
+		t.Fatalf("conn: %v", err)
+	}
+	defer conn.Close()
+
+	stats := svc.PoolStats()
+	if len(stats) != 2 || stats[0].Role != "primary" || stats[1].Role != "replica" {
+		t.Fatalf("expected primary and replica pools, got %+v", stats)
+	}
+	if stats[0].MaxOpen != 4 || stats[0].InUse != 1 || stats[0].Open < 1 {
+		t.Errorf("unexpected primary gauges: %+v", stats[0])
+	}
+}
+
+func TestLeakDetection(t *testing.T) {
+	svc := openFileService(t)
+	ctx := context.Background()
+	leaks := make(chan sqlstore.ConnectionLeak, 4)
+	svc.SetLeakDetection(10*time.Millisecond, func(leak sqlstore.ConnectionLeak) { leaks <- leak })
+	qe := svc.QueryExecutor()
+
+	closed, err := qe.Query(ctx, sqlstore.NewQueryBuilder("entries").Select("id"))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	closed.Close()
+
+	leaked, err := qe.Query(ctx, sqlstore.NewQueryBuilder("entries").Select("id").Where("id", "=", "x"))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer leaked.Close()
+
+	select {
+	case leak := <-leaks:
+		if !strings.Contains(leak.SQL, "WHERE") {
+			t.Errorf("expected the leaked query to be reported, got %s", leak.SQL)
+		}
+		if !strings.Contains(string(leak.Stack), "TestLeakDetection") {
+			t.Errorf("expected the caller's stack, got:\n%s", leak.Stack)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a leak report")
+	}
+	select {
+	case leak := <-leaks:
+		t.Errorf("unexpected report for closed rows: %s", leak.SQL)
+	case <-time.After(30 * time.Millisecond):
+	}
+	if svc.ConnectionLeaks() != 1 {
+		t.Errorf("expected 1 leak, got %d", svc.ConnectionLeaks())
+	}
+}
+
+func TestServiceMetrics(t *testing.T) {
+	svc, repo := openTestService(t)
+	ctx := context.Background()
+	metrics := store.NewMetrics("test")
+	svc.SetMetrics(metrics)
+
+	if err := repo.Create(ctx, &gadget{ID: "g1", Name: "widget"}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := repo.Get(ctx, "g1"); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	_ = svc.ExecuteSQL(ctx, "SELECT * FROM missing")
+
+	if count, _ := metrics.Operations("sqlite", "insert"); count != 1 {
+		t.Errorf("expected 1 insert, got %d", count)
+	}
+	if count, errs := metrics.Operations("sqlite", "select"); count != 2 || errs != 1 {
+		t.Errorf("expected 2 selects with 1 error, got %d and %d", count, errs)
+	}
+
+	var sb strings.Builder
+	if err := metrics.WritePrometheus(&sb); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if !strings.Contains(sb.String(), `test_pool_max_open_connections{backend="sqlite",database="",pool="primary"} 1`) {
+		t.Errorf("expected pool gauges, got:\n%s", sb.String())
+	}
+
+	svc.SetMetrics(nil)
+	sb.Reset()
+	_ = metrics.WritePrometheus(&sb)
+	if strings.Contains(sb.String(), "test_pool_") {
+		t.Error("expected pool gauges to be removed with the recorder")
+	}
+}
+
+func TestStructuredLogging(t *testing.T) {
+	svc, repo := openTestService(t)
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	svc.SetLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	if err := repo.Create(ctx, &gadget{ID: "dup", Name: "first"}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := repo.Create(ctx, &gadget{ID: "dup", Name: "second"}); !store.IsConstraintViolation(err) {
+		t.Fatalf("expected a constraint violation, got %v", err)
+	}
+	if !strings.Contains(buf.String(), `level=WARN msg="constraint violation" table=`+repo.TableName()) {
+		t.Errorf("expected the constraint violation to be logged with its table, got %q", buf.String())
+	}
+
+	buf.Reset()
+	err := svc.TransactionHandler().WithTx(ctx, func(ctx context.Context) error {
+		return errors.New("abort")
+	})
+	if err == nil {
+		t.Fatal("expected the transaction to fail")
+	}
+	if !strings.Contains(buf.String(), `level=DEBUG msg="transaction rolled back" error=abort`) {
+		t.Errorf("expected the rollback to be logged, got %q", buf.String())
+	}
+
+	// Constraint violations are logged with the context of the write
+	type traceKey struct{}
+	logger := &ctxLogger{}
+	svc.SetLogger(logger)
+	traced := context.WithValue(ctx, traceKey{}, "req-1")
+	if err := repo.Create(traced, &gadget{ID: "dup", Name: "third"}); !store.IsConstraintViolation(err) {
+		t.Fatalf("expected a constraint violation, got %v", err)
+	}
+	if len(logger.ctxs) == 0 || logger.ctxs[len(logger.ctxs)-1].Value(traceKey{}) != "req-1" {
+		t.Error("expected the constraint violation to be logged with the caller's context")
+	}
+
+	svc.SetLogger(nil)
+	if svc.Logger() != store.NopLogger {
+		t.Error("expected a nil logger to discard records")
+	}
+}
+
+// ctxLogger records the contexts it is called with.
+type ctxLogger struct {
+	ctxs []context.Context
+}
+
+func (l *ctxLogger) Log(ctx context.Context, _ slog.Level, _ string, _ ...any) {
+	l.ctxs = append(l.ctxs, ctx)
+}
@@ -0,0 +1,143 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// SlowQuery describes a statement that took longer than the slow query
+// threshold.
+type SlowQuery struct {
+	SQL      string
+	Args     []any // redacted, see SlowQueryLogger.WithRedactor
+	Duration time.Duration
+	Err      error
+	Location string // file:line of the first caller outside the store packages
+}
+
+// SlowQueryLogger is an Interceptor reporting statements slower than a
+// threshold. Register it with Service.Use.
+type SlowQueryLogger struct {
+	threshold  time.Duration
+	sampleRate float64
+	redactor   *ArgRedactor
+	report     func(ctx context.Context, query SlowQuery)
+}
+
+var _ Interceptor = (*SlowQueryLogger)(nil)
+
+// NewSlowQueryLogger creates a logger reporting every statement that takes
+// at least threshold to report. A nil report logs the query as a warning to
+// the logger of the service the SlowQueryLogger is added to.
+func NewSlowQueryLogger(threshold time.Duration, report func(ctx context.Context, query SlowQuery)) *SlowQueryLogger {
+	return &SlowQueryLogger{threshold: threshold, sampleRate: 1, report: report}
+}
+
+// WithSampleRate returns a copy of the logger reporting only the given
+// fraction, between 0 and 1, of the slow statements.
+func (l *SlowQueryLogger) WithSampleRate(rate float64) *SlowQueryLogger {
+	cp := *l
+	cp.sampleRate = min(max(rate, 0), 1)
+	return &cp
+}
+
+// WithRedactor returns a copy of the logger hiding the arguments redactor
+// marks as sensitive. By default, the redactor of the service the logger is
+// added to, Service.Redactor, is used.
+func (l *SlowQueryLogger) WithRedactor(redactor *ArgRedactor) *SlowQueryLogger {
+	cp := *l
+	cp.redactor = redactor
+	return &cp
+}
+
+// BeforeQuery leaves the statement unchanged.
+func (l *SlowQueryLogger) BeforeQuery(ctx context.Context, query string, args []any) (context.Context, string, []any) {
+	return ctx, query, args
+}
+
+// AfterQuery reports the statement if it was slow.
+func (l *SlowQueryLogger) AfterQuery(ctx context.Context, event QueryEvent) {
+	l.observe(ctx, event)
+}
+
+// OnError reports the failed statement if it was slow, as a timeout is.
+func (l *SlowQueryLogger) OnError(ctx context.Context, event QueryEvent) {
+	l.observe(ctx, event)
+}
+
+// bind returns a copy of the logger defaulting to the redactor and logger
+// of s. Service.Use adds the copy.
+func (l *SlowQueryLogger) bind(s *Service) Interceptor {
+	cp := *l
+	if cp.redactor == nil {
+		cp.redactor = s.Redactor()
+	}
+	if cp.report == nil {
+		cp.report = s.logSlowQuery
+	}
+	return &cp
+}
+
+func (l *SlowQueryLogger) observe(ctx context.Context, event QueryEvent) {
+	if l.report == nil {
+		return // not added to a service
+	}
+	if event.Duration < l.threshold || l.sampleRate < 1 && rand.Float64() >= l.sampleRate {
+		return
+	}
+	args := event.Args
+	if l.redactor != nil {
+		args = l.redactor.Redact(event.SQL, args)
+	}
+	l.report(ctx, SlowQuery{
+		SQL:      event.SQL,
+		Args:     args,
+		Duration: event.Duration,
+		Err:      event.Err,
+		Location: callerLocation(),
+	})
+}
+
+// internalPrefixes are the function name prefixes skipped when locating the
+// caller of a statement: this package, the store root and database/sql.
+var internalPrefixes = []string{
+	reflect.TypeOf(SlowQueryLogger{}).PkgPath() + ".",
+	"store.",
+	"database/sql.",
+}
+
+// callerLocation returns the file and line of the first caller outside the
+// store packages. Interceptors run on the goroutine executing the statement,
+// so that is where the query was issued.
+func callerLocation() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		internal := false
+		for _, prefix := range internalPrefixes {
+			if strings.HasPrefix(frame.Function, prefix) {
+				internal = true
+				break
+			}
+		}
+		if !internal {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// logSlowQuery is the default slow query report.
+func (s *Service) logSlowQuery(ctx context.Context, q SlowQuery) {
+	s.logger.Log(ctx, slog.LevelWarn, "slow query",
+		"duration", q.Duration, "location", q.Location, "sql", q.SQL, "args", q.Args, "error", q.Err)
+}
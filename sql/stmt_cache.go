@@ -0,0 +1,185 @@
+package sqlstore
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// stmtCache keeps the most recently used prepared statements, keyed by SQL
+// text. A *sql.Stmt prepares itself on each pooled connection as needed, so
+// one entry serves every connection. Statements evicted while in use are
+// closed once their last user releases them.
+type stmtCache struct {
+	mu      sync.Mutex
+	db      *sql.DB
+	size    int
+	order   *list.List // of *stmtEntry, most recently used first
+	entries map[string]*list.Element
+	closed  bool
+}
+
+type stmtEntry struct {
+	query   string
+	stmt    *sql.Stmt
+	refs    int
+	evicted bool
+}
+
+func newStmtCache(db *sql.DB, size int) *stmtCache {
+	return &stmtCache{db: db, size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// acquire returns the prepared statement for query, preparing it on a miss,
+// and a function releasing it. It reports false when the statement cannot be
+// prepared or the cache is closed, in which case the caller should run the
+// query unprepared.
+func (c *stmtCache) acquire(ctx context.Context, query string) (*sql.Stmt, func(), bool) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, nil, false
+	}
+	if el, ok := c.entries[query]; ok {
+		c.order.MoveToFront(el)
+		entry := el.Value.(*stmtEntry)
+		entry.refs++
+		c.mu.Unlock()
+		return entry.stmt, func() { c.release(entry) }, true
+	}
+	c.mu.Unlock()
+
+	// Prepare without holding the lock, so a slow prepare does not block
+	// hits on other statements
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		_ = stmt.Close()
+		return nil, nil, false
+	}
+	if el, ok := c.entries[query]; ok {
+		// Another caller prepared the same query concurrently
+		_ = stmt.Close()
+		c.order.MoveToFront(el)
+		entry := el.Value.(*stmtEntry)
+		entry.refs++
+		return entry.stmt, func() { c.release(entry) }, true
+	}
+
+	entry := &stmtEntry{query: query, stmt: stmt, refs: 1}
+	c.entries[query] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		c.evict(c.order.Back())
+	}
+	return entry.stmt, func() { c.release(entry) }, true
+}
+
+func (c *stmtCache) release(entry *stmtEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry.refs--
+	if entry.evicted && entry.refs == 0 {
+		_ = entry.stmt.Close()
+	}
+}
+
+// discard drops the cached statement for query after it failed, so the next
+// call prepares it again instead of reusing a statement that may have gone
+// stale, e.g. after a schema change.
+func (c *stmtCache) discard(query string, stmt *sql.Stmt) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[query]; ok && el.Value.(*stmtEntry).stmt == stmt {
+		c.evict(el)
+	}
+}
+
+// evict removes el from the cache, closing its statement when unused.
+// c.mu must be held.
+func (c *stmtCache) evict(el *list.Element) {
+	entry := c.order.Remove(el).(*stmtEntry)
+	delete(c.entries, entry.query)
+	entry.evicted = true
+	if entry.refs == 0 {
+		_ = entry.stmt.Close()
+	}
+}
+
+// len returns the number of cached statements.
+func (c *stmtCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// close closes every cached statement and disables the cache.
+func (c *stmtCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for c.order.Len() > 0 {
+		c.evict(c.order.Back())
+	}
+}
+
+// cachingQueryer runs queries through prepared statements from a stmtCache.
+// Inside a transaction the cached statement is rebound to the transaction's
+// connection.
+type cachingQueryer struct {
+	q     queryer
+	cache *stmtCache
+	tx    *sql.Tx
+}
+
+func (c cachingQueryer) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	stmt, release, ok := c.cache.acquire(ctx, query)
+	if !ok {
+		return c.q.QueryContext(ctx, query, args...)
+	}
+	defer release()
+	rows, err := c.bind(ctx, stmt).QueryContext(ctx, args...)
+	if err != nil && ctx.Err() == nil {
+		c.cache.discard(query, stmt)
+	}
+	return rows, err
+}
+
+func (c cachingQueryer) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	stmt, release, ok := c.cache.acquire(ctx, query)
+	if !ok {
+		return c.q.QueryRowContext(ctx, query, args...)
+	}
+	defer release()
+	row := c.bind(ctx, stmt).QueryRowContext(ctx, args...)
+	if err := row.Err(); err != nil && ctx.Err() == nil {
+		c.cache.discard(query, stmt)
+	}
+	return row
+}
+
+// bind returns stmt rebound to the transaction, when there is one, so the
+// query runs on the transaction's connection.
+func (c cachingQueryer) bind(ctx context.Context, stmt *sql.Stmt) *sql.Stmt {
+	if c.tx == nil {
+		return stmt
+	}
+	return c.tx.StmtContext(ctx, stmt)
+}
+
+// withStmtCache wraps q so its queries use cached prepared statements, when
+// cache is set. tx is the transaction q belongs to, if any.
+func withStmtCache(q queryer, cache *stmtCache, tx *sql.Tx) queryer {
+	if cache == nil {
+		return q
+	}
+	return cachingQueryer{q: q, cache: cache, tx: tx}
+}
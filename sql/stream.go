@@ -0,0 +1,123 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+
+	"core/entity"
+	"store"
+)
+
+// Stream returns an iterator over the entities produced by qb, each created
+// with newEntity and filled from its row, so large results can be processed
+// without holding them in memory. A pageSize <= 0 runs one query and reads
+// its rows as the iterator advances. With a pageSize > 0 the query runs in
+// pages of at most pageSize rows (a LIMIT, not a driver fetch size), so no
+// single statement holds a connection for the whole iteration. Each page
+// continues after the last row of the previous one, as List does, so qb must
+// be ordered by non-NULL entity fields ending with a unique one. The
+// iterator stops at the first error, which it yields with a nil entity.
+func (qe *QueryExecutor) Stream(ctx context.Context, qb *QueryBuilder, newEntity func() entity.Entity, pageSize int) iter.Seq2[entity.Entity, error] {
+	return func(yield func(entity.Entity, error) bool) {
+		fieldNames := fieldNamesOf(newEntity())
+
+		if pageSize <= 0 {
+			qe.streamPage(ctx, qb, newEntity, fieldNames, yield)
+			return
+		}
+		if len(qb.orders) == 0 || qb.random {
+			yield(nil, fmt.Errorf("%w: a paged stream needs an ORDER BY ending with a unique column", store.ErrInvalidQuery))
+			return
+		}
+
+		page := *qb
+		fetched := 0
+		for {
+			page.limit = pageSize
+			if qb.limit > 0 {
+				page.limit = min(pageSize, qb.limit-fetched)
+			}
+
+			var last entity.Entity
+			n, more := qe.streamPage(ctx, &page, newEntity, fieldNames, func(ent entity.Entity, err error) bool {
+				last = ent
+				return yield(ent, err)
+			})
+			fetched += n
+			if !more || n < page.limit || qb.limit > 0 && fetched >= qb.limit {
+				return
+			}
+
+			keys, err := orderKeys(last, qb.orders)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			values := make([]any, len(keys))
+			for i, key := range keys {
+				values[i] = key.Value
+			}
+			page.offset = 0
+			page.nodes = append(slices.Clip(qb.nodes), keysetAfter(qb.orders, values))
+		}
+	}
+}
+
+// streamPage yields the entities of one query. It returns the number of
+// rows yielded and whether iteration should continue.
+func (qe *QueryExecutor) streamPage(ctx context.Context, qb *QueryBuilder, newEntity func() entity.Entity,
+	fieldNames map[string]string, yield func(entity.Entity, error) bool) (int, bool) {
+	query, args, err := qe.compiler.CompileQuery(qb)
+	if err != nil {
+		yield(nil, err)
+		return 0, false
+	}
+
+	rows, err := qe.conn(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		yield(nil, store.WrapQueryError(err, "stream", qb.table, query, args))
+		return 0, false
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		values, err := scanRowToValues(rows)
+		if err != nil {
+			yield(nil, store.WrapQueryError(err, "stream", qb.table, query, args))
+			return n, false
+		}
+		ent := newEntity()
+		if err := fillEntity(ent, fieldNames, values); err != nil {
+			yield(nil, store.WrapQueryError(err, "stream", qb.table, query, args))
+			return n, false
+		}
+		n++
+		if !yield(ent, nil) {
+			return n, false
+		}
+	}
+	if err := rows.Err(); err != nil {
+		yield(nil, store.WrapQueryError(err, "stream", qb.table, query, args))
+		return n, false
+	}
+	return n, true
+}
+
+// Stream returns an iterator over the repository's entities matching qb,
+// fetched in pages of pageSize rows as QueryExecutor.Stream does. qb should
+// select from the repository's table; it is ordered by the ID when it has no
+// ordering.
+func (r *Repository) Stream(ctx context.Context, qb *QueryBuilder, pageSize int) iter.Seq2[entity.Entity, error] {
+	qe := NewQueryExecutor(r.sqlService.db, r.compiler)
+	qe.logQuery = r.sqlService.logQuery
+	qe.beforeQuery = r.sqlService.beforeQuery
+	qe.replicas = r.sqlService.replicas
+	if len(qb.orders) == 0 && !qb.random {
+		ordered := *qb
+		qb = ordered.OrderBy(r.IDColumn(), "ASC")
+	}
+	return qe.Stream(r.bindTx(ctx), qb, r.CreateNewEntity, pageSize)
+}
@@ -3,9 +3,12 @@ package sqlstore
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"math"
+	"log/slog"
 	"store"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"store/sql/adapter"
@@ -19,6 +22,70 @@ type TxInfo struct {
 	ReadOnly  bool
 	StartTime time.Time
 	Options   store.TxOptions
+
+	mu            sync.Mutex
+	afterCommit   []func(context.Context)
+	afterRollback []func(context.Context)
+}
+
+// RegisterAfterCommit queues fn to run once the transaction in ctx commits.
+// Callbacks registered within a nested transaction are dropped when it rolls
+// back to its savepoint; the others run, in registration order, only after
+// the outermost commit. It fails with ErrNoTransaction outside a transaction.
+func RegisterAfterCommit(ctx context.Context, fn func(context.Context)) error {
+	info, ok := TxInfoFromContext(ctx)
+	if !ok {
+		return store.NewTransactionError(store.ErrNoTransaction, "register_after_commit")
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	info.afterCommit = append(info.afterCommit, fn)
+	return nil
+}
+
+// RegisterAfterRollback queues fn to run once the transaction in ctx rolls
+// back, or fails to commit. It fails with ErrNoTransaction outside a
+// transaction.
+func RegisterAfterRollback(ctx context.Context, fn func(context.Context)) error {
+	info, ok := TxInfoFromContext(ctx)
+	if !ok {
+		return store.NewTransactionError(store.ErrNoTransaction, "register_after_rollback")
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	info.afterRollback = append(info.afterRollback, fn)
+	return nil
+}
+
+// runHooks runs the after-commit or after-rollback callbacks of info.
+func (info *TxInfo) runHooks(ctx context.Context, committed bool) {
+	info.mu.Lock()
+	hooks := info.afterRollback
+	if committed {
+		hooks = info.afterCommit
+	}
+	info.afterCommit, info.afterRollback = nil, nil
+	info.mu.Unlock()
+
+	for _, fn := range hooks {
+		fn(ctx)
+	}
+}
+
+// pendingCommitHooks returns the number of after-commit callbacks queued.
+func (info *TxInfo) pendingCommitHooks() int {
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	return len(info.afterCommit)
+}
+
+// dropCommitHooks discards the after-commit callbacks queued after the first n.
+func (info *TxInfo) dropCommitHooks(n int) {
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	if n < len(info.afterCommit) {
+		info.afterCommit = info.afterCommit[:n]
+	}
 }
 
 // TransactionFromContext extracts an *sql.Tx from context when present.
@@ -28,7 +95,7 @@ func TransactionFromContext(ctx context.Context) (*sql.Tx, bool) {
 		return nil, false
 	}
 	tx, ok := v.(*sql.Tx)
-	return tx, ok
+	return tx, ok && tx != nil
 }
 
 // TxInfoFromContext extracts transaction info from context.
@@ -38,16 +105,40 @@ func TxInfoFromContext(ctx context.Context) (*TxInfo, bool) {
 		return nil, false
 	}
 	info, ok := v.(*TxInfo)
-	return info, ok
+	return info, ok && info != nil
+}
+
+// withoutTx returns a context in which the transaction of ctx, if any, is
+// suspended.
+func withoutTx(ctx context.Context) context.Context {
+	if _, ok := TransactionFromContext(ctx); !ok {
+		return ctx
+	}
+	ctx = context.WithValue(ctx, txContextKey{}, (*sql.Tx)(nil))
+	return context.WithValue(ctx, txInfoKey{}, (*TxInfo)(nil))
 }
 
 type TransactionHandler struct {
 	db      *sql.DB
 	adapter adapter.Adapter
+
+	// replicas, when set, serve read-only transactions.
+	replicas *replicaSet
+	logger   store.Logger
+	// txs, when set, counts transactions and applies the watchdog.
+	txs *txTracker
 }
 
 func NewTransactionHandler(db *sql.DB, adpt adapter.Adapter) *TransactionHandler {
-	return &TransactionHandler{db: db, adapter: adpt}
+	return &TransactionHandler{db: db, adapter: adpt, logger: store.NopLogger}
+}
+
+// SetLogger sets the logger receiving retries, rollbacks and failed commits.
+func (t *TransactionHandler) SetLogger(logger store.Logger) {
+	if logger == nil {
+		logger = store.NopLogger
+	}
+	t.logger = logger
 }
 
 // Ensure TransactionHandler satisfies enhanced interfaces.
@@ -65,12 +156,39 @@ func (t *TransactionHandler) WithReadTx(ctx context.Context, fn func(context.Con
 }
 
 func (t *TransactionHandler) WithTxOptions(ctx context.Context, opts store.TxOptions, fn func(context.Context) error) error {
-	// Reuse existing transaction if present
-	if existing, ok := TransactionFromContext(ctx); ok && existing != nil {
+	_, inTx := TransactionFromContext(ctx)
+	switch opts.Propagation {
+	case store.PropagationMandatory:
+		if !inTx {
+			return store.NewTransactionError(store.ErrNoTransaction, "mandatory")
+		}
 		return fn(ctx)
+	case store.PropagationSupports:
+		return fn(ctx)
+	case store.PropagationNotSupported:
+		return fn(withoutTx(ctx))
 	}
 
-	// Apply retry policy if specified
+	if inTx {
+		switch opts.Propagation {
+		case store.PropagationRequiresNew:
+			// Start an independent transaction; it shadows the existing one in the
+			// context passed to fn, suspending it until fn returns
+		case store.PropagationNested:
+			return t.executeNested(ctx, fn)
+		default:
+			// Reuse existing transaction
+			return fn(ctx)
+		}
+	}
+
+	// Apply retry policy if specified, or the adapter's own when it
+	// requires client-side retries
+	if opts.RetryPolicy == nil {
+		if r, ok := t.adapter.(interface{ TxRetryPolicy() *store.RetryPolicy }); ok {
+			opts.RetryPolicy = r.TxRetryPolicy()
+		}
+	}
 	if opts.RetryPolicy != nil {
 		return t.withRetry(ctx, opts, fn)
 	}
@@ -148,10 +266,19 @@ func (t *TransactionHandler) executeTx(ctx context.Context, opts store.TxOptions
 		defer cancel()
 	}
 
+	ctx, done := t.txs.track(ctx, opts.ReadOnly)
+	defer done()
+
 	// Convert options to SQL transaction options
 	sqlOpts := t.toSQLTxOptions(opts)
 
-	tx, err := t.db.BeginTx(ctx, sqlOpts)
+	var tx *sql.Tx
+	var err error
+	if opts.ReadOnly && t.replicas.len() > 0 {
+		tx, err = t.replicas.beginRead(ctx, sqlOpts)
+	} else {
+		tx, err = t.db.BeginTx(ctx, sqlOpts)
+	}
 	if err != nil {
 		return store.WrapTransactionError(err, "begin")
 	}
@@ -169,28 +296,85 @@ func (t *TransactionHandler) executeTx(ctx context.Context, opts store.TxOptions
 
 	// Execute function
 	if err := fn(ctxWithInfo); err != nil {
-		_ = tx.Rollback()
-		return store.WrapTransactionError(err, "rollback")
+		// A transaction whose context was canceled is already rolled back
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			t.logger.Log(ctx, slog.LevelError, "transaction rollback failed", "error", rbErr, "cause", err)
+		} else {
+			t.logger.Log(ctx, slog.LevelDebug, "transaction rolled back", "error", err)
+		}
+		info.runHooks(ctx, false)
+		return store.WrapTransactionError(txAborted(ctx, txTimeout(ctx, opts, err)), "rollback")
 	}
 
 	// Commit transaction
 	if err := tx.Commit(); err != nil {
-		return store.WrapTransactionError(err, "commit")
+		t.logger.Log(ctx, slog.LevelError, "transaction commit failed", "error", err)
+		info.runHooks(ctx, false)
+		return store.WrapTransactionError(txAborted(ctx, txTimeout(ctx, opts, err)), "commit")
 	}
 
+	info.runHooks(ctx, true)
 	return nil
 }
 
+// txTimeout marks err as a transaction timeout when the transaction's own
+// timeout has elapsed, even if the statement that failed reported a query
+// timeout.
+func txTimeout(ctx context.Context, opts store.TxOptions, err error) error {
+	if opts.Timeout <= 0 || !errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, store.ErrTransactionTimeout) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", store.ErrTransactionTimeout, err)
+}
+
+// savepointSeq generates unique savepoint names for nested transactions.
+var savepointSeq atomic.Uint64
+
+// executeNested runs fn within a savepoint of the transaction in ctx, rolling
+// back to the savepoint when fn fails so the outer transaction stays usable.
+func (t *TransactionHandler) executeNested(ctx context.Context, fn func(context.Context) error) error {
+	if !t.adapter.Capabilities().Savepoints {
+		return fmt.Errorf("%w: nested transactions on %s", store.ErrNotSupported, t.adapter.Name())
+	}
+	name := fmt.Sprintf("sp_%d", savepointSeq.Add(1))
+
+	if err := t.Savepoint(ctx, name); err != nil {
+		return err
+	}
+	info, hasInfo := TxInfoFromContext(ctx)
+	var hooks int
+	if hasInfo {
+		hooks = info.pendingCommitHooks()
+	}
+
+	if err := fn(ctx); err != nil {
+		if rbErr := t.RollbackToSavepoint(ctx, name); rbErr != nil {
+			return rbErr
+		}
+		if hasInfo {
+			info.dropCommitHooks(hooks)
+		}
+		return store.WrapTransactionError(err, "rollback_savepoint")
+	}
+
+	return t.ReleaseSavepoint(ctx, name)
+}
+
 func (t *TransactionHandler) withRetry(ctx context.Context, opts store.TxOptions, fn func(context.Context) error) error {
 	retryPolicy := opts.RetryPolicy
+	start := time.Now()
 	var lastErr error
 
 	for attempt := 0; attempt <= retryPolicy.MaxRetries; attempt++ {
 		if attempt > 0 {
-			// Calculate delay with exponential backoff
-			delay := time.Duration(float64(retryPolicy.InitialDelay) * math.Pow(retryPolicy.BackoffMultiplier, float64(attempt-1)))
-			if delay > retryPolicy.MaxDelay {
-				delay = retryPolicy.MaxDelay
+			delay := retryPolicy.Delay(attempt)
+			if retryPolicy.MaxElapsedTime > 0 && time.Since(start)+delay > retryPolicy.MaxElapsedTime {
+				break
+			}
+			t.logger.Log(ctx, slog.LevelWarn, "retrying transaction",
+				"attempt", attempt, "max_retries", retryPolicy.MaxRetries, "delay", delay, "error", lastErr)
+			if retryPolicy.OnRetry != nil {
+				retryPolicy.OnRetry(attempt, lastErr, delay)
 			}
 
 			select {
@@ -208,8 +392,7 @@ func (t *TransactionHandler) withRetry(ctx context.Context, opts store.TxOptions
 
 		lastErr = err
 
-		// Check if error is retryable (implementation-specific)
-		if !t.isRetryableError(err) {
+		if !t.adapter.IsRetryableTxError(err) {
 			break
 		}
 	}
@@ -254,47 +437,3 @@ func (t *TransactionHandler) toSQLIsolationLevel(level store.IsolationLevel) sql
 		return sql.LevelDefault
 	}
 }
-
-func (t *TransactionHandler) isRetryableError(err error) bool {
-	// This is database-specific logic
-	// For now, implement basic retry logic for common conflict errors
-	if store.IsTransactionError(err) {
-		return true
-	}
-
-	// Check for specific SQL error codes that indicate conflicts
-	// This would be enhanced per database adapter
-	errMsg := err.Error()
-
-	// Common conflict indicators
-	retryablePatterns := []string{
-		"serialization failure",
-		"deadlock",
-		"lock wait timeout",
-		"could not serialize",
-	}
-
-	for _, pattern := range retryablePatterns {
-		if contains(errMsg, pattern) {
-			return true
-		}
-	}
-
-	return false
-}
-
-// Helper function
-func contains(s, substr string) bool {
-	return len(substr) <= len(s) && (len(substr) == 0 || s[len(s)-len(substr):] == substr ||
-		(len(s) > len(substr) && s[:len(substr)] == substr) ||
-		(len(s) > len(substr) && findSubstring(s, substr)))
-}
-
-func findSubstring(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
-		}
-	}
-	return false
-}
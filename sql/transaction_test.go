@@ -0,0 +1,449 @@
+package sqlstore_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	sqlite3 "github.com/mattn/go-sqlite3"
+
+	"store"
+	sqlstore "store/sql"
+	"store/sql/adapter"
+)
+
+// openFileService opens a file-backed SQLite service that allows several
+// connections, so independent transactions can run side by side.
+func openFileService(t *testing.T) *sqlstore.Service {
+	t.Helper()
+	ctx := context.Background()
+
+	config := store.SQLiteConfig(filepath.Join(t.TempDir(), "tx.db"))
+	config.MaxOpenConns = 4
+	svc, err := sqlstore.Open(ctx, adapter.NewSQLiteAdapter(), &config)
+	if err != nil {
+		t.Fatalf("failed to open sqlite service: %v", err)
+	}
+	t.Cleanup(func() { _ = svc.Close() })
+
+	if err := svc.ExecuteSQL(ctx, "CREATE TABLE entries (id TEXT PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	return svc
+}
+
+func insertEntry(ctx context.Context, id string) error {
+	tx, ok := sqlstore.TransactionFromContext(ctx)
+	if !ok {
+		return errors.New("no transaction in context")
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO entries (id) VALUES ($1)", id)
+	return err
+}
+
+func entryIDs(t *testing.T, svc *sqlstore.Service) map[string]bool {
+	t.Helper()
+	rows, err := svc.DB().Query("SELECT id FROM entries")
+	if err != nil {
+		t.Fatalf("query entries: %v", err)
+	}
+	defer rows.Close()
+
+	ids := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatalf("scan entry: %v", err)
+		}
+		ids[id] = true
+	}
+	return ids
+}
+
+var errAbort = errors.New("abort")
+
+func TestPropagationRequiredJoinsOuterTransaction(t *testing.T) {
+	svc := openFileService(t)
+	th := svc.TransactionHandler()
+
+	err := th.WithTx(context.Background(), func(ctx context.Context) error {
+		if err := insertEntry(ctx, "outer"); err != nil {
+			return err
+		}
+		inner := th.WithTxOptions(ctx, store.TxOptions{Propagation: store.PropagationRequired}, func(ctx context.Context) error {
+			return insertEntry(ctx, "inner")
+		})
+		if inner != nil {
+			return inner
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("expected abort error, got %v", err)
+	}
+
+	if ids := entryIDs(t, svc); len(ids) != 0 {
+		t.Errorf("expected joined work to roll back with the outer transaction, got %v", ids)
+	}
+}
+
+func TestPropagationRequiresNewCommitsIndependently(t *testing.T) {
+	svc := openFileService(t)
+	th := svc.TransactionHandler()
+
+	err := th.WithTx(context.Background(), func(ctx context.Context) error {
+		outerTx, _ := sqlstore.TransactionFromContext(ctx)
+
+		err := th.WithTxOptions(ctx, store.TxOptions{Propagation: store.PropagationRequiresNew}, func(ctx context.Context) error {
+			if tx, _ := sqlstore.TransactionFromContext(ctx); tx == outerTx {
+				return errors.New("requires_new reused the outer transaction")
+			}
+			return insertEntry(ctx, "committed")
+		})
+		if err != nil {
+			return err
+		}
+
+		err = th.WithTxOptions(ctx, store.TxOptions{Propagation: store.PropagationRequiresNew}, func(ctx context.Context) error {
+			if err := insertEntry(ctx, "rolled_back"); err != nil {
+				return err
+			}
+			return errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			return err
+		}
+
+		if err := insertEntry(ctx, "outer"); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("expected abort error, got %v", err)
+	}
+
+	ids := entryIDs(t, svc)
+	if !ids["committed"] {
+		t.Error("expected independent transaction to stay committed after outer rollback")
+	}
+	if ids["rolled_back"] || ids["outer"] {
+		t.Errorf("unexpected rows after rollbacks: %v", ids)
+	}
+}
+
+func TestPropagationNestedRollsBackToSavepoint(t *testing.T) {
+	svc := openFileService(t)
+	th := svc.TransactionHandler()
+	nested := store.TxOptions{Propagation: store.PropagationNested}
+
+	err := th.WithTx(context.Background(), func(ctx context.Context) error {
+		if err := insertEntry(ctx, "outer"); err != nil {
+			return err
+		}
+
+		err := th.WithTxOptions(ctx, nested, func(ctx context.Context) error {
+			if err := insertEntry(ctx, "failed"); err != nil {
+				return err
+			}
+			return errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			return err
+		}
+
+		return th.WithTxOptions(ctx, nested, func(ctx context.Context) error {
+			return insertEntry(ctx, "nested")
+		})
+	})
+	if err != nil {
+		t.Fatalf("outer transaction failed: %v", err)
+	}
+
+	ids := entryIDs(t, svc)
+	if !ids["outer"] || !ids["nested"] {
+		t.Errorf("expected outer and successful nested rows, got %v", ids)
+	}
+	if ids["failed"] {
+		t.Error("expected failed nested work to be rolled back to its savepoint")
+	}
+}
+
+func TestPropagationWithoutTransaction(t *testing.T) {
+	svc := openFileService(t)
+	th := svc.TransactionHandler()
+	ctx := context.Background()
+
+	run := func(ctx context.Context, p store.Propagation) (inTx bool, err error) {
+		err = th.WithTxOptions(ctx, store.TxOptions{Propagation: p}, func(ctx context.Context) error {
+			inTx = th.HasTx(ctx)
+			return nil
+		})
+		return inTx, err
+	}
+
+	if _, err := run(ctx, store.PropagationMandatory); !errors.Is(err, store.ErrNoTransaction) {
+		t.Errorf("expected mandatory propagation to require a transaction, got %v", err)
+	}
+	if inTx, err := run(ctx, store.PropagationSupports); err != nil || inTx {
+		t.Errorf("expected supports propagation to run without a transaction, got %v, %v", inTx, err)
+	}
+
+	err := th.WithTx(ctx, func(ctx context.Context) error {
+		if inTx, err := run(ctx, store.PropagationMandatory); err != nil || !inTx {
+			t.Errorf("expected mandatory propagation to join the transaction, got %v, %v", inTx, err)
+		}
+		if inTx, err := run(ctx, store.PropagationSupports); err != nil || !inTx {
+			t.Errorf("expected supports propagation to join the transaction, got %v, %v", inTx, err)
+		}
+		if inTx, err := run(ctx, store.PropagationNotSupported); err != nil || inTx {
+			t.Errorf("expected not-supported propagation to suspend the transaction, got %v, %v", inTx, err)
+		}
+		if !th.HasTx(ctx) {
+			t.Error("expected the transaction to resume after a suspended call")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("outer transaction failed: %v", err)
+	}
+}
+
+func TestNestedWithTxJoinsByDefault(t *testing.T) {
+	svc := openFileService(t)
+	th := svc.TransactionHandler()
+
+	err := th.WithTx(context.Background(), func(ctx context.Context) error {
+		outer, _ := sqlstore.TransactionFromContext(ctx)
+		if err := insertEntry(ctx, "outer"); err != nil {
+			return err
+		}
+		return th.WithTx(ctx, func(ctx context.Context) error {
+			if inner, _ := sqlstore.TransactionFromContext(ctx); inner != outer {
+				t.Error("expected the nested call to join the outer transaction")
+			}
+			return insertEntry(ctx, "joined")
+		})
+	})
+	if err != nil {
+		t.Fatalf("outer transaction failed: %v", err)
+	}
+
+	ids := entryIDs(t, svc)
+	if !ids["outer"] || !ids["joined"] {
+		t.Errorf("expected both rows committed together, got %v", ids)
+	}
+}
+
+func TestAfterCommitAndRollbackHooks(t *testing.T) {
+	svc := openFileService(t)
+	th := svc.TransactionHandler()
+	ctx := context.Background()
+
+	if err := sqlstore.RegisterAfterCommit(ctx, func(context.Context) {}); !errors.Is(err, store.ErrNoTransaction) {
+		t.Errorf("expected registering outside a transaction to fail, got %v", err)
+	}
+
+	var events []string
+	record := func(event string) func(context.Context) {
+		return func(context.Context) { events = append(events, event) }
+	}
+
+	err := th.WithTx(ctx, func(ctx context.Context) error {
+		_ = sqlstore.RegisterAfterCommit(ctx, record("committed"))
+		_ = sqlstore.RegisterAfterRollback(ctx, record("rolled back"))
+
+		nested := store.TxOptions{Propagation: store.PropagationNested}
+		err := th.WithTxOptions(ctx, nested, func(ctx context.Context) error {
+			_ = sqlstore.RegisterAfterCommit(ctx, record("nested committed"))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		_ = th.WithTxOptions(ctx, nested, func(ctx context.Context) error {
+			_ = sqlstore.RegisterAfterCommit(ctx, record("nested rolled back"))
+			return errAbort
+		})
+
+		if len(events) != 0 {
+			t.Errorf("expected hooks to wait for the outermost commit, got %v", events)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+	if got := strings.Join(events, ","); got != "committed,nested committed" {
+		t.Errorf("unexpected after-commit hooks: %s", got)
+	}
+
+	events = nil
+	err = th.WithTx(ctx, func(ctx context.Context) error {
+		_ = sqlstore.RegisterAfterCommit(ctx, record("committed"))
+		_ = sqlstore.RegisterAfterRollback(ctx, record("rolled back"))
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("expected abort error, got %v", err)
+	}
+	if got := strings.Join(events, ","); got != "rolled back" {
+		t.Errorf("unexpected after-rollback hooks: %s", got)
+	}
+}
+
+func TestCockroachDBRetriesSerializationFailures(t *testing.T) {
+	svc := openFileService(t)
+	crdb := adapter.NewCockroachDBAdapter()
+	tx := sqlstore.NewTransactionHandler(svc.DB(), crdb)
+	ctx := context.Background()
+
+	attempts := 0
+	err := tx.WithTx(ctx, func(ctx context.Context) error {
+		attempts++
+		if err := insertEntry(ctx, "a"); err != nil {
+			return err
+		}
+		if attempts < 3 {
+			return &pq.Error{Code: "40001", Message: "restart transaction"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected the transaction to succeed after retries, got %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+	if ids := entryIDs(t, svc); len(ids) != 1 || !ids["a"] {
+		t.Errorf("expected only the final attempt to commit, got %v", ids)
+	}
+
+	attempts = 0
+	err = tx.WithTx(ctx, func(ctx context.Context) error {
+		attempts++
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) || attempts != 1 {
+		t.Errorf("expected a non-retryable error to fail once, got %v after %d attempts", err, attempts)
+	}
+
+	if !crdb.IsRetryableTxError(store.WrapTransactionError(&pq.Error{Code: "40001"}, "commit")) {
+		t.Error("expected a wrapped 40001 error to be retryable")
+	}
+	if crdb.IsRetryableTxError(&pq.Error{Code: "23505"}) {
+		t.Error("expected a unique violation not to be retryable")
+	}
+	if a, err := adapter.Get("cockroachdb"); err != nil || a.Name() != "cockroachdb" {
+		t.Errorf("expected the cockroachdb adapter to be registered, got %v, %v", a, err)
+	}
+}
+
+func TestRetryPolicyClassifiesAndReportsRetries(t *testing.T) {
+	svc := openFileService(t)
+	th := svc.TransactionHandler()
+	ctx := context.Background()
+
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+	var retries []int
+	policy := &store.RetryPolicy{
+		MaxRetries:        5,
+		InitialDelay:      time.Millisecond,
+		MaxDelay:          5 * time.Millisecond,
+		BackoffMultiplier: 2,
+		Jitter:            0.5,
+		OnRetry: func(attempt int, err error, delay time.Duration) {
+			if !errors.As(err, new(sqlite3.Error)) {
+				t.Errorf("expected the previous error to be reported, got %v", err)
+			}
+			retries = append(retries, attempt)
+		},
+	}
+
+	attempts := 0
+	err := th.WithTxOptions(ctx, store.TxOptions{RetryPolicy: policy}, func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return busy
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected the transaction to succeed after retries, got %v", err)
+	}
+	if len(retries) != 2 || retries[0] != 1 || retries[1] != 2 {
+		t.Errorf("expected OnRetry before attempts 1 and 2, got %v", retries)
+	}
+
+	attempts = 0
+	err = th.WithTxOptions(ctx, store.TxOptions{RetryPolicy: policy}, func(ctx context.Context) error {
+		attempts++
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) || attempts != 1 {
+		t.Errorf("expected a non-retryable error to fail once, got %v after %d attempts", err, attempts)
+	}
+
+	attempts = 0
+	bounded := &store.RetryPolicy{MaxRetries: 5, InitialDelay: time.Second, BackoffMultiplier: 2, MaxElapsedTime: 100 * time.Millisecond}
+	err = th.WithTxOptions(ctx, store.TxOptions{RetryPolicy: bounded}, func(ctx context.Context) error {
+		attempts++
+		return busy
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("expected no retry past MaxElapsedTime, got %v after %d attempts", err, attempts)
+	}
+
+	for attempt := 1; attempt <= 4; attempt++ {
+		if d := policy.Delay(attempt); d <= 0 || d > policy.MaxDelay {
+			t.Errorf("expected delay %d within (0, %s], got %s", attempt, policy.MaxDelay, d)
+		}
+	}
+}
+
+func TestTxWatchdogReportsAndRollsBack(t *testing.T) {
+	svc := openFileService(t)
+	th := svc.TransactionHandler()
+	ctx := context.Background()
+
+	reports := make(chan sqlstore.LongTransaction, 1)
+	svc.SetTxWatchdog(20*time.Millisecond, true, func(tx sqlstore.LongTransaction) {
+		reports <- tx
+	})
+
+	err := th.WithTx(ctx, func(ctx context.Context) error {
+		if active := svc.TxStats().Active; active != 1 {
+			t.Errorf("expected 1 active transaction, got %d", active)
+		}
+		if err := insertEntry(ctx, "stalled"); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return insertEntry(ctx, "late")
+	})
+	if !errors.Is(err, store.ErrTransactionTimeout) {
+		t.Fatalf("expected the watchdog to abort the transaction, got %v", err)
+	}
+
+	select {
+	case tx := <-reports:
+		if !tx.RolledBack || tx.Held < 20*time.Millisecond {
+			t.Errorf("unexpected report: %+v", tx)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the long-running transaction to be reported")
+	}
+	if stats := svc.TxStats(); stats.Active != 0 || stats.LongRunning != 1 || stats.ForcedRollbacks != 1 {
+		t.Errorf("unexpected transaction stats: %+v", stats)
+	}
+	if stats := svc.Stats().(sqlstore.Stats); stats.Transactions != svc.TxStats() {
+		t.Errorf("expected Stats to include the transaction counts, got %+v", stats.Transactions)
+	}
+	if ids := entryIDs(t, svc); len(ids) != 0 {
+		t.Errorf("expected the transaction to be rolled back, got %v", ids)
+	}
+}
@@ -0,0 +1,118 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"store"
+)
+
+// TxStats counts the transactions run through the transaction handlers of a
+// service.
+type TxStats struct {
+	Active          int64 // transactions open now
+	LongRunning     int64 // transactions that exceeded the watchdog threshold
+	ForcedRollbacks int64 // long-running transactions rolled back by the watchdog
+}
+
+// LongTransaction describes a transaction open longer than the watchdog
+// threshold.
+type LongTransaction struct {
+	StartTime  time.Time
+	Held       time.Duration
+	ReadOnly   bool
+	RolledBack bool // the watchdog is rolling the transaction back
+}
+
+// txWatchdog reports transactions open longer than a threshold.
+type txWatchdog struct {
+	threshold time.Duration
+	rollback  bool
+	report    func(LongTransaction)
+}
+
+// txTracker counts the open transactions of a service and applies its
+// watchdog, if any.
+type txTracker struct {
+	active   atomic.Int64
+	long     atomic.Int64
+	forced   atomic.Int64
+	watchdog atomic.Pointer[txWatchdog]
+}
+
+// track records a transaction starting now and returns the context to begin
+// it with, canceled when the watchdog rolls it back, and the function ending
+// it.
+func (tr *txTracker) track(ctx context.Context, readOnly bool) (context.Context, func()) {
+	if tr == nil {
+		return ctx, func() {}
+	}
+	tr.active.Add(1)
+	w := tr.watchdog.Load()
+	if w == nil {
+		return ctx, func() { tr.active.Add(-1) }
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithCancelCause(ctx)
+	timer := time.AfterFunc(w.threshold, func() {
+		tr.long.Add(1)
+		if w.rollback {
+			tr.forced.Add(1)
+			// Canceling the context of BeginTx rolls the transaction back
+			cancel(fmt.Errorf("%w: open longer than %s", store.ErrTransactionTimeout, w.threshold))
+		}
+		w.report(LongTransaction{StartTime: start, Held: time.Since(start), ReadOnly: readOnly, RolledBack: w.rollback})
+	})
+	return ctx, func() {
+		timer.Stop()
+		cancel(nil)
+		tr.active.Add(-1)
+	}
+}
+
+// txAborted marks err with the cause of a rollback forced by the watchdog.
+func txAborted(ctx context.Context, err error) error {
+	cause := context.Cause(ctx)
+	if cause == nil || !errors.Is(cause, store.ErrTransactionTimeout) || errors.Is(err, store.ErrTransactionTimeout) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", cause, err)
+}
+
+// logLongTransaction is the default report of the watchdog, written to the
+// service logger.
+func (s *Service) logLongTransaction(tx LongTransaction) {
+	s.logger.Log(context.Background(), slog.LevelWarn, "long-running transaction",
+		"held", tx.Held, "read_only", tx.ReadOnly, "rolled_back", tx.RolledBack)
+}
+
+// SetTxWatchdog reports the transactions of the service still open after
+// threshold and, when rollback is set, rolls them back by canceling their
+// context, so fn fails and the transaction returns ErrTransactionTimeout. A
+// nil report writes them to the service logger; a threshold <= 0 disables
+// the watchdog. It applies to transactions begun afterwards.
+func (s *Service) SetTxWatchdog(threshold time.Duration, rollback bool, report func(LongTransaction)) {
+	if threshold <= 0 {
+		s.txs.watchdog.Store(nil)
+		return
+	}
+	if report == nil {
+		report = s.logLongTransaction
+	}
+	s.txs.watchdog.Store(&txWatchdog{threshold: threshold, rollback: rollback, report: report})
+}
+
+// TxStats returns the transaction counts of the service, as included in
+// Stats.
+func (s *Service) TxStats() TxStats {
+	return TxStats{
+		Active:          s.txs.active.Load(),
+		LongRunning:     s.txs.long.Load(),
+		ForcedRollbacks: s.txs.forced.Load(),
+	}
+}
@@ -0,0 +1,98 @@
+package sqlstore
+
+import (
+	"fmt"
+	"strings"
+
+	"store"
+)
+
+// Window is a window function call, fn OVER (PARTITION BY ... ORDER BY ...),
+// selected with QueryBuilder.SelectWindow. Window functions are evaluated
+// after WHERE, so filtering on their result (top-N per group, keeping the
+// first row of duplicates) is done by selecting from the query with
+// NewSubqueryBuilder. PostgreSQL, SQLite 3.25 and MySQL 8.0 or later support
+// them.
+type Window struct {
+	fn          string
+	partitionBy []string
+	orders      []store.Order
+}
+
+// RowNumber numbers the rows of each partition from 1.
+func RowNumber() *Window { return &Window{fn: "ROW_NUMBER()"} }
+
+// Rank ranks the rows of each partition, leaving gaps after ties.
+func Rank() *Window { return &Window{fn: "RANK()"} }
+
+// DenseRank ranks the rows of each partition without gaps after ties.
+func DenseRank() *Window { return &Window{fn: "DENSE_RANK()"} }
+
+// Lag returns column from the row offset rows before the current one.
+func Lag(column string, offset int) *Window {
+	return &Window{fn: fmt.Sprintf("LAG(%s, %d)", column, offset)}
+}
+
+// Lead returns column from the row offset rows after the current one.
+func Lead(column string, offset int) *Window {
+	return &Window{fn: fmt.Sprintf("LEAD(%s, %d)", column, offset)}
+}
+
+// OverPartitionBy restarts the window for each distinct value of columns.
+func (w *Window) OverPartitionBy(columns ...string) *Window {
+	w.partitionBy = append(w.partitionBy, columns...)
+	return w
+}
+
+// OrderBy orders the rows within each partition. Direction is "ASC" or
+// "DESC".
+func (w *Window) OrderBy(field, direction string) *Window {
+	w.orders = append(w.orders, store.Order{Field: field, Desc: strings.EqualFold(direction, "DESC")})
+	return w
+}
+
+// expression renders the window call after validating its identifiers.
+func (w *Window) expression() (string, error) {
+	if err := checkExpression("window function", w.fn); err != nil {
+		return "", err
+	}
+	var over []string
+	if len(w.partitionBy) > 0 {
+		for _, col := range w.partitionBy {
+			if err := checkIdentifier("partition by", col); err != nil {
+				return "", err
+			}
+		}
+		over = append(over, "PARTITION BY "+strings.Join(w.partitionBy, ", "))
+	}
+	if len(w.orders) > 0 {
+		terms := make([]string, len(w.orders))
+		for i, o := range w.orders {
+			if err := checkIdentifier("order by", o.Field); err != nil {
+				return "", err
+			}
+			terms[i] = o.Field + " ASC"
+			if o.Desc {
+				terms[i] = o.Field + " DESC"
+			}
+		}
+		over = append(over, "ORDER BY "+strings.Join(terms, ", "))
+	}
+	return w.fn + " OVER (" + strings.Join(over, " ") + ")", nil
+}
+
+// SelectWindow selects the window function w, named alias when alias is not
+// empty.
+func (qb *QueryBuilder) SelectWindow(w *Window, alias string) *QueryBuilder {
+	expr, err := w.expression()
+	if err != nil {
+		if qb.err == nil {
+			qb.err = err
+		}
+		return qb
+	}
+	if alias != "" {
+		expr += " AS " + alias
+	}
+	return qb.Select(expr)
+}
@@ -8,6 +8,8 @@ package store
 
 import (
 	"context"
+	"math"
+	"math/rand/v2"
 	"time"
 
 	"core/entity"
@@ -66,10 +68,36 @@ type TxOptions struct {
 	// RetryPolicy defines retry behavior on transaction conflicts
 	RetryPolicy *RetryPolicy
 
+	// Propagation controls how the call interacts with an existing transaction
+	Propagation Propagation
+
 	// Backend-specific options
 	BackendOptions map[string]any
 }
 
+// Propagation controls how a transactional call interacts with a transaction
+// already present in the context. The zero value behaves as PropagationRequired.
+type Propagation string
+
+const (
+	// PropagationRequired joins the existing transaction or starts a new one.
+	PropagationRequired Propagation = "required"
+	// PropagationRequiresNew suspends the existing transaction and runs in an
+	// independent one that commits or rolls back on its own.
+	PropagationRequiresNew Propagation = "requires_new"
+	// PropagationNested runs within a savepoint of the existing transaction so
+	// a failure only rolls back the nested work, or starts a new transaction.
+	PropagationNested Propagation = "nested"
+	// PropagationSupports joins the existing transaction or runs without one.
+	PropagationSupports Propagation = "supports"
+	// PropagationNotSupported suspends the existing transaction and runs
+	// without one.
+	PropagationNotSupported Propagation = "not_supported"
+	// PropagationMandatory joins the existing transaction and fails with
+	// ErrNoTransaction when there is none.
+	PropagationMandatory Propagation = "mandatory"
+)
+
 // IsolationLevel represents transaction isolation levels.
 type IsolationLevel string
 
@@ -81,12 +109,25 @@ const (
 	IsolationSerializable    IsolationLevel = "serializable"
 )
 
-// RetryPolicy defines how transactions should be retried on conflicts.
+// RetryPolicy defines how transactions should be retried on conflicts, as
+// classified by the backend.
 type RetryPolicy struct {
 	MaxRetries        int
 	InitialDelay      time.Duration
 	MaxDelay          time.Duration
 	BackoffMultiplier float64
+
+	// Jitter shortens each delay by a random fraction of it, up to Jitter
+	// (from 0 to 1), so conflicting transactions do not retry in lockstep.
+	Jitter float64
+
+	// MaxElapsedTime bounds the time spent on all attempts: no retry starts
+	// when its delay would end past it. Zero means no bound.
+	MaxElapsedTime time.Duration
+
+	// OnRetry, when set, is called before each retry with the attempt about
+	// to run (starting at 1), the error of the previous one and the delay.
+	OnRetry func(attempt int, err error, delay time.Duration)
 }
 
 // DefaultRetryPolicy returns a sensible default retry policy.
@@ -96,7 +137,21 @@ func DefaultRetryPolicy() *RetryPolicy {
 		InitialDelay:      10 * time.Millisecond,
 		MaxDelay:          1 * time.Second,
 		BackoffMultiplier: 2.0,
+		Jitter:            0.2,
+	}
+}
+
+// Delay returns the delay before retry attempt (starting at 1): the initial
+// delay grown exponentially, capped at MaxDelay, then jittered.
+func (p *RetryPolicy) Delay(attempt int) time.Duration {
+	delay := time.Duration(float64(p.InitialDelay) * math.Pow(p.BackoffMultiplier, float64(attempt-1)))
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if jitter := min(max(p.Jitter, 0), 1); jitter > 0 {
+		delay -= time.Duration(rand.Float64() * jitter * float64(delay))
 	}
+	return delay
 }
 
 // TransactionManager provides advanced transaction management capabilities.
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+)
+
+// TLSConfig holds the TLS settings of network backends. Files are PEM
+// encoded; a client certificate and key enable mutual TLS.
+type TLSConfig struct {
+	CAFile             string `json:"ca_file"`     // CA bundle verifying the server
+	CertFile           string `json:"cert_file"`   // client certificate
+	KeyFile            string `json:"key_file"`    // client private key
+	ServerName         string `json:"server_name"` // expected server name, when not the host
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// ClientConfig loads the certificates and returns the equivalent *tls.Config.
+func (c *TLSConfig) ClientConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, NewConfigErrorForField("tls.ca_file", c.CAFile, err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, NewConfigErrorForField("tls.ca_file", c.CAFile, "no PEM certificates found")
+		}
+		cfg.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, NewConfigError("tls client certificate and key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, NewConfigErrorForField("tls.cert_file", c.CertFile, err.Error())
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
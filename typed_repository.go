@@ -0,0 +1,150 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"core/entity"
+)
+
+// TypedRepository wraps a Repository of a single entity type T so results
+// are returned as T instead of entity.Entity. It works over any backend;
+// sqlstore.TypedRepository and kvstore.TypedRepository create one from a
+// service.
+type TypedRepository[T entity.Entity] struct {
+	repo Repository
+}
+
+// NewTypedRepository wraps repo, whose entities must all be of type T.
+func NewTypedRepository[T entity.Entity](repo Repository) *TypedRepository[T] {
+	return &TypedRepository[T]{repo: repo}
+}
+
+// Repository returns the wrapped repository.
+func (r *TypedRepository[T]) Repository() Repository {
+	return r.repo
+}
+
+// cast returns ent as T, or an error wrapping ErrInvalidRecord when the
+// repository returned another type.
+func (r *TypedRepository[T]) cast(ent entity.Entity) (T, error) {
+	t, ok := ent.(T)
+	if !ok {
+		var zero T
+		return zero, fmt.Errorf("%w: %s repository returned %T, not %T", ErrInvalidRecord, r.repo.EntityName(), ent, zero)
+	}
+	return t, nil
+}
+
+func (r *TypedRepository[T]) castAll(entities []entity.Entity) ([]T, error) {
+	items := make([]T, len(entities))
+	for i, ent := range entities {
+		t, err := r.cast(ent)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = t
+	}
+	return items, nil
+}
+
+func toEntities[T entity.Entity](items []T) []entity.Entity {
+	entities := make([]entity.Entity, len(items))
+	for i, item := range items {
+		entities[i] = item
+	}
+	return entities
+}
+
+func (r *TypedRepository[T]) Create(ctx context.Context, item T) error {
+	return r.repo.Create(ctx, item)
+}
+
+func (r *TypedRepository[T]) Get(ctx context.Context, id string) (T, error) {
+	ent, err := r.repo.Get(ctx, id)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return r.cast(ent)
+}
+
+func (r *TypedRepository[T]) Update(ctx context.Context, item T) error {
+	return r.repo.Update(ctx, item)
+}
+
+func (r *TypedRepository[T]) Delete(ctx context.Context, id string) error {
+	return r.repo.Delete(ctx, id)
+}
+
+func (r *TypedRepository[T]) Exists(ctx context.Context, id string) (bool, error) {
+	return r.repo.Exists(ctx, id)
+}
+
+func (r *TypedRepository[T]) CreateBatch(ctx context.Context, items []T) error {
+	return r.repo.CreateBatch(ctx, toEntities(items))
+}
+
+func (r *TypedRepository[T]) UpdateBatch(ctx context.Context, items []T) error {
+	return r.repo.UpdateBatch(ctx, toEntities(items))
+}
+
+func (r *TypedRepository[T]) DeleteBatch(ctx context.Context, ids []string) error {
+	return r.repo.DeleteBatch(ctx, ids)
+}
+
+// GetBatch returns the entities found among ids, keyed by ID.
+func (r *TypedRepository[T]) GetBatch(ctx context.Context, ids []string) (map[string]T, error) {
+	found, err := r.repo.GetBatch(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	items := make(map[string]T, len(found))
+	for id, ent := range found {
+		t, err := r.cast(ent)
+		if err != nil {
+			return nil, err
+		}
+		items[id] = t
+	}
+	return items, nil
+}
+
+func (r *TypedRepository[T]) List(ctx context.Context, params CursorParams) (CursorResult[T], error) {
+	page, err := r.repo.List(ctx, params)
+	if err != nil {
+		return CursorResult[T]{}, err
+	}
+	items, err := r.castAll(page.Items)
+	if err != nil {
+		return CursorResult[T]{}, err
+	}
+	return CursorResult[T]{
+		Items:          items,
+		NextCursor:     page.NextCursor,
+		PreviousCursor: page.PreviousCursor,
+		HasMore:        page.HasMore,
+		TotalCount:     page.TotalCount,
+	}, nil
+}
+
+func (r *TypedRepository[T]) FindWhere(ctx context.Context, conditions ...Condition) ([]T, error) {
+	entities, err := r.repo.FindWhere(ctx, conditions...)
+	if err != nil {
+		return nil, err
+	}
+	return r.castAll(entities)
+}
+
+func (r *TypedRepository[T]) CountWhere(ctx context.Context, conditions ...Condition) (int64, error) {
+	return r.repo.CountWhere(ctx, conditions...)
+}
+
+func (r *TypedRepository[T]) FindFirst(ctx context.Context, conditions ...Condition) (T, error) {
+	ent, err := r.repo.FindFirst(ctx, conditions...)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return r.cast(ent)
+}